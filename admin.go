@@ -0,0 +1,281 @@
+package cache
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ItemInfo 管理接口返回的元素元数据
+type ItemInfo struct {
+	Key          string        `json:"key"`
+	Type         string        `json:"type"`
+	Size         int64         `json:"size"`
+	Version      uint64        `json:"version"`
+	LifeSpan     time.Duration `json:"lifespan"`
+	TTLRemaining time.Duration `json:"ttl_remaining"`
+	AccessCount  int64         `json:"access_count"`
+	CreatedOn    time.Time     `json:"created_on"`
+	AccessedOn   time.Time     `json:"accessed_on"`
+	// Value 可以转换为字符串的值(见CacheItem.String), 只在读取单个元素时返回
+	Value string `json:"value,omitempty"`
+}
+
+// TableInfo 管理接口返回的表信息
+type TableInfo struct {
+	Name  string `json:"name"`
+	Stats Stats  `json:"stats"`
+}
+
+// info 返回元素的元数据; 不过期的元素TTLRemaining为0
+func (item *CacheItem) info(now time.Time) ItemInfo {
+	item.RLock()
+	defer item.RUnlock()
+	info := ItemInfo{
+		Key:         fmt.Sprint(item.key),
+		Type:        fmt.Sprintf("%T", item.value),
+		Size:        item.size,
+		Version:     item.version,
+		LifeSpan:    item.lifeSpan,
+		AccessCount: item.accessCount,
+		CreatedOn:   item.createdOn,
+		AccessedOn:  item.accessedOn,
+	}
+	if item.lifeSpan > 0 {
+		if remaining := item.lifeSpan - now.Sub(item.accessedOn); remaining > 0 {
+			info.TTLRemaining = remaining
+		}
+	}
+	return info
+}
+
+// now 返回表时钟的当前时间
+func (table *CacheTable) now() time.Time {
+	table.RLock()
+	defer table.RUnlock()
+	return table.clock.Now()
+}
+
+// lookupKey 按字符串形式查找键: 先按字符串键直接查找, 再比较fmt.Sprint(key)
+func (table *CacheTable) lookupKey(s string) (interface{}, bool) {
+	table.RLock()
+	defer table.RUnlock()
+	if _, ok := table.items.getString(s); ok {
+		return s, true
+	}
+	var (
+		found interface{}
+		ok    bool
+	)
+	table.items.each(func(k interface{}, _ *CacheItem) bool {
+		if fmt.Sprint(k) == s {
+			found, ok = k, true
+		}
+		return !ok
+	})
+	return found, ok
+}
+
+// AdminHandler 返回管理接口的http.Handler, 可挂载到任意路径前缀下(配合http.StripPrefix). 接口均返回JSON:
+//
+//	GET    /tables                          列出已注册的表及统计
+//	GET    /tables/{table}                  表的统计
+//	GET    /tables/{table}/keys?q=&limit=   按键的字符串形式搜索, 返回元素元数据
+//	GET    /tables/{table}/items/{key}      元素元数据
+//	DELETE /tables/{table}/items/{key}      删除元素
+//	POST   /tables/{table}/flush            清空表
+//	GET    /tables/{table}/dump?sort=&limit= Dump的文本输出, sort为key/size/age/ttl/access
+//	GET    /tables/{table}/snapshot         下载表的快照(SaveSnapshot的格式)
+//	POST   /tables/{table}/snapshot         把表写入SetSnapshotFile设置的文件, 未设置时返回409
+//	GET    /tables/{table}/hitrate?window=  每分钟的命中/未命中次数, window默认1h
+//	GET    /healthz                         所有表的Health, 有表不正常时返回503
+//	GET    /                                内嵌的HTML控制台
+func AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", adminDashboard)
+	mux.Handle("GET /healthz", HealthHandler())
+	mux.HandleFunc("GET /tables/{table}/hitrate", withAdminTable(adminHitRate))
+	mux.HandleFunc("GET /tables", adminListTables)
+	mux.HandleFunc("GET /tables/{table}", withAdminTable(adminTableStats))
+	mux.HandleFunc("GET /tables/{table}/keys", withAdminTable(adminSearchKeys))
+	mux.HandleFunc("GET /tables/{table}/items/{key}", withAdminTable(adminGetItem))
+	mux.HandleFunc("DELETE /tables/{table}/items/{key}", withAdminTable(adminDeleteItem))
+	mux.HandleFunc("POST /tables/{table}/flush", withAdminTable(adminFlush))
+	mux.HandleFunc("GET /tables/{table}/dump", withAdminTable(adminDump))
+	mux.HandleFunc("GET /tables/{table}/snapshot", withAdminTable(adminDownloadSnapshot))
+	mux.HandleFunc("POST /tables/{table}/snapshot", withAdminTable(adminPersist))
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// withAdminTable 解析路径中的表名, 只处理已注册的表
+func withAdminTable(h func(http.ResponseWriter, *http.Request, *CacheTable)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("table")
+		if !Exists(name) {
+			writeError(w, http.StatusNotFound, ErrTableNotFound)
+			return
+		}
+		h(w, r, Cache(name))
+	}
+}
+
+func adminListTables(w http.ResponseWriter, r *http.Request) {
+	names := Tables()
+	infos := make([]TableInfo, 0, len(names))
+	for _, name := range names {
+		infos = append(infos, TableInfo{Name: name, Stats: Cache(name).Stats()})
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func adminTableStats(w http.ResponseWriter, r *http.Request, table *CacheTable) {
+	writeJSON(w, http.StatusOK, TableInfo{Name: table.Name(), Stats: table.Stats()})
+}
+
+func adminSearchKeys(w http.ResponseWriter, r *http.Request, table *CacheTable) {
+	q := r.URL.Query().Get("q")
+	limit := 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", s))
+			return
+		}
+		limit = n
+	}
+
+	now := table.now()
+	infos := []ItemInfo{}
+	table.Foreach(func(key interface{}, item *CacheItem) {
+		if strings.Contains(fmt.Sprint(key), q) {
+			infos = append(infos, item.info(now))
+		}
+	})
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	if len(infos) > limit {
+		infos = infos[:limit]
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func adminGetItem(w http.ResponseWriter, r *http.Request, table *CacheTable) {
+	key, ok := table.lookupKey(r.PathValue("key"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrKeyNotFound)
+		return
+	}
+	table.RLock()
+	item, ok := table.items.get(key)
+	table.RUnlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrKeyNotFound)
+		return
+	}
+	info := item.info(table.now())
+	info.Value, _ = item.String()
+	writeJSON(w, http.StatusOK, info)
+}
+
+func adminDeleteItem(w http.ResponseWriter, r *http.Request, table *CacheTable) {
+	key, ok := table.lookupKey(r.PathValue("key"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrKeyNotFound)
+		return
+	}
+	if _, err := table.DeleteContext(WithActor(r.Context(), "admin"), key); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func adminFlush(w http.ResponseWriter, r *http.Request, table *CacheTable) {
+	table.Flush()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// dumpSorts dump接口的sort参数
+var dumpSorts = map[string]DumpSort{"": DumpByKey, "key": DumpByKey, "size": DumpBySize, "age": DumpByAge, "ttl": DumpByTTL, "access": DumpByAccess}
+
+func adminDump(w http.ResponseWriter, r *http.Request, table *CacheTable) {
+	var opts DumpOptions
+	sort, ok := dumpSorts[r.URL.Query().Get("sort")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid sort %q", r.URL.Query().Get("sort")))
+		return
+	}
+	opts.Sort = sort
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", s))
+			return
+		}
+		opts.Limit = n
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	table.Dump(w, opts)
+}
+
+func adminDownloadSnapshot(w http.ResponseWriter, r *http.Request, table *CacheTable) {
+	var buf bytes.Buffer
+	if err := table.SaveSnapshot(&buf); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", table.Name()+".snapshot"))
+	w.Write(buf.Bytes())
+}
+
+func adminPersist(w http.ResponseWriter, r *http.Request, table *CacheTable) {
+	table.RLock()
+	path := table.snapshotFile
+	table.RUnlock()
+	if path == "" {
+		writeError(w, http.StatusConflict, fmt.Errorf("table %s has no snapshot file", table.Name()))
+		return
+	}
+	if err := table.Persist(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"file": path})
+}
+
+//go:embed web/dashboard.html
+var dashboardHTML []byte
+
+func adminDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHTML)
+}
+
+func adminHitRate(w http.ResponseWriter, r *http.Request, table *CacheTable) {
+	window := time.Hour
+	if s := r.URL.Query().Get("window"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid window %q", s))
+			return
+		}
+		window = d
+	}
+	writeJSON(w, http.StatusOK, table.HitRateSeries(window))
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// AdmissionHook 写入前的检查, 返回错误时拒绝写入, 该错误由TryAdd, TryNotFoundAdd, SetIfVersion和Tx返回.
+// 调用时持有表锁, 不能调用表的方法
+type AdmissionHook func(key, value interface{}, lifeSpan time.Duration) error
+
+// SetAdmissionHook 设置写入前的检查, 如拒绝nil值、过大的值或不允许的键类型; f为nil时取消
+func (table *CacheTable) SetAdmissionHook(f AdmissionHook) {
+	table.Lock()
+	defer table.Unlock()
+	table.admissionHook = f
+}
+
+// WithAdmissionHook 同SetAdmissionHook
+func WithAdmissionHook(f AdmissionHook) Option {
+	return func(t *CacheTable) { t.admissionHook = f }
+}
+
+// admit 执行写入前的检查, 调用方需持有表锁
+func (table *CacheTable) admit(key, value interface{}, lifeSpan time.Duration) error {
+	err := table.checkValueSize(key, value)
+	if err == nil && table.admissionHook != nil {
+		err = table.admissionHook(key, value, lifeSpan)
+	}
+	if err == nil && table.admission != nil {
+		err = table.consultAdmission(key, value)
+	}
+	if err != nil {
+		table.logEvent(LevelDebug, EventRejected, key, "Item rejected", "error", err)
+	}
+	return err
+}
+
+// TryAdd 同Add, 返回表已关闭(ErrTableClosed)或写入被拒绝的原因
+func (table *CacheTable) TryAdd(key interface{}, lifeSpan time.Duration, data interface{}) (*CacheItem, error) {
+	return table.TryAddContext(context.Background(), key, lifeSpan, data)
+}
+
+// TryAddContext 同AddContext, 返回表已关闭(ErrTableClosed)或写入被拒绝的原因
+func (table *CacheTable) TryAddContext(ctx context.Context, key interface{}, lifeSpan time.Duration, data interface{}) (*CacheItem, error) {
+	if h := table.intercept(); h != nil {
+		return h(ctx, &Call{Kind: CallAdd, Table: table.name, Key: key, Value: data, LifeSpan: lifeSpan})
+	}
+	return table.tryAdd(ctx, key, lifeSpan, data)
+}
+
+// tryAdd 实现TryAddContext, 不经过中间件
+func (table *CacheTable) tryAdd(ctx context.Context, key interface{}, lifeSpan time.Duration, data interface{}) (*CacheItem, error) {
+	return table.insert(ctx, key, lifeSpan, data, false)
+}
+
+// insert 实现tryAdd; loaded为true表示加载函数的结果, Shutdown等待加载期间仍然写入
+func (table *CacheTable) insert(ctx context.Context, key interface{}, lifeSpan time.Duration, data interface{}, loaded bool) (*CacheItem, error) {
+	key = canonicalKey(key)
+	table.Lock()
+	if table.closed || table.draining && !loaded {
+		table.Unlock()
+		return nil, ErrTableClosed
+	}
+	if err := table.admit(key, data, lifeSpan); err != nil {
+		table.Unlock()
+		return nil, err
+	}
+	item := newCacheItem(key, lifeSpan, data, table.clock.Now())
+	table.addInternal(ctx, item)
+	return item, nil
+}
@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// AdmissionPolicy 决定新键能否写入表, 与淘汰策略分开, 用于防止只出现一次的键挤掉常用的键.
+// 实现可能在持有表锁时被调用, 需自行加锁且不能调用表的方法
+type AdmissionPolicy interface {
+	// Record 记录一次对键的访问, 每次命中和每次写入时调用
+	Record(key interface{})
+	// Admit 返回是否写入新键key, size为Sizer估算的大小; 表已满时victim为写入后将被淘汰的键, 否则为nil.
+	// 覆盖已有的键不经过Admit
+	Admit(key interface{}, size int64, victim interface{}) bool
+}
+
+// SetAdmissionPolicy 设置准入策略, 被拒绝的写入返回ErrNotAdmitted; p为nil时取消
+func (table *CacheTable) SetAdmissionPolicy(p AdmissionPolicy) {
+	table.Lock()
+	defer table.Unlock()
+	table.admission = p
+}
+
+// WithAdmissionPolicy 同SetAdmissionPolicy
+func WithAdmissionPolicy(p AdmissionPolicy) Option {
+	return func(t *CacheTable) { t.admission = p }
+}
+
+// consultAdmission 询问准入策略, 调用方需持有表锁
+func (table *CacheTable) consultAdmission(key, value interface{}) error {
+	p := table.admission
+	p.Record(key)
+	if _, ok := table.items.get(key); ok {
+		return nil
+	}
+	if table.doorkeeper != nil && !table.doorkeeper.pass(key) {
+		atomic.AddUint64(&table.notAdmitted, 1)
+		return ErrNotAdmitted
+	}
+	var victim interface{}
+	if max := table.Settings().MaxItems; max > 0 && table.items.len() >= max {
+		victim, _ = table.victim(key)
+	}
+	if p.Admit(key, table.sizeOf(&CacheItem{key: key, value: value}), victim) {
+		return nil
+	}
+	atomic.AddUint64(&table.notAdmitted, 1)
+	return ErrNotAdmitted
+}
+
+// doorkeeper 记录第一次写入的键的布隆过滤器, 记录满预计的键数后清空
+type doorkeeper struct {
+	mu       sync.Mutex
+	expected int
+	seen     *bloomFilter
+	added    int
+}
+
+// SetDoorkeeper 在准入策略前加一个记录首次出现的键的小型布隆过滤器: 新键第一次写入时只被记录并拒绝,
+// 第二次写入才交给准入策略, 用于挡住爬虫式的一次性访问. 每记录expected个键后清空; 只在设置了准入策略时生效,
+// expected<=0时关闭. 由Value加载得到的值被拒绝时仍返回给调用方, 只是不缓存
+func (table *CacheTable) SetDoorkeeper(expected int) {
+	table.Lock()
+	defer table.Unlock()
+	table.setDoorkeeper(expected)
+}
+
+// WithDoorkeeper 同SetDoorkeeper
+func WithDoorkeeper(expected int) Option {
+	return func(t *CacheTable) { t.setDoorkeeper(expected) }
+}
+
+func (table *CacheTable) setDoorkeeper(expected int) {
+	if expected <= 0 {
+		table.doorkeeper = nil
+		return
+	}
+	table.doorkeeper = &doorkeeper{expected: expected, seen: newBloomFilter(expected, 0.01)}
+}
+
+// pass 键之前是否出现过, 没有时记录下来
+func (d *doorkeeper) pass(key interface{}) bool {
+	h := hashKey(key)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.seen.contains(h) {
+		return true
+	}
+	if d.added++; d.added > d.expected {
+		d.seen = newBloomFilter(d.expected, 0.01)
+		d.added = 1
+	}
+	d.seen.add(h)
+	return false
+}
+
+// FrequencyAdmission TinyLFU准入策略: 用count-min sketch近似统计键的访问频率,
+// 表已满时只有比待淘汰的键更常用的新键才能写入. 每记录约10倍计数器数量的访问后所有计数减半, 让旧的热点逐渐冷却
+type FrequencyAdmission struct {
+	mu      sync.Mutex
+	rows    [4][]uint8
+	mask    uint64
+	records int
+	resetAt int
+}
+
+// 计数器的上限
+const maxFrequency = 15
+
+// NewFrequencyAdmission 创建FrequencyAdmission, counters为每行计数器的数量, 向上取整为2的幂, 应与表的容量相当
+func NewFrequencyAdmission(counters int) *FrequencyAdmission {
+	n := 16
+	for n < counters {
+		n <<= 1
+	}
+	f := &FrequencyAdmission{mask: uint64(n - 1), resetAt: 10 * n}
+	for i := range f.rows {
+		f.rows[i] = make([]uint8, n)
+	}
+	return f
+}
+
+// index 第row行中键的位置, 用同一个哈希的不同部分和不同的奇数乘子得到各行的位置
+func (f *FrequencyAdmission) index(h uint64, row int) uint64 {
+	return mix64(h+uint64(row)*0x9e3779b97f4a7c15) & f.mask
+}
+
+// Record 实现AdmissionPolicy
+func (f *FrequencyAdmission) Record(key interface{}) {
+	h := hashKey(key)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.rows {
+		if c := &f.rows[i][f.index(h, i)]; *c < maxFrequency {
+			*c++
+		}
+	}
+	if f.records++; f.records >= f.resetAt {
+		for i := range f.rows {
+			for j := range f.rows[i] {
+				f.rows[i][j] >>= 1
+			}
+		}
+		f.records /= 2
+	}
+}
+
+// Frequency 返回键的估计访问次数
+func (f *FrequencyAdmission) Frequency(key interface{}) int {
+	h := hashKey(key)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	min := uint8(maxFrequency)
+	for i := range f.rows {
+		if c := f.rows[i][f.index(h, i)]; c < min {
+			min = c
+		}
+	}
+	return int(min)
+}
+
+// Admit 实现AdmissionPolicy
+func (f *FrequencyAdmission) Admit(key interface{}, size int64, victim interface{}) bool {
+	return victim == nil || f.Frequency(key) > f.Frequency(victim)
+}
+
+// SizeAdmission 按大小概率准入: 以exp(-size/scale)的概率写入新键, 越大的值越难进入表,
+// 避免少数大值挤掉大量小值. 只在表已满时生效
+type SizeAdmission struct {
+	scale float64
+}
+
+// NewSizeAdmission 创建SizeAdmission, scale为准入概率降到1/e时的大小
+func NewSizeAdmission(scale int64) *SizeAdmission {
+	return &SizeAdmission{scale: float64(scale)}
+}
+
+// Record 实现AdmissionPolicy
+func (s *SizeAdmission) Record(key interface{}) {}
+
+// Admit 实现AdmissionPolicy
+func (s *SizeAdmission) Admit(key interface{}, size int64, victim interface{}) bool {
+	if victim == nil || s.scale <= 0 {
+		return true
+	}
+	return rand.Float64() < math.Exp(-float64(size)/s.scale)
+}
+
+// RandomAdmission 表已满时以固定概率写入新键, 持续出现的键最终会进入表, 偶发的键大多被挡在外面
+type RandomAdmission struct {
+	p float64
+}
+
+// NewRandomAdmission 创建RandomAdmission, p为表已满时的准入概率
+func NewRandomAdmission(p float64) *RandomAdmission {
+	return &RandomAdmission{p: p}
+}
+
+// Record 实现AdmissionPolicy
+func (r *RandomAdmission) Record(key interface{}) {}
+
+// Admit 实现AdmissionPolicy
+func (r *RandomAdmission) Admit(key interface{}, size int64, victim interface{}) bool {
+	return victim == nil || rand.Float64() < r.p
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+type actorKey struct{}
+
+// WithActor 在ctx中记录操作者, 通过AddContext/DeleteContext等写入时会记入审计日志
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext 返回WithActor设置的操作者, 未设置时返回空字符串
+func ActorFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// AuditEntry 一条审计记录
+type AuditEntry struct {
+	Seq     uint64
+	Time    time.Time
+	Actor   string
+	Op      MutationOp
+	Key     interface{}
+	Version uint64
+	// Caller 发起变更的包外调用位置, 形如"main.handler (main.go:42)"
+	Caller string
+}
+
+// auditRing 固定大小的审计记录环形缓冲
+type auditRing struct {
+	entries []AuditEntry
+	next    int
+	full    bool
+}
+
+func (r *auditRing) add(e AuditEntry) {
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// EnableAudit 开启审计日志, 在内存中保留最近size条变更记录; 重复调用会清空已有记录
+func (table *CacheTable) EnableAudit(size int) {
+	if size < 1 {
+		size = 1
+	}
+	table.Lock()
+	defer table.Unlock()
+	table.audit = &auditRing{entries: make([]AuditEntry, size)}
+}
+
+// DisableAudit 关闭审计日志并丢弃记录
+func (table *CacheTable) DisableAudit() {
+	table.Lock()
+	defer table.Unlock()
+	table.audit = nil
+}
+
+// AuditLog 按时间顺序返回since之后(含)的审计记录; 未开启审计时返回nil
+func (table *CacheTable) AuditLog(since time.Time) []AuditEntry {
+	table.RLock()
+	defer table.RUnlock()
+	r := table.audit
+	if r == nil {
+		return nil
+	}
+	var ordered []AuditEntry
+	if r.full {
+		ordered = append(ordered, r.entries[r.next:]...)
+	}
+	ordered = append(ordered, r.entries[:r.next]...)
+
+	var out []AuditEntry
+	for _, e := range ordered {
+		if !e.Time.Before(since) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// recordAudit 记录一次变更, 调用方需持有表锁
+func (table *CacheTable) recordAudit(ctx context.Context, m *Mutation) {
+	table.audit.add(AuditEntry{
+		Seq:     m.Seq,
+		Time:    m.Time,
+		Actor:   ActorFromContext(ctx),
+		Op:      m.Op,
+		Key:     m.Key,
+		Version: m.Version,
+		Caller:  externalCaller(),
+	})
+}
+
+// externalCaller 返回调用栈中第一个不属于本包的调用位置
+func externalCaller() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, "cache.") || strings.HasSuffix(f.File, "_test.go") {
+			return fmt.Sprintf("%s (%s:%d)", f.Function, shortFile(f.File), f.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+func shortFile(path string) string {
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		return path[i+1:]
+	}
+	return path
+}
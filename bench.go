@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"math/rand"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BenchConfig Bench比较的配置组合, 零值字段使用默认值
+type BenchConfig struct {
+	// Policies 比较的淘汰策略, 默认只有EvictLRU
+	Policies []EvictionPolicy
+	// Shards 比较的分片数, 默认只有1. 分片时容量平均分给各分片, 键按DefaultHasher分配
+	Shards []int
+	// Capacity 所有分片的总容量, 默认为工作负载键数的1/10
+	Capacity int
+	// Concurrency 并发数, 默认为GOMAXPROCS
+	Concurrency int
+}
+
+// BenchWorkload 合成的工作负载
+type BenchWorkload struct {
+	Name string
+	// Keys 键空间大小
+	Keys int
+	// Ops 每种配置执行的总操作数
+	Ops int
+	// Zipf 大于1时键按参数为Zipf的zipf分布选取, 否则均匀分布
+	Zipf float64
+	// WriteRatio 写入占操作的比例, 其余为读取; 读取未命中时写入该键
+	WriteRatio float64
+	// Seed 随机数种子, 相同的种子生成相同的键序列
+	Seed int64
+}
+
+// StandardWorkloads Bench的标准工作负载, 用于调优和回归比较
+var StandardWorkloads = []BenchWorkload{
+	{Name: "zipf-read", Keys: 100000, Ops: 1000000, Zipf: 1.1, WriteRatio: 0.05, Seed: 1},
+	{Name: "zipf-write", Keys: 100000, Ops: 1000000, Zipf: 1.1, WriteRatio: 0.5, Seed: 2},
+	{Name: "uniform", Keys: 100000, Ops: 1000000, WriteRatio: 0.1, Seed: 3},
+}
+
+// BenchResult 一种配置在一个工作负载下的结果
+type BenchResult struct {
+	Workload string
+	Policy   EvictionPolicy
+	Shards   int
+	Ops      int
+	// Hits, Misses 读取的命中和未命中次数
+	Hits, Misses uint64
+	Elapsed      time.Duration
+}
+
+// HitRate 读取的命中率
+func (r BenchResult) HitRate() float64 {
+	if r.Hits+r.Misses == 0 {
+		return 0
+	}
+	return float64(r.Hits) / float64(r.Hits+r.Misses)
+}
+
+// OpsPerSec 吞吐量
+func (r BenchResult) OpsPerSec() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Ops) / r.Elapsed.Seconds()
+}
+
+// Bench 对config中淘汰策略和分片数的每种组合运行workload, 返回可相互比较的结果.
+// 每种组合使用新建的独立表(不加入注册表), 键序列由Seed决定; 并发交错会让命中率有少量波动
+func Bench(config BenchConfig, workload BenchWorkload) []BenchResult {
+	if len(config.Policies) == 0 {
+		config.Policies = []EvictionPolicy{EvictLRU}
+	}
+	if len(config.Shards) == 0 {
+		config.Shards = []int{1}
+	}
+	if config.Capacity <= 0 {
+		config.Capacity = workload.Keys/10 + 1
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = runtime.GOMAXPROCS(0)
+	}
+	streams := workload.streams(config.Concurrency)
+
+	var results []BenchResult
+	for _, p := range config.Policies {
+		for _, shards := range config.Shards {
+			results = append(results, benchRun(config, workload, streams, p, shards))
+		}
+	}
+	return results
+}
+
+// benchOp 预先生成的一个操作
+type benchOp struct {
+	key   string
+	write bool
+}
+
+// streams 为每个并发生成操作序列, 不计入耗时
+func (w BenchWorkload) streams(concurrency int) [][]benchOp {
+	streams := make([][]benchOp, concurrency)
+	keys := w.Keys
+	if keys < 1 {
+		keys = 1
+	}
+	for i := range streams {
+		rng := rand.New(rand.NewSource(w.Seed + int64(i)))
+		next := func() int { return rng.Intn(keys) }
+		if w.Zipf > 1 {
+			z := rand.NewZipf(rng, w.Zipf, 1, uint64(keys-1))
+			next = func() int { return int(z.Uint64()) }
+		}
+		n := w.Ops / concurrency
+		if i < w.Ops%concurrency {
+			n++
+		}
+		ops := make([]benchOp, n)
+		for j := range ops {
+			ops[j] = benchOp{key: "k" + strconv.Itoa(next()), write: rng.Float64() < w.WriteRatio}
+		}
+		streams[i] = ops
+	}
+	return streams
+}
+
+func benchRun(config BenchConfig, workload BenchWorkload, streams [][]benchOp, p EvictionPolicy, shards int) BenchResult {
+	if shards < 1 {
+		shards = 1
+	}
+	tables := make([]*CacheTable, shards)
+	for i := range tables {
+		capacity := config.Capacity / shards
+		if i < config.Capacity%shards {
+			capacity++
+		}
+		tables[i] = NewTable("bench-"+strconv.Itoa(i), WithEvictionPolicy(p), WithMaxItems(capacity))
+	}
+	shard := func(key string) *CacheTable { return tables[hashString(key)%uint64(shards)] }
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		res  = BenchResult{Workload: workload.Name, Policy: p, Shards: shards}
+		item = struct{}{}
+	)
+	start := time.Now()
+	for _, ops := range streams {
+		wg.Add(1)
+		go func(ops []benchOp) {
+			defer wg.Done()
+			var hits, misses uint64
+			for _, op := range ops {
+				table := shard(op.key)
+				if op.write {
+					table.Add(op.key, 0, item)
+					continue
+				}
+				if _, err := table.Value(op.key); err == nil {
+					hits++
+				} else {
+					misses++
+					table.Add(op.key, 0, item)
+				}
+			}
+			mu.Lock()
+			res.Ops += len(ops)
+			res.Hits += hits
+			res.Misses += misses
+			mu.Unlock()
+		}(ops)
+	}
+	wg.Wait()
+	res.Elapsed = time.Since(start)
+	for _, t := range tables {
+		t.Close(true)
+	}
+	return res
+}
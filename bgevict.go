@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// minBackgroundEvictInterval 后台淘汰的最小间隔, 速率较高时每次淘汰多个元素
+const minBackgroundEvictInterval = 10 * time.Millisecond
+
+// backgroundEviction 后台淘汰的状态
+type backgroundEviction struct {
+	interval time.Duration
+	// budget 每次最多淘汰的元素数
+	budget int
+	timer  Timer
+}
+
+// SetBackgroundEviction 开启后台淘汰: 写入不再同步淘汰元素, 由后台以每秒最多rate个的速率把超出容量的表
+// 淘汰到MaxItems(或LowWatermark), 期间元素数可能暂时超过MaxItems. rate<=0时关闭并立即同步淘汰
+func (table *CacheTable) SetBackgroundEviction(rate int) {
+	table.Lock()
+	defer table.Unlock()
+	table.setBackgroundEviction(rate)
+	if table.bgEvict == nil {
+		table.evict(context.Background(), nil, -1)
+	}
+}
+
+// WithBackgroundEviction 同SetBackgroundEviction
+func WithBackgroundEviction(rate int) Option {
+	return func(t *CacheTable) { t.setBackgroundEviction(rate) }
+}
+
+func (table *CacheTable) setBackgroundEviction(rate int) {
+	if table.bgEvict != nil && table.bgEvict.timer != nil {
+		table.bgEvict.timer.Stop()
+	}
+	if rate <= 0 {
+		table.bgEvict = nil
+		return
+	}
+	state := &backgroundEviction{interval: time.Second / time.Duration(rate), budget: 1}
+	if state.interval < minBackgroundEvictInterval {
+		state.interval = minBackgroundEvictInterval
+		state.budget = int(int64(rate) * int64(minBackgroundEvictInterval) / int64(time.Second))
+	}
+	table.bgEvict = state
+	state.timer = table.clock.AfterFunc(state.interval, func() {
+		table.labeled(context.Background(), opLabelEvict, func(context.Context) { table.backgroundEvictTick(state) })
+	})
+}
+
+// backgroundEvictTick 淘汰最多budget个元素, state已被替换或表已关闭时停止
+func (table *CacheTable) backgroundEvictTick(state *backgroundEviction) {
+	table.Lock()
+	defer table.Unlock()
+	if table.bgEvict != state || table.closed {
+		return
+	}
+	table.evict(context.Background(), nil, state.budget)
+	state.timer = table.clock.AfterFunc(state.interval, func() {
+		table.labeled(context.Background(), opLabelEvict, func(context.Context) { table.backgroundEvictTick(state) })
+	})
+}
@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bloomFilter 布隆过滤器, 用同一个键哈希做双重哈希得到k个位置; 不加锁
+type bloomFilter struct {
+	bits []uint64
+	k    int
+}
+
+// newBloomFilter 按预计的键数n和误判率p创建
+func newBloomFilter(n int, p float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	m := int(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := int(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), k: k}
+}
+
+func (b *bloomFilter) positions(h uint64, f func(i uint64) bool) bool {
+	m := uint64(len(b.bits)) * 64
+	h2 := mix64(h) | 1
+	for i := 0; i < b.k; i++ {
+		if !f((h + uint64(i)*h2) % m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bloomFilter) add(h uint64) {
+	b.positions(h, func(i uint64) bool {
+		b.bits[i/64] |= 1 << (i % 64)
+		return true
+	})
+}
+
+func (b *bloomFilter) contains(h uint64) bool {
+	return b.positions(h, func(i uint64) bool { return b.bits[i/64]&(1<<(i%64)) != 0 })
+}
+
+// MissingFilterConfig 不存在键过滤器的配置
+type MissingFilterConfig struct {
+	// Expected 每一代预计记录的键数
+	Expected int
+	// FalsePositive 误判率, 默认0.01; 误判的键在轮换前不会调用加载函数
+	FalsePositive float64
+	// Rotation 大于0时每隔Rotation换一代过滤器, 同时检查当前代和上一代, 记录的键最多2*Rotation后失效;
+	// 为0时不轮换, 记录的键在关闭过滤器或调用ResetMissingFilter前一直有效
+	Rotation time.Duration
+}
+
+// missingFilter 加载函数确认不存在的键. 布隆过滤器不能删除键, 之后写入的键在表中命中时不受影响,
+// 过期后直到轮换前都不会再调用加载函数
+type missingFilter struct {
+	mu       sync.Mutex
+	config   MissingFilterConfig
+	current  *bloomFilter
+	previous *bloomFilter
+	rotateAt time.Time
+	filtered uint64
+}
+
+// SetMissingFilter 开启不存在键过滤器: 加载函数未返回数据时记录该键, 之后Value未命中时先检查过滤器,
+// 命中则直接返回ErrKeyNotFoundOrLoadable, 不调用加载函数或其他节点. cfg为nil时关闭
+func (table *CacheTable) SetMissingFilter(cfg *MissingFilterConfig) {
+	table.Lock()
+	defer table.Unlock()
+	table.setMissingFilter(cfg)
+}
+
+// WithMissingFilter 同SetMissingFilter
+func WithMissingFilter(cfg MissingFilterConfig) Option {
+	return func(t *CacheTable) { t.setMissingFilter(&cfg) }
+}
+
+func (table *CacheTable) setMissingFilter(cfg *MissingFilterConfig) {
+	if cfg == nil {
+		table.missing = nil
+		return
+	}
+	f := &missingFilter{config: *cfg}
+	f.reset(table.clock.Now())
+	table.missing = f
+}
+
+// MarkMissing 把key记录为源数据中不存在的键, 开启了SetMissingFilter时有效
+func (table *CacheTable) MarkMissing(key interface{}) {
+	table.RLock()
+	f, now := table.missing, table.clock.Now()
+	table.RUnlock()
+	if f != nil {
+		f.add(canonicalKey(key), now)
+	}
+}
+
+// ResetMissingFilter 清空不存在键过滤器, 源数据新增了大量键时使用
+func (table *CacheTable) ResetMissingFilter() {
+	table.RLock()
+	f, now := table.missing, table.clock.Now()
+	table.RUnlock()
+	if f != nil {
+		f.mu.Lock()
+		f.reset(now)
+		f.mu.Unlock()
+	}
+}
+
+// reset 调用方需持有f.mu, 创建时除外
+func (f *missingFilter) reset(now time.Time) {
+	f.current = newBloomFilter(f.config.Expected, f.config.FalsePositive)
+	f.previous = nil
+	if f.config.Rotation > 0 {
+		f.rotateAt = now.Add(f.config.Rotation)
+	}
+}
+
+// rotate 到时间时换代, 调用方需持有f.mu
+func (f *missingFilter) rotate(now time.Time) {
+	if f.config.Rotation <= 0 || now.Before(f.rotateAt) {
+		return
+	}
+	if now.Sub(f.rotateAt) >= f.config.Rotation {
+		// 超过一整代没有访问, 上一代也已失效
+		f.reset(now)
+		return
+	}
+	f.previous = f.current
+	f.current = newBloomFilter(f.config.Expected, f.config.FalsePositive)
+	f.rotateAt = f.rotateAt.Add(f.config.Rotation)
+}
+
+func (f *missingFilter) add(key interface{}, now time.Time) {
+	h := hashKey(key)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rotate(now)
+	f.current.add(h)
+}
+
+// contains 键是否被记录为不存在, 命中时计数
+func (f *missingFilter) contains(key interface{}, now time.Time) bool {
+	h := hashKey(key)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rotate(now)
+	if f.current.contains(h) || (f.previous != nil && f.previous.contains(h)) {
+		atomic.AddUint64(&f.filtered, 1)
+		return true
+	}
+	return false
+}
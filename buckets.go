@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expiryBuckets 分桶过期: 元素按过期时间归入宽度为width的桶, 过期检查只访问到期的桶, 不扫描整表
+type expiryBuckets struct {
+	width   time.Duration
+	buckets map[int64]map[interface{}]struct{}
+	// ids 有元素的桶的最小堆, 可能含已清空的桶
+	ids bucketHeap
+}
+
+type bucketHeap []int64
+
+func (h bucketHeap) Len() int            { return len(h) }
+func (h bucketHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h bucketHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *bucketHeap) Push(x interface{}) { *h = append(*h, x.(int64)) }
+func (h *bucketHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// WithExpiryBuckets 开启分桶过期, 适合有效期只有少数几档的表. 元素按创建时间加有效期向上取整到width归入桶,
+// 过期检查整桶移除到期的元素, 不逐个比较整表元素的过期时间. 分桶模式下访问不延长有效期,
+// 元素最多晚width过期
+func WithExpiryBuckets(width time.Duration) Option {
+	return func(t *CacheTable) {
+		if width > 0 {
+			t.buckets = &expiryBuckets{width: width, buckets: make(map[int64]map[interface{}]struct{})}
+		}
+	}
+}
+
+// bucketOf 返回元素所在桶的编号, 桶在编号乘以width时到期
+func (b *expiryBuckets) bucketOf(item *CacheItem) int64 {
+	at := item.createdOn.Add(item.lifeSpan).UnixNano()
+	w := int64(b.width)
+	id := at / w
+	if at%w != 0 {
+		id++
+	}
+	return id
+}
+
+func (b *expiryBuckets) end(id int64) time.Time {
+	return time.Unix(0, id*int64(b.width))
+}
+
+// add 把元素加入桶, 调用方需持有表锁
+func (b *expiryBuckets) add(item *CacheItem) {
+	if item.lifeSpan <= 0 {
+		return
+	}
+	id := b.bucketOf(item)
+	keys, ok := b.buckets[id]
+	if !ok {
+		keys = make(map[interface{}]struct{})
+		b.buckets[id] = keys
+		heap.Push(&b.ids, id)
+	}
+	keys[item.key] = struct{}{}
+}
+
+// remove 把元素移出桶, 调用方需持有表锁
+func (b *expiryBuckets) remove(item *CacheItem) {
+	if item.lifeSpan <= 0 {
+		return
+	}
+	id := b.bucketOf(item)
+	if keys, ok := b.buckets[id]; ok {
+		delete(keys, item.key)
+		if len(keys) == 0 {
+			delete(b.buckets, id)
+		}
+	}
+}
+
+// reset 清空所有桶, 调用方需持有表锁
+func (b *expiryBuckets) reset() {
+	b.buckets = make(map[int64]map[interface{}]struct{})
+	b.ids = nil
+}
+
+// due 返回在now之前到期的桶中的键, 以及距下一个桶到期的时长(没有时为0).
+// 到期的桶留在堆中, 其中的元素被移除后桶被清空, 未能全部移除时(如MaxExpirePerSweep)下一次检查再处理
+func (b *expiryBuckets) due(now time.Time) ([]interface{}, time.Duration) {
+	var expired []interface{}
+	var dueIDs []int64
+	var next time.Duration
+	for b.ids.Len() > 0 {
+		id := heap.Pop(&b.ids).(int64)
+		keys, ok := b.buckets[id]
+		if !ok {
+			// 已清空
+			continue
+		}
+		if left := b.end(id).Sub(now); left > 0 {
+			heap.Push(&b.ids, id)
+			next = left
+			break
+		}
+		for key := range keys {
+			expired = append(expired, key)
+		}
+		dueIDs = append(dueIDs, id)
+	}
+	for _, id := range dueIDs {
+		heap.Push(&b.ids, id)
+	}
+	return expired, next
+}
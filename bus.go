@@ -0,0 +1,250 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"time"
+)
+
+// BusTransport 进程间广播消息的传输层, 如Redis pub/sub(redisbus包).
+// Subscribe在订阅建立后返回, 之后在ctx结束前把收到的每条消息(包括自己发布的)交给handle
+type BusTransport interface {
+	Publish(ctx context.Context, msg []byte) error
+	Subscribe(ctx context.Context, handle func(msg []byte)) error
+}
+
+// BusResubscriber 订阅断开后会自动重新订阅的传输层. 断开期间的消息已经丢失,
+// AttachBus在onResubscribe中清空本表(不发布), 之后的读取重新加载
+type BusResubscriber interface {
+	BusTransport
+	// SubscribeResubscribe 同Subscribe, 断开后重新订阅成功时调用onResubscribe
+	SubscribeResubscribe(ctx context.Context, handle func(msg []byte), onResubscribe func()) error
+}
+
+// BusMessage 总线上传输的一次变更
+type BusMessage struct {
+	// Origin 写入方的节点ID, 每个表首次AttachBus时随机生成; 变更时间相同时用于决定哪一方胜出
+	Origin string
+	Table  string
+	Op     MutationOp
+	Key    interface{}
+	// Time 变更发生的时间, 用于解决冲突: 接收方只用比本地元素更新的消息覆盖或删除本地元素
+	Time time.Time
+
+	// Replicated 为true时消息带有新值, 接收方写入而不是删除
+	Replicated bool
+	Value      interface{}
+	LifeSpan   time.Duration
+}
+
+// BusOption AttachBus的配置项
+type BusOption func(*busConfig)
+
+type busConfig struct {
+	replicate   bool
+	hotEntries  int
+	hotInterval time.Duration
+}
+
+// WithReplication 写入时发布新值, 其他进程直接更新副本而不是删除; 值以gob编码, 自定义类型需要事先gob.Register
+func WithReplication() BusOption {
+	return func(c *busConfig) { c.replicate = true }
+}
+
+type remoteKey struct{}
+
+// withRemote 标记ctx中的写入来自总线, 不再发布
+func withRemote(ctx context.Context) context.Context {
+	return context.WithValue(ctx, remoteKey{}, true)
+}
+
+func isRemote(ctx context.Context) bool {
+	return ctx != nil && ctx.Value(remoteKey{}) != nil
+}
+
+// WithHotEntries 每隔interval把访问次数最多的n个元素连同值发布一次, 使其他进程预先持有热点数据.
+// 值的编码要求同WithReplication
+func WithHotEntries(n int, interval time.Duration) BusOption {
+	return func(c *busConfig) { c.hotEntries, c.hotInterval = n, interval }
+}
+
+// AttachBus 通过transport在多个进程的同名表之间传播失效: 本地的写入、删除和清空发布到总线,
+// 收到其他进程的消息时删除本地的副本(清空时清空本表); 使用WithReplication时写入改为复制新值.
+// 冲突按时间解决: 本地元素的创建时间早于消息中的变更时间时才应用消息, 时间相同时节点ID较大的一方胜出,
+// 使各进程得到相同的结果; 各进程的时钟应大致同步.
+// 过期和淘汰只影响本地, 不发布. transport实现BusResubscriber时, 订阅断开重连后清空本表. 键以gob编码, 自定义类型需要事先gob.Register. 订阅建立后返回, 直到ctx结束
+func (table *CacheTable) AttachBus(ctx context.Context, transport BusTransport, opts ...BusOption) error {
+	var cfg busConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	table.Lock()
+	if table.busOrigin == "" {
+		table.busOrigin = newBusOrigin()
+	}
+	origin := table.busOrigin
+	table.Unlock()
+	name := table.Name()
+	handle := func(msg []byte) {
+		var m BusMessage
+		if err := gob.NewDecoder(bytes.NewReader(msg)).Decode(&m); err != nil {
+			table.RLock()
+			table.logEvent(LevelWarn, EventBus, nil, "Decoding bus message failed", "error", err)
+			table.RUnlock()
+			return
+		}
+		if m.Origin == origin || m.Table != name {
+			return
+		}
+		table.applyBusMessage(&m)
+	}
+	var err error
+	if r, ok := transport.(BusResubscriber); ok {
+		err = r.SubscribeResubscribe(ctx, handle, func() {
+			table.RLock()
+			table.logEvent(LevelWarn, EventBus, nil, "Bus subscription re-established, flushing possibly stale items")
+			table.RUnlock()
+			table.FlushContext(withRemote(context.Background()))
+		})
+	} else {
+		err = transport.Subscribe(ctx, handle)
+	}
+	if err != nil {
+		return err
+	}
+
+	// 在返回前订阅CDC, 之后的本地变更都会发布
+	mutations := table.CDC(ctx)
+	go func() {
+		for {
+			for m := range mutations {
+				if m.remote || m.Op == OpExpire || m.Op == OpEvict {
+					continue
+				}
+				msg := &BusMessage{Origin: origin, Table: name, Op: m.Op, Key: m.Key, Time: m.Time}
+				if cfg.replicate && (m.Op == OpAdd || m.Op == OpUpdate) {
+					msg.Replicated, msg.Value, msg.LifeSpan = true, m.Value, m.LifeSpan
+				}
+				table.publish(ctx, transport, msg)
+			}
+			if ctx.Err() != nil || table.Closed() {
+				return
+			}
+			// CDC订阅因落后被关闭, 遗漏的变更无法补发, 让其他进程清空副本
+			table.publish(ctx, transport, &BusMessage{Origin: origin, Table: name, Op: OpFlush, Time: table.now()})
+			mutations = table.CDC(ctx)
+		}
+	}()
+	if cfg.hotEntries > 0 && cfg.hotInterval > 0 {
+		go table.publishHotEntries(ctx, transport, origin, cfg.hotEntries, cfg.hotInterval)
+	}
+	return nil
+}
+
+// publishHotEntries 定期发布热点元素, 消息时间和Origin为元素的创建时间和写入方, 已持有相同或更新副本的进程会忽略
+func (table *CacheTable) publishHotEntries(ctx context.Context, transport BusTransport, origin string, n int, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		name := table.Name()
+		for _, item := range table.MostAccessed(int64(n)) {
+			item.RLock()
+			msg := &BusMessage{
+				Origin:     origin,
+				Table:      name,
+				Op:         OpUpdate,
+				Key:        item.key,
+				Time:       item.createdOn,
+				Replicated: true,
+				Value:      item.value,
+				LifeSpan:   item.lifeSpan,
+			}
+			if item.origin != "" {
+				// 转发的副本保留写入方, 接收方才能与同一时间的其他写入比较
+				msg.Origin = item.origin
+			}
+			item.RUnlock()
+			table.publish(ctx, transport, msg)
+		}
+	}
+}
+
+// applyBusMessage 应用其他进程发布的变更, 本地元素不比消息旧时忽略消息
+func (table *CacheTable) applyBusMessage(m *BusMessage) {
+	ctx := withRemote(context.Background())
+	if m.Op == OpFlush {
+		table.FlushContext(ctx)
+		return
+	}
+
+	table.Lock()
+	if table.closed {
+		table.Unlock()
+		return
+	}
+	if cur, ok := table.items.get(m.Key); ok && !m.Time.IsZero() && !table.busWins(m, cur) {
+		table.Unlock()
+		return
+	}
+	// 本地拒绝的副本按失效处理
+	if m.Replicated && (m.Op == OpAdd || m.Op == OpUpdate) && table.admit(m.Key, m.Value, m.LifeSpan) == nil {
+		item := newCacheItem(m.Key, m.LifeSpan, m.Value, table.clock.Now())
+		if !m.Time.IsZero() {
+			// 副本保留原始的创建时间和写入方, 后续消息才能与之比较
+			item.createdOn = m.Time
+		}
+		item.origin = m.Origin
+		table.addInternal(ctx, item)
+		return
+	}
+	table.deleteInternal(ctx, m.Key, OpDelete)
+	table.Unlock()
+}
+
+// busWins 消息是否比本地元素cur新: 比较变更时间, 时间相同时比较节点ID. 没有Origin的消息(如Rebalance转移)
+// 在时间相同时不覆盖本地元素; 调用方需持有表锁
+func (table *CacheTable) busWins(m *BusMessage, cur *CacheItem) bool {
+	cur.RLock()
+	created, origin := cur.createdOn, cur.origin
+	cur.RUnlock()
+	if !m.Time.Equal(created) {
+		return m.Time.After(created)
+	}
+	if origin == "" {
+		origin = table.busOrigin
+	}
+	return m.Origin > origin
+}
+
+func (table *CacheTable) publish(ctx context.Context, transport BusTransport, m *BusMessage) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(m)
+	if err != nil && m.Replicated {
+		// 值无法编码时退回为失效
+		m.Replicated, m.Value, m.LifeSpan = false, nil, 0
+		buf.Reset()
+		err = gob.NewEncoder(&buf).Encode(m)
+	}
+	if err == nil {
+		err = transport.Publish(ctx, buf.Bytes())
+	}
+	if err != nil && ctx.Err() == nil {
+		table.RLock()
+		table.logEvent(LevelError, EventBus, m.Key, "Publishing to bus failed", "error", err)
+		table.RUnlock()
+	}
+}
+
+func newBusOrigin() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
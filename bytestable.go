@@ -0,0 +1,308 @@
+package cache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BytesTable的默认配置
+const (
+	DefaultBytesShards      = 64
+	DefaultBytesSegmentSize = 1 << 20
+)
+
+// 条目头: 总长度(4) + 写入时间(8) + 键的哈希(8) + 键长度(2)
+const bytesHeaderSize = 4 + 8 + 8 + 2
+
+// BytesConfig NewBytesTable的配置
+type BytesConfig struct {
+	// Shards 分片数, 向上取整为2的幂, 默认DefaultBytesShards
+	Shards int
+	// SegmentSize 每个分片的环形缓冲区字节数, 默认DefaultBytesSegmentSize; 总内存约为Shards*SegmentSize
+	SegmentSize int
+	// TTL 大于0时条目写入后经过TTL过期
+	TTL time.Duration
+	// Clock 为nil时使用系统时钟
+	Clock Clock
+}
+
+// BytesStats BytesTable的统计
+type BytesStats struct {
+	Entries   int
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// BytesTable 键为字符串、值为[]byte的专用表. 条目连续存放在每个分片预先分配的环形缓冲区中,
+// 索引为map[uint64]uint32, 不含指针, 条目数量多时也不会增加GC扫描的开销. 适合缓存序列化后的数据.
+//
+// 与CacheTable不同: 缓冲区写满时按写入顺序淘汰最旧的条目(不是LRU), 读取不续期,
+// 覆盖和删除的空间在缓冲区循环到该位置时才回收, 没有回调、加载函数和持久化.
+// 哈希冲突时后写入的键覆盖先写入的键
+type BytesTable struct {
+	shards []*bytesShard
+	mask   uint64
+	ttl    time.Duration
+	clock  Clock
+
+	hits   uint64
+	misses uint64
+}
+
+// bytesShard 一个分片. 有效数据在wrapped为false时是[head, tail), 否则是[head, wrapAt)和[0, tail)
+type bytesShard struct {
+	mu        sync.RWMutex
+	index     map[uint64]uint32
+	buf       []byte
+	head      int
+	tail      int
+	wrapAt    int
+	wrapped   bool
+	evictions uint64
+}
+
+// NewBytesTable 按cfg创建BytesTable, 缓冲区在创建时一次分配
+func NewBytesTable(cfg BytesConfig) *BytesTable {
+	n := cfg.Shards
+	if n <= 0 {
+		n = DefaultBytesShards
+	}
+	shards := 1
+	for shards < n {
+		shards <<= 1
+	}
+	size := cfg.SegmentSize
+	if size <= 0 {
+		size = DefaultBytesSegmentSize
+	}
+	clock := cfg.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	t := &BytesTable{
+		shards: make([]*bytesShard, shards),
+		mask:   uint64(shards - 1),
+		ttl:    cfg.TTL,
+		clock:  clock,
+	}
+	for i := range t.shards {
+		t.shards[i] = &bytesShard{index: make(map[uint64]uint32), buf: make([]byte, size)}
+	}
+	return t
+}
+
+func (t *BytesTable) shard(hash uint64) *bytesShard {
+	return t.shards[hash&t.mask]
+}
+
+// Set 写入key, value会被复制. 条目超过分片大小或键超过64KB时返回ErrValueTooLarge
+func (t *BytesTable) Set(key string, value []byte) error {
+	if len(key) > 1<<16-1 {
+		return fmt.Errorf("%w: key length %d", ErrValueTooLarge, len(key))
+	}
+	hash := hashString(key)
+	s := t.shard(hash)
+	need := bytesHeaderSize + len(key) + len(value)
+	if need > len(s.buf) {
+		return fmt.Errorf("%w: %d > %d", ErrValueTooLarge, need, len(s.buf))
+	}
+	now := t.clock.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.ttl > 0 {
+		s.dropExpired(now.Add(-t.ttl))
+	}
+	off := s.reserve(need)
+	e := s.buf[off : off+need]
+	binary.LittleEndian.PutUint32(e[0:], uint32(need))
+	binary.LittleEndian.PutUint64(e[4:], uint64(now.UnixNano()))
+	binary.LittleEndian.PutUint64(e[12:], hash)
+	binary.LittleEndian.PutUint16(e[20:], uint16(len(key)))
+	copy(e[bytesHeaderSize:], key)
+	copy(e[bytesHeaderSize+len(key):], value)
+	s.index[hash] = uint32(off)
+	return nil
+}
+
+// Get 返回key的值的副本
+func (t *BytesTable) Get(key string) ([]byte, bool) {
+	hash := hashString(key)
+	s := t.shard(hash)
+	s.mu.RLock()
+	value, ok := s.get(hash, key, t.expiredBefore())
+	s.mu.RUnlock()
+	if ok {
+		atomic.AddUint64(&t.hits, 1)
+	} else {
+		atomic.AddUint64(&t.misses, 1)
+	}
+	return value, ok
+}
+
+// Exists key是否存在且未过期, 不计入命中统计
+func (t *BytesTable) Exists(key string) bool {
+	hash := hashString(key)
+	s := t.shard(hash)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.lookup(hash, key, t.expiredBefore())
+	return ok
+}
+
+// Delete 删除key, 返回key是否存在
+func (t *BytesTable) Delete(key string) bool {
+	hash := hashString(key)
+	s := t.shard(hash)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.lookup(hash, key, time.Time{}); !ok {
+		return false
+	}
+	delete(s.index, hash)
+	return true
+}
+
+// Len 返回条目数, 包含已过期但还未回收的条目
+func (t *BytesTable) Len() int {
+	n := 0
+	for _, s := range t.shards {
+		s.mu.RLock()
+		n += len(s.index)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// Reset 清空所有条目, 保留已分配的缓冲区
+func (t *BytesTable) Reset() {
+	for _, s := range t.shards {
+		s.mu.Lock()
+		s.index = make(map[uint64]uint32)
+		s.head, s.tail, s.wrapAt, s.wrapped = 0, 0, 0, false
+		s.mu.Unlock()
+	}
+}
+
+// Stats 返回统计快照
+func (t *BytesTable) Stats() BytesStats {
+	st := BytesStats{
+		Hits:   atomic.LoadUint64(&t.hits),
+		Misses: atomic.LoadUint64(&t.misses),
+	}
+	for _, s := range t.shards {
+		s.mu.RLock()
+		st.Entries += len(s.index)
+		st.Evictions += s.evictions
+		s.mu.RUnlock()
+	}
+	return st
+}
+
+// expiredBefore 返回过期的写入时间界限, 不过期时为零值
+func (t *BytesTable) expiredBefore() time.Time {
+	if t.ttl <= 0 {
+		return time.Time{}
+	}
+	return t.clock.Now().Add(-t.ttl)
+}
+
+// lookup 返回key的条目偏移, 条目不晚于before写入时视为已过期; 调用方需持有分片锁
+func (s *bytesShard) lookup(hash uint64, key string, before time.Time) (int, bool) {
+	o, ok := s.index[hash]
+	if !ok {
+		return 0, false
+	}
+	off := int(o)
+	e := s.buf[off:]
+	keyLen := int(binary.LittleEndian.Uint16(e[20:]))
+	if string(e[bytesHeaderSize:bytesHeaderSize+keyLen]) != key {
+		return 0, false
+	}
+	if !before.IsZero() && int64(binary.LittleEndian.Uint64(e[4:])) <= before.UnixNano() {
+		return 0, false
+	}
+	return off, true
+}
+
+// get 返回key的值的副本; 调用方需持有分片锁
+func (s *bytesShard) get(hash uint64, key string, before time.Time) ([]byte, bool) {
+	off, ok := s.lookup(hash, key, before)
+	if !ok {
+		return nil, false
+	}
+	e := s.buf[off:]
+	size := int(binary.LittleEndian.Uint32(e))
+	start := bytesHeaderSize + len(key)
+	value := make([]byte, size-start)
+	copy(value, e[start:size])
+	return value, true
+}
+
+// reserve 在tail处预留n字节, 空间不足时从head起淘汰最旧的条目, 返回预留的偏移; 调用方需持有分片写锁
+func (s *bytesShard) reserve(n int) int {
+	for {
+		if len(s.index) == 0 && s.head == s.tail && !s.wrapped {
+			s.head, s.tail = 0, 0
+		}
+		if !s.wrapped {
+			if len(s.buf)-s.tail >= n {
+				break
+			}
+			// 末尾放不下, 回到缓冲区开头
+			s.wrapAt, s.tail, s.wrapped = s.tail, 0, true
+			continue
+		}
+		if s.head-s.tail >= n {
+			break
+		}
+		s.evictHead()
+	}
+	off := s.tail
+	s.tail += n
+	return off
+}
+
+// evictHead 回收head处最旧的条目, 仍在索引中时计入淘汰; 调用方需持有分片写锁
+func (s *bytesShard) evictHead() {
+	if s.wrapped && s.head == s.wrapAt {
+		s.head, s.wrapped = 0, false
+		return
+	}
+	e := s.buf[s.head:]
+	size := int(binary.LittleEndian.Uint32(e))
+	hash := binary.LittleEndian.Uint64(e[12:])
+	if o, ok := s.index[hash]; ok && int(o) == s.head {
+		delete(s.index, hash)
+		s.evictions++
+	}
+	s.head += size
+	if s.wrapped && s.head == s.wrapAt {
+		s.head, s.wrapped = 0, false
+	}
+}
+
+// dropExpired 从head起回收不晚于before写入的条目; 条目按写入顺序排列, 遇到未过期的条目即停止.
+// 调用方需持有分片写锁
+func (s *bytesShard) dropExpired(before time.Time) {
+	for s.wrapped || s.head < s.tail {
+		if s.wrapped && s.head == s.wrapAt {
+			s.head, s.wrapped = 0, false
+			continue
+		}
+		e := s.buf[s.head:]
+		if int64(binary.LittleEndian.Uint64(e[4:])) > before.UnixNano() {
+			return
+		}
+		size := int(binary.LittleEndian.Uint32(e))
+		hash := binary.LittleEndian.Uint64(e[12:])
+		if o, ok := s.index[hash]; ok && int(o) == s.head {
+			delete(s.index, hash)
+		}
+		s.head += size
+	}
+}
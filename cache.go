@@ -1,29 +1,192 @@
 package cache
 
 import (
+	"context"
+	"errors"
+	"sort"
 	"sync"
 )
 
 var (
 	cache = make(map[string]*CacheTable)
-	mutex sync.RWMutex
+	// aliases 别名 -> 表名
+	aliases = make(map[string]string)
+	mutex   sync.RWMutex
 )
 
-func Cache(name string) *CacheTable {
+// resolve 把别名解析为表名, 调用方需持有mutex
+func resolve(name string) string {
+	if target, ok := aliases[name]; ok {
+		return target
+	}
+	return name
+}
+
+// Cache 返回名为name(或别名为name)的表, 不存在时用opts创建并注册; 表已存在时忽略opts.
+// 表在注册表锁之外创建, 插件的OnTableCreate等钩子中可以调用注册表的函数; 并发创建同名表时只注册一个, 其余的被关闭
+func Cache(name string, opts ...Option) *CacheTable {
+	t, _ := lookupOrCreate(name, opts...)
+	return t
+}
+
+// lookupOrCreate 同Cache, created表示表是否由本次调用创建(已应用opts)
+func lookupOrCreate(name string, opts ...Option) (t *CacheTable, created bool) {
 	mutex.RLock()
-	t, ok := cache[name]
+	resolved := resolve(name)
+	t, ok := cache[resolved]
 	mutex.RUnlock()
+	if ok {
+		return t, false
+	}
+
+	fresh := NewTable(resolved, opts...)
+	mutex.Lock()
+	name = resolve(name)
+	t, ok = cache[name]
+	if !ok && name == resolved {
+		t = fresh
+		cache[name] = t
+	}
+	mutex.Unlock()
+	if t != fresh {
+		fresh.Close(false)
+		if t == nil {
+			// 创建期间name成为了其他表的别名
+			return lookupOrCreate(name, opts...)
+		}
+		return t, false
+	}
+	return t, true
+}
+
+// Tables 返回已注册的所有表名(不含别名), 按名称排序
+func Tables() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	names := make([]string, 0, len(cache))
+	for name := range cache {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Exists 是否已注册名为name(或别名为name)的表, 不会创建表
+func Exists(name string) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	_, ok := cache[resolve(name)]
+	return ok
+}
+
+// Remove 关闭并清空名为name(或别名为name)的表, 然后移除该表及其所有别名; 表不存在时返回false
+func Remove(name string) bool {
+	mutex.Lock()
+	name = resolve(name)
+	t, ok := cache[name]
+	unregister(name)
+	mutex.Unlock()
+	if ok {
+		t.Close(true)
+	}
+	return ok
+}
+
+// unregister 移除表和指向它的别名, 调用方需持有mutex
+func unregister(name string) {
+	delete(cache, name)
+	for alias, target := range aliases {
+		if target == name {
+			delete(aliases, alias)
+		}
+	}
+}
+
+// Rename 把表oldName改名为newName, 指向旧名的别名随之指向新名. 不复制元素
+func Rename(oldName, newName string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	t, ok := cache[oldName]
 	if !ok {
+		return ErrTableNotFound
+	}
+	if _, exists := cache[newName]; exists {
+		return ErrTableExists
+	}
+	if _, exists := aliases[newName]; exists {
+		return ErrTableExists
+	}
+
+	delete(cache, oldName)
+	cache[newName] = t
+	for alias, target := range aliases {
+		if target == oldName {
+			aliases[alias] = newName
+		}
+	}
+
+	t.Lock()
+	t.name = newName
+	t.Unlock()
+	return nil
+}
+
+// Alias 注册别名alias指向表target, 之后Cache(alias)返回同一个表; alias已是别名时改为指向target
+func Alias(alias, target string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	target = resolve(target)
+	if _, ok := cache[target]; !ok {
+		return ErrTableNotFound
+	}
+	if _, exists := cache[alias]; exists {
+		return ErrTableExists
+	}
+	aliases[alias] = target
+	return nil
+}
+
+// Unalias 移除别名, 别名不存在时返回false
+func Unalias(alias string) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, ok := aliases[alias]
+	delete(aliases, alias)
+	return ok
+}
+
+// registered 返回注册表的副本
+func registered() map[string]*CacheTable {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	tables := make(map[string]*CacheTable, len(cache))
+	for name, t := range cache {
+		tables[name] = t
+	}
+	return tables
+}
+
+// FlushAll 清空所有已注册的表
+func FlushAll() {
+	for _, t := range registered() {
+		t.Flush()
+	}
+}
+
+// CloseAll 依次对所有已注册的表调用Shutdown(等待加载完成、写入快照并关闭), 然后清空注册表.
+// ctx结束后不再等待加载, 但仍会关闭剩余的所有表, 返回的错误包含ctx.Err(); 持久化失败的表仍会关闭, 错误合并后返回
+func CloseAll(ctx context.Context) error {
+	var errs []error
+	for name, t := range registered() {
+		if err := t.Shutdown(ctx); err != nil && !errors.Is(err, ErrTableClosed) {
+			errs = append(errs, err)
+		}
+
 		mutex.Lock()
-		t, ok = cache[name]
-		if !ok {
-			t = &CacheTable{
-				name:  name,
-				items: make(map[interface{}]*CacheItem),
-			}
-			cache[name] = t
+		if cache[name] == t {
+			unregister(name)
 		}
 		mutex.Unlock()
 	}
-	return t
+	return errors.Join(errs...)
 }
@@ -1,6 +1,25 @@
 package cache
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -50,4 +69,3445 @@ func TestExists(t *testing.T) {
 	if !table.Exists(k) {
 		t.Error("Error verifying existing data in cache")
 	}
-}
\ No newline at end of file
+}
+func TestLoaderLatency(t *testing.T) {
+	table := Cache("testLoaderLatency")
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		if key == "missing" {
+			return nil
+		}
+		time.Sleep(2 * time.Millisecond)
+		return NewCacheItem(key, 0, v)
+	})
+	if _, err := table.Value(k); err != nil {
+		t.Error("Error loading data", err)
+	}
+	if _, err := table.Value("missing"); !errors.Is(err, ErrKeyNotFoundOrLoadable) {
+		t.Error("Expected ErrKeyNotFoundOrLoadable, got", err)
+	}
+
+	s := table.Stats()
+	if s.LoaderLatency.Count != 2 || s.LoaderFailures != 1 {
+		t.Error("Error counting loader calls", s.LoaderLatency.Count, s.LoaderFailures)
+	}
+	if s.LoaderLatency.P99 < 2*time.Millisecond || s.LoaderLatency.P50 > s.LoaderLatency.P99 {
+		t.Error("Error computing loader percentiles", s.LoaderLatency)
+	}
+}
+
+func TestHitRate(t *testing.T) {
+	table := Cache("testHitRate")
+	table.Add(k, 0, v)
+	for i := 0; i < 3; i++ {
+		table.Value(k)
+	}
+	table.Value("missing")
+
+	if r := table.HitRate(2 * time.Minute); r != 0.75 {
+		t.Error("Error computing hit rate", r)
+	}
+	if s := table.Stats(); s.Hits != 3 || s.Misses != 1 {
+		t.Error("Error counting hits and misses", s.Hits, s.Misses)
+	}
+}
+
+func TestColdItems(t *testing.T) {
+	table := Cache("testColdItems")
+	table.Add(k+"_1", 0, v)
+	time.Sleep(10 * time.Millisecond)
+	table.Add(k+"_2", 0, v)
+	table.Value(k + "_2")
+
+	if items := table.LeastAccessed(1); len(items) != 1 || items[0].Key() != k+"_1" {
+		t.Error("Error finding least accessed item")
+	}
+	if items := table.OldestItems(2); len(items) != 2 || items[0].Key() != k+"_1" {
+		t.Error("Error finding oldest items")
+	}
+	if items := table.IdleLongerThan(5 * time.Millisecond); len(items) != 1 || items[0].Key() != k+"_1" {
+		t.Error("Error finding idle items")
+	}
+	if table.LeastAccessed(0) != nil || table.OldestItems(0) != nil || table.MostAccessed(0) != nil {
+		t.Error("Error returning items for zero count")
+	}
+}
+
+func TestMostAccessed(t *testing.T) {
+	table := Cache("testMostAccessed")
+	for i := 0; i < 10; i++ {
+		key := k + string(rune('0'+i))
+		table.Add(key, 0, v)
+		for j := 0; j < i; j++ {
+			table.Value(key)
+		}
+	}
+
+	items := table.MostAccessed(3)
+	if len(items) != 3 || items[0].AccessCount() != 9 || items[2].AccessCount() != 7 {
+		t.Error("Error finding most accessed items")
+	}
+	pairs := table.MostAccessedPairs(MostAccessedOptions{Offset: 2, Limit: 2})
+	if len(pairs) != 2 || pairs[0].AccessCount != 7 || pairs[1].AccessCount != 6 {
+		t.Error("Error paginating most accessed pairs", pairs)
+	}
+	if pairs := table.MostAccessedPairs(MostAccessedOptions{LastAccessedSince: time.Now().Add(time.Hour)}); len(pairs) != 0 {
+		t.Error("Error filtering most accessed pairs by time", pairs)
+	}
+}
+
+func TestHeaviest(t *testing.T) {
+	table := Cache("testHeaviest")
+	table.Add(k+"_small", 0, "x")
+	table.Add(k+"_big", 0, make([]byte, 1024))
+	table.Add(k+"_map", 0, map[string]string{"a": "b"})
+
+	items := table.Heaviest(1)
+	if len(items) != 1 || items[0].Key() != k+"_big" || items[0].Size() < 1024 {
+		t.Error("Error finding heaviest item")
+	}
+
+	table.SetSizer(func(key, value interface{}) int64 { return 1 })
+	table.Add(k+"_small", 0, "y")
+	if s := table.Stats(); s.Items != 3 || s.Size < 1025 {
+		t.Error("Error tracking table size", s.Size)
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	table := Cache("testSlogLogger")
+	table.SetSlogLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	table.Add(k, 0, v)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal("Error decoding log entry", err)
+	}
+	if entry["table"] != "testSlogLogger" || entry["event"] != "add" || entry["key"] != k {
+		t.Error("Error logging structured fields", entry)
+	}
+}
+
+// sugaredLogger 记录*w调用的SugaredLogger
+type sugaredLogger struct {
+	Logger
+	calls []string
+}
+
+func (s *sugaredLogger) Debugw(msg string, kv ...interface{}) { s.log("debug", msg, kv) }
+func (s *sugaredLogger) Infow(msg string, kv ...interface{})  { s.log("info", msg, kv) }
+func (s *sugaredLogger) Warnw(msg string, kv ...interface{})  { s.log("warn", msg, kv) }
+func (s *sugaredLogger) Errorw(msg string, kv ...interface{}) { s.log("error", msg, kv) }
+
+func (s *sugaredLogger) log(level, msg string, kv []interface{}) {
+	s.calls = append(s.calls, fmt.Sprint(level, " ", msg, " ", kv))
+}
+
+func TestZapLogger(t *testing.T) {
+	sugared := &sugaredLogger{Logger: StdLogger(log.New(io.Discard, "", 0))}
+	table := Cache("testZapLogger")
+	table.SetLeveledLogger(ZapLogger(sugared))
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem { return nil })
+	table.Value(k)
+
+	if len(sugared.calls) != 1 || !strings.HasPrefix(sugared.calls[0], "error Loading item failed [table testZapLogger event load_failed") {
+		t.Error("Error logging structured fields through zap", sugared.calls)
+	}
+}
+
+func TestLeveledLogger(t *testing.T) {
+	var buf bytes.Buffer
+	table := Cache("testLeveledLogger")
+	table.SetLogger(log.New(&buf, "", 0))
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem { return nil })
+	table.Value(k)
+
+	if !strings.HasPrefix(buf.String(), "ERROR Loading item failed table=testLeveledLogger event=load_failed key="+k) {
+		t.Error("Error logging load failure", buf.String())
+	}
+}
+
+func TestLogRateLimit(t *testing.T) {
+	var buf bytes.Buffer
+	table := Cache("testLogRateLimit")
+	table.SetLogger(log.New(&buf, "", 0))
+	table.SetLogRateLimit(EventAdd, 2)
+	for i := 0; i < 5; i++ {
+		table.Add(i, 0, v)
+	}
+	if n := strings.Count(buf.String(), "event=add"); n != 2 {
+		t.Error("Expected 2 rate limited log lines, got", n)
+	}
+
+	buf.Reset()
+	table.SetLogRateLimit(EventAdd, 0)
+	table.SetLogSampling(EventAdd, 2)
+	for i := 0; i < 4; i++ {
+		table.Add(i, 0, v)
+	}
+	if n := strings.Count(buf.String(), "event=add"); n != 2 {
+		t.Error("Expected 2 sampled log lines, got", n)
+	}
+}
+
+// manualClock 从不触发定时器的时钟, 过期只能通过RunExpirationNow执行
+type manualClock struct {
+	now time.Time
+}
+
+type nopTimer struct{}
+
+func (nopTimer) Stop() bool { return false }
+
+func (c *manualClock) Now() time.Time                            { return c.now }
+func (c *manualClock) Since(t time.Time) time.Duration           { return c.now.Sub(t) }
+func (c *manualClock) AfterFunc(d time.Duration, f func()) Timer { return nopTimer{} }
+
+func TestRunExpirationNow(t *testing.T) {
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	table := Cache("testRunExpirationNow")
+	table.SetClock(clock)
+	table.Add(k+"_1", time.Second, v)
+	table.Add(k+"_2", time.Second, v)
+	table.Add(k+"_3", time.Hour, v)
+
+	if n := table.RunExpirationNow(); n != 0 {
+		t.Error("Expected no expired items, got", n)
+	}
+	clock.now = clock.now.Add(2 * time.Second)
+	if n := table.RunExpirationNow(); n != 2 || table.Count() != 1 {
+		t.Error("Expected 2 expired items, got", n, table.Count())
+	}
+}
+
+func TestClose(t *testing.T) {
+	table := Cache("testClose")
+	table.Add(k, time.Hour, v)
+	if _, err := table.Delete(k); err != nil {
+		t.Error("Error deleting item", err)
+	}
+	table.Add(k, time.Hour, v)
+
+	if err := table.Close(true); err != nil {
+		t.Fatal("Error closing table", err)
+	}
+	if err := table.Close(true); err != ErrTableClosed {
+		t.Error("Expected ErrTableClosed on second close, got", err)
+	}
+	if table.Count() != 0 || table.Add(k, 0, v) != nil || table.NotFoundAdd(k, 0, v) {
+		t.Error("Closed table accepted items")
+	}
+	if _, err := table.Value(k); err != ErrTableClosed {
+		t.Error("Expected ErrTableClosed, got", err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	table := Cache("testRegistry")
+	if !Exists("testRegistry") || Exists("testRegistryMissing") {
+		t.Error("Error checking registered tables")
+	}
+	found := false
+	for _, name := range Tables() {
+		found = found || name == "testRegistry"
+	}
+	if !found {
+		t.Error("Error listing registered tables")
+	}
+
+	if !Remove("testRegistry") || Remove("testRegistry") {
+		t.Error("Error removing table")
+	}
+	if Exists("testRegistry") || !table.Closed() {
+		t.Error("Removed table still registered or open")
+	}
+	if Cache("testRegistry") == table {
+		t.Error("Expected a new table after removal")
+	}
+}
+
+func TestCloseAll(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.gob")
+	table := Cache("testCloseAll")
+	table.SetSnapshotFile(path)
+	table.Add(k, time.Hour, v)
+
+	if err := CloseAll(context.Background()); err != nil {
+		t.Fatal("Error closing all tables", err)
+	}
+	if !table.Closed() || len(Tables()) != 0 {
+		t.Error("Error closing registered tables")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal("Snapshot not written", err)
+	}
+	defer f.Close()
+	restored := Cache("testCloseAllRestored")
+	if n, err := restored.LoadSnapshot(f); err != nil || n != 1 {
+		t.Fatal("Error loading snapshot", n, err)
+	}
+	if p, err := restored.Value(k); err != nil || p.Value() != v || p.LifeSpan() != time.Hour {
+		t.Error("Error restoring item from snapshot", err)
+	}
+
+	a, b := Cache("testCloseAllA"), Cache("testCloseAllB")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := CloseAll(ctx); !errors.Is(err, context.Canceled) {
+		t.Error("Expected context.Canceled from CloseAll, got", err)
+	}
+	if !a.Closed() || !b.Closed() || len(Tables()) != 0 {
+		t.Error("Error closing remaining tables after ctx ended")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	table := NewTable("testOptions",
+		WithMaxItems(2),
+		WithDefaultTTL(time.Minute),
+		WithClock(clock),
+		WithEvictionPolicy(EvictLRU))
+	if Exists("testOptions") {
+		t.Error("NewTable registered the table")
+	}
+
+	table.Set(k+"_1", v)
+	clock.now = clock.now.Add(time.Second)
+	table.Set(k+"_2", v)
+	clock.now = clock.now.Add(time.Second)
+	table.Value(k + "_1")
+	table.Set(k+"_3", v)
+
+	if table.Count() != 2 || table.Exists(k+"_2") || !table.Exists(k+"_1") {
+		t.Error("Error evicting least recently used item")
+	}
+	if p, _ := table.Value(k + "_3"); p.LifeSpan() != time.Minute {
+		t.Error("Error applying default TTL")
+	}
+	if s := table.Stats(); s.Evictions != 1 {
+		t.Error("Error counting evictions", s.Evictions)
+	}
+}
+
+func TestNewTableIsolated(t *testing.T) {
+	for i := 0; i < 4; i++ {
+		t.Run(string(rune('a'+i)), func(t *testing.T) {
+			t.Parallel()
+			// 同名的独立表互不影响
+			table := NewTable("testNewTableIsolated")
+			table.Add(k, 0, v)
+			if table.Count() != 1 || Exists("testNewTableIsolated") {
+				t.Error("Error isolating standalone tables")
+			}
+		})
+	}
+}
+
+func TestConfigureFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.yaml")
+	config := `
+tables:
+  - name: testConfigure
+    default_ttl: 5m
+    max_items: 1
+    eviction_policy: fifo
+`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConfigureFromFile(path); err != nil {
+		t.Fatal("Error loading config", err)
+	}
+
+	table := Cache("testConfigure")
+	table.Set(k+"_1", v)
+	table.Set(k+"_2", v)
+	if table.Count() != 1 || !table.Exists(k+"_2") {
+		t.Error("Error applying max items and eviction policy")
+	}
+	if p, _ := table.Value(k + "_2"); p.LifeSpan() != 5*time.Minute {
+		t.Error("Error applying default TTL")
+	}
+
+	// 重新配置已有数据的表: 已有的键登记到新的Evictor, 并按新的容量淘汰
+	existing := Cache("testConfigureExisting")
+	for i := 0; i < 4; i++ {
+		existing.Add(i, 0, v)
+	}
+	err := Configure(Config{Tables: []TableConfig{{Name: "testConfigureExisting", MaxItems: 2, EvictionPolicy: Evict2Q}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if existing.Count() != 2 {
+		t.Error("Error trimming reconfigured table to capacity", existing.Count())
+	}
+	existing.Add("new", 0, v)
+	existing.Add("newer", 0, v)
+	if existing.Count() != 2 || !existing.Exists("newer") {
+		t.Error("Error evicting keys added before reconfiguring", existing.Count())
+	}
+}
+
+func TestSettings(t *testing.T) {
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	table := NewTable("testSettings", WithClock(clock))
+	for i := 0; i < 3; i++ {
+		table.Add(i, 0, v)
+	}
+	table.SetSettings(Settings{MaxItems: 2})
+	if table.Count() != 2 {
+		t.Error("Error trimming table after lowering max items", table.Count())
+	}
+
+	loaded := make(chan interface{}, 1)
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		loaded <- key
+		return NewCacheItem(key, time.Minute, "fresh")
+	})
+	table.UpdateSettings(func(s *Settings) { s.RefreshAheadFraction = 0.5 })
+	table.Add(k, time.Minute, v)
+
+	clock.now = clock.now.Add(29 * time.Second)
+	table.Value(k)
+	clock.now = clock.now.Add(2 * time.Second)
+	if p, _ := table.Value(k); p.Value() != v {
+		t.Error("Expected stale value while refreshing")
+	}
+	select {
+	case key := <-loaded:
+		if key != k {
+			t.Error("Refreshed wrong key", key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Item was not refreshed ahead of expiry")
+	}
+}
+
+func TestRenameAndAlias(t *testing.T) {
+	table := Cache("testRenameBlue")
+	table.Add(k, 0, v)
+	if err := Alias("testRenameCurrent", "testRenameBlue"); err != nil {
+		t.Fatal("Error adding alias", err)
+	}
+	if Cache("testRenameCurrent") != table {
+		t.Error("Alias does not resolve to the table")
+	}
+
+	if err := Rename("testRenameBlue", "testRenameGreen"); err != nil {
+		t.Fatal("Error renaming table", err)
+	}
+	if Exists("testRenameBlue") || table.Name() != "testRenameGreen" {
+		t.Error("Error renaming table")
+	}
+	if Cache("testRenameCurrent") != table || !Cache("testRenameGreen").Exists(k) {
+		t.Error("Alias lost after rename")
+	}
+	if err := Rename("testRenameMissing", "x"); err != ErrTableNotFound {
+		t.Error("Expected ErrTableNotFound, got", err)
+	}
+
+	Remove("testRenameCurrent")
+	if Exists("testRenameGreen") || Exists("testRenameCurrent") {
+		t.Error("Error removing table through alias")
+	}
+}
+
+func TestClone(t *testing.T) {
+	table := NewTable("testClone")
+	shared := map[string]int{"a": 1}
+	table.Add(k, time.Hour, shared)
+	table.Value(k)
+
+	shallow := table.Clone("testCloneShallow", false)
+	deep := table.Clone("testCloneDeep", true)
+	shared["a"] = 2
+
+	p, err := deep.Value(k)
+	if err != nil || p.Value().(map[string]int)["a"] != 1 {
+		t.Error("Deep clone shares values with the source table")
+	}
+	if p.AccessCount() != 2 || p.LifeSpan() != time.Hour {
+		t.Error("Error copying item metadata")
+	}
+	if p, _ := shallow.Value(k); p.Value().(map[string]int)["a"] != 2 {
+		t.Error("Shallow clone copied values")
+	}
+
+	table.Delete(k)
+	if !deep.Exists(k) {
+		t.Error("Clone is not independent of the source table")
+	}
+}
+
+func TestMergeFrom(t *testing.T) {
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	serving := NewTable("testMergeServing", WithClock(clock))
+	warmup := NewTable("testMergeWarmup", WithClock(clock))
+	serving.Add(k+"_old", 0, "serving")
+	warmup.Add(k+"_old", 0, "warmup")
+	clock.now = clock.now.Add(time.Second)
+	serving.Add(k+"_new", 0, "serving")
+	warmup.Add(k+"_only", 0, "warmup")
+
+	if n := serving.MergeFrom(warmup, KeepExisting); n != 1 {
+		t.Error("Expected 1 merged item with KeepExisting, got", n)
+	}
+	if p, _ := serving.Value(k + "_old"); p.Value() != "serving" {
+		t.Error("KeepExisting overwrote an item")
+	}
+
+	clock.now = clock.now.Add(time.Second)
+	warmup.Add(k+"_old", 0, "newer")
+	if n := serving.MergeFrom(warmup, KeepNewest); n != 1 {
+		t.Error("Expected 1 merged item with KeepNewest, got", n)
+	}
+	if p, _ := serving.Value(k + "_old"); p.Value() != "newer" {
+		t.Error("KeepNewest kept the older item")
+	}
+	if n := serving.MergeFrom(warmup, Overwrite); n != 2 {
+		t.Error("Expected 2 merged items with Overwrite, got", n)
+	}
+
+	// 删除合并的副本不影响来源表中的元素
+	source, _ := warmup.Value(k + "_only")
+	merged, _ := serving.Value(k + "_only")
+	serving.Delete(k + "_only")
+	select {
+	case <-merged.Done():
+	default:
+		t.Error("Error Done not closed for the deleted merged item")
+	}
+	select {
+	case <-source.Done():
+		t.Error("Error deleting the merged item closed Done in the source table")
+	default:
+	}
+}
+
+func TestReplaceAll(t *testing.T) {
+	table := NewTable("testReplaceAll")
+	table.Add(k+"_1", 0, v)
+	table.Add(k+"_2", 0, v)
+
+	var deleted, added []interface{}
+	table.SetAboutToDeleteItemCallback(func(item *CacheItem) { deleted = append(deleted, item.Key()) })
+	table.SetAddedItemCallback(func(item *CacheItem) { added = append(added, item.Key()) })
+
+	err := table.ReplaceAll(map[interface{}]interface{}{k + "_2": "new", k + "_3": "new"}, time.Hour)
+	if err != nil {
+		t.Fatal("Error replacing items", err)
+	}
+	if table.Count() != 2 || table.Exists(k+"_1") {
+		t.Error("Error replacing table contents")
+	}
+	if p, _ := table.Value(k + "_2"); p.Value() != "new" || p.LifeSpan() != time.Hour {
+		t.Error("Error replacing existing item")
+	}
+	if len(deleted) != 1 || deleted[0] != k+"_1" || len(added) != 2 {
+		t.Error("Error firing callbacks", deleted, added)
+	}
+
+	// 替换后超出容量时被淘汰的元素不执行添加回调
+	capped := NewTable("testReplaceAllCapped", WithMaxItems(2))
+	var cappedAdded []interface{}
+	capped.SetAddedItemCallback(func(item *CacheItem) { cappedAdded = append(cappedAdded, item.Key()) })
+	capped.ReplaceAll(map[interface{}]interface{}{"a": v, "b": v, "c": v, "d": v}, 0)
+	if capped.Count() != 2 || len(cappedAdded) != 2 {
+		t.Error("Error firing add callbacks for evicted items", capped.Count(), cappedAdded)
+	}
+	for _, key := range cappedAdded {
+		if !capped.Exists(key) {
+			t.Error("Error add callback fired for evicted item", key)
+		}
+	}
+}
+
+func TestTx(t *testing.T) {
+	table := NewTable("testTx")
+	table.Add("from", 0, 10)
+	table.Add("to", 0, 0)
+
+	transfer := func(amount int) error {
+		return table.Tx(func(tx *Txn) error {
+			from, _ := tx.Get("from")
+			to, _ := tx.Get("to")
+			tx.Set("from", 0, from.(int)-amount)
+			tx.Set("to", 0, to.(int)+amount)
+			if from.(int) < amount {
+				return errors.New("insufficient")
+			}
+			return nil
+		})
+	}
+	if err := transfer(4); err != nil {
+		t.Fatal("Error committing transaction", err)
+	}
+	if err := transfer(100); err == nil {
+		t.Fatal("Expected transaction error")
+	}
+	from, _ := table.Value("from")
+	to, _ := table.Value("to")
+	if from.Value() != 6 || to.Value() != 4 {
+		t.Error("Error applying transactions", from.Value(), to.Value())
+	}
+
+	table.Tx(func(tx *Txn) error {
+		tx.Delete("from")
+		if tx.Exists("from") {
+			t.Error("Transaction does not see its own delete")
+		}
+		return nil
+	})
+	if table.Exists("from") {
+		t.Error("Error committing delete")
+	}
+
+	table = NewTable("testTxEvict", WithMaxItems(2))
+	table.Add(k+"_0", 0, v)
+	table.Add(k+"_1", 0, v)
+	var counts []int
+	table.SetAboutToDeleteItemCallback(func(*CacheItem) {
+		counts = append(counts, table.Count())
+	})
+	table.Tx(func(tx *Txn) error {
+		tx.Set(k+"_2", 0, v)
+		tx.Set(k+"_3", 0, v)
+		return nil
+	})
+	if len(counts) != 2 || counts[0] != 2 || counts[1] != 2 || table.Count() != 2 {
+		t.Error("Error evicting within transaction commit", counts, table.Count())
+	}
+}
+
+func TestSetIfVersion(t *testing.T) {
+	table := NewTable("testSetIfVersion")
+	p, err := table.SetIfVersion(k, 0, 1)
+	if err != nil || p.Version() != 1 {
+		t.Fatal("Error creating item", err)
+	}
+	if _, err := table.SetIfVersion(k, 0, 1); !errors.Is(err, ErrVersionMismatch) {
+		t.Error("Expected ErrVersionMismatch for existing key, got", err)
+	}
+
+	p, _ = table.Value(k)
+	next, err := table.SetIfVersion(k, p.Version(), p.Value().(int)+1)
+	if err != nil || next.Version() != 2 || next.Value() != 2 {
+		t.Error("Error updating item with current version", err)
+	}
+	if _, err := table.SetIfVersion(k, p.Version(), 3); !errors.Is(err, ErrVersionMismatch) {
+		t.Error("Expected ErrVersionMismatch for stale version, got", err)
+	}
+	if table.Add(k, 0, 4).Version() != 3 {
+		t.Error("Add did not increment the version")
+	}
+}
+
+func TestLockKey(t *testing.T) {
+	table := NewTable("testLockKey")
+	unlock := table.LockKey(k)
+	if _, ok := table.TryLockKey(k); ok {
+		t.Error("Acquired a held key lock")
+	}
+	unlock()
+
+	unlock, ok := table.TryLockKey(k)
+	if !ok {
+		t.Fatal("Error acquiring a free key lock")
+	}
+	unlock()
+}
+
+func TestKeyMutex(t *testing.T) {
+	m := NewKeyMutex(1)
+	if m.Stripes() != 1 {
+		t.Error("Error configuring stripes", m.Stripes())
+	}
+	unlock := m.Lock("a")
+	// 只有一个分段时所有键共用一把锁
+	if _, ok := m.TryLock("b"); ok {
+		t.Error("Acquired a lock sharing a held stripe")
+	}
+	unlock()
+
+	var zero KeyMutex
+	if zero.Stripes() != DefaultKeyMutexStripes {
+		t.Error("Error using default stripes for zero value")
+	}
+	if table := NewTable("testKeyMutexShards", WithShards(8)); table.keyLocks.Stripes() != 8 {
+		t.Error("Error configuring key lock shards", table.keyLocks.Stripes())
+	}
+}
+
+func TestView(t *testing.T) {
+	table := NewTable("testView")
+	table.Add(k+"_1", 0, 1)
+	table.Add(k+"_2", 0, 2)
+
+	view := table.View()
+	table.Add(k+"_1", 0, 10)
+	table.Delete(k + "_2")
+	table.Add(k+"_3", 0, 3)
+
+	if view.Count() != 2 || view.Exists(k+"_3") {
+		t.Error("View sees later mutations")
+	}
+	if p, err := view.Value(k + "_1"); err != nil || p.Value() != 1 {
+		t.Error("View sees later writes")
+	}
+	if p, _ := table.Value(k + "_1"); p.Value() != 10 || table.Count() != 2 {
+		t.Error("Error writing table while a view is held")
+	}
+}
+
+func TestCDC(t *testing.T) {
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	table := NewTable("testCDC", WithClock(clock))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := table.CDC(ctx)
+
+	table.Add(k, time.Second, 1)
+	table.Add(k, time.Second, 2)
+	table.Delete(k)
+	table.Add(k, time.Second, 3)
+	clock.now = clock.now.Add(2 * time.Second)
+	table.RunExpirationNow()
+
+	want := []MutationOp{OpAdd, OpUpdate, OpDelete, OpAdd, OpExpire}
+	for i, op := range want {
+		m := <-ch
+		if m.Op != op || m.Seq != uint64(i+1) || m.Key != k {
+			t.Errorf("Mutation %d: got %v seq %d, want %v", i, m.Op, m.Seq, op)
+		}
+	}
+
+	small := table.CDCWithBuffer(ctx, 1)
+	table.Add(k, 0, 1)
+	table.Add(k, 0, 2)
+	<-small
+	if _, ok := <-small; ok {
+		t.Error("Expected overflowing subscription to be closed")
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	table := NewTable("testAuditLog", WithClock(clock))
+	table.EnableAudit(2)
+
+	ctx := WithActor(context.Background(), "alice")
+	table.AddContext(ctx, k, 0, v)
+	clock.now = clock.now.Add(time.Second)
+	table.Add(k, 0, v)
+	table.DeleteContext(WithActor(context.Background(), "bob"), k)
+
+	entries := table.AuditLog(time.Time{})
+	if len(entries) != 2 {
+		t.Fatal("Expected ring buffer to keep 2 entries, got", len(entries))
+	}
+	if entries[0].Op != OpUpdate || entries[0].Actor != "" || entries[1].Op != OpDelete || entries[1].Actor != "bob" {
+		t.Error("Error recording audit entries", entries)
+	}
+	if !strings.Contains(entries[1].Caller, "TestAuditLog") {
+		t.Error("Error recording caller", entries[1].Caller)
+	}
+	if n := len(table.AuditLog(clock.now.Add(time.Second))); n != 0 {
+		t.Error("Error filtering audit log by time", n)
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	table := Cache("testAdminHandler")
+	table.Add(k, time.Hour, v)
+	table.Add(42, 0, v)
+	srv := httptest.NewServer(AdminHandler())
+	defer srv.Close()
+
+	var item ItemInfo
+	resp, err := http.Get(srv.URL + "/tables/testAdminHandler/items/" + k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(resp.Body).Decode(&item)
+	resp.Body.Close()
+	if item.Key != k || item.Type != "string" || item.TTLRemaining <= 0 {
+		t.Error("Error fetching item metadata", item)
+	}
+
+	var items []ItemInfo
+	resp, _ = http.Get(srv.URL + "/tables/testAdminHandler/keys?q=4")
+	json.NewDecoder(resp.Body).Decode(&items)
+	resp.Body.Close()
+	if len(items) != 1 || items[0].Key != "42" {
+		t.Error("Error searching keys", items)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/tables/testAdminHandler/items/42", nil)
+	resp, _ = http.DefaultClient.Do(req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || table.Exists(42) {
+		t.Error("Error deleting item through admin handler", resp.StatusCode)
+	}
+
+	var points []HitRatePoint
+	table.Value(k)
+	resp, _ = http.Get(srv.URL + "/tables/testAdminHandler/hitrate?window=5m")
+	json.NewDecoder(resp.Body).Decode(&points)
+	resp.Body.Close()
+	if len(points) != 5 || points[4].Hits != 1 {
+		t.Error("Error fetching hit rate series", points)
+	}
+	resp, _ = http.Get(srv.URL + "/")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Error("Error serving dashboard", resp.StatusCode)
+	}
+
+	resp, _ = http.Get(srv.URL + "/tables/testAdminHandler/dump?sort=size&limit=1")
+	dump, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.HasPrefix(string(dump), "KEY") || !strings.Contains(string(dump), "1 of 1 items") {
+		t.Error("Error dumping table through admin handler", string(dump))
+	}
+
+	resp, _ = http.Get(srv.URL + "/tables/testAdminHandler/snapshot")
+	restored := NewTable("testAdminHandlerRestored")
+	if n, err := restored.LoadSnapshot(resp.Body); err != nil || n != 1 || !restored.Exists(k) {
+		t.Error("Error downloading snapshot", n, err)
+	}
+	resp.Body.Close()
+	resp, _ = http.Post(srv.URL+"/tables/testAdminHandler/snapshot", "", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Error("Error persisting table without snapshot file", resp.StatusCode)
+	}
+	table.SetSnapshotFile(filepath.Join(t.TempDir(), "admin.snapshot"))
+	resp, _ = http.Post(srv.URL+"/tables/testAdminHandler/snapshot", "", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Error("Error persisting table", resp.StatusCode)
+	}
+
+	resp, _ = http.Get(srv.URL + "/tables/testAdminHandlerMissing")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || Exists("testAdminHandlerMissing") {
+		t.Error("Admin handler created or found a missing table")
+	}
+}
+
+func TestSecurity(t *testing.T) {
+	sec := &Security{BearerTokens: []string{"secret"}}
+	srv := httptest.NewServer(sec.Wrap(AdminHandler()))
+	defer srv.Close()
+
+	resp, _ := http.Get(srv.URL + "/tables")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Error("Expected 401 without token, got", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/tables", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, _ = http.DefaultClient.Do(req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Error("Expected 200 with token, got", resp.StatusCode)
+	}
+
+	sec.Authenticate = func(r *http.Request) error { return errors.New("denied") }
+	resp, _ = http.DefaultClient.Do(req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Error("Expected auth callback to reject request, got", resp.StatusCode)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	table := NewTable("testPeek")
+	table.Add(k, time.Minute, v)
+	item, err := table.Peek(k)
+	if err != nil || item.Value() != v {
+		t.Error("Error peeking item", err)
+	}
+	if item.AccessCount() != 0 || table.Stats().Hits != 0 {
+		t.Error("Error Peek should not count as an access")
+	}
+	if _, err := table.Peek("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Error("Error expected ErrKeyNotFound, got", err)
+	}
+}
+
+func TestTieredCache(t *testing.T) {
+	l1, l2 := NewTable("testTieredL1"), NewTable("testTieredL2")
+	tc := NewTieredCache(l1, TableStore(l2))
+	defer tc.Close()
+	tc.SetL1TTL(time.Minute)
+
+	l2.Add(k, 0, v)
+	if val, err := tc.Get(context.Background(), k); err != nil || val != v {
+		t.Error("Error reading through to L2", val, err)
+	}
+	if item, err := l1.Peek(k); err != nil || item.LifeSpan() != time.Minute {
+		t.Error("Error L1 was not populated with capped lifespan")
+	}
+
+	loads := 0
+	tc.SetLoader(func(ctx context.Context, key interface{}) (interface{}, time.Duration, error) {
+		loads++
+		return "loaded", 0, nil
+	})
+	if val, err := tc.Get(context.Background(), "other"); err != nil || val != "loaded" || loads != 1 {
+		t.Error("Error loading missing key", val, err)
+	}
+	if !l1.Exists("other") || !l2.Exists("other") {
+		t.Error("Error loaded value was not written to both tiers")
+	}
+
+	// L2上的修改使L1中的副本失效
+	l2.Add(k, 0, "changed")
+	for i := 0; i < 100 && l1.Exists(k); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if l1.Exists(k) {
+		t.Error("Error L2 update did not invalidate L1")
+	}
+	if val, _ := tc.Get(context.Background(), k); val != "changed" {
+		t.Error("Error expected updated value, got", val)
+	}
+
+	if err := tc.Delete(context.Background(), "other"); err != nil || l1.Exists("other") || l2.Exists("other") {
+		t.Error("Error deleting from both tiers", err)
+	}
+}
+
+func TestHashRing(t *testing.T) {
+	ring := NewHashRing(0)
+	ring.Add("a", "b", "c")
+	owners := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		owners[strconv.Itoa(i)] = ring.Get(i)
+	}
+	ring.Remove("c")
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		owner := ring.Get(i)
+		if owner == "c" {
+			t.Fatal("Error removed node still owns keys")
+		}
+		if owners[strconv.Itoa(i)] != "c" && owner != owners[strconv.Itoa(i)] {
+			moved++
+		}
+	}
+	if moved != 0 {
+		t.Error("Error keys of remaining nodes moved:", moved)
+	}
+	if nodes := ring.Nodes(); len(nodes) != 2 || nodes[0] != "a" {
+		t.Error("Error listing nodes", nodes)
+	}
+}
+
+func TestHTTPPool(t *testing.T) {
+	var loads int32
+	owner := Cache("testPeers")
+	defer Remove("testPeers")
+	owner.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(10 * time.Millisecond)
+		return NewCacheItem(key, time.Minute, "origin")
+	})
+	srv := httptest.NewServer(NewHTTPPool(""))
+	defer srv.Close()
+
+	pool := NewHTTPPool("self")
+	pool.Set(srv.URL)
+	local := NewTable("testPeers", WithPeers(pool))
+	local.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		t.Error("Error non-owner called its own loader")
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if item, err := local.Value(k); err != nil || item.Value() != "origin" || item.LifeSpan() != time.Minute {
+				t.Error("Error fetching from owner", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Error("Error expected exactly one origin load, got", n)
+	}
+	if !owner.Exists(k) || !local.Exists(k) {
+		t.Error("Error value should be cached by owner and fetcher")
+	}
+}
+
+func TestHTTPPoolRemainingTTL(t *testing.T) {
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	owner := Cache("testPeersTTL", WithClock(clock))
+	defer Remove("testPeersTTL")
+	owner.Add(k, time.Minute, v)
+	clock.now = clock.now.Add(40 * time.Second)
+	srv := httptest.NewServer(NewHTTPPool(""))
+	defer srv.Close()
+
+	pool := NewHTTPPool("self")
+	pool.Set(srv.URL)
+	local := NewTable("testPeersTTL", WithPeers(pool))
+	if item, err := local.Value(k); err != nil || item.LifeSpan() != 20*time.Second {
+		t.Error("Error expected fetched item to keep the owner's remaining TTL", item, err)
+	}
+	if item, _ := owner.Peek(k); !item.AccessedOn().Equal(time.Unix(1000, 0)) {
+		t.Error("Error peer fetch should not extend the owner's item", item.AccessedOn())
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	owner := Cache("testRebalance")
+	defer Remove("testRebalance")
+	srv := httptest.NewServer(NewHTTPPool(""))
+	defer srv.Close()
+
+	pool := NewHTTPPool("self")
+	local := NewTable("testRebalance", WithPeers(pool))
+	local.Add(k, time.Minute, v)
+	local.Add("forever", 0, v)
+
+	// 新节点加入后所有键都归它所有
+	pool.Set(srv.URL)
+	n, err := local.Rebalance(context.Background())
+	if err != nil || n != 2 {
+		t.Error("Error rebalancing", n, err)
+	}
+	if local.Count() != 0 {
+		t.Error("Error transferred entries should be removed locally")
+	}
+	item, err := owner.Peek(k)
+	if err != nil || item.Value() != v || item.LifeSpan() <= 0 || item.LifeSpan() > time.Minute {
+		t.Error("Error entry was not transferred with its remaining TTL", err)
+	}
+	if item, err := owner.Peek("forever"); err != nil || item.LifeSpan() != 0 {
+		t.Error("Error non-expiring entry was not transferred", err)
+	}
+	stats := local.Stats().Rebalance
+	if stats.Running || stats.Pending != 2 || stats.Transferred != 2 || stats.Failed != 0 || stats.FinishedAt.IsZero() {
+		t.Error("Error unexpected rebalance stats", stats)
+	}
+}
+
+func TestHTTPPoolSecurity(t *testing.T) {
+	owner := Cache("testPoolSecurity")
+	defer Remove("testPoolSecurity")
+	server := NewHTTPPool("")
+	server.Security = &Security{BearerTokens: []string{"secret"}}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	pool := NewHTTPPool("self")
+	pool.Set(srv.URL)
+	peer, _ := pool.PickPeer(k)
+	entries := []TransferEntry{{Key: k, Value: v}}
+	if err := peer.(PeerReceiver).Receive(context.Background(), "testPoolSecurity", entries); !errors.Is(err, ErrUnauthorized) {
+		t.Error("Expected ErrUnauthorized for push without token, got", err)
+	}
+	if owner.Exists(k) {
+		t.Error("Error unauthenticated push was applied")
+	}
+	if _, err := pool.Acquire(context.Background(), "testPoolSecurity", k, time.Minute); !errors.Is(err, ErrUnauthorized) {
+		t.Error("Expected ErrUnauthorized for lease without token, got", err)
+	}
+	pool.Token = "secret"
+	if err := peer.(PeerReceiver).Receive(context.Background(), "testPoolSecurity", entries); err != nil || !owner.Exists(k) {
+		t.Error("Error pushing with token", err)
+	}
+	token, err := pool.Acquire(context.Background(), "testPoolSecurity", k, time.Minute)
+	if err != nil || token == 0 {
+		t.Error("Error acquiring lease with token", token, err)
+	}
+	if err := pool.Release(context.Background(), "testPoolSecurity", k, token); err != nil {
+		t.Error("Error releasing lease with token", err)
+	}
+}
+
+func TestLeases(t *testing.T) {
+	leases := NewLocalLeases()
+	var loads int32
+	loader := func(key interface{}, args ...interface{}) *CacheItem {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(20 * time.Millisecond)
+		return NewCacheItem(key, time.Minute, v)
+	}
+	a := NewTable("testLeases", WithLeases(leases, LeaseConfig{Wait: time.Second}))
+	b := NewTable("testLeases", WithLeases(leases, LeaseConfig{Wait: time.Second}))
+	a.SetDataLoader(loader)
+	b.SetDataLoader(loader)
+	// 模拟总线复制
+	a.AddAddedItemCallback(func(item *CacheItem) { b.NotFoundAdd(item.Key(), item.LifeSpan(), item.Value()) })
+	b.AddAddedItemCallback(func(item *CacheItem) { a.NotFoundAdd(item.Key(), item.LifeSpan(), item.Value()) })
+
+	var wg sync.WaitGroup
+	for _, table := range []*CacheTable{a, b} {
+		wg.Add(1)
+		go func(table *CacheTable) {
+			defer wg.Done()
+			if item, err := table.Value(k); err != nil || item.Value() != v {
+				t.Error("Error reading through lease", err)
+			}
+		}(table)
+		time.Sleep(5 * time.Millisecond)
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Error("Error expected exactly one origin load, got", n)
+	}
+
+	stale := NewTable("testLeasesStale", WithLeases(leases, LeaseConfig{StaleFor: time.Minute}))
+	stale.SetDataLoader(loader)
+	stale.Add(k, time.Minute, "old")
+	stale.Lock()
+	stale.removeItem(context.Background(), k, OpExpire)
+	stale.Unlock()
+	token, _ := leases.Acquire(context.Background(), "testLeasesStale", k, time.Minute)
+	if item, err := stale.Value(k); err != nil || item.Value() != "old" {
+		t.Error("Error stale value not served while lease is held elsewhere", err)
+	}
+	leases.Release(context.Background(), "testLeasesStale", k, token)
+	if item, err := stale.Value(k); err != nil || item.Value() != v {
+		t.Error("Error lease holder did not load a fresh value", err)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var hits, revalidations int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				atomic.AddInt32(&revalidations, 1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/private":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept, *")
+		case "/large":
+			w.Header().Set("Cache-Control", "max-age=60")
+			// 分块发送, 响应没有Content-Length
+			w.Write([]byte("body "))
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte("body " + r.URL.Path))
+	}))
+	defer srv.Close()
+
+	table := NewTable("testTransport")
+	client := NewTransport(table).Client()
+	get := func(path string) (string, bool) {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), resp.Header.Get(XFromCache) == "1"
+	}
+
+	for _, path := range []string{"/fresh", "/etag", "/private"} {
+		if body, cached := get(path); body != "body "+path || cached {
+			t.Error("Error unexpected first response", path, body, cached)
+		}
+	}
+	atomic.StoreInt32(&hits, 0)
+	if body, cached := get("/fresh"); body != "body /fresh" || !cached || atomic.LoadInt32(&hits) != 0 {
+		t.Error("Error fresh response was not served from cache")
+	}
+	if body, cached := get("/etag"); body != "body /etag" || !cached || atomic.LoadInt32(&revalidations) != 1 {
+		t.Error("Error response was not revalidated with ETag")
+	}
+	if _, cached := get("/private"); cached || table.Exists("GET "+srv.URL+"/private") {
+		t.Error("Error no-store response was cached")
+	}
+
+	forced := NewTransport(NewTable("testTransportForced"), WithForcedTTL(time.Minute)).Client()
+	for i := 0; i < 2; i++ {
+		resp, err := forced.Get(srv.URL + "/private")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if cached := resp.Header.Get(XFromCache) == "1"; cached != (i == 1) {
+			t.Error("Error forced TTL was not applied")
+		}
+	}
+
+	if _, cached := get("/vary"); cached || table.Exists("GET "+srv.URL+"/vary") {
+		t.Error("Error response with Vary: * was cached")
+	}
+	small := NewTable("testTransportSmall")
+	client = NewTransport(small, WithMaxCachedBody(12)).Client()
+	if body, _ := get("/fresh"); body != "body /fresh" || !small.Exists("GET "+srv.URL+"/fresh") {
+		t.Error("Error small response was not cached", body)
+	}
+	for i := 0; i < 2; i++ {
+		if body, cached := get("/large"); body != "body body /large" || cached {
+			t.Error("Error large response was truncated or cached", body, cached)
+		}
+	}
+	if small.Exists("GET " + srv.URL + "/large") {
+		t.Error("Error response above the body limit was cached")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var calls int32
+	m := NewMiddleware(NewTable("testMiddleware"), WithVaryHeaders("Accept-Language"))
+	h := m.Handler(time.Minute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Query().Get("cookie") != "" {
+			w.Header().Set("Set-Cookie", "a=b")
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(r.Header.Get("Accept-Language") + " " + r.URL.RequestURI()))
+	}))
+	get := func(uri, lang string) (string, bool) {
+		req := httptest.NewRequest(http.MethodGet, uri, nil)
+		req.Header.Set("Accept-Language", lang)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Body.String(), rec.Header().Get(XFromCache) == "1"
+	}
+
+	get("/users/1", "en")
+	if body, cached := get("/users/1", "en"); !cached || body != "en /users/1" || atomic.LoadInt32(&calls) != 1 {
+		t.Error("Error response was not served from cache", body)
+	}
+	if body, cached := get("/users/1", "de"); cached || body != "de /users/1" {
+		t.Error("Error vary header was not part of the key", body)
+	}
+	get("/users/1?cookie=1", "en")
+	if _, cached := get("/users/1?cookie=1", "en"); cached {
+		t.Error("Error response with Set-Cookie was cached")
+	}
+	get("/users/2", "en")
+	if n := m.Invalidate("/users/1"); n != 2 {
+		t.Error("Error expected two invalidated responses, got", n)
+	}
+	if _, cached := get("/users/1", "en"); cached {
+		t.Error("Error invalidated response was served from cache")
+	}
+	if n := m.InvalidatePrefix("/users/"); n != 2 {
+		t.Error("Error expected two invalidated responses by prefix, got", n)
+	}
+}
+
+func TestMemoize(t *testing.T) {
+	var calls int32
+	errOdd := errors.New("odd")
+	square := func(n int) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		if n%2 == 1 {
+			return 0, errOdd
+		}
+		return n * n, nil
+	}
+	key := func(n int) interface{} { return n }
+
+	memo := Memoize(NewTable("testMemoize"), key, time.Minute, square)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := memo(4); err != nil || v != 16 {
+				t.Error("Error memoized result", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	memo(4)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Error("Error expected a single call, got", n)
+	}
+	memo(3)
+	if _, err := memo(3); err != errOdd || atomic.LoadInt32(&calls) != 3 {
+		t.Error("Error errors should not be cached by default", err)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	memoErr := Memoize(NewTable("testMemoizeErrors"), key, time.Minute, square, CacheErrors(time.Minute))
+	memoErr(3)
+	if _, err := memoErr(3); err != errOdd || atomic.LoadInt32(&calls) != 1 {
+		t.Error("Error cached error was not returned", err)
+	}
+}
+
+func TestKey(t *testing.T) {
+	if key := Key("user", 42, "profile"); key != "user:#42:profile" {
+		t.Error("Error unexpected key", key)
+	}
+	distinct := []string{
+		Key("a", 1), Key("a", "1"), Key("a:b"), Key("a", "b"), Key("a", int64(1)*10),
+		Key("a", []byte("b")), Key("a", nil), Key("a", "#nil"), Key("a", true), Key("a", 1.5),
+	}
+	seen := make(map[string]bool)
+	for _, key := range distinct {
+		if seen[key] {
+			t.Error("Error key collision", key)
+		}
+		seen[key] = true
+	}
+	long := strings.Repeat("x", MaxKeySegment+1)
+	if key := Key("doc", long); len(key) > 4+2+32 || key != Key("doc", long) || key == Key("doc", long+"y") {
+		t.Error("Error long segment was not hashed stably", key)
+	}
+	users := Prefix("svc", "user")
+	if key := users.Key(42, "profile"); key != "svc:user:#42:profile" || key != Key(users, 42, "profile") {
+		t.Error("Error prefixed key", key)
+	}
+	if key := users.Prefix(7).Key("x"); key != "svc:user:#7:x" {
+		t.Error("Error nested prefix", key)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	clock := &manualClock{now: time.Unix(0, 0)}
+	table := NewTable("testDedup", WithClock(clock))
+	d := NewDedup(table, time.Minute)
+
+	var first int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if d.FirstSeen("req-1") {
+				atomic.AddInt32(&first, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if first != 1 {
+		t.Error("Error expected exactly one first sighting, got", first)
+	}
+	if s := d.Stats(); s.First != 1 || s.Duplicates != 9 {
+		t.Error("Error unexpected dedup stats", s)
+	}
+
+	// 过期检查尚未运行时, 过期的ID也视为第一次出现
+	var expired int32
+	table.AddAboutToDeleteItemCallback(func(*CacheItem) { atomic.AddInt32(&expired, 1) })
+	clock.now = clock.now.Add(time.Minute)
+	if !d.FirstSeen("req-1") || atomic.LoadInt32(&expired) != 1 {
+		t.Error("Error expired id should be seen again")
+	}
+	d.Forget("req-1")
+	if !d.FirstSeen("req-1") {
+		t.Error("Error forgotten id should be seen again")
+	}
+}
+
+func TestGet(t *testing.T) {
+	table := NewTable("testGet")
+	table.Add(k, 0, v)
+	if s, err := Get[string](table, k); err != nil || s != v {
+		t.Error("Error getting typed value", s, err)
+	}
+	_, err := Get[int](table, k)
+	if !errors.Is(err, ErrTypeMismatch) || !strings.Contains(err.Error(), "holds string, want int") {
+		t.Error("Error expected descriptive type mismatch", err)
+	}
+	if _, err := Get[string](table, "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Error("Error expected key not found", err)
+	}
+	if s := MustGet[string](table, k); s != v {
+		t.Error("Error MustGet returned", s)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Error MustGet should panic on type mismatch")
+		}
+	}()
+	MustGet[int](table, k)
+}
+
+func TestItemTypedValues(t *testing.T) {
+	if s, err := NewCacheItem(k, 0, 42).String(); err != nil || s != "42" {
+		t.Error("Error converting int to string", s, err)
+	}
+	if n, err := NewCacheItem(k, 0, "42").Int64(); err != nil || n != 42 {
+		t.Error("Error parsing string as int64", n, err)
+	}
+	if n, err := NewCacheItem(k, 0, 2.0).Int64(); err != nil || n != 2 {
+		t.Error("Error converting whole float to int64", n, err)
+	}
+	if _, err := NewCacheItem(k, 0, 2.5).Int64(); !errors.Is(err, ErrTypeMismatch) {
+		t.Error("Error fractional float should not convert", err)
+	}
+	if _, err := NewCacheItem(k, 0, uint64(math.MaxUint64)).Int64(); !errors.Is(err, ErrTypeMismatch) {
+		t.Error("Error overflowing uint64 should not convert", err)
+	}
+	if b, err := NewCacheItem(k, 0, "abc").Bytes(); err != nil || string(b) != "abc" {
+		t.Error("Error converting string to bytes", b, err)
+	}
+	if b, err := NewCacheItem(k, 0, "true").Bool(); err != nil || !b {
+		t.Error("Error parsing bool", b, err)
+	}
+	if _, err := NewCacheItem(k, 0, struct{}{}).String(); !errors.Is(err, ErrTypeMismatch) {
+		t.Error("Error struct should not convert to string", err)
+	}
+}
+
+func TestAdmissionHook(t *testing.T) {
+	errNil := errors.New("nil value")
+	table := NewTable("testAdmission", WithAdmissionHook(func(key, value interface{}, lifeSpan time.Duration) error {
+		if value == nil {
+			return errNil
+		}
+		return nil
+	}))
+	if item, err := table.TryAdd(k, 0, nil); item != nil || err != errNil {
+		t.Error("Error nil value was not rejected by TryAdd", err)
+	}
+	if table.Add(k, 0, nil) != nil || table.Exists(k) {
+		t.Error("Error nil value was not rejected by Add")
+	}
+	if added, err := table.TryNotFoundAdd(k, 0, nil); added || err != errNil {
+		t.Error("Error nil value was not rejected by TryNotFoundAdd", err)
+	}
+	if added, err := table.TryNotFoundAdd(k, 0, v); !added || err != nil {
+		t.Error("Error valid value was rejected", err)
+	}
+	if added, err := table.TryNotFoundAdd(k, 0, v); added || err != nil {
+		t.Error("Error existing key should not be reported as rejection", err)
+	}
+	if err := table.Tx(func(tx *Txn) error { tx.Set("a", 0, v); tx.Set("b", 0, nil); return nil }); err != errNil || table.Exists("a") {
+		t.Error("Error rejected transaction should not apply any write", err)
+	}
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem { return NewCacheItem(key, 0, nil) })
+	if _, err := table.Value("loaded"); err != errNil {
+		t.Error("Error rejected loader result should return the hook error", err)
+	}
+}
+
+func TestHasher(t *testing.T) {
+	type userKey struct{ id int }
+	var calls int32
+	hasher := HasherFunc(func(key interface{}) uint64 {
+		atomic.AddInt32(&calls, 1)
+		return uint64(key.(userKey).id)
+	})
+
+	table := NewTable("testHasher", WithHasher(hasher))
+	unlock := table.LockKey(userKey{1})
+	unlock()
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Error("Error key lock did not use the custom hasher")
+	}
+
+	ring := NewHashRing(1)
+	ring.SetHasher(hasher)
+	ring.Add("a", "b", "c")
+	owner := ring.Get(userKey{1})
+	for i := 0; i < 10; i++ {
+		if ring.Get(userKey{1}) != owner {
+			t.Error("Error custom hasher is not stable")
+		}
+	}
+	if atomic.LoadInt32(&calls) != 12 {
+		t.Error("Error ring did not use the custom hasher")
+	}
+	if DefaultHasher.Hash("x") != DefaultHasher.Hash("x") || DefaultHasher.Hash(1) == DefaultHasher.Hash(2) {
+		t.Error("Error default hasher")
+	}
+}
+
+// idList 不可比较的键
+type idList []int
+
+func (l idList) CacheKey() string { return Key("ids", fmt.Sprint([]int(l))) }
+
+func TestKeyer(t *testing.T) {
+	table := NewTable("testKeyer")
+	var loaded interface{}
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		loaded = key
+		return NewCacheItem(key, 0, len(key.(idList)))
+	})
+	if item, err := table.Value(idList{1, 2, 3}); err != nil || item.Value() != 3 {
+		t.Fatal("Error loading slice key", err)
+	}
+	if _, ok := loaded.(idList); !ok {
+		t.Error("Error loader should receive the original key, got", loaded)
+	}
+	if !table.Exists(idList{1, 2, 3}) || table.Exists(idList{1, 2}) {
+		t.Error("Error slice keys should compare by CacheKey")
+	}
+	if item, err := table.Peek(idList{1, 2, 3}); err != nil || item.Key() != (idList{1, 2, 3}).CacheKey() {
+		t.Error("Error item key should be the canonical string", err)
+	}
+	table.Add(idList{4}, 0, "x")
+	if !table.NotFoundAdd(idList{5}, 0, "y") || table.NotFoundAdd(idList{4}, 0, "z") {
+		t.Error("Error NotFoundAdd with slice keys")
+	}
+	err := table.Tx(func(tx *Txn) error {
+		if !tx.Exists(idList{4}) {
+			t.Error("Error transaction did not find slice key")
+		}
+		tx.Delete(idList{4})
+		return nil
+	})
+	if err != nil || table.Exists(idList{4}) {
+		t.Error("Error deleting slice key in transaction", err)
+	}
+	if _, err := table.Delete(idList{5}); err != nil {
+		t.Error("Error deleting slice key", err)
+	}
+	unlock := table.LockKey(idList{1})
+	unlock()
+}
+
+func TestCopyOnRead(t *testing.T) {
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+	for name, copier := range map[string]Copier{"reflect": nil, "gob": GobCopier} {
+		table := NewTable("testCopyOnRead", WithCopyOnRead(copier))
+		table.Add(k, 0, map[string]interface{}{"tags": []interface{}{"a"}})
+
+		item, err := table.Value(k)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		m := item.Value().(map[string]interface{})
+		m["tags"].([]interface{})[0] = "mutated"
+		m["new"] = true
+
+		peeked, err := table.Peek(k)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if got := peeked.Value().(map[string]interface{}); len(got) != 1 || got["tags"].([]interface{})[0] != "a" {
+			t.Error("Error cached value was mutated through a read", name, got)
+		}
+	}
+
+	table := NewTable("testCopyOnReadError", WithCopyOnRead(GobCopier))
+	table.Add(k, 0, func() {})
+	if _, err := table.Value(k); err == nil {
+		t.Error("Error copy failure should be returned")
+	}
+}
+
+func TestImmutable(t *testing.T) {
+	var buf bytes.Buffer
+	clock := &manualClock{now: time.Unix(0, 0)}
+	table := NewTable("testImmutable", WithClock(clock),
+		WithLogger(SlogLogger(slog.New(slog.NewTextHandler(&buf, nil)))),
+		WithImmutable(ImmutableConfig{}))
+	table.Add("clean", 0, map[string]int{"a": 1})
+	table.Add(k, 0, map[string]int{"a": 1})
+
+	item, _ := table.Value(k)
+	item.Value().(map[string]int)["a"] = 2
+	if keys := table.VerifyImmutable(); len(keys) != 1 || keys[0] != k {
+		t.Error("Error expected mutated key to be reported", keys)
+	}
+	buf.Reset()
+	table.Delete(k)
+	if !strings.Contains(buf.String(), EventMutated) {
+		t.Error("Error mutation should be logged on removal", buf.String())
+	}
+	buf.Reset()
+	table.Delete("clean")
+	if strings.Contains(buf.String(), EventMutated) {
+		t.Error("Error unmodified value reported as mutated")
+	}
+
+	table.SetImmutable(&ImmutableConfig{Panic: true})
+	table.Add(k, 0, []int{1})
+	item, _ = table.Value(k)
+	item.Value().([]int)[0] = 2
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Error expected panic on mutated value")
+			}
+		}()
+		table.VerifyImmutable()
+	}()
+
+	table.SetImmutable(nil)
+	if table.VerifyImmutable() != nil {
+		t.Error("Error disabled check should report nothing")
+	}
+}
+
+func TestMaxValueSize(t *testing.T) {
+	var reported int64
+	table := NewTable("testMaxValueSize",
+		WithMaxValueSize(10, false),
+		WithOversizeCallback(func(key, value interface{}, size int64) { reported = size }))
+	table.SetSizer(func(key, value interface{}) int64 { return int64(len(value.(string))) })
+
+	if _, err := table.TryAdd(k, 0, "small"); err != nil {
+		t.Error("Error small value rejected", err)
+	}
+	if _, err := table.TryAdd(k, 0, strings.Repeat("x", 11)); !errors.Is(err, ErrValueTooLarge) {
+		t.Error("Error expected ErrValueTooLarge, got", err)
+	}
+	if reported != 11 {
+		t.Error("Error oversize callback not called with size", reported)
+	}
+	if item, _ := table.Value(k); item.Value() != "small" {
+		t.Error("Error rejected write replaced existing value")
+	}
+	if table.Stats().Oversized != 1 {
+		t.Error("Error oversized writes not counted", table.Stats().Oversized)
+	}
+
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		return NewCacheItem(key, 0, strings.Repeat("y", 20))
+	})
+	if _, err := table.Value("big"); !errors.Is(err, ErrValueTooLarge) {
+		t.Error("Error oversized loaded value should be rejected", err)
+	}
+	table.UpdateSettings(func(s *Settings) { s.OversizePassThrough = true })
+	item, err := table.Value("big")
+	if err != nil || item.Value() != strings.Repeat("y", 20) {
+		t.Error("Error expected pass-through of oversized loaded value", err)
+	}
+	if table.Exists("big") {
+		t.Error("Error passed-through value should not be cached")
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	errDenied := errors.New("denied")
+	var calls []string
+	table := NewTable("testInterceptors", WithInterceptors(func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (*CacheItem, error) {
+			calls = append(calls, call.Kind.String())
+			return next(ctx, call)
+		}
+	}))
+	// 键改写
+	table.Use(func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (*CacheItem, error) {
+			call.Key = fmt.Sprint("tenant:", call.Key)
+			return next(ctx, call)
+		}
+	})
+	// 鉴权
+	table.Use(func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (*CacheItem, error) {
+			if call.Kind == CallDelete && call.Key == "tenant:protected" {
+				return nil, errDenied
+			}
+			return next(ctx, call)
+		}
+	})
+
+	table.Add(k, 0, v)
+	if !table.Exists("tenant:" + k) {
+		t.Error("Error key was not rewritten")
+	}
+	if item, err := table.Value(k); err != nil || item.Value() != v {
+		t.Error("Error reading through interceptors", err)
+	}
+	table.Add("protected", 0, v)
+	if _, err := table.Delete("protected"); err != errDenied {
+		t.Error("Error expected delete to be denied, got", err)
+	}
+	if _, err := table.Delete(k); err != nil || table.Exists("tenant:"+k) {
+		t.Error("Error deleting through interceptors", err)
+	}
+	if strings.Join(calls, ",") != "add,get,add,delete,delete" {
+		t.Error("Error unexpected calls", calls)
+	}
+}
+
+type recordingPlugin struct {
+	BasePlugin
+	name   string
+	table  string
+	events []string
+}
+
+func (p *recordingPlugin) Name() string { return p.name }
+
+func (p *recordingPlugin) OnTableCreate(table *CacheTable) {
+	if table.Name() == p.table {
+		p.events = append(p.events, "create")
+	}
+}
+
+func (p *recordingPlugin) OnAdd(ctx context.Context, table *CacheTable, item *CacheItem) {
+	p.events = append(p.events, fmt.Sprint("add:", item.Key()))
+}
+
+func (p *recordingPlugin) OnDelete(ctx context.Context, table *CacheTable, item *CacheItem) {
+	p.events = append(p.events, fmt.Sprint("delete:", item.Key()))
+}
+
+func (p *recordingPlugin) OnExpire(ctx context.Context, table *CacheTable, item *CacheItem) {
+	p.events = append(p.events, fmt.Sprint("expire:", item.Key()))
+}
+
+func (p *recordingPlugin) OnFlush(ctx context.Context, table *CacheTable) {
+	p.events = append(p.events, "flush")
+}
+
+// registryPlugin 在OnTableCreate中调用注册表的函数
+type registryPlugin struct {
+	BasePlugin
+	table string
+}
+
+func (p *registryPlugin) Name() string { return "testPluginRegistry" }
+
+func (p *registryPlugin) OnTableCreate(table *CacheTable) {
+	if table.Name() == p.table {
+		Exists(p.table)
+		Tables()
+		Cache(p.table + "Other")
+	}
+}
+
+func TestPluginRegistryAccess(t *testing.T) {
+	p := &registryPlugin{table: "testPluginRegistry"}
+	if err := RegisterPlugin(p); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterPlugin(p.Name())
+
+	done := make(chan *CacheTable)
+	go func() { done <- Cache(p.table) }()
+	select {
+	case table := <-done:
+		if table != Cache(p.table) || !Exists(p.table+"Other") {
+			t.Error("Error registering tables created from a plugin hook")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Error Cache deadlocked on a plugin calling registry functions")
+	}
+	Remove(p.table)
+	Remove(p.table + "Other")
+}
+
+func TestPlugins(t *testing.T) {
+	global := &recordingPlugin{name: "testPluginsGlobal", table: "testPlugins"}
+	if err := RegisterPlugin(global); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterPlugin(global.name)
+	if err := RegisterPlugin(global); err != ErrPluginExists {
+		t.Error("Error expected ErrPluginExists, got", err)
+	}
+	found := false
+	for _, name := range Plugins() {
+		found = found || name == global.name
+	}
+	if !found {
+		t.Error("Error registered plugin not listed")
+	}
+
+	clock := &manualClock{now: time.Unix(0, 0)}
+	own := &recordingPlugin{name: "testPluginsOwn", table: "testPlugins"}
+	table := NewTable("testPlugins", WithClock(clock), WithPlugins(own))
+	table.Add(k, 0, v)
+	table.Add("short", time.Second, v)
+	table.Delete(k)
+	clock.now = clock.now.Add(time.Second)
+	table.RunExpirationNow()
+	table.Flush()
+
+	want := "create,add:" + k + ",add:short,delete:" + k + ",expire:short,flush"
+	if got := strings.Join(own.events, ","); got != want {
+		t.Error("Error unexpected plugin events", got)
+	}
+	if !strings.HasPrefix(strings.Join(global.events, ","), "create,") {
+		t.Error("Error global plugin not applied to new table", global.events)
+	}
+	if !UnregisterPlugin(global.name) || UnregisterPlugin(global.name) {
+		t.Error("Error unregistering plugin")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	table := NewTable("testRemove")
+	table.Add(k, 0, v)
+	if value, found := table.Remove(k); !found || value != v {
+		t.Error("Error removing existing key", value, found)
+	}
+	if value, found := table.Remove(k); found || value != nil {
+		t.Error("Error removing missing key", value, found)
+	}
+
+	// 并发的Remove只有一个取得值
+	table.Add(k, 0, v)
+	var got int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, found := table.Remove(k); found {
+				atomic.AddInt32(&got, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got != 1 {
+		t.Error("Error expected exactly one winner, got", got)
+	}
+}
+
+func TestAddIfAbsent(t *testing.T) {
+	table := NewTable("testAddIfAbsent")
+	item, added := table.AddIfAbsent(k, 0, v)
+	if !added || item == nil || item.Value() != v {
+		t.Error("Error adding absent key", item, added)
+	}
+	item, added = table.AddIfAbsent(k, 0, "loser")
+	if added || item == nil || item.Value() != v {
+		t.Error("Error expected existing item to be returned", item, added)
+	}
+
+	var winners int32
+	var wg sync.WaitGroup
+	values := make([]interface{}, 10)
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			item, added := table.AddIfAbsent("race", 0, i)
+			if added {
+				atomic.AddInt32(&winners, 1)
+			}
+			values[i] = item.Value()
+		}(i)
+	}
+	wg.Wait()
+	if winners != 1 {
+		t.Error("Error expected one winner, got", winners)
+	}
+	for _, value := range values {
+		if value != values[0] {
+			t.Error("Error losers should see the winner's value", values)
+			break
+		}
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	table := NewTable("testUpsert")
+	incr := func(old *CacheItem, ok bool) interface{} {
+		if !ok {
+			return 1
+		}
+		return old.Value().(int) + 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			table.Upsert(k, 0, incr)
+		}()
+	}
+	wg.Wait()
+	item, err := table.Upsert(k, 0, incr)
+	if err != nil || item.Value() != 51 || item.Version() != 51 {
+		t.Error("Error expected 51 atomic increments", item.Value(), err)
+	}
+
+	table.SetAdmissionHook(func(key, value interface{}, lifeSpan time.Duration) error {
+		if value.(int) > 51 {
+			return errors.New("too big")
+		}
+		return nil
+	})
+	if _, err := table.Upsert(k, 0, incr); err == nil {
+		t.Error("Error rejected upsert should return an error")
+	}
+	if p, _ := table.Peek(k); p.Value() != 51 {
+		t.Error("Error rejected upsert changed the value", p.Value())
+	}
+}
+
+func TestSwap(t *testing.T) {
+	table := NewTable("testSwap")
+	if old, existed := table.Swap(k, 0, "pending"); existed || old != nil {
+		t.Error("Error swapping absent key", old, existed)
+	}
+
+	// 并发的状态转换中每个旧值只被一个调用方取得
+	var wg sync.WaitGroup
+	seen := make(chan interface{}, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			old, _ := table.Swap(k, 0, i)
+			seen <- old
+		}(i)
+	}
+	wg.Wait()
+	close(seen)
+	olds := make(map[interface{}]bool)
+	for old := range seen {
+		if olds[old] {
+			t.Error("Error previous value returned twice", old)
+		}
+		olds[old] = true
+	}
+	if !olds["pending"] {
+		t.Error("Error initial value never returned")
+	}
+}
+
+func TestSetIfNewer(t *testing.T) {
+	table := NewTable("testSetIfNewer")
+	table.Add(k, 0, "plain")
+	if _, err := table.SetIfNewer(k, "v5", 5); err != nil {
+		t.Error("Error any version should replace a plain write", err)
+	}
+	if _, err := table.SetIfNewer(k, "v3", 3); !errors.Is(err, ErrStaleVersion) {
+		t.Error("Error expected ErrStaleVersion for out-of-order update, got", err)
+	}
+	if _, err := table.SetIfNewer(k, "again", 5); !errors.Is(err, ErrStaleVersion) {
+		t.Error("Error equal version should not replace", err)
+	}
+	item, err := table.SetIfNewer(k, "v7", 7)
+	if err != nil || item.SourceVersion() != 7 {
+		t.Error("Error newer version not applied", err)
+	}
+	if p, _ := table.Peek(k); p.Value() != "v7" {
+		t.Error("Error unexpected value", p.Value())
+	}
+}
+
+func TestNextExpiration(t *testing.T) {
+	clock := &manualClock{now: time.Unix(0, 0)}
+	table := NewTable("testNextExpiration", WithClock(clock))
+	if _, _, ok := table.NextExpiration(); ok {
+		t.Error("Error empty table has no next expiration")
+	}
+	table.Add("forever", 0, v)
+	table.Add("late", time.Hour, v)
+	table.Add("soon", time.Minute, v)
+	table.Add("mid", 10*time.Minute, v)
+
+	key, at, ok := table.NextExpiration()
+	if !ok || key != "soon" || !at.Equal(time.Unix(60, 0)) {
+		t.Error("Error unexpected next expiration", key, at, ok)
+	}
+	items := table.ExpiringWithin(10 * time.Minute)
+	if len(items) != 2 || items[0].Key() != "soon" || items[1].Key() != "mid" {
+		t.Error("Error unexpected expiring items", len(items))
+	}
+
+	// 访问会推迟过期时间
+	clock.now = clock.now.Add(30 * time.Second)
+	table.Value("soon")
+	if _, at, _ := table.NextExpiration(); !at.Equal(time.Unix(90, 0)) {
+		t.Error("Error access should extend expiration", at)
+	}
+}
+
+func TestItemDone(t *testing.T) {
+	closed := func(item *CacheItem) bool {
+		select {
+		case <-item.Done():
+			return true
+		default:
+			return false
+		}
+	}
+	clock := &manualClock{now: time.Unix(0, 0)}
+	table := NewTable("testItemDone", WithClock(clock))
+
+	deleted := table.Add("deleted", 0, v)
+	expiring := table.Add("expiring", time.Second, v)
+	overwritten := table.Add("overwritten", 0, v)
+	flushed := table.Add("flushed", 0, v)
+	if closed(deleted) || closed(expiring) || closed(overwritten) || closed(flushed) {
+		t.Fatal("Error Done closed while item is cached")
+	}
+
+	table.Delete("deleted")
+	clock.now = clock.now.Add(time.Second)
+	table.RunExpirationNow()
+	table.Add("overwritten", 0, "new")
+	if !closed(deleted) || !closed(expiring) || !closed(overwritten) {
+		t.Error("Error Done not closed on removal")
+	}
+	current, _ := table.Peek("overwritten")
+	if closed(current) || closed(flushed) {
+		t.Error("Error Done closed for cached item")
+	}
+	table.Flush()
+	if !closed(flushed) || !closed(current) {
+		t.Error("Error Done not closed on flush")
+	}
+	if NewCacheItem(k, 0, v).Done() == nil {
+		t.Error("Error Done should not be nil for standalone items")
+	}
+}
+
+func TestExpiredBatchCallback(t *testing.T) {
+	clock := &manualClock{now: time.Unix(0, 0)}
+	table := NewTable("testExpiredBatch", WithClock(clock))
+	var batches [][]*CacheItem
+	table.AddExpiredBatchCallback(func(items []*CacheItem) { batches = append(batches, items) })
+	for i := 0; i < 100; i++ {
+		table.Add(i, time.Second, v)
+	}
+	table.Add("forever", 0, v)
+
+	table.RunExpirationNow()
+	if len(batches) != 0 {
+		t.Error("Error batch callback called without expirations")
+	}
+	clock.now = clock.now.Add(time.Second)
+	table.RunExpirationNow()
+	if len(batches) != 1 || len(batches[0]) != 100 {
+		t.Error("Error expected one batch of 100 items", len(batches))
+	}
+
+	table.RemoveExpiredBatchCallbacks()
+	table.Add(k, time.Second, v)
+	clock.now = clock.now.Add(time.Second)
+	table.RunExpirationNow()
+	if len(batches) != 1 {
+		t.Error("Error removed batch callback still called")
+	}
+}
+
+func TestMaxExpirePerSweep(t *testing.T) {
+	clock := &manualClock{now: time.Unix(0, 0)}
+	table := NewTable("testMaxExpirePerSweep", WithClock(clock), WithMaxExpirePerSweep(40))
+	for i := 0; i < 100; i++ {
+		table.Add(i, time.Second, v)
+	}
+	clock.now = clock.now.Add(time.Second)
+	for _, want := range []int{40, 40, 20, 0} {
+		if n := table.RunExpirationNow(); n != want {
+			t.Error("Error expected", want, "removals, got", n)
+		}
+	}
+	if table.Count() != 0 {
+		t.Error("Error expired items left", table.Count())
+	}
+}
+
+func TestMinCleanupInterval(t *testing.T) {
+	clock := &manualClock{now: time.Unix(0, 0)}
+	table := NewTable("testMinCleanupInterval", WithClock(clock), WithMinCleanupInterval(10*time.Millisecond))
+	table.Add("a", time.Second, v)
+	table.Add("b", time.Second+5*time.Millisecond, v)
+	table.Add("c", time.Second+20*time.Millisecond, v)
+
+	clock.now = clock.now.Add(time.Second)
+	if n := table.RunExpirationNow(); n != 1 || !table.Exists("b") {
+		t.Error("Error expected only expired items to be removed, removed", n)
+	}
+	if table.cleanupInterval != 10*time.Millisecond {
+		t.Error("Error expected the cleanup interval to be raised to the floor", table.cleanupInterval)
+	}
+
+	clock.now = clock.now.Add(10 * time.Millisecond)
+	if n := table.RunExpirationNow(); n != 1 || table.Exists("b") || !table.Exists("c") {
+		t.Error("Error expected items due within the floor to be removed by the next check, removed", n)
+	}
+	if table.cleanupInterval != 10*time.Millisecond {
+		t.Error("Error unexpected cleanup interval", table.cleanupInterval)
+	}
+}
+
+func TestCleanupSchedule(t *testing.T) {
+	clock := &manualClock{now: time.Unix(0, 0)}
+	table := NewTable("testCleanupSchedule", WithClock(clock), WithCleanupSchedule(0.5, 0))
+	table.Add(k, time.Second, v)
+	for i := 0; i < 20; i++ {
+		table.RunExpirationNow()
+		if d := table.cleanupInterval; d < time.Second || d > 1500*time.Millisecond {
+			t.Fatal("Error jittered interval out of range", d)
+		}
+	}
+
+	table.SetSettings(Settings{CleanupJitter: 0.5, MaxCleanupInterval: 1200 * time.Millisecond})
+	table.Add("long", time.Hour, v)
+	table.Delete(k)
+	table.RunExpirationNow()
+	if d := table.cleanupInterval; d != 1200*time.Millisecond {
+		t.Error("Error max interval not honored", d)
+	}
+}
+
+func TestExpiredGrace(t *testing.T) {
+	clock := &manualClock{now: time.Unix(0, 0)}
+	table := NewTable("testExpiredGrace", WithClock(clock), WithExpiredGrace(time.Minute))
+	table.Add(k, time.Second, v)
+	if _, ok := table.Stale(k); ok {
+		t.Error("Error live item reported as stale")
+	}
+
+	clock.now = clock.now.Add(time.Second)
+	table.RunExpirationNow()
+	if table.Exists(k) {
+		t.Fatal("Error expired item still in table")
+	}
+	stale, ok := table.Stale(k)
+	if !ok || !stale.Stale() || stale.Value() != v {
+		t.Error("Error expired item not kept during grace period")
+	}
+
+	// stale-if-error
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem { return nil })
+	item, err := table.Value(k)
+	if err != nil || !item.Stale() || item.Value() != v {
+		t.Error("Error expected stale value when loader fails", err)
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	table.RunExpirationNow()
+	if _, ok := table.Stale(k); ok {
+		t.Error("Error item kept after grace period")
+	}
+	if _, err := table.Value(k); !errors.Is(err, ErrKeyNotFoundOrLoadable) {
+		t.Error("Error expected load failure after grace period, got", err)
+	}
+
+	table.Add(k, time.Second, v)
+	clock.now = clock.now.Add(time.Second)
+	table.RunExpirationNow()
+	table.Add(k, 0, "new")
+	if _, ok := table.Stale(k); ok {
+		t.Error("Error rewritten key still has a stale item")
+	}
+
+	for i := 0; i < 3; i++ {
+		table.Add(i, time.Second, v)
+		clock.now = clock.now.Add(time.Second)
+		table.RunExpirationNow()
+	}
+	clock.now = clock.now.Add(time.Minute - 2*time.Second)
+	table.RunExpirationNow()
+	table.RLock()
+	left := len(table.graveyard.entries)
+	table.RUnlock()
+	if _, ok := table.Stale(0); ok || left != 2 {
+		t.Error("Error pruning grace entries in expiry order", left)
+	}
+}
+
+func TestExpiryBuckets(t *testing.T) {
+	clock := &manualClock{now: time.Unix(0, 0)}
+	table := NewTable("testExpiryBuckets", WithClock(clock), WithExpiryBuckets(time.Second))
+	for i := 0; i < 100; i++ {
+		table.Add(i, time.Minute, v)
+	}
+	table.Add("short", 1500*time.Millisecond, v)
+	table.Add("forever", 0, v)
+	table.Add("overwritten", time.Second, v)
+	table.Add("overwritten", time.Hour, v)
+	table.Delete(0)
+
+	if len(table.buckets.buckets) != 3 {
+		t.Error("Error expected three buckets, got", len(table.buckets.buckets))
+	}
+	// 1.5s归入2s到期的桶
+	clock.now = clock.now.Add(1500 * time.Millisecond)
+	if n := table.RunExpirationNow(); n != 0 {
+		t.Error("Error bucket dropped before its end", n)
+	}
+	clock.now = clock.now.Add(500 * time.Millisecond)
+	if n := table.RunExpirationNow(); n != 1 || table.Exists("short") {
+		t.Error("Error expected short bucket to be dropped", n)
+	}
+	// 访问不延长分桶模式下的有效期
+	table.Value(1)
+	clock.now = time.Unix(60, 0)
+	if n := table.RunExpirationNow(); n != 99 {
+		t.Error("Error expected minute bucket to be dropped, removed", n)
+	}
+	if !table.Exists("overwritten") || !table.Exists("forever") || table.Count() != 2 {
+		t.Error("Error unexpected remaining items", table.Count())
+	}
+	if table.cleanupInterval != time.Hour-time.Minute {
+		t.Error("Error next check should be at the hour bucket", table.cleanupInterval)
+	}
+}
+
+func TestEvictionPolicy2Q(t *testing.T) {
+	table := NewTable("testEvictionPolicy2Q", WithMaxItems(8), WithEvictionPolicy(Evict2Q))
+	hot := func(i int) string { return k + "_hot_" + strconv.Itoa(i) }
+	for i := 0; i < 4; i++ {
+		table.Add(hot(i), 0, v)
+	}
+	// 把热点键挤出试用队列后再次加入, 进入受保护队列
+	for i := 0; i < 8; i++ {
+		table.Add(k+"_fill_"+strconv.Itoa(i), 0, v)
+	}
+	for i := 0; i < 4; i++ {
+		if table.Exists(hot(i)) {
+			t.Fatal("Error evicting probationary item", hot(i))
+		}
+		table.Add(hot(i), 0, v)
+	}
+
+	// 一次性扫描只淘汰试用队列中的元素
+	for i := 0; i < 20; i++ {
+		table.Add(k+"_scan_"+strconv.Itoa(i), 0, v)
+	}
+	if table.Count() != 8 {
+		t.Error("Error enforcing capacity", table.Count())
+	}
+	for i := 0; i < 4; i++ {
+		if !table.Exists(hot(i)) {
+			t.Error("Error protecting re-admitted item from scan", hot(i))
+		}
+	}
+	if Evict2Q.String() != "2q" {
+		t.Error("Error naming 2Q policy")
+	}
+}
+
+func TestEvictionPolicySLRU(t *testing.T) {
+	table := NewTable("testEvictionPolicySLRU", WithMaxItems(4), WithSegmentedLRU(0.5))
+	for i := 0; i < 4; i++ {
+		table.Add(k+"_"+strconv.Itoa(i), 0, v)
+	}
+	// 命中一次的元素晋升到受保护段, 未命中的先被淘汰
+	table.Value(k + "_0")
+	table.Value(k + "_1")
+	table.Add(k+"_4", 0, v)
+	table.Add(k+"_5", 0, v)
+	for i, exists := range []bool{true, true, false, false, true, true} {
+		if table.Exists(k+"_"+strconv.Itoa(i)) != exists {
+			t.Error("Error evicting probationary items first", i)
+		}
+	}
+
+	// 受保护段超出比例时最久未用的元素降回试用段的头部
+	table.Value(k + "_4")
+	table.Add(k+"_6", 0, v)
+	table.Add(k+"_7", 0, v)
+	if table.Exists(k+"_0") || table.Exists(k+"_5") || !table.Exists(k+"_1") || !table.Exists(k+"_4") {
+		t.Error("Error demoting protected item")
+	}
+
+	// 覆盖写入与命中一样算作一次访问, 加入后只需一次即晋升
+	table = NewTable("testEvictionPolicySLRUOverwrite", WithMaxItems(3), WithSegmentedLRU(0.5))
+	for i := 0; i < 3; i++ {
+		table.Add(k+"_"+strconv.Itoa(i), 0, v)
+	}
+	table.Add(k+"_0", 0, v)
+	table.Add(k+"_3", 0, v)
+	if !table.Exists(k+"_0") || table.Exists(k+"_1") {
+		t.Error("Error promoting overwritten item")
+	}
+}
+
+func TestEvictionPolicyClock(t *testing.T) {
+	table := NewTable("testEvictionPolicyClock", WithMaxItems(3), WithEvictionPolicy(EvictClock))
+	for i := 0; i < 3; i++ {
+		table.Add(k+"_"+strconv.Itoa(i), 0, v)
+	}
+	// 被命中的元素得到第二次机会
+	table.Value(k + "_0")
+	table.Add(k+"_3", 0, v)
+	if !table.Exists(k+"_0") || table.Exists(k+"_1") {
+		t.Error("Error giving referenced item a second chance")
+	}
+	table.Add(k+"_4", 0, v)
+	if table.Exists(k+"_2") || table.Count() != 3 {
+		t.Error("Error advancing clock hand")
+	}
+	if EvictClock.String() != "clock" {
+		t.Error("Error naming CLOCK policy")
+	}
+}
+
+func TestEvictionPolicyRandom(t *testing.T) {
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	table := NewTable("testEvictionPolicyRandom", WithClock(clock), WithMaxItems(10), WithRandomEviction(1))
+	for i := 0; i < 100; i++ {
+		table.Add(k+"_"+strconv.Itoa(i), 0, v)
+		if table.Count() > 10 {
+			t.Fatal("Error enforcing capacity", table.Count())
+		}
+	}
+	if !table.Exists(k + "_99") {
+		t.Error("Error evicting newly added item")
+	}
+	for i := 0; i < 100; i += 3 {
+		table.Delete(k + "_" + strconv.Itoa(i))
+	}
+	for i := 100; i < 120; i++ {
+		table.Add(k+"_"+strconv.Itoa(i), 0, v)
+	}
+	if table.Count() != 10 {
+		t.Error("Error tracking keys after delete", table.Count())
+	}
+
+	// 抽样数不小于元素数时几乎总是淘汰最久未访问的元素
+	table = NewTable("testEvictionPolicyRandom", WithClock(clock), WithMaxItems(2), WithRandomEviction(64))
+	table.Add(k+"_old", 0, v)
+	clock.now = clock.now.Add(time.Second)
+	table.Add(k+"_new", 0, v)
+	clock.now = clock.now.Add(time.Second)
+	table.Add(k+"_newest", 0, v)
+	if table.Exists(k+"_old") || !table.Exists(k+"_new") {
+		t.Error("Error evicting least recently accessed sample")
+	}
+}
+
+// recordingEvictor 记录调用的Evictor, 淘汰最早加入的键
+type recordingEvictor struct {
+	mu      sync.Mutex
+	keys    []interface{}
+	removed map[interface{}]MutationOp
+}
+
+func (e *recordingEvictor) OnAdd(key interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.keys = append(e.keys, key)
+}
+
+func (e *recordingEvictor) OnAccess(key interface{}) {}
+
+func (e *recordingEvictor) OnRemove(key interface{}, op MutationOp) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.removed[key] = op
+	for i, k := range e.keys {
+		if k == key {
+			e.keys = append(e.keys[:i], e.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+func (e *recordingEvictor) Victim(keep interface{}) (interface{}, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, k := range e.keys {
+		if k != keep {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+func TestEvictor(t *testing.T) {
+	e := &recordingEvictor{removed: map[interface{}]MutationOp{}}
+	table := NewTable("testEvictor", WithMaxItems(2))
+	table.Add(k+"_0", 0, v)
+	table.SetEvictor(e)
+	table.Add(k+"_1", 0, v)
+	table.Add(k+"_2", 0, v)
+	if table.Exists(k+"_0") || e.removed[k+"_0"] != OpEvict {
+		t.Error("Error evicting through custom evictor")
+	}
+	table.Delete(k + "_1")
+	table.Flush()
+	if e.removed[k+"_1"] != OpDelete || e.removed[k+"_2"] != OpFlush || len(e.keys) != 0 {
+		t.Error("Error notifying evictor of removals", e.removed, e.keys)
+	}
+
+	for _, c := range []struct {
+		evictor Evictor
+		evicted string
+	}{
+		{NewLRUEvictor(), "_1"},
+		{NewLFUEvictor(), "_2"},
+		{NewFIFOEvictor(), "_0"},
+	} {
+		table := NewTable("testEvictorBuiltin", WithMaxItems(3), WithEvictor(c.evictor))
+		for i := 0; i < 3; i++ {
+			table.Add(k+"_"+strconv.Itoa(i), 0, v)
+		}
+		for _, i := range []int{1, 1, 1, 2, 0, 0} {
+			table.Value(k + "_" + strconv.Itoa(i))
+		}
+		table.Add(k+"_3", 0, v)
+		if table.Exists(k+c.evicted) || table.Count() != 3 {
+			t.Errorf("Error evicting with %T, expected %s evicted", c.evictor, c.evicted)
+		}
+	}
+
+	for _, p := range []EvictionPolicy{EvictLRU, EvictLFU, EvictFIFO} {
+		if NewTable("testEvictorPolicy", WithEvictionPolicy(p)).evictor == nil {
+			t.Error("Error installing evictor for policy", p)
+		}
+	}
+	// 没有设置淘汰策略的表在第一次淘汰时按访问时间创建LRU
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	table = NewTable("testEvictorSeed", WithClock(clock))
+	for i := 0; i < 3; i++ {
+		table.Add(k+"_"+strconv.Itoa(i), 0, v)
+		clock.now = clock.now.Add(time.Second)
+	}
+	table.Value(k + "_0")
+	if table.evictor != nil {
+		t.Error("Error installing evictor before eviction is needed")
+	}
+	table.SetSettings(Settings{MaxItems: 2})
+	table.Add(k+"_3", 0, v)
+	if table.evictor == nil || table.Exists(k+"_1") || table.Exists(k+"_2") || !table.Exists(k+"_0") {
+		t.Error("Error seeding default evictor in access order")
+	}
+}
+
+func TestWatermarks(t *testing.T) {
+	table := NewTable("testWatermarks", WithWatermarks(10, 6))
+	for i := 0; i < 10; i++ {
+		table.Add(k+"_"+strconv.Itoa(i), 0, v)
+	}
+	if table.Count() != 10 {
+		t.Error("Error evicting below high watermark", table.Count())
+	}
+	table.Add(k+"_10", 0, v)
+	if table.Count() != 6 || !table.Exists(k+"_10") {
+		t.Error("Error evicting down to low watermark", table.Count())
+	}
+	if s := table.Stats(); s.Evictions != 5 {
+		t.Error("Error counting evictions", s.Evictions)
+	}
+	table.Add(k+"_11", 0, v)
+	if table.Count() != 7 {
+		t.Error("Error evicting before reaching high watermark", table.Count())
+	}
+}
+
+func TestEvictConcurrentDelete(t *testing.T) {
+	table := NewTable("testEvictConcurrentDelete", WithMaxItems(4))
+	table.SetAboutToDeleteItemCallback(func(*CacheItem) {
+		time.Sleep(time.Millisecond)
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					key := k + "_" + strconv.Itoa(i%8)
+					table.Add(key, 0, v)
+					if (i+g)%2 == 0 {
+						table.Delete(key)
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Error evicting items concurrently deleted: writers did not finish")
+	}
+}
+
+func TestBackgroundEviction(t *testing.T) {
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	table := NewTable("testBackgroundEviction", WithClock(clock), WithMaxItems(10), WithBackgroundEviction(300))
+	for i := 0; i < 20; i++ {
+		table.Add(k+"_"+strconv.Itoa(i), 0, v)
+		clock.now = clock.now.Add(time.Second)
+	}
+	if table.Count() != 20 {
+		t.Error("Error evicting in foreground", table.Count())
+	}
+
+	// 每秒300个, 每10ms最多淘汰3个
+	state := table.bgEvict
+	if state.interval != 10*time.Millisecond || state.budget != 3 {
+		t.Error("Error pacing background eviction", state.interval, state.budget)
+	}
+	table.backgroundEvictTick(state)
+	if table.Count() != 17 {
+		t.Error("Error evicting budget per tick", table.Count())
+	}
+	for i := 0; i < 5; i++ {
+		table.backgroundEvictTick(state)
+	}
+	if table.Count() != 10 || !table.Exists(k+"_19") {
+		t.Error("Error evicting down to capacity", table.Count())
+	}
+
+	table.Add(k+"_20", 0, v)
+	table.SetBackgroundEviction(0)
+	if table.Count() != 10 || table.bgEvict != nil {
+		t.Error("Error evicting after disabling background eviction", table.Count())
+	}
+}
+
+func TestAdmissionPolicy(t *testing.T) {
+	freq := NewFrequencyAdmission(64)
+	table := NewTable("testAdmissionPolicy", WithMaxItems(2), WithAdmissionPolicy(freq))
+	table.Add(k+"_hot", 0, v)
+	table.Add(k+"_warm", 0, v)
+	for i := 0; i < 3; i++ {
+		table.Value(k + "_hot")
+		table.Value(k + "_warm")
+	}
+
+	// 表已满时只出现一次的键被拒绝
+	if _, err := table.TryAdd(k+"_once", 0, v); !errors.Is(err, ErrNotAdmitted) {
+		t.Error("Error rejecting one-hit key", err)
+	}
+	if table.Count() != 2 || !table.Exists(k+"_hot") || !table.Exists(k+"_warm") {
+		t.Error("Error keeping frequent items")
+	}
+	for i := 0; i < 5; i++ {
+		table.Add(k+"_new", 0, v)
+	}
+	if !table.Exists(k + "_new") {
+		t.Error("Error admitting key more frequent than victim", freq.Frequency(k+"_new"))
+	}
+	// 覆盖已有的键不经过准入
+	if _, err := table.TryAdd(k+"_new", 0, v); err != nil {
+		t.Error("Error updating existing key", err)
+	}
+	if s := table.Stats(); s.NotAdmitted != 5 {
+		t.Error("Error counting rejected writes", s.NotAdmitted)
+	}
+
+	table = NewTable("testAdmissionPolicy", WithMaxItems(1), WithAdmissionPolicy(NewRandomAdmission(0)))
+	if table.Add(k+"_0", 0, v) == nil || table.Add(k+"_1", 0, v) != nil {
+		t.Error("Error applying random admission")
+	}
+	table = NewTable("testAdmissionPolicy", WithMaxItems(1), WithAdmissionPolicy(NewSizeAdmission(1)))
+	table.Add(k+"_0", 0, v)
+	if table.Add(k+"_1", 0, strings.Repeat(v, 100)) != nil {
+		t.Error("Error rejecting large value")
+	}
+}
+
+func TestMissingFilter(t *testing.T) {
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	loads := map[string]int{}
+	table := NewTable("testMissingFilter", WithClock(clock),
+		WithMissingFilter(MissingFilterConfig{Expected: 100, Rotation: time.Minute}))
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		loads[key.(string)]++
+		if key == k+"_exists" {
+			return NewCacheItem(key, 0, v)
+		}
+		return nil
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := table.Value(k + "_missing"); !errors.Is(err, ErrKeyNotFoundOrLoadable) {
+			t.Error("Error returning not found for missing key", err)
+		}
+	}
+	if loads[k+"_missing"] != 1 {
+		t.Error("Error skipping loader for known-missing key", loads[k+"_missing"])
+	}
+	if s := table.Stats(); s.MissingFiltered != 2 {
+		t.Error("Error counting filtered misses", s.MissingFiltered)
+	}
+	if _, err := table.Value(k + "_exists"); err != nil {
+		t.Error("Error loading existing key", err)
+	}
+	table.MarkMissing(k + "_marked")
+	table.Value(k + "_marked")
+	if loads[k+"_marked"] != 0 {
+		t.Error("Error marking key missing")
+	}
+
+	// 两代之后记录失效
+	clock.now = clock.now.Add(90 * time.Second)
+	table.Value(k + "_missing")
+	if loads[k+"_missing"] != 1 {
+		t.Error("Error checking previous generation")
+	}
+	clock.now = clock.now.Add(time.Minute)
+	table.Value(k + "_missing")
+	if loads[k+"_missing"] != 2 {
+		t.Error("Error rotating filter", loads[k+"_missing"])
+	}
+	table.ResetMissingFilter()
+	table.Value(k + "_missing")
+	if loads[k+"_missing"] != 3 {
+		t.Error("Error resetting filter", loads[k+"_missing"])
+	}
+}
+
+func TestDoorkeeper(t *testing.T) {
+	loads := 0
+	table := NewTable("testDoorkeeper", WithAdmissionPolicy(NewRandomAdmission(1)), WithDoorkeeper(100))
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		loads++
+		return NewCacheItem(key, 0, v)
+	})
+
+	// 第一次出现的键不进入表, 但加载的值仍然返回
+	if p, err := table.Value(k + "_crawl"); err != nil || p.Value() != v {
+		t.Error("Error returning loaded value rejected by doorkeeper", err)
+	}
+	if table.Exists(k + "_crawl") {
+		t.Error("Error admitting first-time key")
+	}
+	table.Value(k + "_crawl")
+	if !table.Exists(k+"_crawl") || loads != 2 {
+		t.Error("Error admitting key seen twice", loads)
+	}
+	if _, err := table.TryAdd(k+"_once", 0, v); !errors.Is(err, ErrNotAdmitted) {
+		t.Error("Error rejecting first write", err)
+	}
+
+	// 没有准入策略时不生效
+	table = NewTable("testDoorkeeper", WithDoorkeeper(100))
+	if table.Add(k, 0, v) == nil {
+		t.Error("Error applying doorkeeper without admission policy")
+	}
+}
+
+func TestTraceReplay(t *testing.T) {
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	table := NewTable("testTraceReplay", WithClock(clock))
+	var buf bytes.Buffer
+	table.StartTrace(&buf)
+	// 两个热点键夹在一次性扫描之间
+	for round := 0; round < 5; round++ {
+		for _, key := range []string{"hot_a", "hot_b"} {
+			if _, err := table.Value(key); err != nil {
+				table.Add(key, 0, v)
+			}
+			clock.now = clock.now.Add(time.Millisecond)
+		}
+		for i := 0; i < 3; i++ {
+			table.Value("scan_" + strconv.Itoa(round*3+i))
+			clock.now = clock.now.Add(time.Millisecond)
+		}
+	}
+	table.Delete("hot_a")
+	if err := table.StopTrace(); err != nil {
+		t.Fatal("Error stopping trace", err)
+	}
+	table.Value("untraced")
+
+	records, err := ReadTrace(&buf)
+	if err != nil || len(records) != 5*(2+3)+2+1 {
+		t.Fatal("Error reading trace", len(records), err)
+	}
+	if r := records[0]; r.Op != CallGet || r.Key != "hot_a" || !r.Time.Equal(time.Unix(1000, 0)) {
+		t.Error("Error recording access", r)
+	}
+	if r := records[len(records)-1]; r.Op != CallDelete || r.Key != "hot_a" {
+		t.Error("Error recording delete", r)
+	}
+
+	results := Replay(records, []EvictionPolicy{EvictLRU, Evict2Q}, []int{2, 100})
+	if len(results) != 4 {
+		t.Fatal("Error replaying all configurations", len(results))
+	}
+	for _, r := range results {
+		if r.Gets != 25 {
+			t.Error("Error counting gets", r)
+		}
+		if r.Capacity == 100 && r.Hits != 8 {
+			t.Error("Error replaying with large capacity", r)
+		}
+		if r.Capacity == 2 && r.HitRate() >= 8.0/25 {
+			t.Error("Error evicting during replay", r)
+		}
+	}
+}
+
+func TestBench(t *testing.T) {
+	workload := BenchWorkload{Name: "test", Keys: 1000, Ops: 20000, Zipf: 1.2, WriteRatio: 0.1, Seed: 1}
+	results := Bench(BenchConfig{
+		Policies:    []EvictionPolicy{EvictLRU, EvictFIFO, Evict2Q},
+		Shards:      []int{1, 4},
+		Capacity:    100,
+		Concurrency: 2,
+	}, workload)
+	if len(results) != 6 {
+		t.Fatal("Error running all combinations", len(results))
+	}
+	for _, r := range results {
+		if r.Workload != "test" || r.Ops != 20000 || r.Hits+r.Misses == 0 || r.OpsPerSec() <= 0 {
+			t.Error("Error reporting result", r)
+		}
+		// zipf分布下容量为键数1/10时命中率应明显高于1/10
+		if r.HitRate() < 0.3 {
+			t.Error("Error caching hot keys", r.Policy, r.Shards, r.HitRate())
+		}
+	}
+	if results[0].Policy != EvictLRU || results[1].Shards != 4 || results[2].Policy != EvictFIFO {
+		t.Error("Error ordering results")
+	}
+
+	uniform := Bench(BenchConfig{Capacity: 100, Concurrency: 1}, BenchWorkload{Keys: 1000, Ops: 20000, Seed: 1})
+	if len(uniform) != 1 || uniform[0].HitRate() > 0.2 {
+		t.Error("Error running uniform workload with defaults", uniform)
+	}
+}
+
+func TestDump(t *testing.T) {
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	table := NewTable("testDump", WithClock(clock))
+	table.Add("b", time.Minute, strings.Repeat("x", 100))
+	clock.now = clock.now.Add(time.Second)
+	table.Add("a", 0, 42)
+	table.Add("c", 10*time.Second, v)
+	table.Value("c")
+	table.Value("c")
+	clock.now = clock.now.Add(time.Second)
+
+	var buf bytes.Buffer
+	if err := table.Dump(&buf, DumpOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], "KEY ") || lines[4] != "3 of 3 items" {
+		t.Fatal("Error dumping table", buf.String())
+	}
+	if f := strings.Fields(lines[1]); f[0] != "a" || f[1] != "int" || f[3] != "1s" || f[4] != "-" || f[5] != "0" {
+		t.Error("Error formatting item", lines[1])
+	}
+	if f := strings.Fields(lines[3]); f[0] != "c" || f[4] != "9s" || f[5] != "2" {
+		t.Error("Error formatting item with ttl", lines[3])
+	}
+
+	for sort, first := range map[DumpSort]string{DumpBySize: "b", DumpByAge: "b", DumpByTTL: "c", DumpByAccess: "c"} {
+		buf.Reset()
+		table.Dump(&buf, DumpOptions{Sort: sort, Limit: 1})
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 3 || strings.Fields(lines[1])[0] != first || lines[2] != "1 of 3 items" {
+			t.Error("Error sorting dump", sort, buf.String())
+		}
+	}
+}
+
+func TestProfilerLabels(t *testing.T) {
+	var table, op string
+	var ok bool
+	loader := func(ctx context.Context, key interface{}, args ...interface{}) *CacheItem {
+		table, ok = pprof.Label(ctx, LabelTable)
+		op, _ = pprof.Label(ctx, LabelOp)
+		return NewCacheItem(key, 0, v)
+	}
+
+	plain := Cache("testProfilerLabelsOff")
+	plain.SetDataLoaderContext(loader)
+	if _, err := plain.Value(k); err != nil {
+		t.Error("Error loading value", err)
+	}
+	if ok {
+		t.Error("Error labels set while profiler labels are disabled")
+	}
+
+	labeled := Cache("testProfilerLabels", WithProfilerLabels())
+	labeled.SetDataLoaderContext(loader)
+	if _, err := labeled.Value(k); err != nil {
+		t.Error("Error loading value", err)
+	}
+	if table != "testProfilerLabels" || op != "load" {
+		t.Error("Error unexpected loader labels", table, op)
+	}
+
+	var addOp string
+	labeled.AddAddedItemCallbackContext(func(ctx context.Context, item *CacheItem) {
+		addOp, _ = pprof.Label(ctx, LabelOp)
+	})
+	labeled.Add(k+"_2", 0, v)
+	if addOp != "callback" {
+		t.Error("Error unexpected callback label", addOp)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	table := Cache("testHealth", WithClock(clock))
+	defer Remove("testHealth")
+	table.Add(k, time.Second, v)
+	if h := table.Health(); !h.Healthy || h.SweepBacklog != 0 || !h.LastSweep.Equal(clock.now) {
+		t.Error("Error reporting healthy table", h)
+	}
+
+	// 定时器不会触发, 过期元素一直积压
+	clock.now = clock.now.Add(2 * time.Minute)
+	h := table.Health()
+	if h.Healthy || h.SweepBacklog != 1 || h.SweepLag != 2*time.Minute-time.Second {
+		t.Error("Error detecting stalled expiration sweep", h)
+	}
+	rec := httptest.NewRecorder()
+	HealthHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	var registry RegistryHealth
+	json.NewDecoder(rec.Body).Decode(&registry)
+	if rec.Code != http.StatusServiceUnavailable || registry.Healthy {
+		t.Error("Error aggregating unhealthy table", rec.Code, registry)
+	}
+	table.RunExpirationNow()
+	if h := table.Health(); !h.Healthy || h.SweepBacklog != 0 {
+		t.Error("Error recovering after sweep", h)
+	}
+
+	table.SetHealthThresholds(HealthThresholds{MaxLoaderFailureRate: 0.5, MaxPersistLag: time.Hour})
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem { return nil })
+	table.Value(k + "_missing")
+	if h := table.Health(); h.Healthy || h.LoaderCalls != 1 || h.LoaderFailureRate != 1 {
+		t.Error("Error reporting loader failure rate", h)
+	}
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem { return NewCacheItem(key, 0, v) })
+	table.Value(k + "_a")
+	table.Value(k + "_b")
+
+	table.SetSnapshotFile(filepath.Join(t.TempDir(), "health.snapshot"))
+	if h := table.Health(); h.Healthy || len(h.Problems) != 1 {
+		t.Error("Error reporting table that was never persisted", h)
+	}
+	if err := table.Persist(); err != nil {
+		t.Error("Error persisting table", err)
+	}
+	clock.now = clock.now.Add(30 * time.Minute)
+	if h := table.Health(); !h.Healthy || h.PersistLag != 30*time.Minute {
+		t.Error("Error reporting persistence lag", h)
+	}
+	clock.now = clock.now.Add(time.Hour)
+	if h := table.Health(); h.Healthy {
+		t.Error("Error detecting stale snapshot", h)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shutdown.gob")
+	table := NewTable("testShutdown")
+	table.SetSnapshotFile(path)
+	started, release := make(chan struct{}), make(chan struct{})
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		close(started)
+		<-release
+		return NewCacheItem(key, 0, v)
+	})
+	loaded := make(chan error)
+	go func() {
+		_, err := table.Value(k)
+		loaded <- err
+	}()
+	<-started
+
+	shutdown := make(chan error)
+	go func() { shutdown <- table.Shutdown(context.Background()) }()
+	for {
+		table.RLock()
+		draining := table.draining
+		table.RUnlock()
+		if draining {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := table.TryAdd(k+"_2", 0, v); !errors.Is(err, ErrTableClosed) {
+		t.Error("Error accepting writes while shutting down", err)
+	}
+	if _, err := table.Value(k + "_3"); !errors.Is(err, ErrTableClosed) {
+		t.Error("Error starting loads while shutting down", err)
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatal("Shutdown returned before in-flight load completed", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-loaded; err != nil {
+		t.Error("Error completing in-flight load", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Error("Error shutting down table", err)
+	}
+	if !table.Closed() || table.Count() != 1 {
+		t.Error("Error closing table", table.Count())
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal("Snapshot not written", err)
+	}
+	defer f.Close()
+	restored := NewTable("testShutdownRestored")
+	if n, err := restored.LoadSnapshot(f); err != nil || n != 1 || !restored.Exists(k) {
+		t.Error("Error draining loaded item to snapshot", n, err)
+	}
+	if err := table.Shutdown(context.Background()); !errors.Is(err, ErrTableClosed) {
+		t.Error("Error shutting down closed table", err)
+	}
+
+	stuck := NewTable("testShutdownTimeout")
+	stuckStarted, block := make(chan struct{}), make(chan struct{})
+	defer close(block)
+	stuck.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		close(stuckStarted)
+		<-block
+		return nil
+	})
+	go stuck.Value(k)
+	<-stuckStarted
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := stuck.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) || !stuck.Closed() {
+		t.Error("Error giving up on stuck load", err)
+	}
+
+	store := NewTable("testShutdownStore")
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	drained := NewTable("testShutdownDrain", WithClock(clock), WithDrainStore(TableStore(store)))
+	drained.Add(k, time.Minute, v)
+	drained.Add(k+"_forever", 0, v)
+	drained.Add(k+"_expired", time.Second, v)
+	clock.now = clock.now.Add(10 * time.Second)
+	if err := drained.Shutdown(context.Background()); err != nil {
+		t.Error("Error shutting down table with drain store", err)
+	}
+	if item, err := store.Peek(k); err != nil || item.LifeSpan() != 50*time.Second {
+		t.Error("Error draining item with remaining TTL", item, err)
+	}
+	if !store.Exists(k+"_forever") || store.Exists(k+"_expired") {
+		t.Error("Error draining non-expiring and skipping expired items")
+	}
+
+	// Shutdown的ctx已结束时仍写入Store
+	late := NewTable("testShutdownLateStore")
+	expired := NewTable("testShutdownExpiredCtx", WithDrainStore(ctxStore{TableStore(late)}))
+	expired.Add(k, 0, v)
+	done, cancelDone := context.WithCancel(context.Background())
+	cancelDone()
+	if err := expired.Shutdown(done); !late.Exists(k) {
+		t.Error("Error draining after Shutdown context ended", err)
+	}
+}
+
+// ctxStore ctx结束时拒绝写入的Store
+type ctxStore struct {
+	Store
+}
+
+func (s ctxStore) Set(ctx context.Context, key interface{}, value interface{}, lifeSpan time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Store.Set(ctx, key, value, lifeSpan)
+}
+
+func TestWarmStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "warm.gob")
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	table := NewTable("testWarmStart", WithClock(clock))
+	table.SetSnapshotFile(path)
+	table.Add(k+"_hour", time.Hour, v)
+	table.Add(k+"_minute", time.Minute, v)
+	table.Add(k+"_forever", 0, v)
+	if err := table.Persist(); err != nil {
+		t.Fatal("Error persisting table", err)
+	}
+
+	clock.now = clock.now.Add(30 * time.Minute)
+	var restored int
+	var from string
+	warm := NewTable("testWarmStartRestored", WithClock(clock), WithWarmStart(path, func(n int, p string, err error) {
+		if err != nil {
+			t.Error("Error restoring snapshot", err)
+		}
+		restored, from = n, p
+	}))
+	if restored != 2 || from != path || !warm.Exists(k+"_hour") || !warm.Exists(k+"_forever") || warm.Exists(k+"_minute") {
+		t.Error("Error restoring unexpired items", restored, from, warm.Count())
+	}
+	clock.now = clock.now.Add(31 * time.Minute)
+	warm.RunExpirationNow()
+	if warm.Exists(k+"_hour") || !warm.Exists(k+"_forever") {
+		t.Error("Error honoring remaining TTL")
+	}
+
+	// 最新的快照损坏时退回上一个快照
+	if err := warm.Persist(); err != nil {
+		t.Fatal("Error persisting table", err)
+	}
+	if err := os.WriteFile(path, []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(path, future, future)
+	NewTable("testWarmStartFallback", WithClock(clock), WithWarmStart(path, func(n int, p string, err error) {
+		restored, from = n, p
+	}))
+	if restored != 1 || from != path+PrevSnapshotSuffix {
+		t.Error("Error falling back to previous snapshot", restored, from)
+	}
+
+	var missingErr error
+	empty := NewTable("testWarmStartMissing", WithWarmStart(filepath.Join(t.TempDir(), "none.gob"), func(n int, p string, err error) {
+		restored, missingErr = n, err
+	}))
+	if restored != 0 || missingErr != nil || empty.Count() != 0 {
+		t.Error("Error starting without snapshot", missingErr)
+	}
+}
+
+func TestSnapshotExpiresAt(t *testing.T) {
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	table := NewTable("testSnapshotExpiresAt", WithClock(clock))
+	table.Add(k+"_short", time.Minute, v)
+	table.Add(k+"_long", time.Hour, v)
+	clock.now = clock.now.Add(10 * time.Minute)
+	// 访问续期后过期时间变为写快照前10分钟+1小时
+	table.Value(k + "_long")
+
+	var buf bytes.Buffer
+	if err := table.SaveSnapshot(&buf); err != nil {
+		t.Fatal("Error saving snapshot", err)
+	}
+	clock.now = clock.now.Add(59 * time.Minute)
+	restored := NewTable("testSnapshotExpiresAtRestored", WithClock(clock))
+	if n, err := restored.LoadSnapshot(&buf); err != nil || n != 1 || restored.Exists(k+"_short") {
+		t.Fatal("Error dropping item past its deadline", n, err)
+	}
+	item, err := restored.Value(k + "_long")
+	if err != nil || item.LifeSpan() != time.Hour {
+		t.Fatal("Error restoring lifespan", err)
+	}
+	// 读取会续期, 按原有效期从现在起算
+	clock.now = clock.now.Add(59 * time.Minute)
+	restored.RunExpirationNow()
+	if !restored.Exists(k + "_long") {
+		t.Error("Error renewing restored item on access")
+	}
+
+	// 只有过期时间的快照
+	buf.Reset()
+	gob.NewEncoder(&buf).Encode([]snapshotEntry{
+		{Key: k + "_due", Value: v, LifeSpan: time.Hour, CreatedOn: time.Unix(0, 0), ExpiresAt: clock.now.Add(time.Minute)},
+		{Key: k + "_past", Value: v, LifeSpan: time.Hour, CreatedOn: time.Unix(0, 0), ExpiresAt: clock.now},
+	})
+	deadline := NewTable("testSnapshotDeadline", WithClock(clock))
+	if n, err := deadline.LoadSnapshot(&buf); err != nil || n != 1 || deadline.Exists(k+"_past") {
+		t.Fatal("Error loading absolute expiry", n, err)
+	}
+	clock.now = clock.now.Add(time.Minute)
+	deadline.RunExpirationNow()
+	if deadline.Exists(k + "_due") {
+		t.Error("Error expiring restored item at its deadline")
+	}
+}
+
+func TestCollections(t *testing.T) {
+	table := NewTable("testCollections", WithDefaultTTL(time.Hour))
+
+	if n, err := table.ListPush(k+"_list", 1, 2); err != nil || n != 2 {
+		t.Error("Error pushing to list", n, err)
+	}
+	before, _ := table.Value(k + "_list")
+	table.ListPush(k+"_list", 3)
+	if got, _ := table.ListRange(k+"_list", 0, -1); len(got) != 3 || got[2] != 3 {
+		t.Error("Error reading list range", got)
+	}
+	if len(before.Value().(List)) != 2 {
+		t.Error("Error modifying list value in place")
+	}
+	if got, _ := table.ListRange(k+"_list", -2, 10); len(got) != 2 || got[0] != 2 {
+		t.Error("Error clamping list range", got)
+	}
+	for i := 1; i <= 3; i++ {
+		if x, ok, err := table.ListPop(k + "_list"); err != nil || !ok || x != i {
+			t.Error("Error popping list", x, ok, err)
+		}
+	}
+	if table.Exists(k+"_list") || table.Exists(k+"_missing") {
+		t.Error("Error deleting emptied list")
+	}
+	if _, ok, err := table.ListPop(k + "_list"); ok || err != nil {
+		t.Error("Error popping missing list", ok, err)
+	}
+
+	if n, _ := table.SetAdd(k+"_set", "a", "b", "a"); n != 2 {
+		t.Error("Error adding set members", n)
+	}
+	if n, _ := table.SetAdd(k+"_set", "b", "c"); n != 1 {
+		t.Error("Error adding existing set member", n)
+	}
+	if ok, _ := table.SetIsMember(k+"_set", "c"); !ok {
+		t.Error("Error checking set membership")
+	}
+	if n, _ := table.SetRemove(k+"_set", "a", "x"); n != 1 {
+		t.Error("Error removing set members", n)
+	}
+	if members, _ := table.SetMembers(k + "_set"); len(members) != 2 {
+		t.Error("Error listing set members", members)
+	}
+
+	if created, _ := table.HashSet(k+"_hash", "f", 1); !created {
+		t.Error("Error creating hash field")
+	}
+	if created, _ := table.HashSet(k+"_hash", "f", 2); created {
+		t.Error("Error overwriting hash field")
+	}
+	table.HashSet(k+"_hash", "g", 3)
+	if x, ok, _ := table.HashGet(k+"_hash", "f"); !ok || x != 2 {
+		t.Error("Error reading hash field", x)
+	}
+	if n, _ := table.HashDelete(k+"_hash", "f", "missing"); n != 1 {
+		t.Error("Error deleting hash fields", n)
+	}
+	if all, _ := table.HashGetAll(k + "_hash"); len(all) != 1 || all["g"] != 3 {
+		t.Error("Error reading whole hash", all)
+	}
+
+	table.Add(k, 0, v)
+	var keyErr *KeyError
+	if _, err := table.SetAdd(k, "a"); !errors.Is(err, ErrTypeMismatch) || !errors.As(err, &keyErr) || keyErr.Op != "set_add" {
+		t.Error("Error rejecting wrong value type", err)
+	}
+	if _, err := table.HashSet(k+"_set", "f", 1); !errors.Is(err, ErrTypeMismatch) {
+		t.Error("Error rejecting set as hash", err)
+	}
+	if _, err := table.SetAdd(k+"_set", []byte("a")); !errors.Is(err, ErrUnhashableMember) {
+		t.Error("Error rejecting unhashable set member", err)
+	}
+	if _, err := table.SetIsMember(k+"_set", []int{1}); !errors.Is(err, ErrUnhashableMember) {
+		t.Error("Error rejecting unhashable member lookup", err)
+	}
+	if _, err := table.SetRemove(k+"_set", [1]interface{}{[]int{1}}); !errors.Is(err, ErrUnhashableMember) || !table.Exists(k+"_set") {
+		t.Error("Error rejecting unhashable member removal", err)
+	}
+
+	// 并发追加不会丢失
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			table.ListPush(k+"_concurrent", i)
+		}()
+	}
+	wg.Wait()
+	if got, _ := table.ListRange(k+"_concurrent", 0, -1); len(got) != 50 {
+		t.Error("Error pushing concurrently", len(got))
+	}
+
+	var buf bytes.Buffer
+	if err := table.SaveSnapshot(&buf); err != nil {
+		t.Error("Error saving collections to snapshot", err)
+	}
+}
+
+func TestSortedSet(t *testing.T) {
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	table := NewTable("testSortedSet", WithClock(clock))
+
+	if n, err := table.ZAdd(k, time.Hour, ScoredMember{"a", 3}, ScoredMember{"b", 1}, ScoredMember{"c", 2}); err != nil || n != 3 {
+		t.Fatal("Error adding sorted set members", n, err)
+	}
+	if n, _ := table.ZAdd(k, time.Hour, ScoredMember{"b", 5}, ScoredMember{"d", 4}); n != 1 {
+		t.Error("Error updating existing member", n)
+	}
+	if score, _ := table.ZIncrBy(k, time.Hour, "c", 0.5); score != 2.5 {
+		t.Error("Error incrementing score", score)
+	}
+	if score, ok, _ := table.ZScore(k, "b"); !ok || score != 5 {
+		t.Error("Error reading score", score)
+	}
+	if n, _ := table.ZCard(k); n != 4 {
+		t.Error("Error counting members", n)
+	}
+
+	got, _ := table.ZRangeByScore(k, 2.5, 4, 0)
+	if len(got) != 3 || got[0].Member != "c" || got[2].Member != "d" {
+		t.Error("Error ranging by score", got)
+	}
+	if got, _ := table.ZRangeByScore(k, 0, 10, 2); len(got) != 2 || got[0].Member != "c" {
+		t.Error("Error limiting score range", got)
+	}
+	top, _ := table.ZRevRange(k, 0, 1)
+	if len(top) != 2 || top[0].Member != "b" || top[1].Member != "d" {
+		t.Error("Error reading top members", top)
+	}
+
+	if n, _ := table.ZRem(k, "b", "missing"); n != 1 {
+		t.Error("Error removing members", n)
+	}
+	if top, _ := table.ZRevRange(k, 0, 0); len(top) != 1 || top[0].Member != "d" {
+		t.Error("Error reading top member after removal", top)
+	}
+
+	// 有效期属于整个集合, 修改不续期
+	clock.now = clock.now.Add(59 * time.Minute)
+	table.ZAdd(k, time.Hour, ScoredMember{"e", 1})
+	clock.now = clock.now.Add(time.Minute)
+	table.RunExpirationNow()
+	if n, _ := table.ZCard(k); n != 0 || table.Exists(k) {
+		t.Error("Error expiring whole sorted set", n)
+	}
+
+	table.ListPush(k+"_list", 1)
+	if _, err := table.ZAdd(k+"_list", 0, ScoredMember{"a", 1}); !errors.Is(err, ErrTypeMismatch) {
+		t.Error("Error rejecting wrong value type", err)
+	}
+}
+
+func TestBytesTable(t *testing.T) {
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	table := NewBytesTable(BytesConfig{Shards: 1, SegmentSize: 256, TTL: time.Minute, Clock: clock})
+
+	value := []byte("value")
+	if err := table.Set(k, value); err != nil {
+		t.Fatal("Error setting bytes", err)
+	}
+	value[0] = 'X'
+	if got, ok := table.Get(k); !ok || string(got) != "value" {
+		t.Error("Error reading bytes", string(got))
+	}
+	table.Set(k, []byte("updated"))
+	if got, _ := table.Get(k); string(got) != "updated" || table.Len() != 1 {
+		t.Error("Error overwriting bytes", string(got), table.Len())
+	}
+	if !table.Delete(k) || table.Exists(k) || table.Delete(k) {
+		t.Error("Error deleting bytes")
+	}
+	if err := table.Set(k, make([]byte, 300)); !errors.Is(err, ErrValueTooLarge) {
+		t.Error("Error rejecting entry larger than segment", err)
+	}
+
+	// 每个条目22+9+32=63字节, 256字节的缓冲区放4个, 之后按写入顺序淘汰
+	table.Reset()
+	for i := 0; i < 10; i++ {
+		if err := table.Set(k+"_"+strconv.Itoa(i), bytes.Repeat([]byte{byte(i)}, 32)); err != nil {
+			t.Fatal("Error setting bytes", err)
+		}
+		for j := 0; j <= i; j++ {
+			got, ok := table.Get(k + "_" + strconv.Itoa(j))
+			if want := j > i-4; ok != want || ok && (len(got) != 32 || got[0] != byte(j)) {
+				t.Fatal("Error evicting oldest entries", i, j, ok)
+			}
+		}
+	}
+	if st := table.Stats(); st.Entries != 4 || st.Evictions != 6 {
+		t.Error("Error counting evictions", st)
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	if table.Exists(k + "_9") {
+		t.Error("Error expiring entry")
+	}
+	table.Set(k, value)
+	if table.Len() != 1 {
+		t.Error("Error reclaiming expired entries", table.Len())
+	}
+	table.Reset()
+	if _, ok := table.Get(k); ok || table.Len() != 0 {
+		t.Error("Error resetting table")
+	}
+}
+
+func TestStringKeys(t *testing.T) {
+	table := NewTable("testStringKeys", WithEvictionPolicy(Evict2Q), WithMaxItems(10))
+	table.Add(k, 0, v)
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		if key == k+"_loadable" {
+			return NewCacheItem(key, 0, v)
+		}
+		return nil
+	})
+
+	if item, err := table.ValueString(k); err != nil || item.Value() != v {
+		t.Error("Error reading string key", err)
+	}
+	if item, err := table.ValueString(k + "_loadable"); err != nil || item.Value() != v {
+		t.Error("Error loading string key", err)
+	}
+	var keyErr *KeyError
+	if _, err := table.ValueString(k + "_missing"); !errors.As(err, &keyErr) || keyErr.Op != "get" {
+		t.Error("Error reporting missing string key", err)
+	}
+	if !table.ExistsString(k) || table.ExistsString(k+"_missing") {
+		t.Error("Error checking string key")
+	}
+	if _, err := table.PeekString(k + "_missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Error("Error peeking missing string key", err)
+	}
+	if st := table.Stats(); st.Hits != 1 || st.Misses != 2 {
+		t.Error("Error counting string key accesses", st.Hits, st.Misses)
+	}
+
+	key := k
+	if n := testing.AllocsPerRun(100, func() { table.ValueString(key) }); n != 0 {
+		t.Error("Error allocating on string key hit", n)
+	}
+	if n := testing.AllocsPerRun(100, func() { table.ExistsString(key) }); n != 0 {
+		t.Error("Error allocating on string key lookup", n)
+	}
+
+	var seen interface{}
+	table.Use(func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (*CacheItem, error) {
+			seen = call.Key
+			return next(ctx, call)
+		}
+	})
+	if _, err := table.ValueString(k); err != nil || seen != k {
+		t.Error("Error passing string key through middleware", err, seen)
+	}
+}
+
+func TestWithStringKeys(t *testing.T) {
+	table := NewTable("testWithStringKeys", WithStringKeys())
+	table.Add(k, 0, v)
+	table.Add(1, 0, v)
+	if !table.items.stringKeys || len(table.items.str) != 1 || len(table.items.other) != 1 {
+		t.Error("Error storing string and other keys separately")
+	}
+	if !table.Exists(k) || !table.ExistsString(k) || !table.Exists(1) || table.Count() != 2 {
+		t.Error("Error looking up mixed keys")
+	}
+	view := table.View()
+	table.Add(k+"_2", 0, v)
+	table.Add(k+"_3", 0, v)
+	if table.Count() != 4 || view.Count() != 2 || view.Exists(k+"_2") {
+		t.Error("Error copying string keyed map for view", table.Count(), view.Count())
+	}
+	seen := 0
+	table.Foreach(func(key interface{}, item *CacheItem) { seen++ })
+	if seen != 4 {
+		t.Error("Error iterating mixed keys", seen)
+	}
+	if _, err := table.Delete(k); err != nil || table.ExistsString(k) {
+		t.Error("Error deleting string key", err)
+	}
+
+	key := k + "_2"
+	if n := testing.AllocsPerRun(100, func() { table.ValueString(key) }); n != 0 {
+		t.Error("Error allocating on string key hit", n)
+	}
+	table.Flush()
+	if table.Count() != 0 || !table.items.stringKeys {
+		t.Error("Error flushing string keyed table")
+	}
+}
+
+func TestKeyError(t *testing.T) {
+	table := NewTable("testKeyError")
+	_, err := table.Delete("missing")
+	var ke *KeyError
+	if !errors.As(err, &ke) || !errors.Is(err, ErrKeyNotFound) {
+		t.Fatal("Error expected KeyError wrapping ErrKeyNotFound, got", err)
+	}
+	if ke.Table != "testKeyError" || ke.Key != "missing" || ke.Op != "delete" {
+		t.Error("Error unexpected KeyError fields", ke)
+	}
+	if err.Error() != "cache: delete testKeyError/missing: "+ErrKeyNotFound.Error() {
+		t.Error("Error unexpected message", err)
+	}
+	if _, err := table.Value("missing"); !errors.As(err, &ke) || ke.Op != "get" {
+		t.Error("Error expected KeyError from Value, got", err)
+	}
+}
+
+type tenantKey struct{}
+
+func TestContextPropagation(t *testing.T) {
+	ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+	var seen []string
+	record := func(where string, ctx context.Context) {
+		seen = append(seen, fmt.Sprint(where, "=", ctx.Value(tenantKey{})))
+	}
+
+	table := NewTable("testContextPropagation")
+	table.SetDataLoaderContext(func(ctx context.Context, key interface{}, args ...interface{}) *CacheItem {
+		record("load", ctx)
+		return NewCacheItem(key, 0, v)
+	})
+	table.AddAddedItemCallbackContext(func(ctx context.Context, item *CacheItem) { record("added", ctx) })
+	table.AddAboutToDeleteItemCallbackContext(func(ctx context.Context, item *CacheItem) { record("deleted", ctx) })
+
+	if _, err := table.ValueContext(ctx, k); err != nil {
+		t.Fatal(err)
+	}
+	table.DeleteContext(ctx, k)
+	table.NotFoundAddContext(ctx, "other", 0, v)
+	table.Value("plain")
+	want := "load=acme,added=acme,deleted=acme,added=acme,load=<nil>,added=<nil>"
+	if got := strings.Join(seen, ","); got != want {
+		t.Error("Error context not propagated", got)
+	}
+
+	// ctx结束时不再等待其他持有者的租约
+	leases := NewLocalLeases()
+	table = NewTable("testContextPropagationLease", WithLeases(leases, LeaseConfig{Wait: time.Minute}))
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem { return NewCacheItem(key, 0, v) })
+	if token, _ := leases.Acquire(context.Background(), table.Name(), k, time.Minute); token == 0 {
+		t.Fatal("Error acquiring lease")
+	}
+	cancelled, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := table.ValueContext(cancelled, k); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Error expected context deadline while waiting for lease, got", err)
+	}
+}
+
+func TestBusConflictResolution(t *testing.T) {
+	table := NewTable("testBusConflict")
+	table.Add(k, 0, v)
+	item, _ := table.items.get(k)
+	created := item.CreatedOn()
+
+	table.applyBusMessage(&BusMessage{Op: OpUpdate, Key: k, Time: created.Add(-time.Second), Replicated: true, Value: "old"})
+	if item, _ := table.Peek(k); item.Value() != v {
+		t.Error("Error older replicated value overwrote newer local value")
+	}
+	table.applyBusMessage(&BusMessage{Op: OpDelete, Key: k, Time: created.Add(-time.Second)})
+	if !table.Exists(k) {
+		t.Error("Error older invalidation deleted newer local value")
+	}
+	newer := created.Add(time.Second)
+	table.applyBusMessage(&BusMessage{Op: OpUpdate, Key: k, Time: newer, Replicated: true, Value: "new"})
+	if item, _ := table.Peek(k); item.Value() != "new" || !item.CreatedOn().Equal(newer) {
+		t.Error("Error newer replicated value was not applied")
+	}
+	table.applyBusMessage(&BusMessage{Op: OpDelete, Key: k, Time: newer.Add(time.Second)})
+	if table.Exists(k) {
+		t.Error("Error newer invalidation was not applied")
+	}
+
+	// 同一时间的并发写入按节点ID决定, 两个副本收到对方的消息后保留同一个值
+	a, b := NewTable("testBusTieA"), NewTable("testBusTieB")
+	a.busOrigin, b.busOrigin = "a", "b"
+	at := time.Unix(100, 0)
+	for _, r := range []*CacheTable{a, b} {
+		r.Lock()
+		item := newCacheItem(k, 0, r.busOrigin, at)
+		r.addInternal(context.Background(), item)
+	}
+	a.applyBusMessage(&BusMessage{Origin: "b", Op: OpUpdate, Key: k, Time: at, Replicated: true, Value: "b"})
+	b.applyBusMessage(&BusMessage{Origin: "a", Op: OpUpdate, Key: k, Time: at, Replicated: true, Value: "a"})
+	itemA, _ := a.Peek(k)
+	itemB, _ := b.Peek(k)
+	if itemA.Value() != "b" || itemB.Value() != "b" {
+		t.Error("Error replicas diverged on a timestamp tie", itemA.Value(), itemB.Value())
+	}
+	b.applyBusMessage(&BusMessage{Origin: "a", Op: OpDelete, Key: k, Time: at})
+	if !b.Exists(k) {
+		t.Error("Error losing side of a tie invalidated the winner")
+	}
+}
@@ -12,40 +12,121 @@ type CacheItem struct {
 	value interface{}
 
 	lifeSpan time.Duration
+	size     int64
+	version  uint64
+	// sourceVersion SetIfNewer写入时调用方提供的版本
+	sourceVersion uint64
 
 	createdOn   time.Time
 	accessedOn  time.Time
 	accessCount int64
 
 	aboutToExpire []func(key interface{})
+
+	// refreshing 提前刷新是否已触发
+	refreshing int32
+	// deleting 是否已有deleteInternal在删除该元素, 并发删除时只有一个成功
+	deleting int32
+
+	// checksum 开启SetImmutable时写入值的校验和
+	checksum    uint64
+	checksummed bool
+
+	// life 元素是否已离开表, 由copyMeta得到的副本共享
+	life *itemLife
+	// stale 宽限期内返回的过期元素
+	stale bool
+	// origin 从总线复制的元素为写入方的节点ID, 本地写入的为空
+	origin string
+}
+
+// itemLife 实现Done
+type itemLife struct {
+	mu      sync.Mutex
+	done    chan struct{}
+	removed bool
 }
 
 func NewCacheItem(key interface{}, lifeSpan time.Duration, value interface{}) *CacheItem {
-	now := time.Now()
+	return newCacheItem(key, lifeSpan, value, time.Now())
+}
+
+func newCacheItem(key interface{}, lifeSpan time.Duration, value interface{}, now time.Time) *CacheItem {
 	return &CacheItem{
-		key:           key,
+		key:           canonicalKey(key),
 		value:         value,
 		lifeSpan:      lifeSpan,
 		createdOn:     now,
 		accessedOn:    now,
 		accessCount:   0,
 		aboutToExpire: nil,
+		life:          &itemLife{},
+	}
+}
+
+// copyMeta 复制元素的值和元数据, 不含回调; 副本与原元素共享Done
+func (item *CacheItem) copyMeta() *CacheItem {
+	item.RLock()
+	defer item.RUnlock()
+	return &CacheItem{
+		key:           item.key,
+		value:         item.value,
+		lifeSpan:      item.lifeSpan,
+		size:          item.size,
+		version:       item.version,
+		sourceVersion: item.sourceVersion,
+		createdOn:     item.createdOn,
+		accessedOn:    item.accessedOn,
+		accessCount:   item.accessCount,
+		life:          item.life,
+		origin:        item.origin,
 	}
 }
 
 func (item *CacheItem) KeepAlive() {
+	item.keepAlive(time.Now())
+}
+
+func (item *CacheItem) keepAlive(now time.Time) {
 	item.Lock()
 	defer item.Unlock()
-	item.accessedOn = time.Now()
+	item.accessedOn = now
 	item.accessCount++
 }
 
+// expired reports whether the item outlived its lifespan at now.
+func (item *CacheItem) expired(now time.Time) bool {
+	item.RLock()
+	defer item.RUnlock()
+	return item.lifeSpan > 0 && now.Sub(item.accessedOn) >= item.lifeSpan
+}
+
 // LifeSpan returns this item's expiration duration.
 func (item *CacheItem) LifeSpan() time.Duration {
 	// immutable
 	return item.lifeSpan
 }
 
+// Size returns the size estimated by the table's Sizer when this item was added.
+func (item *CacheItem) Size() int64 {
+	// immutable once added
+	return item.size
+}
+
+// Version returns the generation of this key's value, starting at 1 and
+// incremented every time the key is written.
+func (item *CacheItem) Version() uint64 {
+	// immutable once added
+	return item.version
+}
+
+// SourceVersion returns the caller-supplied version the value was written
+// with by SetIfNewer, or 0 if it was written any other way.
+func (item *CacheItem) SourceVersion() uint64 {
+	// immutable once added
+	return item.sourceVersion
+}
+
 // AccessedOn returns when this item was last accessed.
 func (item *CacheItem) AccessedOn() time.Time {
 	item.RLock()
@@ -78,6 +159,50 @@ func (item *CacheItem) Value() interface{} {
 	return item.value
 }
 
+// Stale reports whether this item had already expired and was returned from
+// the table's grace period (see Settings.ExpiredGrace).
+func (item *CacheItem) Stale() bool {
+	// immutable
+	return item.stale
+}
+
+// Done returns a channel that is closed once this item leaves its table,
+// whether it expired, was deleted or evicted, was overwritten by a newer
+// value, or the table was flushed. Copies returned by copy-on-read share the
+// channel of the stored item. The channel never closes for items that were
+// not added to a table.
+func (item *CacheItem) Done() <-chan struct{} {
+	l := item.life
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done == nil {
+		l.done = make(chan struct{})
+		if l.removed {
+			close(l.done)
+		}
+	}
+	return l.done
+}
+
+// markRemoved closes the Done channel.
+func (item *CacheItem) markRemoved() {
+	l := item.life
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.removed {
+		l.removed = true
+		if l.done != nil {
+			close(l.done)
+		}
+	}
+}
+
 func (item *CacheItem) SetAboutToExpireCallback(f func(interface{})) {
 	if len(item.aboutToExpire) > 0 {
 		item.RemoveAboutToExpireCallback()
@@ -97,4 +222,4 @@ func (item *CacheItem) RemoveAboutToExpireCallback() {
 	item.Lock()
 	defer item.Unlock()
 	item.aboutToExpire = nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,236 @@
+package cacheserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cache"
+)
+
+// Client 通过REST接口(Service.Handler)访问远程表, 实现cache.Table.
+//
+// 键按fmt.Sprint转为字符串, 值按EncodeValue转为字节, 读回的值总是[]byte. 返回的CacheItem是本地构造的副本,
+// 只有Key/Value/LifeSpan有意义. Value的args不会发送到服务端. Add/Exists/Count/Flush没有错误返回值,
+// 远程调用失败时交给OnError
+type Client struct {
+	// BaseURL 服务端地址, 如 http://cache:8080, 末尾不带/
+	BaseURL string
+	// Table 远程表名
+	Table string
+	// Token 非空时作为Bearer token发送, 对应cache.Security
+	Token string
+	// HTTPClient 为nil时使用http.DefaultClient
+	HTTPClient *http.Client
+	// OnError 接收没有错误返回值的方法中发生的错误
+	OnError func(error)
+}
+
+var _ cache.Table = (*Client)(nil)
+
+// NewClient 返回访问baseURL上表table的客户端
+func NewClient(baseURL, table string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Table: table}
+}
+
+func (c *Client) itemURL(key interface{}) string {
+	return c.tableURL() + "/items/" + url.PathEscape(fmt.Sprint(key))
+}
+
+func (c *Client) tableURL() string {
+	return c.BaseURL + "/tables/" + url.PathEscape(c.Table)
+}
+
+func (c *Client) do(ctx context.Context, method, u string, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return hc.Do(req)
+}
+
+func (c *Client) report(err error) {
+	if err != nil && c.OnError != nil {
+		c.OnError(err)
+	}
+}
+
+// responseError 把错误响应转换为cache包的错误
+func responseError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&body)
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		if body.Error == cache.ErrTableNotFound.Error() {
+			return cache.ErrTableNotFound
+		}
+		return cache.ErrKeyNotFound
+	case http.StatusPreconditionFailed:
+		return cache.ErrVersionMismatch
+	case http.StatusServiceUnavailable:
+		return cache.ErrTableClosed
+	case http.StatusUnauthorized:
+		return cache.ErrUnauthorized
+	}
+	if body.Error != "" {
+		return fmt.Errorf("cacheserver: %s: %s", resp.Status, body.Error)
+	}
+	return fmt.Errorf("cacheserver: %s", resp.Status)
+}
+
+// AddContext 写入元素, lifeSpan为0表示不过期
+func (c *Client) AddContext(ctx context.Context, key interface{}, lifeSpan time.Duration, data interface{}) (*cache.CacheItem, error) {
+	value, err := EncodeValue(data)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{TTLHeader: {lifeSpan.String()}}
+	resp, err := c.do(ctx, http.MethodPut, c.itemURL(key), value, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, responseError(resp)
+	}
+	return cache.NewCacheItem(key, lifeSpan, value), nil
+}
+
+// Add 同AddContext, 失败时交给OnError并返回nil, 与CacheTable.Add写入被拒绝时相同
+func (c *Client) Add(key interface{}, lifeSpan time.Duration, data interface{}) *cache.CacheItem {
+	item, err := c.AddContext(context.Background(), key, lifeSpan, data)
+	if err != nil {
+		c.report(err)
+		return nil
+	}
+	return item
+}
+
+// ValueContext 读取元素, 不存在时返回cache.ErrKeyNotFound
+func (c *Client) ValueContext(ctx context.Context, key interface{}) (*cache.CacheItem, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.itemURL(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	value, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var lifeSpan time.Duration
+	if secs, err := strconv.ParseInt(resp.Header.Get(TTLHeader), 10, 64); err == nil {
+		lifeSpan = time.Duration(secs) * time.Second
+	}
+	return cache.NewCacheItem(key, lifeSpan, value), nil
+}
+
+// Value 同ValueContext, args被忽略. 返回元素的LifeSpan为读取时的剩余有效期
+func (c *Client) Value(key interface{}, args ...interface{}) (*cache.CacheItem, error) {
+	return c.ValueContext(context.Background(), key)
+}
+
+// DeleteContext 删除元素, 不存在时返回cache.ErrKeyNotFound
+func (c *Client) DeleteContext(ctx context.Context, key interface{}) error {
+	resp, err := c.do(ctx, http.MethodDelete, c.itemURL(key), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// Delete 同DeleteContext. 服务端不返回被删除的值, 成功时返回的元素只有Key
+func (c *Client) Delete(key interface{}) (*cache.CacheItem, error) {
+	if err := c.DeleteContext(context.Background(), key); err != nil {
+		return nil, err
+	}
+	return cache.NewCacheItem(key, 0, nil), nil
+}
+
+// ExistsContext 元素是否存在, 不计入服务端的命中统计
+func (c *Client) ExistsContext(ctx context.Context, key interface{}) (bool, error) {
+	resp, err := c.do(ctx, http.MethodHead, c.itemURL(key), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, responseError(resp)
+}
+
+// Exists 同ExistsContext, 失败时交给OnError并返回false
+func (c *Client) Exists(key interface{}) bool {
+	ok, err := c.ExistsContext(context.Background(), key)
+	c.report(err)
+	return ok
+}
+
+// StatsContext 返回远程表的统计
+func (c *Client) StatsContext(ctx context.Context) (cache.Stats, error) {
+	var stats cache.Stats
+	resp, err := c.do(ctx, http.MethodGet, c.tableURL(), nil, nil)
+	if err != nil {
+		return stats, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return stats, responseError(resp)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	return stats, err
+}
+
+// Count 远程表的元素数量, 失败时交给OnError并返回0
+func (c *Client) Count() int {
+	stats, err := c.StatsContext(context.Background())
+	c.report(err)
+	return stats.Items
+}
+
+// FlushContext 清空远程表
+func (c *Client) FlushContext(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodPost, c.tableURL()+"/flush", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// Flush 同FlushContext, 失败时交给OnError
+func (c *Client) Flush() {
+	c.report(c.FlushContext(context.Background()))
+}
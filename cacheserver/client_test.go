@@ -0,0 +1,54 @@
+package cacheserver_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cache"
+	"cache/cacheserver"
+)
+
+func TestClient(t *testing.T) {
+	defer cache.Remove("client")
+	cache.Cache("client")
+	srv := httptest.NewServer((&cacheserver.Service{}).Handler())
+	defer srv.Close()
+
+	var table cache.Table = cacheserver.NewClient(srv.URL, "client")
+	table.Add("a b", time.Minute, "v")
+	if !table.Exists("a b") || table.Exists("missing") {
+		t.Error("Error checking existence")
+	}
+	item, err := table.Value("a b")
+	if err != nil || string(item.Value().([]byte)) != "v" || item.LifeSpan() != time.Minute {
+		t.Error("Error retrieving item", item, err)
+	}
+	if _, err := table.Value("missing"); err != cache.ErrKeyNotFound {
+		t.Error("Error expected ErrKeyNotFound, got", err)
+	}
+	if table.Count() != 1 {
+		t.Error("Error counting items")
+	}
+	if _, err := table.Delete("a b"); err != nil {
+		t.Error("Error deleting item", err)
+	}
+	if _, err := table.Delete("a b"); err != cache.ErrKeyNotFound {
+		t.Error("Error expected ErrKeyNotFound, got", err)
+	}
+	table.Add("x", 0, "y")
+	table.Flush()
+	if cache.Cache("client").Count() != 0 {
+		t.Error("Error flushing remote table")
+	}
+
+	var errs []error
+	missing := cacheserver.NewClient(srv.URL, "client-missing")
+	missing.OnError = func(err error) { errs = append(errs, err) }
+	if item := missing.Add("k", 0, "v"); item != nil {
+		t.Error("Error expected nil item for failed Add", item)
+	}
+	if len(errs) != 1 || errs[0] != cache.ErrTableNotFound {
+		t.Error("Error expected ErrTableNotFound via OnError", errs)
+	}
+}
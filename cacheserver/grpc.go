@@ -0,0 +1,360 @@
+package cacheserver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"cache"
+	"cache/cacheserver/cachepb"
+)
+
+// GRPCServiceName cache.proto中Cache服务的全名, 方法的路径为"/"+GRPCServiceName+"/"+方法名
+const GRPCServiceName = "cacheserver.v1.Cache"
+
+// GRPCMaxMessageSize gRPC请求和响应消息的最大大小, 与grpc-go的默认接收上限一致
+const GRPCMaxMessageSize = 4 << 20
+
+// gRPC状态码, 见https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	GRPCOK                 = 0
+	GRPCCanceled           = 1
+	GRPCUnknown            = 2
+	GRPCInvalidArgument    = 3
+	GRPCDeadlineExceeded   = 4
+	GRPCNotFound           = 5
+	GRPCResourceExhausted  = 8
+	GRPCFailedPrecondition = 9
+	GRPCUnimplemented      = 12
+	GRPCInternal           = 13
+	GRPCUnavailable        = 14
+	GRPCUnauthenticated    = 16
+)
+
+// GRPCError gRPC调用以非OK状态结束时返回的错误
+type GRPCError struct {
+	Code    int
+	Message string
+}
+
+func (e *GRPCError) Error() string {
+	return fmt.Sprintf("cacheserver: grpc status %d: %s", e.Code, e.Message)
+}
+
+// grpcCall 一个gRPC方法: 解码请求, 调用Service, 通过send发送响应消息. 一元方法只调用一次send
+type grpcCall func(ctx context.Context, req []byte, send func(proto.Message) error) error
+
+// GRPCHandler 返回以gRPC协议提供cache.proto中Cache服务的http.Handler, 可与grpc-go等标准gRPC客户端互通.
+// gRPC要求HTTP/2: 用http.Server.ServeTLS(或Security.Listen的TLS监听)提供时自动协商; 不使用TLS时需在
+// http.Server.Protocols中开启未加密的HTTP/2. 设置了Security时按authorization元数据("Bearer <token>")鉴权,
+// 未通过返回Unauthenticated. 不支持压缩的消息. 错误的状态码: 元素或表不存在为NotFound, 值过大、未通过准入策略
+// 或Watch落后为ResourceExhausted, 值的类型不符为FailedPrecondition, 表已关闭为Unavailable
+func (s *Service) GRPCHandler() http.Handler {
+	calls := map[string]grpcCall{
+		"Get":      s.grpcGet,
+		"Set":      s.grpcSet,
+		"Delete":   s.grpcDelete,
+		"GetMulti": s.grpcGetMulti,
+		"Stats":    s.grpcStats,
+		"Watch":    s.grpcWatch,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.ProtoMajor != 2 {
+			http.Error(w, "gRPC requires POST over HTTP/2", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		service, method, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		call, ok := calls[method]
+		if service != GRPCServiceName || !ok {
+			writeGRPCStatus(w, GRPCUnimplemented, "unknown method "+r.URL.Path)
+			return
+		}
+		if err := s.Security.Authorize(r); err != nil {
+			writeGRPCStatus(w, GRPCUnauthenticated, err.Error())
+			return
+		}
+		ctx := r.Context()
+		if timeout, ok := parseGRPCTimeout(r.Header.Get("Grpc-Timeout")); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		req, err := readGRPCMessage(r.Body)
+		if errors.Is(err, io.EOF) {
+			err = &GRPCError{Code: GRPCInternal, Message: "missing request message"}
+		}
+		if err != nil {
+			writeGRPCError(w, err)
+			return
+		}
+
+		// 先发送响应头, 流式方法的客户端在第一条消息之前就能开始接收
+		w.WriteHeader(http.StatusOK)
+		rc := http.NewResponseController(w)
+		rc.Flush()
+		err = call(ctx, req, func(m proto.Message) error {
+			if err := writeGRPCMessage(w, m); err != nil {
+				return err
+			}
+			return rc.Flush()
+		})
+		if err != nil {
+			writeGRPCError(w, err)
+			return
+		}
+		writeGRPCStatus(w, GRPCOK, "")
+	})
+}
+
+func (s *Service) grpcGet(ctx context.Context, body []byte, send func(proto.Message) error) error {
+	var req cachepb.GetRequest
+	if err := unmarshalGRPC(body, &req); err != nil {
+		return err
+	}
+	item, err := s.Get(ctx, req.Table, req.Key)
+	if err != nil {
+		return err
+	}
+	return send(&cachepb.GetResponse{Item: item.proto()})
+}
+
+func (s *Service) grpcSet(ctx context.Context, body []byte, send func(proto.Message) error) error {
+	var req cachepb.SetRequest
+	if err := unmarshalGRPC(body, &req); err != nil {
+		return err
+	}
+	if req.LifespanMs < 0 {
+		return &GRPCError{Code: GRPCInvalidArgument, Message: "negative lifespan_ms"}
+	}
+	item, err := s.Set(ctx, req.Table, req.Key, req.Value, time.Duration(req.LifespanMs)*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	return send(&cachepb.SetResponse{Item: item.proto()})
+}
+
+func (s *Service) grpcDelete(ctx context.Context, body []byte, send func(proto.Message) error) error {
+	var req cachepb.DeleteRequest
+	if err := unmarshalGRPC(body, &req); err != nil {
+		return err
+	}
+	found, err := s.Delete(ctx, req.Table, req.Key)
+	if err != nil {
+		return err
+	}
+	return send(&cachepb.DeleteResponse{Found: found})
+}
+
+func (s *Service) grpcGetMulti(ctx context.Context, body []byte, send func(proto.Message) error) error {
+	var req cachepb.GetMultiRequest
+	if err := unmarshalGRPC(body, &req); err != nil {
+		return err
+	}
+	items, err := s.GetMulti(ctx, req.Table, req.Keys)
+	if err != nil {
+		return err
+	}
+	resp := &cachepb.GetMultiResponse{Items: make([]*cachepb.Item, len(items))}
+	for i, item := range items {
+		resp.Items[i] = item.proto()
+	}
+	return send(resp)
+}
+
+func (s *Service) grpcStats(ctx context.Context, body []byte, send func(proto.Message) error) error {
+	var req cachepb.StatsRequest
+	if err := unmarshalGRPC(body, &req); err != nil {
+		return err
+	}
+	stats, err := s.Stats(ctx, req.Table)
+	if err != nil {
+		return err
+	}
+	return send(&cachepb.StatsResponse{
+		Items:          int64(stats.Items),
+		Size:           stats.Size,
+		Hits:           stats.Hits,
+		Misses:         stats.Misses,
+		Evictions:      stats.Evictions,
+		LoaderFailures: stats.LoaderFailures,
+		LoaderP50Us:    stats.LoaderLatency.P50.Microseconds(),
+		LoaderP95Us:    stats.LoaderLatency.P95.Microseconds(),
+		LoaderP99Us:    stats.LoaderLatency.P99.Microseconds(),
+	})
+}
+
+func (s *Service) grpcWatch(ctx context.Context, body []byte, send func(proto.Message) error) error {
+	var req cachepb.WatchRequest
+	if err := unmarshalGRPC(body, &req); err != nil {
+		return err
+	}
+	return s.Watch(ctx, req.Table, func(m Mutation) error {
+		return send(m.proto())
+	})
+}
+
+// proto 转为cache.proto的Item
+func (item *Item) proto() *cachepb.Item {
+	return &cachepb.Item{
+		Key:            item.Key,
+		Value:          item.Value,
+		LifespanMs:     item.LifeSpan.Milliseconds(),
+		TtlRemainingMs: item.TTLRemaining.Milliseconds(),
+		Version:        item.Version,
+		AccessCount:    item.AccessCount,
+	}
+}
+
+// proto 转为cache.proto的Mutation
+func (m Mutation) proto() *cachepb.Mutation {
+	return &cachepb.Mutation{
+		Seq:        m.Seq,
+		Op:         m.Op,
+		Key:        m.Key,
+		Value:      m.Value,
+		LifespanMs: m.LifeSpan.Milliseconds(),
+		Version:    m.Version,
+		UnixNano:   m.Time.UnixNano(),
+	}
+}
+
+func unmarshalGRPC(body []byte, m proto.Message) error {
+	if err := proto.Unmarshal(body, m); err != nil {
+		return &GRPCError{Code: GRPCInvalidArgument, Message: err.Error()}
+	}
+	return nil
+}
+
+// grpcCode 把错误换算为gRPC状态码
+func grpcCode(err error) int {
+	var ge *GRPCError
+	switch {
+	case errors.As(err, &ge):
+		return ge.Code
+	case errors.Is(err, cache.ErrKeyNotFound), errors.Is(err, cache.ErrKeyNotFoundOrLoadable), errors.Is(err, cache.ErrTableNotFound):
+		return GRPCNotFound
+	case errors.Is(err, cache.ErrValueTooLarge), errors.Is(err, cache.ErrNotAdmitted), errors.Is(err, ErrWatchLagged):
+		return GRPCResourceExhausted
+	case errors.Is(err, cache.ErrTypeMismatch), errors.Is(err, cache.ErrVersionMismatch):
+		return GRPCFailedPrecondition
+	case errors.Is(err, cache.ErrTableClosed):
+		return GRPCUnavailable
+	case errors.Is(err, context.Canceled):
+		return GRPCCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return GRPCDeadlineExceeded
+	}
+	return GRPCUnknown
+}
+
+// readGRPCMessage 读取一条长度前缀的消息: 1字节压缩标志, 4字节大端长度, 消息体. 在消息边界结束时返回io.EOF
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, &GRPCError{Code: GRPCInternal, Message: "truncated gRPC message"}
+		}
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, &GRPCError{Code: GRPCUnimplemented, Message: "compressed messages are not supported"}
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > GRPCMaxMessageSize {
+		return nil, &GRPCError{Code: GRPCResourceExhausted, Message: fmt.Sprintf("message of %d bytes exceeds %d", size, GRPCMaxMessageSize)}
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, &GRPCError{Code: GRPCInternal, Message: "truncated gRPC message"}
+	}
+	return msg, nil
+}
+
+// writeGRPCMessage 写入一条长度前缀的消息
+func writeGRPCMessage(w io.Writer, m proto.Message) error {
+	body, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(body)))
+	_, err = w.Write(append(frame, body...))
+	return err
+}
+
+// writeGRPCError 按grpcCode写入err对应的状态
+func writeGRPCError(w http.ResponseWriter, err error) {
+	msg := err.Error()
+	var ge *GRPCError
+	if errors.As(err, &ge) {
+		msg = ge.Message
+	}
+	writeGRPCStatus(w, grpcCode(err), msg)
+}
+
+// writeGRPCStatus 以trailer写入调用的状态
+func writeGRPCStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeGRPCMessage(msg))
+	}
+}
+
+// encodeGRPCMessage 按gRPC的要求对grpc-message做百分号编码
+func encodeGRPCMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// decodeGRPCMessage encodeGRPCMessage的逆操作, 无法解码的部分原样保留
+func decodeGRPCMessage(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseGRPCTimeout 解析grpc-timeout头部, 如"100m"(100毫秒)
+func parseGRPCTimeout(s string) (time.Duration, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	unit, ok := units[s[len(s)-1]]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
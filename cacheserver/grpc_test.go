@@ -0,0 +1,113 @@
+package cacheserver_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cache"
+	"cache/cacheserver"
+	"cache/cacheserver/cachepb"
+)
+
+func startGRPC(t *testing.T, s *cacheserver.Service) *cacheserver.GRPCClient {
+	srv := httptest.NewUnstartedServer(s.GRPCHandler())
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return cacheserver.NewGRPCClient(srv.URL, srv.Client())
+}
+
+func grpcCode(err error) int {
+	var ge *cacheserver.GRPCError
+	if !errors.As(err, &ge) {
+		return -1
+	}
+	return ge.Code
+}
+
+func TestGRPC(t *testing.T) {
+	defer cache.Remove("grpc")
+	ctx := context.Background()
+	c := startGRPC(t, &cacheserver.Service{AutoCreate: true})
+
+	set, err := c.Set(ctx, &cachepb.SetRequest{Table: "grpc", Key: "k", Value: []byte("v"), LifespanMs: 60000})
+	if err != nil || set.Item.Version != 1 || set.Item.LifespanMs != 60000 {
+		t.Fatal("Error setting item", set, err)
+	}
+	got, err := c.Get(ctx, &cachepb.GetRequest{Table: "grpc", Key: "k"})
+	if err != nil || string(got.Item.Value) != "v" || got.Item.TtlRemainingMs <= 0 {
+		t.Error("Error getting item", got, err)
+	}
+	if _, err := c.Get(ctx, &cachepb.GetRequest{Table: "grpc", Key: "missing"}); grpcCode(err) != cacheserver.GRPCNotFound {
+		t.Error("Error expected NotFound for missing key", err)
+	}
+	multi, err := c.GetMulti(ctx, &cachepb.GetMultiRequest{Table: "grpc", Keys: []string{"missing", "k"}})
+	if err != nil || len(multi.Items) != 1 || multi.Items[0].Key != "k" {
+		t.Error("Error getting multiple items", multi, err)
+	}
+	stats, err := c.Stats(ctx, &cachepb.StatsRequest{Table: "grpc"})
+	if err != nil || stats.Items != 1 || stats.Hits != 2 || stats.Misses != 2 {
+		t.Error("Error reading stats", stats, err)
+	}
+	if del, err := c.Delete(ctx, &cachepb.DeleteRequest{Table: "grpc", Key: "k"}); err != nil || !del.Found {
+		t.Error("Error deleting item", del, err)
+	}
+
+	cache.Cache("grpc-small", cache.WithMaxValueSize(4, false))
+	defer cache.Remove("grpc-small")
+	if _, err := c.Set(ctx, &cachepb.SetRequest{Table: "grpc-small", Key: "k", Value: []byte("too large")}); grpcCode(err) != cacheserver.GRPCResourceExhausted {
+		t.Error("Error expected ResourceExhausted for rejected write", err)
+	}
+}
+
+func TestGRPCWatch(t *testing.T) {
+	defer cache.Remove("grpc-watch")
+	table := cache.Cache("grpc-watch")
+	c := startGRPC(t, &cacheserver.Service{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	stream, err := c.Watch(ctx, &cachepb.WatchRequest{Table: "grpc-watch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	// 订阅在服务端收到请求后才建立, 写入直到收到变更
+	received := make(chan *cachepb.Mutation)
+	go func() {
+		m, err := stream.Recv()
+		if err != nil {
+			t.Error("Error receiving mutation", err)
+		}
+		received <- m
+	}()
+	for {
+		table.Add("k", 0, []byte("v"))
+		select {
+		case m := <-received:
+			if m == nil || m.Key != "k" || string(m.Value) != "v" || m.Op == "" {
+				t.Error("Error unexpected mutation", m)
+			}
+			return
+		case <-ctx.Done():
+			t.Fatal("Error no mutation received")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestGRPCAuth(t *testing.T) {
+	defer cache.Remove("grpc-auth")
+	c := startGRPC(t, &cacheserver.Service{AutoCreate: true, Security: &cache.Security{BearerTokens: []string{"secret"}}})
+	req := &cachepb.SetRequest{Table: "grpc-auth", Key: "k", Value: []byte("v")}
+	if _, err := c.Set(context.Background(), req); grpcCode(err) != cacheserver.GRPCUnauthenticated {
+		t.Error("Error expected Unauthenticated without token", err)
+	}
+	c.Token = "secret"
+	if _, err := c.Set(context.Background(), req); err != nil {
+		t.Error("Error calling with valid token", err)
+	}
+}
@@ -0,0 +1,198 @@
+package cacheserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"cache/cacheserver/cachepb"
+)
+
+// GRPCClient 以gRPC协议调用cache.proto中的Cache服务(Service.GRPCHandler或其他实现), 方法与protoc-gen-go-grpc
+// 生成的CacheClient对应. 调用以非OK状态结束时返回*GRPCError
+type GRPCClient struct {
+	// BaseURL 服务端地址, 如 https://cache:8443, 末尾不带/
+	BaseURL string
+	// Token 非空时作为authorization元数据("Bearer <token>")发送, 对应cache.Security
+	Token string
+	// HTTPClient 需支持HTTP/2: TLS时http.Client默认协商HTTP/2; 不使用TLS时需在Transport.Protocols中开启未加密的HTTP/2
+	HTTPClient *http.Client
+}
+
+// NewGRPCClient 返回通过hc访问baseURL的客户端
+func NewGRPCClient(baseURL string, hc *http.Client) *GRPCClient {
+	return &GRPCClient{BaseURL: strings.TrimRight(baseURL, "/"), HTTPClient: hc}
+}
+
+// Get 读取元素
+func (c *GRPCClient) Get(ctx context.Context, req *cachepb.GetRequest) (*cachepb.GetResponse, error) {
+	resp := new(cachepb.GetResponse)
+	if err := c.invoke(ctx, "Get", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Set 写入元素
+func (c *GRPCClient) Set(ctx context.Context, req *cachepb.SetRequest) (*cachepb.SetResponse, error) {
+	resp := new(cachepb.SetResponse)
+	if err := c.invoke(ctx, "Set", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Delete 删除元素
+func (c *GRPCClient) Delete(ctx context.Context, req *cachepb.DeleteRequest) (*cachepb.DeleteResponse, error) {
+	resp := new(cachepb.DeleteResponse)
+	if err := c.invoke(ctx, "Delete", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// GetMulti 批量读取
+func (c *GRPCClient) GetMulti(ctx context.Context, req *cachepb.GetMultiRequest) (*cachepb.GetMultiResponse, error) {
+	resp := new(cachepb.GetMultiResponse)
+	if err := c.invoke(ctx, "GetMulti", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Stats 读取表的统计
+func (c *GRPCClient) Stats(ctx context.Context, req *cachepb.StatsRequest) (*cachepb.StatsResponse, error) {
+	resp := new(cachepb.StatsResponse)
+	if err := c.invoke(ctx, "Stats", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Watch 订阅表的变更, 用Recv逐条读取, 不再需要时Close或取消ctx
+func (c *GRPCClient) Watch(ctx context.Context, req *cachepb.WatchRequest) (*GRPCWatchStream, error) {
+	resp, err := c.call(ctx, "Watch", req)
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCWatchStream{resp: resp}, nil
+}
+
+// GRPCWatchStream Watch返回的变更流
+type GRPCWatchStream struct {
+	resp *http.Response
+}
+
+// Recv 返回下一条变更. 服务端以OK结束时返回io.EOF, 以其他状态结束时返回*GRPCError(如落后太多时为ResourceExhausted)
+func (s *GRPCWatchStream) Recv() (*cachepb.Mutation, error) {
+	msg, err := readGRPCMessage(s.resp.Body)
+	if errors.Is(err, io.EOF) {
+		if err := grpcStatus(s.resp.Trailer); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := new(cachepb.Mutation)
+	if err := proto.Unmarshal(msg, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Close 结束订阅
+func (s *GRPCWatchStream) Close() error {
+	return s.resp.Body.Close()
+}
+
+// invoke 调用一元方法, 把响应消息解码到out
+func (c *GRPCClient) invoke(ctx context.Context, method string, in, out proto.Message) error {
+	resp, err := c.call(ctx, method, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, err := readGRPCMessage(resp.Body)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	// 读到结尾才能取得trailer
+	io.Copy(io.Discard, resp.Body)
+	if err := grpcStatus(resp.Trailer); err != nil {
+		return err
+	}
+	if msg == nil {
+		return &GRPCError{Code: GRPCInternal, Message: "missing response message"}
+	}
+	return proto.Unmarshal(msg, out)
+}
+
+// call 发送请求消息, 返回响应; 服务端没有开始返回消息就结束调用时返回其状态
+func (c *GRPCClient) call(ctx context.Context, method string, in proto.Message) (*http.Response, error) {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(body)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/"+GRPCServiceName+"/"+method, bytes.NewReader(append(frame, body...)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Set("TE", "trailers")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set("Grpc-Timeout", strconv.FormatInt(max(time.Until(deadline).Milliseconds(), 1), 10)+"m")
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		code := GRPCUnknown
+		if resp.StatusCode == http.StatusUnauthorized {
+			code = GRPCUnauthenticated
+		}
+		return nil, &GRPCError{Code: code, Message: fmt.Sprintf("unexpected HTTP status %s", resp.Status)}
+	}
+	// 只有状态没有消息的响应(Trailers-Only)把状态放在头部
+	if resp.Header.Get("Grpc-Status") != "" {
+		resp.Body.Close()
+		if err := grpcStatus(resp.Header); err != nil {
+			return nil, err
+		}
+		resp.Body, resp.Trailer = http.NoBody, resp.Header
+	}
+	return resp, nil
+}
+
+// grpcStatus 取出调用的状态, OK时返回nil
+func grpcStatus(h http.Header) error {
+	code, err := strconv.Atoi(h.Get("Grpc-Status"))
+	if err != nil {
+		return &GRPCError{Code: GRPCInternal, Message: "missing grpc-status"}
+	}
+	if code == GRPCOK {
+		return nil
+	}
+	return &GRPCError{Code: code, Message: decodeGRPCMessage(h.Get("Grpc-Message"))}
+}
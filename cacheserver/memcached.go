@@ -0,0 +1,293 @@
+package cacheserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"cache"
+)
+
+// memcachedMaxRelativeExpiry memcached协议中超过30天的过期时间按unix时间戳处理
+const memcachedMaxRelativeExpiry = 60 * 60 * 24 * 30
+
+// MemcachedMaxValueSize set接受的最大值大小, 与memcached默认的item大小上限一致
+const MemcachedMaxValueSize = 1 << 20
+
+var errMemcachedNonNumeric = errors.New("cannot increment or decrement non-numeric value")
+
+// ServeMemcached 在l上以memcached文本协议提供表table的访问, 直到l被关闭.
+// 支持get/gets/set/delete/incr/decr/touch/version/quit; flags不保存, 读取时总为0, gets的cas值为元素版本号
+func (s *Service) ServeMemcached(l net.Listener, table string) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.serveMemcachedConn(conn, table)
+	}
+}
+
+func (s *Service) serveMemcachedConn(conn net.Conn, table string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	if s.authRequired() {
+		ok := s.memcachedAuth(w, r)
+		if err := w.Flush(); err != nil || !ok {
+			return
+		}
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			fmt.Fprint(w, "ERROR\r\n")
+		} else if fields[0] == "quit" {
+			w.Flush()
+			return
+		} else if !s.memcachedCommand(w, r, table, fields) {
+			w.Flush()
+			return
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// memcachedAuth 读取连接的第一条命令做鉴权, 与memcached的-Y相同: 命令需是set, 数据为"<用户名> <token>"或token.
+// 返回是否通过鉴权, 未通过时连接应关闭
+func (s *Service) memcachedAuth(w *bufio.Writer, r *bufio.Reader) bool {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "set" {
+		fmt.Fprint(w, "CLIENT_ERROR unauthenticated\r\n")
+		return false
+	}
+	size, err := strconv.Atoi(fields[4])
+	if err != nil || size < 0 || size > MemcachedMaxValueSize {
+		fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+		return false
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return false
+	}
+	creds := strings.Fields(string(data[:size]))
+	if len(creds) == 0 || !s.checkToken(creds[len(creds)-1]) {
+		fmt.Fprint(w, "CLIENT_ERROR authentication failure\r\n")
+		return false
+	}
+	fmt.Fprint(w, "STORED\r\n")
+	return true
+}
+
+// memcachedCommand 执行一条命令并写入响应, 连接无法继续使用时返回false
+func (s *Service) memcachedCommand(w *bufio.Writer, r *bufio.Reader, table string, fields []string) bool {
+	cmd, args := fields[0], fields[1:]
+	noreply := len(args) > 0 && args[len(args)-1] == "noreply"
+	if noreply {
+		args = args[:len(args)-1]
+	}
+	reply := func(format string, a ...interface{}) {
+		if !noreply {
+			fmt.Fprintf(w, format+"\r\n", a...)
+		}
+	}
+
+	t, err := s.table(table)
+	if err != nil {
+		fmt.Fprintf(w, "SERVER_ERROR %v\r\n", err)
+		return true
+	}
+
+	switch cmd {
+	case "get", "gets":
+		if len(args) == 0 {
+			fmt.Fprint(w, "ERROR\r\n")
+			return true
+		}
+		for _, key := range args {
+			item, err := t.Value(key)
+			if err != nil {
+				continue
+			}
+			value, err := EncodeValue(item.Value())
+			if err != nil {
+				continue
+			}
+			if cmd == "gets" {
+				fmt.Fprintf(w, "VALUE %s 0 %d %d\r\n", key, len(value), item.Version())
+			} else {
+				fmt.Fprintf(w, "VALUE %s 0 %d\r\n", key, len(value))
+			}
+			w.Write(value)
+			fmt.Fprint(w, "\r\n")
+		}
+		fmt.Fprint(w, "END\r\n")
+
+	case "set":
+		if len(args) != 4 {
+			fmt.Fprint(w, "ERROR\r\n")
+			return true
+		}
+		exptime, err1 := strconv.ParseInt(args[2], 10, 64)
+		size, err2 := strconv.Atoi(args[3])
+		if err1 != nil || err2 != nil || size < 0 {
+			fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+			return false
+		}
+		if size > MemcachedMaxValueSize {
+			fmt.Fprint(w, "SERVER_ERROR object too large for cache\r\n")
+			// 跳过数据块后连接仍可使用
+			_, err := io.CopyN(io.Discard, r, int64(size)+2)
+			return err == nil
+		}
+		value := make([]byte, size+2)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return false
+		}
+		if string(value[size:]) != "\r\n" {
+			fmt.Fprint(w, "CLIENT_ERROR bad data chunk\r\n")
+			return false
+		}
+		lifeSpan, expired := memcachedLifeSpan(exptime)
+		if expired {
+			t.Delete(args[0])
+			reply("STORED")
+			return true
+		}
+		_, err := t.TryAdd(args[0], lifeSpan, value[:size])
+		switch {
+		case err == nil:
+			reply("STORED")
+		case errors.Is(err, cache.ErrNotAdmitted):
+			reply("NOT_STORED")
+		case errors.Is(err, cache.ErrValueTooLarge):
+			reply("SERVER_ERROR object too large for cache")
+		default:
+			reply("SERVER_ERROR %v", err)
+		}
+
+	case "delete":
+		if len(args) != 1 {
+			fmt.Fprint(w, "ERROR\r\n")
+			return true
+		}
+		if _, err := t.Delete(args[0]); err != nil {
+			reply("NOT_FOUND")
+		} else {
+			reply("DELETED")
+		}
+
+	case "incr", "decr":
+		if len(args) != 2 {
+			fmt.Fprint(w, "ERROR\r\n")
+			return true
+		}
+		delta, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			fmt.Fprint(w, "CLIENT_ERROR invalid numeric delta argument\r\n")
+			return true
+		}
+		n, err := memcachedIncr(t, args[0], delta, cmd == "decr")
+		switch {
+		case errors.Is(err, cache.ErrKeyNotFound):
+			reply("NOT_FOUND")
+		case err != nil:
+			reply("CLIENT_ERROR %v", err)
+		default:
+			reply("%d", n)
+		}
+
+	case "touch":
+		if len(args) != 2 {
+			fmt.Fprint(w, "ERROR\r\n")
+			return true
+		}
+		exptime, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fmt.Fprint(w, "CLIENT_ERROR invalid exptime argument\r\n")
+			return true
+		}
+		if memcachedTouch(t, args[0], exptime) {
+			reply("TOUCHED")
+		} else {
+			reply("NOT_FOUND")
+		}
+
+	case "version":
+		fmt.Fprint(w, "VERSION cache\r\n")
+
+	default:
+		fmt.Fprint(w, "ERROR\r\n")
+	}
+	return true
+}
+
+// memcachedLifeSpan 把exptime换算为有效期: 0不过期, 负数表示已过期, 超过30天的值是unix时间戳
+func memcachedLifeSpan(exptime int64) (lifeSpan time.Duration, expired bool) {
+	switch {
+	case exptime < 0:
+		return 0, true
+	case exptime == 0:
+		return 0, false
+	case exptime > memcachedMaxRelativeExpiry:
+		lifeSpan = time.Until(time.Unix(exptime, 0))
+		return lifeSpan, lifeSpan <= 0
+	}
+	return time.Duration(exptime) * time.Second, false
+}
+
+// memcachedIncr 按版本号比较并交换地修改十进制数值, 有效期沿用当前元素. decr最小减到0, incr按64位无符号数回绕
+func memcachedIncr(t *cache.CacheTable, key string, delta uint64, decr bool) (uint64, error) {
+	for {
+		item, err := t.Value(key)
+		if err != nil {
+			return 0, cache.ErrKeyNotFound
+		}
+		value, err := EncodeValue(item.Value())
+		if err != nil {
+			return 0, errMemcachedNonNumeric
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(string(value)), 10, 64)
+		if err != nil {
+			return 0, errMemcachedNonNumeric
+		}
+		switch {
+		case !decr:
+			n += delta
+		case delta > n:
+			n = 0
+		default:
+			n -= delta
+		}
+		_, err = t.SetIfVersion(key, item.Version(), []byte(strconv.FormatUint(n, 10)))
+		if !errors.Is(err, cache.ErrVersionMismatch) {
+			return n, err
+		}
+	}
+}
+
+// memcachedTouch 修改元素的有效期, 元素不存在时返回false
+func memcachedTouch(t *cache.CacheTable, key string, exptime int64) bool {
+	lifeSpan, expired := memcachedLifeSpan(exptime)
+	return expireKey(t, key, lifeSpan, expired)
+}
@@ -0,0 +1,107 @@
+package cacheserver_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+
+	"cache"
+	"cache/cacheserver"
+)
+
+func TestMemcached(t *testing.T) {
+	defer cache.Remove("memcached")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go (&cacheserver.Service{AutoCreate: true}).ServeMemcached(l, "memcached")
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	expect := func(cmd string, want ...string) {
+		fmt.Fprint(conn, cmd)
+		for _, w := range want {
+			line, err := r.ReadString('\n')
+			if err != nil || line != w+"\r\n" {
+				t.Errorf("Error %q: got %q, want %q", cmd, line, w)
+				return
+			}
+		}
+	}
+
+	expect("set k 0 60 5\r\nhello\r\n", "STORED")
+	expect("get k missing\r\n", "VALUE k 0 5", "hello", "END")
+	expect("gets k\r\n", "VALUE k 0 5 1", "hello", "END")
+	expect("incr k 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value")
+	expect("set n 0 0 2 noreply\r\n10\r\nincr n 5\r\n", "15")
+	expect("decr n 20\r\n", "0")
+	expect("incr missing 1\r\n", "NOT_FOUND")
+	expect("touch n 100\r\n", "TOUCHED")
+	if item, err := cache.Cache("memcached").Value("n"); err != nil || item.LifeSpan().Seconds() != 100 {
+		t.Error("Error touch did not update lifespan", item, err)
+	}
+	expect("touch missing 100\r\n", "NOT_FOUND")
+	expect("delete k\r\n", "DELETED")
+	expect("delete k\r\n", "NOT_FOUND")
+	expect("bogus\r\n", "ERROR")
+
+	cache.Cache("memcached").SetAdmissionPolicy(rejectAll{})
+	expect("set fresh 0 0 1\r\nx\r\n", "NOT_STORED")
+	if cache.Cache("memcached").Exists("fresh") {
+		t.Error("Error rejected item was stored")
+	}
+}
+
+func TestMemcachedAuth(t *testing.T) {
+	defer cache.Remove("memcached-auth")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s := &cacheserver.Service{AutoCreate: true, Security: &cache.Security{BearerTokens: []string{"secret"}}}
+	go s.ServeMemcached(l, "memcached-auth")
+
+	session := func(cmds ...string) []string {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var lines []string
+		for _, cmd := range cmds {
+			fmt.Fprint(conn, cmd)
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			lines = append(lines, line)
+		}
+		return lines
+	}
+
+	if got := session("get k\r\n", "version\r\n"); len(got) != 1 || got[0] != "CLIENT_ERROR unauthenticated\r\n" {
+		t.Error("Error expected unauthenticated connection to be rejected", got)
+	}
+	if got := session("set auth 0 0 10\r\nuser wrong\r\n"); len(got) != 1 || got[0] != "CLIENT_ERROR authentication failure\r\n" {
+		t.Error("Error expected wrong token to be rejected", got)
+	}
+	got := session("set auth 0 0 11\r\nuser secret\r\n", "set k 0 0 1\r\nv\r\n")
+	if len(got) != 2 || got[0] != "STORED\r\n" || got[1] != "STORED\r\n" || !cache.Cache("memcached-auth").Exists("k") {
+		t.Error("Error writing after authentication", got)
+	}
+}
+
+// rejectAll 拒绝所有新键的准入策略
+type rejectAll struct{}
+
+func (rejectAll) Record(key interface{})                                     {}
+func (rejectAll) Admit(key interface{}, size int64, victim interface{}) bool { return false }
@@ -0,0 +1,404 @@
+package cacheserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cache"
+)
+
+// RESPMaxBulkSize 请求中单个参数的最大长度, 与Redis的proto-max-bulk-len默认值一致
+const RESPMaxBulkSize = 512 << 20
+
+var errRESPProtocol = errors.New("Protocol error")
+
+// ServeRESP 在l上以Redis协议(RESP2)提供表table的访问, 直到l被关闭, 可直接使用redis-cli和Redis客户端库.
+// 支持GET/SET(EX/PX/NX/XX)/DEL/EXPIRE/TTL/KEYS/SCAN以及PING/COMMAND/QUIT/AUTH. 没有数据库的概念, 所有连接共享同一个表;
+// SCAN的游标是按键排序后的偏移量, 扫描期间有写入时可能重复或遗漏元素
+func (s *Service) ServeRESP(l net.Listener, table string) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.serveRESPConn(conn, table)
+	}
+}
+
+func (s *Service) serveRESPConn(conn net.Conn, table string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := &respWriter{bufio.NewWriter(conn)}
+	authed := !s.authRequired()
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			if errors.Is(err, errRESPProtocol) {
+				w.error("ERR " + err.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if strings.EqualFold(args[0], "quit") {
+			w.simple("OK")
+			w.Flush()
+			return
+		}
+		switch {
+		case strings.EqualFold(args[0], "auth"):
+			authed = s.respAuth(w, args) || authed
+		case !authed:
+			w.error("NOAUTH Authentication required.")
+		default:
+			s.respCommand(w, table, args)
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// respAuth 执行AUTH [username] token, 用户名被忽略; 返回是否通过鉴权
+func (s *Service) respAuth(w *respWriter, args []string) bool {
+	if len(args) != 2 && len(args) != 3 {
+		w.wrongArgs("AUTH")
+		return false
+	}
+	if !s.authRequired() {
+		w.error("ERR AUTH called without any password configured for the default user")
+		return false
+	}
+	if !s.checkToken(args[len(args)-1]) {
+		w.error("WRONGPASS invalid username-password pair or user is disabled.")
+		return false
+	}
+	w.simple("OK")
+	return true
+}
+
+// readRESPCommand 读取一条命令, 支持RESP数组和redis-cli等工具使用的内联命令
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > 1024*1024 {
+		return nil, errRESPProtocol
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, errRESPProtocol
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > RESPMaxBulkSize {
+			return nil, errRESPProtocol
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if string(buf[size:]) != "\r\n" {
+			return nil, errRESPProtocol
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+type respWriter struct {
+	*bufio.Writer
+}
+
+func (w *respWriter) simple(s string) { fmt.Fprintf(w, "+%s\r\n", s) }
+func (w *respWriter) error(s string)  { fmt.Fprintf(w, "-%s\r\n", s) }
+func (w *respWriter) int(n int64)     { fmt.Fprintf(w, ":%d\r\n", n) }
+func (w *respWriter) null()           { w.WriteString("$-1\r\n") }
+func (w *respWriter) array(n int)     { fmt.Fprintf(w, "*%d\r\n", n) }
+
+func (w *respWriter) bulk(b []byte) {
+	fmt.Fprintf(w, "$%d\r\n", len(b))
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (w *respWriter) strings(ss []string) {
+	w.array(len(ss))
+	for _, s := range ss {
+		w.bulk([]byte(s))
+	}
+}
+
+func (w *respWriter) wrongArgs(cmd string) {
+	w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+func (s *Service) respCommand(w *respWriter, table string, args []string) {
+	cmd := strings.ToUpper(args[0])
+	t, err := s.table(table)
+	if err != nil {
+		w.error("ERR " + err.Error())
+		return
+	}
+
+	switch cmd {
+	case "PING":
+		switch len(args) {
+		case 1:
+			w.simple("PONG")
+		case 2:
+			w.bulk([]byte(args[1]))
+		default:
+			w.wrongArgs(cmd)
+		}
+
+	case "COMMAND":
+		// redis-cli连接时会查询命令文档, 返回空列表即可
+		w.array(0)
+
+	case "GET":
+		if len(args) != 2 {
+			w.wrongArgs(cmd)
+			return
+		}
+		item, err := t.Value(args[1])
+		if err != nil {
+			w.null()
+			return
+		}
+		value, err := EncodeValue(item.Value())
+		if err != nil {
+			w.error("ERR " + err.Error())
+			return
+		}
+		w.bulk(value)
+
+	case "SET":
+		if len(args) < 3 {
+			w.wrongArgs(cmd)
+			return
+		}
+		opts, err := parseRESPSetOptions(args[3:])
+		if err != nil {
+			w.error(err.Error())
+			return
+		}
+		if respSet(t, args[1], []byte(args[2]), opts) {
+			w.simple("OK")
+		} else {
+			w.null()
+		}
+
+	case "DEL":
+		if len(args) < 2 {
+			w.wrongArgs(cmd)
+			return
+		}
+		var n int64
+		for _, key := range args[1:] {
+			if _, err := t.Delete(key); err == nil {
+				n++
+			}
+		}
+		w.int(n)
+
+	case "EXPIRE":
+		if len(args) != 3 {
+			w.wrongArgs(cmd)
+			return
+		}
+		secs, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			w.error("ERR value is not an integer or out of range")
+			return
+		}
+		if expireKey(t, args[1], time.Duration(secs)*time.Second, secs <= 0) {
+			w.int(1)
+		} else {
+			w.int(0)
+		}
+
+	case "TTL":
+		if len(args) != 2 {
+			w.wrongArgs(cmd)
+			return
+		}
+		item, err := t.Peek(args[1])
+		switch {
+		case err != nil:
+			w.int(-2)
+		case item.LifeSpan() == 0:
+			w.int(-1)
+		default:
+			remaining := item.LifeSpan() - time.Since(item.AccessedOn())
+			w.int(int64(math.Max(0, math.Ceil(remaining.Seconds()))))
+		}
+
+	case "KEYS":
+		if len(args) != 2 {
+			w.wrongArgs(cmd)
+			return
+		}
+		w.strings(matchingKeys(t, args[1]))
+
+	case "SCAN":
+		if len(args) < 2 {
+			w.wrongArgs(cmd)
+			return
+		}
+		respScan(w, t, args[1:])
+
+	default:
+		w.error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+}
+
+type respSetOptions struct {
+	lifeSpan time.Duration
+	nx, xx   bool
+}
+
+func parseRESPSetOptions(args []string) (respSetOptions, error) {
+	var opts respSetOptions
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			opts.nx = true
+		case "XX":
+			opts.xx = true
+		case "EX", "PX":
+			if i+1 >= len(args) {
+				return opts, errors.New("ERR syntax error")
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				return opts, errors.New("ERR invalid expire time in 'set' command")
+			}
+			unit := time.Second
+			if strings.EqualFold(args[i], "PX") {
+				unit = time.Millisecond
+			}
+			opts.lifeSpan = time.Duration(n) * unit
+			i++
+		default:
+			return opts, errors.New("ERR syntax error")
+		}
+	}
+	if opts.nx && opts.xx {
+		return opts, errors.New("ERR syntax error")
+	}
+	return opts, nil
+}
+
+// respSet 按NX/XX条件写入, 条件不满足时返回false
+func respSet(t *cache.CacheTable, key string, value []byte, opts respSetOptions) bool {
+	if !opts.nx && !opts.xx {
+		t.Add(key, opts.lifeSpan, value)
+		return true
+	}
+	stored := false
+	t.Tx(func(tx *cache.Txn) error {
+		if tx.Exists(key) == opts.xx {
+			tx.Set(key, opts.lifeSpan, value)
+			stored = true
+		}
+		return nil
+	})
+	return stored
+}
+
+// matchingKeys 返回与glob模式匹配的键(字符串形式), 按字典序排列
+func matchingKeys(t *cache.CacheTable, pattern string) []string {
+	keys := []string{}
+	t.Foreach(func(key interface{}, item *cache.CacheItem) {
+		k := fmt.Sprint(key)
+		if ok, _ := path.Match(pattern, k); ok {
+			keys = append(keys, k)
+		}
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// respScan SCAN cursor [MATCH pattern] [COUNT count]
+func respScan(w *respWriter, t *cache.CacheTable, args []string) {
+	cursor, err := strconv.Atoi(args[0])
+	if err != nil || cursor < 0 {
+		w.error("ERR invalid cursor")
+		return
+	}
+	pattern, count := "*", 10
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			w.error("ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil || count < 1 {
+				w.error("ERR value is not an integer or out of range")
+				return
+			}
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+	}
+
+	keys := matchingKeys(t, "*")
+	end := cursor + count
+	if end >= len(keys) {
+		end = len(keys)
+	}
+	var page []string
+	if cursor < end {
+		for _, k := range keys[cursor:end] {
+			if ok, _ := path.Match(pattern, k); ok {
+				page = append(page, k)
+			}
+		}
+	}
+	next := end
+	if next >= len(keys) {
+		next = 0
+	}
+	w.array(2)
+	w.bulk([]byte(strconv.Itoa(next)))
+	w.strings(page)
+}
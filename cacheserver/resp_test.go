@@ -0,0 +1,97 @@
+package cacheserver_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+
+	"cache"
+	"cache/cacheserver"
+)
+
+func TestRESP(t *testing.T) {
+	defer cache.Remove("resp")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go (&cacheserver.Service{AutoCreate: true}).ServeRESP(l, "resp")
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	expect := func(args []string, want ...string) {
+		fmt.Fprintf(conn, "*%d\r\n", len(args))
+		for _, a := range args {
+			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(a), a)
+		}
+		for _, w := range want {
+			line, err := r.ReadString('\n')
+			if err != nil || line != w+"\r\n" {
+				t.Errorf("Error %q: got %q, want %q", args, line, w)
+				return
+			}
+		}
+	}
+
+	expect([]string{"PING"}, "+PONG")
+	expect([]string{"SET", "a", "1", "EX", "100"}, "+OK")
+	expect([]string{"SET", "a", "2", "NX"}, "$-1")
+	expect([]string{"SET", "b", "2", "XX"}, "$-1")
+	expect([]string{"SET", "b", "two"}, "+OK")
+	expect([]string{"GET", "b"}, "$3", "two")
+	expect([]string{"GET", "missing"}, "$-1")
+	expect([]string{"TTL", "a"}, ":100")
+	expect([]string{"TTL", "b"}, ":-1")
+	expect([]string{"TTL", "missing"}, ":-2")
+	expect([]string{"EXPIRE", "b", "50"}, ":1")
+	expect([]string{"TTL", "b"}, ":50")
+	expect([]string{"KEYS", "*"}, "*2", "$1", "a", "$1", "b")
+	expect([]string{"SCAN", "0", "COUNT", "1"}, "*2", "$1", "1", "*1", "$1", "a")
+	expect([]string{"SCAN", "1", "COUNT", "1"}, "*2", "$1", "0", "*1", "$1", "b")
+	expect([]string{"DEL", "a", "b", "missing"}, ":2")
+	expect([]string{"FLUSHALL"}, "-ERR unknown command 'FLUSHALL'")
+
+	fmt.Fprint(conn, "PING inline\r\n")
+	if line, _ := r.ReadString('\n'); line != "$6\r\n" {
+		t.Error("Error inline command", line)
+	}
+}
+
+func TestRESPAuth(t *testing.T) {
+	defer cache.Remove("resp-auth")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s := &cacheserver.Service{AutoCreate: true, Security: &cache.Security{BearerTokens: []string{"secret"}}}
+	go s.ServeRESP(l, "resp-auth")
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	expect := func(cmd, want string) {
+		fmt.Fprint(conn, cmd+"\r\n")
+		if line, err := r.ReadString('\n'); err != nil || line != want+"\r\n" {
+			t.Errorf("Error %q: got %q, want %q", cmd, line, want)
+		}
+	}
+
+	expect("SET a 1", "-NOAUTH Authentication required.")
+	expect("AUTH wrong", "-WRONGPASS invalid username-password pair or user is disabled.")
+	expect("GET a", "-NOAUTH Authentication required.")
+	expect("AUTH default secret", "+OK")
+	expect("SET a 1", "+OK")
+	if !cache.Cache("resp-auth").Exists("a") {
+		t.Error("Error writing after AUTH")
+	}
+}
@@ -0,0 +1,239 @@
+package cacheserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cache"
+)
+
+// TTLHeader 请求中指定有效期、响应中返回剩余有效期的头部. 值为秒数或time.ParseDuration格式, 0表示不过期
+const TTLHeader = "X-Cache-TTL"
+
+// MaxBodySize REST接口接受的最大值大小
+const MaxBodySize = 32 << 20
+
+// Handler 返回REST接口的http.Handler, 值以原始字节作为请求/响应体:
+//
+//	GET    /tables/{table}/items/{key}   读取值, 响应带ETag(版本号)和X-Cache-TTL; 支持If-None-Match
+//	PUT    /tables/{table}/items/{key}   写入值, 有效期取自X-Cache-TTL; 支持If-Match和If-None-Match: *
+//	DELETE /tables/{table}/items/{key}   删除值; 支持If-Match
+//	HEAD   /tables/{table}/items/{key}   元素是否存在, 不计入命中统计
+//	GET    /tables/{table}               表的统计(JSON)
+//	POST   /tables/{table}/flush         清空表
+//
+// 条件不满足时返回412, 元素不存在返回404, 表不存在返回404(AutoCreate为false时);
+// 写入被拒绝时值过大返回413, 未通过准入策略返回507, 表已关闭返回503; 设置了Security时未通过鉴权返回401
+func (s *Service) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tables/{table}/items/{key}", s.restGet)
+	mux.HandleFunc("PUT /tables/{table}/items/{key}", s.restPut)
+	mux.HandleFunc("DELETE /tables/{table}/items/{key}", s.restDelete)
+	mux.HandleFunc("HEAD /tables/{table}/items/{key}", s.restHead)
+	mux.HandleFunc("GET /tables/{table}", s.restStats)
+	mux.HandleFunc("POST /tables/{table}/flush", s.restFlush)
+	return s.Security.Wrap(mux)
+}
+
+func (s *Service) restGet(w http.ResponseWriter, r *http.Request) {
+	item, err := s.Get(r.Context(), r.PathValue("table"), r.PathValue("key"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setItemHeaders(w, item)
+	if etagMatches(r.Header.Get("If-None-Match"), item.Version) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(item.Value)
+}
+
+func (s *Service) restPut(w http.ResponseWriter, r *http.Request) {
+	lifeSpan, err := parseTTL(r.Header.Get(TTLHeader))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodySize))
+	if err != nil {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+
+	table, key := r.PathValue("table"), r.PathValue("key")
+	var item *Item
+	switch {
+	case r.Header.Get("If-Match") != "":
+		version, ok := parseETag(r.Header.Get("If-Match"))
+		if !ok {
+			writeJSONError(w, http.StatusPreconditionFailed, cache.ErrVersionMismatch)
+			return
+		}
+		item, err = s.CompareAndSet(r.Context(), table, key, value, lifeSpan, version)
+		err = preconditionError(err)
+	case strings.TrimSpace(r.Header.Get("If-None-Match")) == "*":
+		item, err = s.CompareAndSet(r.Context(), table, key, value, lifeSpan, 0)
+	default:
+		item, err = s.Set(r.Context(), table, key, value, lifeSpan)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setItemHeaders(w, item)
+	if item.Version == 1 {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Service) restDelete(w http.ResponseWriter, r *http.Request) {
+	table, key := r.PathValue("table"), r.PathValue("key")
+	if match := r.Header.Get("If-Match"); match != "" {
+		version, ok := parseETag(match)
+		if !ok {
+			writeJSONError(w, http.StatusPreconditionFailed, cache.ErrVersionMismatch)
+			return
+		}
+		if err := s.CompareAndDelete(r.Context(), table, key, version); err != nil {
+			writeError(w, preconditionError(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	found, err := s.Delete(r.Context(), table, key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !found {
+		writeJSONError(w, http.StatusNotFound, cache.ErrKeyNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Service) restHead(w http.ResponseWriter, r *http.Request) {
+	found, err := s.Exists(r.Context(), r.PathValue("table"), r.PathValue("key"))
+	switch {
+	case err != nil:
+		writeError(w, err)
+	case !found:
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *Service) restStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.Stats(r.Context(), r.PathValue("table"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (s *Service) restFlush(w http.ResponseWriter, r *http.Request) {
+	if err := s.Flush(r.Context(), r.PathValue("table")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setItemHeaders 写入ETag和剩余有效期, 不过期的元素不带X-Cache-TTL
+func setItemHeaders(w http.ResponseWriter, item *Item) {
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatUint(item.Version, 10)))
+	if item.LifeSpan > 0 {
+		secs := int64(math.Ceil(item.TTLRemaining.Seconds()))
+		w.Header().Set(TTLHeader, strconv.FormatInt(secs, 10))
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", secs))
+	}
+}
+
+// parseTTL 解析X-Cache-TTL, 空值表示不过期
+func parseTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q", TTLHeader, s)
+	}
+	return d, nil
+}
+
+// parseETag 解析If-Match中的版本号, 接受带引号或弱校验前缀的形式; 多个值只取第一个
+func parseETag(s string) (uint64, bool) {
+	s = strings.TrimSpace(strings.Split(s, ",")[0])
+	s = strings.TrimPrefix(s, "W/")
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	version, err := strconv.ParseUint(s, 10, 64)
+	return version, err == nil
+}
+
+// etagMatches If-None-Match中是否包含version, "*"匹配任意版本
+func etagMatches(header string, version uint64) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if v, ok := parseETag(tag); ok && v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// preconditionError If-Match时元素不存在也属于条件不满足
+func preconditionError(err error) error {
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		return cache.ErrVersionMismatch
+	}
+	return err
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, cache.ErrVersionMismatch):
+		writeJSONError(w, http.StatusPreconditionFailed, err)
+	case errors.Is(err, cache.ErrKeyNotFound), errors.Is(err, cache.ErrKeyNotFoundOrLoadable), errors.Is(err, cache.ErrTableNotFound):
+		writeJSONError(w, http.StatusNotFound, err)
+	case errors.Is(err, cache.ErrTableClosed):
+		writeJSONError(w, http.StatusServiceUnavailable, err)
+	case errors.Is(err, cache.ErrValueTooLarge):
+		writeJSONError(w, http.StatusRequestEntityTooLarge, err)
+	case errors.Is(err, cache.ErrNotAdmitted):
+		writeJSONError(w, http.StatusInsufficientStorage, err)
+	case errors.Is(err, cache.ErrTypeMismatch):
+		writeJSONError(w, http.StatusConflict, err)
+	default:
+		writeJSONError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,110 @@
+package cacheserver_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cache"
+	"cache/cacheserver"
+)
+
+func TestRESTPutRejected(t *testing.T) {
+	cache.Cache("rest-small", cache.WithMaxValueSize(64, false))
+	defer cache.Remove("rest-small")
+	srv := httptest.NewServer((&cacheserver.Service{}).Handler())
+	defer srv.Close()
+
+	req, _ := http.NewRequest("PUT", srv.URL+"/tables/rest-small/items/k", strings.NewReader(strings.Repeat("v", 128)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Error("Error expected 413 for rejected write", resp.Status)
+	}
+}
+
+func TestRESTAuth(t *testing.T) {
+	defer cache.Remove("rest-auth")
+	s := &cacheserver.Service{AutoCreate: true, Security: &cache.Security{BearerTokens: []string{"secret"}}}
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized, "secret": http.StatusCreated} {
+		req, _ := http.NewRequest("PUT", srv.URL+"/tables/rest-auth/items/"+token, strings.NewReader("v"))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Error token %q: got %s, want %d", token, resp.Status, want)
+		}
+	}
+}
+
+func TestRESTHandler(t *testing.T) {
+	defer cache.Remove("rest")
+	srv := httptest.NewServer((&cacheserver.Service{AutoCreate: true}).Handler())
+	defer srv.Close()
+	url := srv.URL + "/tables/rest/items/k"
+
+	do := func(method, body string, header ...string) *http.Response {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := do("PUT", "v1", "X-Cache-TTL", "60", "If-None-Match", "*"); resp.StatusCode != http.StatusCreated || resp.Header.Get("ETag") != `"1"` {
+		t.Error("Error creating item", resp.Status, resp.Header)
+	}
+	if resp := do("PUT", "v2", "If-None-Match", "*"); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Error("Error expected 412 for create of existing item", resp.Status)
+	}
+	if resp := do("PUT", "v2", "If-Match", `"7"`); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Error("Error expected 412 for stale If-Match", resp.Status)
+	}
+	if resp := do("PUT", "v2", "If-Match", `"1"`, "X-Cache-TTL", "1m"); resp.StatusCode != http.StatusNoContent || resp.Header.Get("ETag") != `"2"` {
+		t.Error("Error updating item", resp.Status, resp.Header)
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "v2" || resp.Header.Get("X-Cache-TTL") != "60" || resp.Header.Get("ETag") != `"2"` {
+		t.Error("Error reading item", string(body), resp.Header)
+	}
+	if resp := do("GET", "", "If-None-Match", `"2"`); resp.StatusCode != http.StatusNotModified {
+		t.Error("Error expected 304", resp.Status)
+	}
+
+	if resp := do("DELETE", "", "If-Match", `"1"`); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Error("Error expected 412 for stale delete", resp.Status)
+	}
+	if resp := do("DELETE", "", "If-Match", `"2"`); resp.StatusCode != http.StatusNoContent {
+		t.Error("Error deleting item", resp.Status)
+	}
+	if resp := do("GET", ""); resp.StatusCode != http.StatusNotFound {
+		t.Error("Error expected 404 after delete", resp.Status)
+	}
+	if resp := do("PUT", "v", "X-Cache-TTL", "soon"); resp.StatusCode != http.StatusBadRequest {
+		t.Error("Error expected 400 for invalid TTL", resp.Status)
+	}
+}
@@ -0,0 +1,301 @@
+// Package cacheserver 把cache包的表暴露给其他进程使用.
+//
+// 接口定义在cachepb/cache.proto中(Get/Set/Delete/GetMulti/Stats/Watch), cachepb包含protoc-gen-go生成的消息类型.
+// Service实现了这些调用的语义, 与传输方式无关. GRPCHandler以gRPC协议提供该服务(基于net/http的HTTP/2,
+// 不依赖google.golang.org/grpc), 可与标准gRPC客户端互通, 客户端见GRPCClient.
+// 也可使用Handler提供的REST接口(客户端见Client)、ServeRESP或ServeMemcached.
+package cacheserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"cache"
+)
+
+// ErrWatchLagged Watch的订阅者落后太多, 变更流已被服务端关闭, 对应gRPC的ResourceExhausted
+var ErrWatchLagged = errors.New("Watch subscriber fell too far behind")
+
+// Item 远程调用返回的元素, 对应cache.proto的Item
+type Item struct {
+	Key          string        `json:"key"`
+	Value        []byte        `json:"value"`
+	LifeSpan     time.Duration `json:"lifespan"`
+	TTLRemaining time.Duration `json:"ttl_remaining"`
+	Version      uint64        `json:"version"`
+	AccessCount  int64         `json:"access_count"`
+}
+
+// Mutation 变更流中的一条记录, 对应cache.proto的Mutation
+type Mutation struct {
+	Seq      uint64        `json:"seq"`
+	Op       string        `json:"op"`
+	Key      string        `json:"key"`
+	Value    []byte        `json:"value,omitempty"`
+	LifeSpan time.Duration `json:"lifespan"`
+	Version  uint64        `json:"version"`
+	Time     time.Time     `json:"time"`
+}
+
+// Service 远程访问已注册表的服务. 键均为字符串, 值以字节形式传输
+type Service struct {
+	// AutoCreate 为true时访问不存在的表会创建该表, 否则返回cache.ErrTableNotFound
+	AutoCreate bool
+	// Security 为nil时不做鉴权. Handler按Security.Wrap鉴权; ServeRESP的连接需先AUTH <token>,
+	// ServeMemcached的连接第一条命令需是数据为token的set(同memcached的-Y). 非HTTP协议只能校验BearerTokens,
+	// 只配置了Authenticate时这两种连接都会被拒绝. TLS由调用方用Security.Listen创建监听
+	Security *cache.Security
+}
+
+// authRequired 非HTTP连接是否需要先鉴权
+func (s *Service) authRequired() bool {
+	return s.Security != nil && (len(s.Security.BearerTokens) > 0 || s.Security.Authenticate != nil)
+}
+
+// checkToken 校验非HTTP连接提供的token
+func (s *Service) checkToken(token string) bool {
+	return len(s.Security.BearerTokens) > 0 && s.Security.CheckToken(token)
+}
+
+func (s *Service) table(name string) (*cache.CacheTable, error) {
+	if !s.AutoCreate && !cache.Exists(name) {
+		return nil, cache.ErrTableNotFound
+	}
+	return cache.Cache(name), nil
+}
+
+// Get 读取元素, 计入表的命中/未命中统计并触发数据加载函数
+func (s *Service) Get(ctx context.Context, table, key string) (*Item, error) {
+	t, err := s.table(table)
+	if err != nil {
+		return nil, err
+	}
+	item, err := t.ValueContext(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return newItem(item, time.Now())
+}
+
+// Set 写入元素, lifeSpan为0表示不过期. 表已关闭或写入被拒绝(如cache.ErrNotAdmitted、cache.ErrValueTooLarge)时返回对应的错误
+func (s *Service) Set(ctx context.Context, table, key string, value []byte, lifeSpan time.Duration) (*Item, error) {
+	t, err := s.table(table)
+	if err != nil {
+		return nil, err
+	}
+	item, err := t.TryAddContext(ctx, key, lifeSpan, value)
+	if err != nil {
+		return nil, err
+	}
+	return newItem(item, time.Now())
+}
+
+// Delete 删除元素, 返回元素是否存在
+func (s *Service) Delete(ctx context.Context, table, key string) (bool, error) {
+	t, err := s.table(table)
+	if err != nil {
+		return false, err
+	}
+	_, err = t.DeleteContext(ctx, key)
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Exists 元素是否存在, 不计入命中统计也不延长有效期
+func (s *Service) Exists(ctx context.Context, table, key string) (bool, error) {
+	t, err := s.table(table)
+	if err != nil {
+		return false, err
+	}
+	return t.Exists(key), nil
+}
+
+// Flush 清空表
+func (s *Service) Flush(ctx context.Context, table string) error {
+	t, err := s.table(table)
+	if err != nil {
+		return err
+	}
+	t.Flush()
+	return nil
+}
+
+// CompareAndSet 只有元素当前版本等于version时才写入; version为0表示只在键不存在时写入.
+// 版本不符返回cache.ErrVersionMismatch, 键不存在(且version不为0)返回cache.ErrKeyNotFound
+func (s *Service) CompareAndSet(ctx context.Context, table, key string, value []byte, lifeSpan time.Duration, version uint64) (*Item, error) {
+	t, err := s.table(table)
+	if err != nil {
+		return nil, err
+	}
+	var item *cache.CacheItem
+	err = t.Tx(func(tx *cache.Txn) error {
+		if err := checkVersion(tx, key, version); err != nil {
+			return err
+		}
+		item = tx.Set(key, lifeSpan, value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newItem(item, time.Now())
+}
+
+// CompareAndDelete 只有元素当前版本等于version时才删除, 错误同CompareAndSet
+func (s *Service) CompareAndDelete(ctx context.Context, table, key string, version uint64) error {
+	t, err := s.table(table)
+	if err != nil {
+		return err
+	}
+	return t.Tx(func(tx *cache.Txn) error {
+		if err := checkVersion(tx, key, version); err != nil {
+			return err
+		}
+		tx.Delete(key)
+		return nil
+	})
+}
+
+func checkVersion(tx *cache.Txn, key string, version uint64) error {
+	cur, ok := tx.Version(key)
+	switch {
+	case ok && cur != version:
+		return cache.ErrVersionMismatch
+	case !ok && version != 0:
+		return cache.ErrKeyNotFound
+	}
+	return nil
+}
+
+// GetMulti 批量读取, 只返回存在(或可加载)的元素, 顺序与keys一致
+func (s *Service) GetMulti(ctx context.Context, table string, keys []string) ([]*Item, error) {
+	t, err := s.table(table)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*Item, 0, len(keys))
+	for _, key := range keys {
+		item, err := t.ValueContext(ctx, key)
+		if err != nil {
+			continue
+		}
+		it, err := newItem(item, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+// Stats 返回表的统计
+func (s *Service) Stats(ctx context.Context, table string) (cache.Stats, error) {
+	t, err := s.table(table)
+	if err != nil {
+		return cache.Stats{}, err
+	}
+	return t.Stats(), nil
+}
+
+// Watch 把表的变更依次交给send, 直到ctx结束(返回ctx.Err())或send出错.
+// 订阅者落后超过cache.DefaultCDCBuffer条时返回ErrWatchLagged, 客户端应重新读取后再订阅
+func (s *Service) Watch(ctx context.Context, table string, send func(Mutation) error) error {
+	t, err := s.table(table)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for m := range t.CDC(ctx) {
+		mutation, err := newMutation(m)
+		if err != nil {
+			return err
+		}
+		if err := send(mutation); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if t.Closed() {
+		return cache.ErrTableClosed
+	}
+	return ErrWatchLagged
+}
+
+// expireKey 保留值并把元素的有效期改为lifeSpan, expired为true时删除元素; 元素不存在时返回false
+func expireKey(t *cache.CacheTable, key string, lifeSpan time.Duration, expired bool) bool {
+	found := false
+	t.Tx(func(tx *cache.Txn) error {
+		value, ok := tx.Get(key)
+		if !ok {
+			return nil
+		}
+		found = true
+		if expired {
+			tx.Delete(key)
+		} else {
+			tx.Set(key, lifeSpan, value)
+		}
+		return nil
+	})
+	return found
+}
+
+// EncodeValue 把表中的值转为字节: []byte原样返回, 字符串直接转换, 其他类型编码为JSON
+func EncodeValue(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return json.Marshal(v)
+}
+
+func newItem(item *cache.CacheItem, now time.Time) (*Item, error) {
+	value, err := EncodeValue(item.Value())
+	if err != nil {
+		return nil, err
+	}
+	it := &Item{
+		Key:         fmt.Sprint(item.Key()),
+		Value:       value,
+		LifeSpan:    item.LifeSpan(),
+		Version:     item.Version(),
+		AccessCount: item.AccessCount(),
+	}
+	if it.LifeSpan > 0 {
+		if remaining := it.LifeSpan - now.Sub(item.AccessedOn()); remaining > 0 {
+			it.TTLRemaining = remaining
+		}
+	}
+	return it, nil
+}
+
+func newMutation(m cache.Mutation) (Mutation, error) {
+	value, err := EncodeValue(m.Value)
+	if err != nil {
+		return Mutation{}, err
+	}
+	mutation := Mutation{
+		Seq:      m.Seq,
+		Op:       m.Op.String(),
+		Value:    value,
+		LifeSpan: m.LifeSpan,
+		Version:  m.Version,
+		Time:     m.Time,
+	}
+	if m.Key != nil {
+		mutation.Key = fmt.Sprint(m.Key)
+	}
+	return mutation, nil
+}
@@ -0,0 +1,87 @@
+package cacheserver_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cache"
+	"cache/cacheserver"
+)
+
+func TestService(t *testing.T) {
+	ctx := context.Background()
+	s := &cacheserver.Service{}
+	if _, err := s.Get(ctx, "cacheserver-missing", "k"); err != cache.ErrTableNotFound {
+		t.Error("Error expected ErrTableNotFound for unknown table, got", err)
+	}
+
+	s.AutoCreate = true
+	defer cache.Remove("cacheserver")
+	if _, err := s.Set(ctx, "cacheserver", "k", []byte("v"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	cache.Cache("cacheserver").Add("n", 0, map[string]int{"a": 1})
+
+	item, err := s.Get(ctx, "cacheserver", "k")
+	if err != nil || string(item.Value) != "v" || item.LifeSpan != time.Minute || item.TTLRemaining <= 0 {
+		t.Error("Error retrieving item", item, err)
+	}
+	items, err := s.GetMulti(ctx, "cacheserver", []string{"n", "missing", "k"})
+	if err != nil || len(items) != 2 || string(items[0].Value) != `{"a":1}` || items[1].Key != "k" {
+		t.Error("Error retrieving multiple items", items, err)
+	}
+	cache.Cache("cacheserver-small", cache.WithMaxValueSize(64, false))
+	defer cache.Remove("cacheserver-small")
+	if item, err := s.Set(ctx, "cacheserver-small", "big", make([]byte, 128), 0); item != nil || !errors.Is(err, cache.ErrValueTooLarge) {
+		t.Error("Error expected rejected write to return ErrValueTooLarge", item, err)
+	}
+	if found, err := s.Delete(ctx, "cacheserver", "k"); !found || err != nil {
+		t.Error("Error deleting item", found, err)
+	}
+	if found, err := s.Delete(ctx, "cacheserver", "k"); found || err != nil {
+		t.Error("Error deleting missing item", found, err)
+	}
+	if stats, err := s.Stats(ctx, "cacheserver"); err != nil || stats.Items != 1 || stats.Misses != 1 {
+		t.Error("Error reading stats", stats, err)
+	}
+}
+
+func TestServiceWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &cacheserver.Service{AutoCreate: true}
+	defer cache.Remove("cacheserver-watch")
+	cache.Cache("cacheserver-watch")
+
+	got := make(chan cacheserver.Mutation, 2)
+	done := make(chan error)
+	go func() {
+		done <- s.Watch(ctx, "cacheserver-watch", func(m cacheserver.Mutation) error {
+			select {
+			case got <- m:
+			default:
+			}
+			return nil
+		})
+	}()
+
+	deadline := time.After(time.Second)
+	var m cacheserver.Mutation
+	for m.Op == "" {
+		s.Set(ctx, "cacheserver-watch", "k", []byte("v"), 0)
+		select {
+		case m = <-got:
+		case <-deadline:
+			t.Fatal("Error no mutation received")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if m.Key != "k" || string(m.Value) != "v" {
+		t.Error("Error unexpected mutation", m)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Error("Error expected context.Canceled from Watch, got", err)
+	}
+}
@@ -0,0 +1,232 @@
+// Package cachesql 缓存database/sql的查询结果. 键为规范化后的SQL和参数, 结果按表的默认有效期(cache.WithDefaultTTL)保存,
+// 并记录查询涉及的数据库表名, 写入后可以按表名失效:
+//
+//	t := cache.NewTable("queries", cache.WithDefaultTTL(time.Minute))
+//	rows, err := cachesql.Query(ctx, t, db, "SELECT id, name FROM users WHERE id = ?", 1)
+//	_, err = cachesql.Exec(ctx, t, db, "UPDATE users SET name = ? WHERE id = ?", "bob", 1) // 使涉及users的结果失效
+package cachesql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"cache"
+)
+
+// Querier 执行查询, *sql.DB, *sql.Tx和*sql.Conn都满足
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Execer 执行写入, *sql.DB, *sql.Tx和*sql.Conn都满足
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Rows 缓存的查询结果. 同一结果会返回给多个调用方, 不应修改
+type Rows struct {
+	Columns []string
+	Values  [][]interface{}
+	// Tables 查询涉及的数据库表名(小写)
+	Tables []string
+}
+
+// Len 返回行数
+func (r *Rows) Len() int {
+	return len(r.Values)
+}
+
+// Scan 把第i行的各列依次赋给dest, dest为指针, 列的类型需要能赋值或转换为dest指向的类型; NULL赋为零值
+func (r *Rows) Scan(i int, dest ...interface{}) error {
+	if i < 0 || i >= len(r.Values) {
+		return fmt.Errorf("cachesql: row %d out of range", i)
+	}
+	row := r.Values[i]
+	if len(dest) != len(row) {
+		return fmt.Errorf("cachesql: expected %d destination arguments, got %d", len(row), len(dest))
+	}
+	for j, d := range dest {
+		dv := reflect.ValueOf(d)
+		if dv.Kind() != reflect.Ptr || dv.IsNil() {
+			return fmt.Errorf("cachesql: destination %d is not a non-nil pointer", j)
+		}
+		target := dv.Elem()
+		if row[j] == nil {
+			target.Set(reflect.Zero(target.Type()))
+			continue
+		}
+		v := reflect.ValueOf(row[j])
+		switch {
+		case v.Type().AssignableTo(target.Type()):
+			target.Set(v)
+		case target.Kind() == reflect.String && v.Type() == reflect.TypeOf([]byte(nil)):
+			target.SetString(string(row[j].([]byte)))
+		case v.Type().ConvertibleTo(target.Type()) && v.Kind() != reflect.Slice && target.Kind() != reflect.String:
+			target.Set(v.Convert(target.Type()))
+		default:
+			return fmt.Errorf("cachesql: cannot scan %T into %s for column %q", row[j], target.Type(), r.Columns[j])
+		}
+	}
+	return nil
+}
+
+// Query 返回查询结果, 未命中时在db上执行并按表的默认有效期缓存
+func Query(ctx context.Context, table *cache.CacheTable, db Querier, query string, args ...interface{}) (*Rows, error) {
+	return QueryTTL(ctx, table, db, table.Settings().DefaultTTL, query, args...)
+}
+
+// QueryTTL 同Query, 结果缓存ttl时长
+func QueryTTL(ctx context.Context, table *cache.CacheTable, db Querier, ttl time.Duration, query string, args ...interface{}) (*Rows, error) {
+	normalized := Normalize(query)
+	key := Key(normalized, args...)
+	if item, err := table.ValueContext(ctx, key); err == nil {
+		if rows, ok := item.Value().(*Rows); ok {
+			return rows, nil
+		}
+	}
+
+	sqlRows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer sqlRows.Close()
+	rows := &Rows{Tables: Tables(normalized)}
+	if rows.Columns, err = sqlRows.Columns(); err != nil {
+		return nil, err
+	}
+	for sqlRows.Next() {
+		values := make([]interface{}, len(rows.Columns))
+		ptrs := make([]interface{}, len(values))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := sqlRows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			// 驱动可能复用[]byte的底层数组
+			if b, ok := v.([]byte); ok {
+				values[i] = append([]byte(nil), b...)
+			}
+		}
+		rows.Values = append(rows.Values, values)
+	}
+	if err := sqlRows.Err(); err != nil {
+		return nil, err
+	}
+	table.Add(key, ttl, rows)
+	return rows, nil
+}
+
+// Exec 在db上执行写入, 成功后使涉及语句中数据库表的缓存结果失效
+func Exec(ctx context.Context, table *cache.CacheTable, db Execer, query string, args ...interface{}) (sql.Result, error) {
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	Invalidate(table, Tables(Normalize(query))...)
+	return res, nil
+}
+
+// Invalidate 移除涉及任一数据库表的缓存结果, 表名不区分大小写; 返回移除的数量
+func Invalidate(table *cache.CacheTable, tables ...string) int {
+	if len(tables) == 0 {
+		return 0
+	}
+	names := make(map[string]bool, len(tables))
+	for _, name := range tables {
+		names[strings.ToLower(name)] = true
+	}
+	var keys []interface{}
+	table.Foreach(func(key interface{}, item *cache.CacheItem) {
+		if rows, ok := item.Value().(*Rows); ok {
+			for _, name := range rows.Tables {
+				if names[name] {
+					keys = append(keys, key)
+					return
+				}
+			}
+		}
+	})
+	n := 0
+	for _, key := range keys {
+		if _, err := table.Delete(key); err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Key 返回查询的缓存键, 参数按类型和值区分
+func Key(normalized string, args ...interface{}) string {
+	var b strings.Builder
+	b.WriteString(normalized)
+	for _, arg := range args {
+		fmt.Fprintf(&b, "\x00%T:%v", arg, arg)
+	}
+	return b.String()
+}
+
+// Normalize 把引号外的连续空白合并为一个空格, 去掉首尾空白和末尾的分号
+func Normalize(query string) string {
+	var b strings.Builder
+	var quote rune
+	space := false
+	for _, r := range strings.TrimSpace(query) {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	return strings.TrimRight(b.String(), "; ")
+}
+
+// Tables 返回语句中FROM, JOIN, UPDATE, INTO之后的数据库表名(小写, 去重)
+func Tables(query string) []string {
+	fields := strings.FieldsFunc(query, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '(' || r == ')'
+	})
+	seen := make(map[string]bool)
+	var tables []string
+	add := func(name string) {
+		name = strings.ToLower(strings.Trim(name, "`\"[];"))
+		if name != "" && name != "select" && !seen[name] {
+			seen[name] = true
+			tables = append(tables, name)
+		}
+	}
+	for i := 0; i < len(fields)-1; i++ {
+		switch strings.ToLower(fields[i]) {
+		case "from", "join", "update", "into":
+			// FROM a, b
+			for j := i + 1; j < len(fields); j++ {
+				name := fields[j]
+				more := strings.HasSuffix(name, ",")
+				for _, part := range strings.Split(strings.TrimSuffix(name, ","), ",") {
+					add(part)
+				}
+				if !more && (j+1 >= len(fields) || !strings.HasPrefix(fields[j+1], ",")) {
+					break
+				}
+			}
+		}
+	}
+	return tables
+}
@@ -0,0 +1,119 @@
+package cachesql_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cache"
+	"cache/cachesql"
+)
+
+// fakeDriver 每次查询返回两行 (id, name), 并统计执行次数
+type fakeDriver struct{ queries, execs int32 }
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) { return &fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{c.d}, nil }
+func (c *fakeConn) Close() error                              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)                 { return nil, driver.ErrSkip }
+
+type fakeStmt struct{ d *fakeDriver }
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	atomic.AddInt32(&s.d.execs, 1)
+	return driver.RowsAffected(1), nil
+}
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	atomic.AddInt32(&s.d.queries, 1)
+	return &fakeRows{rows: [][]driver.Value{{int64(1), []byte("alice")}, {int64(2), nil}}}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+	i    int
+}
+
+func (r *fakeRows) Columns() []string { return []string{"id", "name"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.i])
+	r.i++
+	return nil
+}
+
+var fake = &fakeDriver{}
+
+func init() {
+	sql.Register("cachesql-fake", fake)
+}
+
+func TestQuery(t *testing.T) {
+	db, err := sql.Open("cachesql-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	table := cache.NewTable("queries", cache.WithDefaultTTL(time.Minute))
+
+	rows, err := cachesql.Query(ctx, table, db, "SELECT id, name\n  FROM users WHERE id > ?", 0)
+	if err != nil || rows.Len() != 2 {
+		t.Fatal("Error querying", err)
+	}
+	var id int
+	var name string
+	if err := rows.Scan(0, &id, &name); err != nil || id != 1 || name != "alice" {
+		t.Error("Error scanning row", err, id, name)
+	}
+	if err := rows.Scan(1, &id, &name); err != nil || id != 2 || name != "" {
+		t.Error("Error scanning NULL column", err, id, name)
+	}
+
+	cachesql.Query(ctx, table, db, "SELECT id, name FROM users WHERE id > ?;", 0)
+	if n := atomic.LoadInt32(&fake.queries); n != 1 {
+		t.Error("Error normalized query was not served from cache, queries:", n)
+	}
+	cachesql.Query(ctx, table, db, "SELECT id, name FROM users WHERE id > ?", int64(0))
+	cachesql.Query(ctx, table, db, "SELECT o.id, u.name FROM orders o JOIN users u ON u.id = o.user_id")
+	if n := atomic.LoadInt32(&fake.queries); n != 3 || table.Count() != 3 {
+		t.Error("Error different args should use different keys, queries:", n)
+	}
+
+	if _, err := cachesql.Exec(ctx, table, db, "UPDATE orders SET total = ? WHERE id = ?", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if table.Count() != 2 {
+		t.Error("Error update did not invalidate results for orders")
+	}
+	if n := cachesql.Invalidate(table, "USERS"); n != 2 || table.Count() != 0 {
+		t.Error("Error invalidating by table name", n)
+	}
+}
+
+func TestTables(t *testing.T) {
+	cases := map[string][]string{
+		"SELECT * FROM a, b WHERE a.id = b.id":          {"a", "b"},
+		"select x from `Users` u left join orders o on": {"users", "orders"},
+		"INSERT INTO logs (msg) VALUES (?)":             {"logs"},
+		"DELETE FROM sessions WHERE expired":            {"sessions"},
+		"SELECT * FROM (SELECT id FROM inner_t) t":      {"inner_t"},
+	}
+	for query, want := range cases {
+		if got := cachesql.Tables(query); !reflect.DeepEqual(got, want) {
+			t.Error("Error parsing tables", query, got, want)
+		}
+	}
+}
@@ -1,9 +1,10 @@
 package cache
 
 import (
-	"log"
-	"sort"
+	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,24 +12,106 @@ type CacheTable struct {
 	sync.RWMutex
 
 	name  string
-	items map[interface{}]*CacheItem
+	items itemMap
 
-	cleanupTimer    *time.Timer
+	clock           Clock
+	cleanupTimer    Timer
 	cleanupInterval time.Duration
 
-	logger   *log.Logger
-	loadData func(key interface{}, args ...interface{}) *CacheItem
-
-	addItem []func(item *CacheItem)
+	logger     Logger
+	logLimiter logLimiter
+	loadData   loaderFunc
+
+	addItem []func(ctx context.Context, item *CacheItem)
+
+	aboutToDeleteItem []func(ctx context.Context, item *CacheItem)
+
+	expiredBatch []func(items []*CacheItem)
+
+	admissionHook AdmissionHook
+	admission     AdmissionPolicy
+	doorkeeper    *doorkeeper
+	missing       *missingFilter
+	// trace StartTrace开启的访问记录
+	trace *traceRecorder
+	// profilerLabels 是否给加载函数、回调和定时任务打pprof标签
+	profilerLabels atomic.Bool
+	notAdmitted    uint64
+	oversize       OversizeCallback
+	oversized      uint64
+	copyOnRead     Copier
+
+	loaderLatency  latencyHistogram
+	loaderFailures uint64
+
+	hits      uint64
+	misses    uint64
+	hitWindow hitWindow
+
+	sizer     Sizer
+	totalSize int64
+
+	snapshotFile string
+	warmStart    *warmStart
+	// draining Shutdown开始后为true, 不再接受写入和新的加载; loads 正在执行的加载
+	draining bool
+	loads    sync.WaitGroup
+	// drainStore Shutdown时写入剩余元素的Store
+	drainStore Store
+	// busOrigin 本表在总线上的节点ID, 首次AttachBus时生成
+	busOrigin string
+	// lastPersist, persistErr 最近一次成功Persist的时间和最近一次Persist的错误
+	lastPersist time.Time
+	persistErr  error
+	closed      bool
+
+	// lastSweep 最近一次过期检查的时间
+	lastSweep        time.Time
+	healthThresholds HealthThresholds
+
+	settings       atomic.Pointer[Settings]
+	evictionPolicy EvictionPolicy
+	// evictor 维护访问顺序的淘汰策略实现, 为nil时在第一次淘汰时按evictionPolicy创建(seedEvictor)
+	evictor   Evictor
+	evictions uint64
+
+	keyLocks KeyMutex
+
+	// viewShared 当前items是否被View引用, 为true时修改前需复制
+	viewShared bool
+
+	mutationSeq uint64
+	cdcSubs     map[*cdcSub]struct{}
+	audit       *auditRing
+
+	peers     PeerPicker
+	flights   flightGroup
+	rebalance rebalanceState
+	leases    *leaseState
+	immutable *immutableState
+	// bgEvict 开启后台淘汰时的状态
+	bgEvict *backgroundEviction
+	// graveyard 宽限期内保留的过期元素
+	graveyard *graveyard
+	// buckets 分桶过期, 为nil时过期检查遍历整表
+	buckets *expiryBuckets
+
+	interceptors atomic.Pointer[interceptorChain]
+	plugins      []Plugin
+}
 
-	aboutToDeleteItem []func(item *CacheItem)
+// Name 返回表名
+func (table *CacheTable) Name() string {
+	table.RLock()
+	defer table.RUnlock()
+	return table.name
 }
 
 // 表长度
 func (table *CacheTable) Count() int {
 	table.RLock()
 	defer table.RUnlock()
-	return len(table.items)
+	return table.items.len()
 }
 
 // 遍历所有元素
@@ -36,15 +119,30 @@ func (table *CacheTable) Foreach(trans func(key interface{}, item *CacheItem)) {
 	table.RLock()
 	defer table.RUnlock()
 
-	for k, v := range table.items {
+	table.items.each(func(k interface{}, v *CacheItem) bool {
 		trans(k, v)
-	}
+		return true
+	})
 }
 
+// loaderFunc 表内部使用的加载函数形式
+type loaderFunc func(ctx context.Context, key interface{}, args ...interface{}) *CacheItem
+
 // 数据加载
 func (table *CacheTable) SetDataLoader(f func(interface{}, ...interface{}) *CacheItem) {
-	table.RLock()
-	defer table.RUnlock()
+	if f == nil {
+		table.SetDataLoaderContext(nil)
+		return
+	}
+	table.SetDataLoaderContext(func(_ context.Context, key interface{}, args ...interface{}) *CacheItem {
+		return f(key, args...)
+	})
+}
+
+// SetDataLoaderContext 同SetDataLoader, 加载函数收到ValueContext的ctx(Value为context.Background())
+func (table *CacheTable) SetDataLoaderContext(f func(ctx context.Context, key interface{}, args ...interface{}) *CacheItem) {
+	table.Lock()
+	defer table.Unlock()
 	table.loadData = f
 }
 
@@ -58,8 +156,13 @@ func (table *CacheTable) SetAddedItemCallback(f func(item *CacheItem)) {
 
 // 添加Callback
 func (table *CacheTable) AddAddedItemCallback(f func(item *CacheItem)) {
-	table.RLock()
-	defer table.RUnlock()
+	table.AddAddedItemCallbackContext(func(_ context.Context, item *CacheItem) { f(item) })
+}
+
+// AddAddedItemCallbackContext 同AddAddedItemCallback, 回调收到写入操作的ctx(如AddContext的ctx)
+func (table *CacheTable) AddAddedItemCallbackContext(f func(ctx context.Context, item *CacheItem)) {
+	table.Lock()
+	defer table.Unlock()
 	table.addItem = append(table.addItem, f)
 }
 
@@ -75,13 +178,16 @@ func (table *CacheTable) SetAboutToDeleteItemCallback(f func(*CacheItem)) {
 	if len(table.aboutToDeleteItem) > 0 {
 		table.RemoveAboutToDeleteItemCallback()
 	}
-	table.Lock()
-	defer table.Unlock()
-	table.aboutToDeleteItem = append(table.aboutToDeleteItem, f)
+	table.AddAboutToDeleteItemCallback(f)
 }
 
 // AddAboutToDeleteItemCallback 追加aboutToDeleteItem
 func (table *CacheTable) AddAboutToDeleteItemCallback(f func(*CacheItem)) {
+	table.AddAboutToDeleteItemCallbackContext(func(_ context.Context, item *CacheItem) { f(item) })
+}
+
+// AddAboutToDeleteItemCallbackContext 同AddAboutToDeleteItemCallback, 回调收到删除操作的ctx(过期和淘汰时为context.Background())
+func (table *CacheTable) AddAboutToDeleteItemCallbackContext(f func(ctx context.Context, item *CacheItem)) {
 	table.Lock()
 	defer table.Unlock()
 	table.aboutToDeleteItem = append(table.aboutToDeleteItem, f)
@@ -94,20 +200,107 @@ func (table *CacheTable) RemoveAboutToDeleteItemCallback() {
 	table.aboutToDeleteItem = nil
 }
 
-func (table *CacheTable) expirationCheck() {
+// AddExpiredBatchCallback 追加过期批量回调, 每次过期检查后以本次移除的全部元素调用一次(没有移除时不调用),
+// 便于指标和持久化批量处理; 与逐个元素的删除回调相互独立
+func (table *CacheTable) AddExpiredBatchCallback(f func(items []*CacheItem)) {
+	table.Lock()
+	defer table.Unlock()
+	table.expiredBatch = append(table.expiredBatch, f)
+}
+
+// RemoveExpiredBatchCallbacks 移除所有过期批量回调
+func (table *CacheTable) RemoveExpiredBatchCallbacks() {
+	table.Lock()
+	defer table.Unlock()
+	table.expiredBatch = nil
+}
+
+// ExpireSweepDelay 设置了MaxExpirePerSweep且还有剩余过期元素时, 下一次过期检查的延迟, 期间其他操作可以获得表锁
+const ExpireSweepDelay = time.Millisecond
+
+// RunExpirationNow 同步执行一次过期检查, 返回删除的元素数量, 并按剩余最短有效期重新安排定时检查
+func (table *CacheTable) RunExpirationNow() int {
+	return table.expirationCheck()
+}
+
+func (table *CacheTable) expirationCheck() int {
 	table.Lock()
+	if table.closed {
+		table.Unlock()
+		return 0
+	}
 	if table.cleanupTimer != nil {
 		table.cleanupTimer.Stop()
 	}
 	if table.cleanupInterval > 0 {
-		table.log("Expiration check triggered after", table.cleanupInterval, "for table", table.name)
+		table.logEvent(LevelDebug, EventExpirationCheck, nil, "Expiration check triggered", "interval", table.cleanupInterval)
 	} else {
-		table.log("Expiration check installed for table", table.name)
+		table.logEvent(LevelDebug, EventExpirationCheck, nil, "Expiration check installed")
 	}
-	now := time.Now()
+	now := table.clock.Now()
+	table.lastSweep = now
 	smallestDuration := 0 * time.Second
+	settings := table.Settings()
+	minInterval := settings.MinCleanupInterval
+
+	var expired []interface{}
+	if table.buckets != nil {
+		// 分桶模式只访问到期的桶
+		expired, smallestDuration = table.buckets.due(now)
+	} else {
+		expired, smallestDuration = table.scanExpired(now)
+	}
+	// 超出单次上限的过期元素留到下一次检查
+	if max := settings.MaxExpirePerSweep; max > 0 && len(expired) > max {
+		expired = expired[:max]
+		smallestDuration = ExpireSweepDelay
+		if minInterval > smallestDuration {
+			smallestDuration = minInterval
+		}
+	}
+	// deleteInternal会临时释放表锁, 不能在遍历map时调用
+	var removed []*CacheItem
+	for _, key := range expired {
+		if r, err := table.deleteInternal(context.Background(), key, OpExpire); err == nil {
+			removed = append(removed, r)
+		}
+	}
+
+	// 宽限期结束时也需要检查
+	if next := table.pruneGraveyard(now); next > 0 && (smallestDuration == 0 || next < smallestDuration) {
+		smallestDuration = next
+	}
+	// 不足minInterval即将过期的元素等到minInterval后的检查中一并移除
+	if smallestDuration > 0 && smallestDuration < minInterval {
+		smallestDuration = minInterval
+	}
+	if smallestDuration > 0 {
+		smallestDuration = settings.cleanupDelay(smallestDuration)
+	}
+	table.cleanupInterval = smallestDuration
+	if smallestDuration > 0 {
+		// 定时递归检测是否是失效
+		// AfterFunc的回调本身运行在独立协程中
+		table.cleanupTimer = table.clock.AfterFunc(smallestDuration, func() {
+			table.labeled(context.Background(), opLabelExpire, func(context.Context) { table.expirationCheck() })
+		})
+	}
+	expiredBatch := table.expiredBatch
+	table.Unlock()
+
+	if len(removed) > 0 && len(expiredBatch) > 0 {
+		table.labeled(context.Background(), opLabelCallback, func(context.Context) {
+			for _, callback := range expiredBatch {
+				callback(removed)
+			}
+		})
+	}
+	return len(removed)
+}
 
-	for key, item := range table.items {
+// scanExpired 遍历整表, 返回在now之前过期的键, 以及距下一个元素过期的时长(没有时为0); 调用方需持有表锁
+func (table *CacheTable) scanExpired(now time.Time) (expired []interface{}, next time.Duration) {
+	table.items.each(func(key interface{}, item *CacheItem) bool {
 		item.RLock()
 		// 存活时长(有效期)
 		lifeSpan := item.lifeSpan
@@ -116,45 +309,44 @@ func (table *CacheTable) expirationCheck() {
 		item.RUnlock()
 
 		if lifeSpan == 0 {
-			continue
+			return true
 		}
 		if now.Sub(assessedOn) >= lifeSpan {
 			// 已失效
-			table.deleteInternal(key)
+			expired = append(expired, key)
 		} else {
-			if smallestDuration == 0 || lifeSpan-now.Sub(assessedOn) < smallestDuration {
-				smallestDuration = lifeSpan - now.Sub(assessedOn)
+			if next == 0 || lifeSpan-now.Sub(assessedOn) < next {
+				next = lifeSpan - now.Sub(assessedOn)
 			}
 		}
-	}
-
-	table.cleanupInterval = smallestDuration
-	if smallestDuration > 0 {
-		// 定时递归检测是否是失效
-		table.cleanupTimer = time.AfterFunc(smallestDuration, func() {
-			// 通过协程重检测
-			go table.expirationCheck()
-		})
-	}
-	table.Unlock()
+		return true
+	})
+	return expired, next
 }
 
-func (table *CacheTable) addInternal(item *CacheItem) {
+func (table *CacheTable) addInternal(ctx context.Context, item *CacheItem) {
 	// Careful: do not run this method unless the table-mutex is locked!
 	// It will unlock it for the caller before running the callbacks and checks
-	table.log("Adding item with key", item.key, "and lifespan of", item.lifeSpan, "to table", table.name)
-	table.items[item.key] = item
+	table.logEvent(LevelDebug, EventAdd, item.key, "Adding item", "lifespan", item.lifeSpan)
+	table.setItem(ctx, item)
+	table.enforceCapacity(ctx, item.key)
 
 	// Cache values so we don't keep blocking the mutex.
 	expDur := table.cleanupInterval
 	addedItem := table.addItem
+	// enforceCapacity会临时释放表锁, 元素可能已被删除或替换, 此时不执行添加回调
+	if cur, _ := table.items.get(item.key); cur != item {
+		addedItem = nil
+	}
 	table.Unlock()
 
 	// Trigger callback after adding an item to cache.
 	if addedItem != nil {
-		for _, callback := range addedItem {
-			callback(item)
-		}
+		table.labeled(ctx, opLabelCallback, func(ctx context.Context) {
+			for _, callback := range addedItem {
+				callback(ctx, item)
+			}
+		})
 	}
 
 	// If we haven't set up any expiration check timer or found a more imminent item.
@@ -163,156 +355,460 @@ func (table *CacheTable) addInternal(item *CacheItem) {
 	}
 }
 
-// Add 添加键值对到table
+// Add 添加键值对到table, 表已关闭或被SetAdmissionHook拒绝时返回nil, 需要原因时使用TryAdd
 func (table *CacheTable) Add(key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem {
-	item := NewCacheItem(key, lifeSpan, data)
-
-	// Add item to cache.
-	table.Lock()
-	table.addInternal(item)
+	return table.AddContext(context.Background(), key, lifeSpan, data)
+}
 
+// AddContext 同Add, ctx中的信息(如WithActor设置的操作者)会随变更记录下来
+func (table *CacheTable) AddContext(ctx context.Context, key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem {
+	item, _ := table.TryAddContext(ctx, key, lifeSpan, data)
 	return item
 }
 
 // 移除元素
-func (table *CacheTable) deleteInternal(key interface{}) (*CacheItem, error) {
-	r, ok := table.items[key]
-	if !ok {
+// op为记录到变更流的删除原因
+func (table *CacheTable) deleteInternal(ctx context.Context, key interface{}, op MutationOp) (*CacheItem, error) {
+	r, ok := table.items.get(key)
+	if !ok || !atomic.CompareAndSwapInt32(&r.deleting, 0, 1) {
 		return nil, ErrKeyNotFound
 	}
 	aboutToDeleteItem := table.aboutToDeleteItem
 	table.Unlock()
 	if aboutToDeleteItem != nil {
-		for _, callback := range aboutToDeleteItem {
-			callback(r)
-		}
+		table.labeled(ctx, opLabelCallback, func(ctx context.Context) {
+			for _, callback := range aboutToDeleteItem {
+				callback(ctx, r)
+			}
+		})
 	}
 
 	r.RLock()
-	defer r.Unlock()
-	if r.aboutToExpire != nil {
-		for _, callback := range r.aboutToExpire {
-			callback(key)
-		}
+	aboutToExpire := r.aboutToExpire
+	createdOn, accessCount := r.createdOn, r.accessCount
+	r.RUnlock()
+	for _, callback := range aboutToExpire {
+		callback(key)
 	}
 
 	table.Lock()
-	table.log("Deleting item with key", key, "created on", r.createdOn, "and hit", r.accessCount, "times from table", table.name)
-	delete(table.items, key)
+	table.logEvent(LevelDebug, EventDelete, key, "Deleting item", "created_on", createdOn, "access_count", accessCount)
+	// 回调期间可能已被替换为新元素, 只删除原来的元素
+	if cur, ok := table.items.get(key); ok && cur == r {
+		table.removeItem(ctx, key, op)
+	}
 	return r, nil
 }
 
+// setItem 写入元素并维护统计和版本号, 调用方需持有表锁
+func (table *CacheTable) setItem(ctx context.Context, item *CacheItem) {
+	table.ensureOwned()
+	if table.trace != nil {
+		table.trace.record(table.clock.Now(), CallAdd, item.key)
+	}
+	item.size = table.sizeOf(item)
+	table.stampChecksum(item)
+	item.version = 1
+	if old, ok := table.items.get(item.key); ok {
+		table.totalSize -= old.size
+		item.version = old.version + 1
+		old.markRemoved()
+		if table.buckets != nil {
+			table.buckets.remove(old)
+		}
+		if table.evictor != nil {
+			table.evictor.OnAccess(item.key)
+		}
+	} else if table.evictor != nil {
+		table.evictor.OnAdd(item.key)
+	}
+	if table.buckets != nil {
+		table.buckets.add(item)
+	}
+	table.items.set(item.key, item)
+	table.totalSize += item.size
+	table.dropStale(item.key)
+	table.dropExpired(item.key)
+	if item.version == 1 {
+		table.emit(ctx, OpAdd, item)
+	} else {
+		table.emit(ctx, OpUpdate, item)
+	}
+}
+
+// removeItem 删除元素并维护统计, op为删除原因; 调用方需持有表锁
+func (table *CacheTable) removeItem(ctx context.Context, key interface{}, op MutationOp) (*CacheItem, bool) {
+	item, ok := table.items.get(key)
+	if !ok {
+		return nil, false
+	}
+	table.ensureOwned()
+	if table.trace != nil && op == OpDelete {
+		table.trace.record(table.clock.Now(), CallDelete, key)
+	}
+	table.checkRemoved(item)
+	table.totalSize -= item.size
+	table.items.delete(key)
+	item.markRemoved()
+	if table.buckets != nil {
+		table.buckets.remove(item)
+	}
+	if table.evictor != nil {
+		table.evictor.OnRemove(key, op)
+	}
+	if op == OpExpire {
+		now := table.clock.Now()
+		table.keepStale(item, now)
+		table.keepExpired(item, now)
+	}
+	table.emit(ctx, op, item)
+	return item, true
+}
+
 // 公共移除元素方法
 func (table *CacheTable) Delete(key interface{}) (*CacheItem, error) {
+	return table.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext 同Delete, ctx中的信息(如WithActor设置的操作者)会随变更记录下来
+func (table *CacheTable) DeleteContext(ctx context.Context, key interface{}) (*CacheItem, error) {
+	if h := table.intercept(); h != nil {
+		return h(ctx, &Call{Kind: CallDelete, Table: table.name, Key: key})
+	}
+	return table.delete(ctx, key)
+}
+
+// Remove 删除key并返回删除前的值, 与map的用法相同: 键不存在或表已关闭时found为false.
+// 并发调用时只有一个调用方取得值, 可用于交接(pop)
+func (table *CacheTable) Remove(key interface{}) (value interface{}, found bool) {
+	item, err := table.Delete(key)
+	if err != nil {
+		return nil, false
+	}
+	return item.Value(), true
+}
+
+// delete 实现DeleteContext, 不经过中间件
+func (table *CacheTable) delete(ctx context.Context, key interface{}) (*CacheItem, error) {
+	key = canonicalKey(key)
 	table.Lock()
 	defer table.Unlock()
-	delete(table.items, key)
-	return table.deleteInternal(key)
+	if table.rejectsWrites() {
+		return nil, ErrTableClosed
+	}
+	item, err := table.deleteInternal(ctx, key, OpDelete)
+	if err != nil {
+		return nil, &KeyError{Table: table.name, Key: key, Op: "delete", Err: err}
+	}
+	return item, nil
 }
 
 // 是否存在key元素
 func (table *CacheTable) Exists(key interface{}) bool {
 	table.RLock()
 	defer table.RUnlock()
-	_, ok := table.items[key]
+	_, ok := table.items.get(canonicalKey(key))
 	return ok
 }
 
+// Peek 返回key对应的元素, 不延长有效期、不计入命中统计、不调用加载函数
+func (table *CacheTable) Peek(key interface{}) (*CacheItem, error) {
+	table.RLock()
+	item, ok := table.items.get(canonicalKey(key))
+	table.RUnlock()
+	if !ok {
+		return nil, table.keyError("peek", key, ErrKeyNotFound)
+	}
+	return table.readCopy(item)
+}
+
+// NotFoundAdd 键不存在时添加并返回true. 已过期但还未被过期检查移除的元素视为不存在, 在同一次加锁中被替换,
+// 其删除回调在新元素加入后调用. 表已关闭或写入被拒绝时返回false, 需要原因时使用TryNotFoundAdd
 func (table *CacheTable) NotFoundAdd(key interface{}, lifeSpan time.Duration, data interface{}) bool {
-	table.Lock()
+	return table.NotFoundAddContext(context.Background(), key, lifeSpan, data)
+}
 
-	if _, ok := table.items[key]; ok {
+// NotFoundAddContext 同NotFoundAdd, ctx传给回调、插件并随变更记录下来
+func (table *CacheTable) NotFoundAddContext(ctx context.Context, key interface{}, lifeSpan time.Duration, data interface{}) bool {
+	added, _ := table.TryNotFoundAddContext(ctx, key, lifeSpan, data)
+	return added
+}
+
+// TryNotFoundAdd 同NotFoundAdd, 键已存在时返回false和nil, 表已关闭或写入被拒绝时返回原因
+func (table *CacheTable) TryNotFoundAdd(key interface{}, lifeSpan time.Duration, data interface{}) (bool, error) {
+	return table.TryNotFoundAddContext(context.Background(), key, lifeSpan, data)
+}
+
+// TryNotFoundAddContext 同TryNotFoundAdd, ctx传给回调、插件并随变更记录下来
+func (table *CacheTable) TryNotFoundAddContext(ctx context.Context, key interface{}, lifeSpan time.Duration, data interface{}) (bool, error) {
+	_, added, err := table.addIfAbsent(ctx, key, lifeSpan, data)
+	return added, err
+}
+
+// AddIfAbsent 同NotFoundAdd, 添加时返回新元素和true, 键已存在时返回已有的元素和false,
+// 竞争失败的调用方可以直接使用胜者的值. 表已关闭、写入被拒绝或SetCopyOnRead复制失败时返回nil和false
+func (table *CacheTable) AddIfAbsent(key interface{}, lifeSpan time.Duration, data interface{}) (*CacheItem, bool) {
+	item, added, err := table.addIfAbsent(context.Background(), key, lifeSpan, data)
+	if err != nil || added {
+		return item, added
+	}
+	if item, err = table.readCopy(item); err != nil {
+		return nil, false
+	}
+	return item, false
+}
+
+// addIfAbsent 实现NotFoundAdd系列方法, 返回新元素或已有的元素
+func (table *CacheTable) addIfAbsent(ctx context.Context, key interface{}, lifeSpan time.Duration, data interface{}) (*CacheItem, bool, error) {
+	table.Lock()
+	if table.rejectsWrites() {
+		table.Unlock()
+		return nil, false, ErrTableClosed
+	}
+	key = canonicalKey(key)
+	now := table.clock.Now()
+	old, ok := table.items.get(key)
+	if ok && !old.expired(now) {
 		table.Unlock()
-		return false
+		return old, false, nil
+	}
+	if err := table.admit(key, data, lifeSpan); err != nil {
+		table.Unlock()
+		return nil, false, err
+	}
+	if ok {
+		table.removeItem(ctx, key, OpExpire)
+	}
+	aboutToDeleteItem := table.aboutToDeleteItem
+	item := newCacheItem(key, lifeSpan, data, now)
+	table.addInternal(ctx, item)
+
+	// 正在被过期检查删除的元素由过期检查调用回调
+	if old != nil && atomic.CompareAndSwapInt32(&old.deleting, 0, 1) {
+		for _, callback := range aboutToDeleteItem {
+			callback(ctx, old)
+		}
+		old.RLock()
+		aboutToExpire := old.aboutToExpire
+		old.RUnlock()
+		for _, callback := range aboutToExpire {
+			callback(key)
+		}
 	}
-	item := NewCacheItem(key, lifeSpan, data)
-	table.addInternal(item)
-	return true
+	return item, true, nil
 }
 
 func (table *CacheTable) Value(key interface{}, args ...interface{}) (*CacheItem, error) {
-	table.RLock()
-	item, ok := table.items[key]
-	loadData := table.loadData
+	return table.ValueContext(context.Background(), key, args...)
+}
 
+// ValueContext 同Value, ctx传给SetDataLoaderContext设置的加载函数和其他节点; ctx结束时不再等待租约
+func (table *CacheTable) ValueContext(ctx context.Context, key interface{}, args ...interface{}) (*CacheItem, error) {
+	if h := table.intercept(); h != nil {
+		return h(ctx, &Call{Kind: CallGet, Table: table.name, Key: key, Args: args})
+	}
+	return table.getValue(ctx, key, args)
+}
+
+// getValue 实现ValueContext, 不经过中间件
+func (table *CacheTable) getValue(ctx context.Context, key interface{}, args []interface{}) (*CacheItem, error) {
+	item, err := table.value(ctx, key, args, true)
+	if errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrKeyNotFoundOrLoadable) {
+		return nil, table.keyError("get", key, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return table.readCopy(item)
+}
+
+// value 实现Value, usePeers为false时未命中只使用本地加载函数(处理其他节点的请求时, 避免节点视图不一致时互相转发)
+func (table *CacheTable) value(ctx context.Context, key interface{}, args []interface{}, usePeers bool) (*CacheItem, error) {
+	table.RLock()
+	if table.closed {
+		table.RUnlock()
+		return nil, ErrTableClosed
+	}
+	item, ok := table.items.get(canonicalKey(key))
+	st := table.readState()
 	table.RUnlock()
+
+	if st.trace != nil {
+		st.trace.record(st.now, CallGet, key)
+	}
+	table.recordAccess(st.now, ok)
 	if ok {
-		item.KeepAlive()
+		table.hit(item, st, args)
 		return item, nil
 	}
-	if loadData != nil {
-		item = loadData(key, args)
-		if item != nil {
-			table.Add(key, item.lifeSpan, item)
-			return item, nil
-		}
+	if st.missing != nil && st.missing.contains(canonicalKey(key), st.now) {
 		return nil, ErrKeyNotFoundOrLoadable
 	}
+	if st.peers != nil {
+		return table.loadFromPeers(ctx, key, st.peers, usePeers, st.loadData, args)
+	}
+	if st.loadData != nil {
+		return table.loadWithLease(ctx, key, st.loadData, args)
+	}
 	return nil, ErrKeyNotFound
 }
 
+// valueState value在表锁内取得的状态, 之后的处理不持有表锁
+type valueState struct {
+	loadData  loaderFunc
+	peers     PeerPicker
+	evictor   Evictor
+	admission AdmissionPolicy
+	missing   *missingFilter
+	trace     *traceRecorder
+	now       time.Time
+}
+
+// readState 调用方需持有表锁
+func (table *CacheTable) readState() valueState {
+	return valueState{
+		loadData:  table.loadData,
+		peers:     table.peers,
+		evictor:   table.evictor,
+		admission: table.admission,
+		missing:   table.missing,
+		trace:     table.trace,
+		now:       table.clock.Now(),
+	}
+}
+
+// hit 命中后续期并通知淘汰和准入策略, 不持有表锁
+func (table *CacheTable) hit(item *CacheItem, st valueState, args []interface{}) {
+	item.keepAlive(st.now)
+	if st.evictor != nil {
+		st.evictor.OnAccess(item.key)
+	}
+	if st.admission != nil {
+		st.admission.Record(item.key)
+	}
+	table.refreshAhead(item, st.now, st.loadData, args)
+}
+
+// load 调用加载函数并把结果加入表中
+func (table *CacheTable) load(ctx context.Context, key interface{}, loadData loaderFunc, args []interface{}) (*CacheItem, error) {
+	if !table.beginLoad() {
+		return nil, ErrTableClosed
+	}
+	defer table.loads.Done()
+	start := time.Now()
+	var item *CacheItem
+	table.labeled(ctx, opLabelLoad, func(ctx context.Context) { item = loadData(ctx, key, args...) })
+	table.loaderLatency.observe(time.Since(start))
+	if item != nil {
+		return table.addLoaded(ctx, key, item.lifeSpan, item.value)
+	}
+	atomic.AddUint64(&table.loaderFailures, 1)
+	table.RLock()
+	table.logEvent(LevelError, EventLoadFailed, key, "Loading item failed")
+	now := table.clock.Now()
+	stale, ok := table.staleCopy(canonicalKey(key), now)
+	missing := table.missing
+	table.RUnlock()
+	if ok {
+		return stale, nil
+	}
+	if missing != nil {
+		missing.add(canonicalKey(key), now)
+	}
+	return nil, ErrKeyNotFoundOrLoadable
+}
+
 func (table *CacheTable) Flush() {
+	table.FlushContext(context.Background())
+}
+
+// FlushContext 同Flush, ctx中的信息会随变更记录下来
+func (table *CacheTable) FlushContext(ctx context.Context) {
 	table.Lock()
 	defer table.Unlock()
-	table.log("Flushing table", table.name)
+	table.logEvent(LevelInfo, EventFlush, nil, "Flushing table")
 
-	table.items = make(map[interface{}]*CacheItem)
+	table.markAllRemoved()
+	table.removeAllFromEvictor()
+	table.items = table.items.empty(0)
+	if table.buckets != nil {
+		table.buckets.reset()
+	}
+	table.viewShared = false
+	table.totalSize = 0
+	if table.leases != nil {
+		table.leases.stale = nil
+	}
+	table.graveyard = nil
+	table.emit(ctx, OpFlush, nil)
 	table.cleanupInterval = 0
 	if table.cleanupTimer != nil {
 		table.cleanupTimer.Stop()
 	}
 }
 
-type CacheItemPair struct {
-	Key         interface{}
-	AccessCount int64
-}
-
-type CacheItemPairList []CacheItemPair
-
-func (p CacheItemPairList) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
-func (p CacheItemPairList) Len() int           { return len(p) }
-func (p CacheItemPairList) Less(i, j int) bool { return p[i].AccessCount > p[j].AccessCount }
-
-func (table *CacheTable) MostAccessed(count int64) []*CacheItem {
-	table.RLock()
+// Close 停止过期检查定时器并拒绝之后的读写, flush为true时同时清空所有元素.
+// 重复关闭返回ErrTableClosed
+func (table *CacheTable) Close(flush bool) error {
+	table.Lock()
 	defer table.Unlock()
+	if table.closed {
+		return ErrTableClosed
+	}
+	table.closed = true
+	table.logEvent(LevelInfo, EventClose, nil, "Closing table", "flush", flush)
 
-	p := make(CacheItemPairList, len(table.items))
-	i := 0
-	for k, v := range table.items {
-		p[i] = CacheItemPair{k, v.accessCount}
-		i++
-	}
-	// 排序
-	sort.Sort(p)
-	// 用于返回
-	var items []*CacheItem
-	// 数据转换
-	c := int64(0)
-	for _, val := range p {
-		if c >= count {
-			break
-		}
-		item, ok := table.items[val.Key]
-		if ok {
-			items = append(items, item)
+	if table.cleanupTimer != nil {
+		table.cleanupTimer.Stop()
+		table.cleanupTimer = nil
+	}
+	table.cleanupInterval = 0
+	if table.immutable != nil && table.immutable.timer != nil {
+		table.immutable.timer.Stop()
+	}
+	if table.bgEvict != nil && table.bgEvict.timer != nil {
+		table.bgEvict.timer.Stop()
+	}
+	if flush {
+		table.markAllRemoved()
+		table.removeAllFromEvictor()
+		table.items = table.items.empty(0)
+		table.graveyard = nil
+		if table.buckets != nil {
+			table.buckets.reset()
 		}
-		c++
+		table.viewShared = false
+		table.totalSize = 0
+		table.emit(context.Background(), OpFlush, nil)
+	}
+	for sub := range table.cdcSubs {
+		table.unsubscribe(sub)
 	}
-	return items
+	return nil
 }
 
-// 设置日志对象
-func (table *CacheTable) SetLogger(logger *log.Logger) {
+// markAllRemoved 关闭所有元素的Done, 在整表替换前调用; 调用方需持有表锁
+func (table *CacheTable) markAllRemoved() {
+	table.items.each(func(_ interface{}, item *CacheItem) bool {
+		item.markRemoved()
+		return true
+	})
+}
+
+// Closed 表是否已关闭
+func (table *CacheTable) Closed() bool {
 	table.RLock()
 	defer table.RUnlock()
-	table.logger = logger
+	return table.closed
 }
 
-func (table *CacheTable) log(v ...interface{}) {
-	if table.logger == nil {
-		return
-	}
-	table.logger.Println(v...)
+type CacheItemPair struct {
+	Key         interface{}
+	AccessCount int64
 }
+
+type CacheItemPairList []CacheItemPair
+
+func (p CacheItemPairList) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p CacheItemPairList) Len() int           { return len(p) }
+func (p CacheItemPairList) Less(i, j int) bool { return p[i].AccessCount > p[j].AccessCount }
@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// MutationOp 变更类型
+type MutationOp int
+
+const (
+	OpAdd MutationOp = iota
+	OpUpdate
+	OpDelete
+	OpExpire
+	OpEvict
+	// OpFlush 整表被清空, Key为nil
+	OpFlush
+)
+
+func (op MutationOp) String() string {
+	switch op {
+	case OpAdd:
+		return "add"
+	case OpUpdate:
+		return "update"
+	case OpDelete:
+		return "delete"
+	case OpExpire:
+		return "expire"
+	case OpEvict:
+		return "evict"
+	case OpFlush:
+		return "flush"
+	}
+	return "unknown"
+}
+
+// Mutation 表的一次变更记录. Seq在表内严格递增, 订阅者可据此发现遗漏
+type Mutation struct {
+	Seq      uint64
+	Op       MutationOp
+	Key      interface{}
+	Value    interface{}
+	LifeSpan time.Duration
+	Version  uint64
+	Time     time.Time
+
+	// remote 变更来自总线上的其他进程, 不再转发
+	remote bool
+}
+
+// DefaultCDCBuffer CDC订阅的默认缓冲区大小
+const DefaultCDCBuffer = 1024
+
+type cdcSub struct {
+	ch     chan Mutation
+	closed bool
+}
+
+// CDC 订阅表的变更流, 按发生顺序输出Add/Update/Delete/Expire/Evict/Flush记录, ctx结束时关闭通道.
+//
+// 背压: 变更在持有表锁时以非阻塞方式写入缓冲区(大小为DefaultCDCBuffer), 写入方从不等待订阅者.
+// 缓冲区满时该订阅被终止并关闭通道, 订阅者在ctx结束前看到通道关闭即说明已落后, 需要重新同步后再订阅
+func (table *CacheTable) CDC(ctx context.Context) <-chan Mutation {
+	return table.CDCWithBuffer(ctx, DefaultCDCBuffer)
+}
+
+// CDCWithBuffer 同CDC, 指定缓冲区大小
+func (table *CacheTable) CDCWithBuffer(ctx context.Context, buffer int) <-chan Mutation {
+	if buffer < 1 {
+		buffer = 1
+	}
+	sub := &cdcSub{ch: make(chan Mutation, buffer)}
+	table.Lock()
+	if table.cdcSubs == nil {
+		table.cdcSubs = make(map[*cdcSub]struct{})
+	}
+	table.cdcSubs[sub] = struct{}{}
+	table.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		table.Lock()
+		table.unsubscribe(sub)
+		table.Unlock()
+	}()
+	return sub.ch
+}
+
+// unsubscribe 移除订阅并关闭通道, 调用方需持有表锁
+func (table *CacheTable) unsubscribe(sub *cdcSub) {
+	delete(table.cdcSubs, sub)
+	if !sub.closed {
+		sub.closed = true
+		close(sub.ch)
+	}
+}
+
+// emit 记录一次变更, 写入审计日志并发送给CDC订阅者; 调用方需持有表锁
+func (table *CacheTable) emit(ctx context.Context, op MutationOp, item *CacheItem) {
+	table.mutationSeq++
+	table.notifyPlugins(ctx, op, item)
+	if len(table.cdcSubs) == 0 && table.audit == nil {
+		return
+	}
+	m := Mutation{
+		Seq:    table.mutationSeq,
+		Op:     op,
+		Time:   table.clock.Now(),
+		remote: isRemote(ctx),
+	}
+	if item != nil {
+		m.Key, m.Value, m.LifeSpan, m.Version = item.key, item.value, item.lifeSpan, item.version
+	}
+	if table.audit != nil {
+		table.recordAudit(ctx, &m)
+	}
+	for sub := range table.cdcSubs {
+		select {
+		case sub.ch <- m:
+		default:
+			table.logEvent(LevelWarn, EventCDCOverflow, nil, "CDC subscriber fell behind, closing subscription", "seq", m.Seq)
+			table.unsubscribe(sub)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package cache
+
+import "time"
+
+// Clock 表使用的时间源, 测试中可替换为假时钟(见testutil.FakeClock)
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer Clock.AfterFunc返回的定时器
+type Timer interface {
+	Stop() bool
+}
+
+// realClock 基于time包的默认时钟
+type realClock struct{}
+
+func (realClock) Now() time.Time                  { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration { return time.Since(t) }
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// SystemClock 返回基于系统时间的Clock
+func SystemClock() Clock {
+	return realClock{}
+}
+
+// SetClock 设置表的时间源, 应在加入元素之前调用; 传nil恢复为系统时钟
+func (table *CacheTable) SetClock(c Clock) {
+	if c == nil {
+		c = realClock{}
+	}
+	table.Lock()
+	defer table.Unlock()
+	table.clock = c
+}
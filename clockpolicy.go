@@ -0,0 +1,81 @@
+package cache
+
+import "sync"
+
+// clockPolicy EvictClock的状态. 元素放在环形数组中, 命中时只设置引用位;
+// 淘汰时指针沿环移动, 清除遇到的引用位, 选中第一个引用位未设置的元素
+type clockPolicy struct {
+	mu    sync.Mutex
+	slots []clockSlot
+	index map[interface{}]int
+	free  []int
+	hand  int
+}
+
+type clockSlot struct {
+	key        interface{}
+	used       bool
+	referenced bool
+}
+
+func newClockPolicy() *clockPolicy {
+	return &clockPolicy{index: make(map[interface{}]int)}
+}
+
+func (c *clockPolicy) OnAdd(key interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slot := clockSlot{key: key, used: true}
+	if n := len(c.free); n > 0 {
+		i := c.free[n-1]
+		c.free = c.free[:n-1]
+		c.slots[i] = slot
+		c.index[key] = i
+		return
+	}
+	c.index[key] = len(c.slots)
+	c.slots = append(c.slots, slot)
+}
+
+func (c *clockPolicy) OnAccess(key interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if i, ok := c.index[key]; ok {
+		c.slots[i].referenced = true
+	}
+}
+
+func (c *clockPolicy) OnRemove(key interface{}, op MutationOp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, ok := c.index[key]
+	if !ok {
+		return
+	}
+	delete(c.index, key)
+	c.slots[i] = clockSlot{}
+	c.free = append(c.free, i)
+}
+
+func (c *clockPolicy) Victim(keep interface{}) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.slots)
+	// 转两圈后所有引用位都已清除
+	for step := 0; step < 2*n; step++ {
+		if c.hand >= n {
+			c.hand = 0
+		}
+		s := &c.slots[c.hand]
+		c.hand++
+		if !s.used || s.key == keep {
+			continue
+		}
+		if s.referenced {
+			s.referenced = false
+			continue
+		}
+		return s.key, true
+	}
+	return nil, false
+}
@@ -0,0 +1,35 @@
+package cache
+
+// Clone 复制出一个名为newName的独立表(不加入注册表), 包含相同的元素、剩余有效期和访问统计,
+// 以及时钟、Sizer、设置、淘汰策略和键的保存方式(WithStringKeys); 不复制回调、加载函数和自定义的Evictor. deepCopyValues为true时用DeepCopy复制值.
+// 只在复制元素列表时持有源表的读锁
+func (table *CacheTable) Clone(newName string, deepCopyValues bool) *CacheTable {
+	table.RLock()
+	clone := NewTable(newName,
+		WithClock(table.clock),
+		WithEvictionPolicy(table.evictionPolicy))
+	clone.items = clone.items.withMode(table.items.stringKeys)
+	clone.sizer = table.sizer
+	clone.settings.Store(table.settings.Load())
+	items := table.items.values()
+	table.RUnlock()
+
+	hasExpiring := false
+	for _, item := range items {
+		c := item.copyMeta()
+		c.life = &itemLife{}
+		if deepCopyValues {
+			c.value = DeepCopy(c.value)
+		}
+		clone.items.set(c.key, c)
+		if clone.evictor != nil {
+			clone.evictor.OnAdd(c.key)
+		}
+		clone.totalSize += c.size
+		hasExpiring = hasExpiring || c.lifeSpan > 0
+	}
+	if hasExpiring {
+		clone.expirationCheck()
+	}
+	return clone
+}
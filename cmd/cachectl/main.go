@@ -0,0 +1,253 @@
+// cachectl 通过管理接口(cache.AdminHandler)和REST接口(cacheserver.Service.Handler)操作远程缓存:
+//
+//	cachectl [-admin URL] [-server URL] [-token TOKEN] <命令> [参数]
+//
+//	tables                          列出表及统计
+//	stats    <table>                表的统计
+//	keys     <table> [query]        按键的字符串形式搜索元素
+//	info     <table> <key>          元素的元数据
+//	get      <table> <key>          读取值, 原样写到标准输出
+//	set      <table> <key> <value>  写入值, 有效期由-ttl指定; value为"-"时从标准输入读取
+//	del      <table> <key>          删除元素
+//	flush    <table>                清空表
+//	snapshot <table> [file]         下载快照到file(默认<table>.snapshot), file为"-"时写到标准输出;
+//	                                带-server-side时让服务端写入表的快照文件
+//
+// 地址和token也可以用环境变量CACHE_ADMIN, CACHE_SERVER和CACHE_TOKEN设置
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"cache/cacheserver"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// ctl 一次命令的连接参数
+type ctl struct {
+	admin, server, token string
+	ttl                  time.Duration
+	serverSide           bool
+	stdin                io.Reader
+	stdout               io.Writer
+}
+
+// run 执行命令并返回退出码
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("cachectl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	c := &ctl{stdin: stdin, stdout: stdout}
+	fs.StringVar(&c.admin, "admin", envOr("CACHE_ADMIN", "http://localhost:6060"), "管理接口地址, 默认取环境变量CACHE_ADMIN")
+	fs.StringVar(&c.server, "server", envOr("CACHE_SERVER", "http://localhost:8080"), "REST接口地址, 默认取环境变量CACHE_SERVER")
+	fs.StringVar(&c.token, "token", os.Getenv("CACHE_TOKEN"), "Bearer token, 默认取环境变量CACHE_TOKEN")
+	fs.DurationVar(&c.ttl, "ttl", 0, "set的有效期, 0表示不过期")
+	fs.BoolVar(&c.serverSide, "server-side", false, "snapshot写入服务端的快照文件")
+	timeout := fs.Duration("timeout", 10*time.Second, "请求超时")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(stderr, "usage: cachectl [flags] <tables|stats|keys|info|get|set|del|flush|snapshot> [args]")
+		return 2
+	}
+	c.admin = strings.TrimRight(c.admin, "/")
+	c.server = strings.TrimRight(c.server, "/")
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	if err := c.exec(ctx, fs.Arg(0), fs.Args()[1:]); err != nil {
+		fmt.Fprintln(stderr, "cachectl:", err)
+		if errors.Is(err, errUsage) {
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+var errUsage = errors.New("wrong number of arguments")
+
+func (c *ctl) exec(ctx context.Context, cmd string, args []string) error {
+	need := func(min, max int) error {
+		if len(args) < min || len(args) > max {
+			return fmt.Errorf("%s: %w", cmd, errUsage)
+		}
+		return nil
+	}
+	switch cmd {
+	case "tables":
+		if err := need(0, 0); err != nil {
+			return err
+		}
+		return c.adminJSON(ctx, http.MethodGet, "/tables")
+	case "stats":
+		if err := need(1, 1); err != nil {
+			return err
+		}
+		return c.adminJSON(ctx, http.MethodGet, tablePath(args[0]))
+	case "keys":
+		if err := need(1, 2); err != nil {
+			return err
+		}
+		q := ""
+		if len(args) == 2 {
+			q = args[1]
+		}
+		return c.adminJSON(ctx, http.MethodGet, tablePath(args[0])+"/keys?q="+url.QueryEscape(q))
+	case "info":
+		if err := need(2, 2); err != nil {
+			return err
+		}
+		return c.adminJSON(ctx, http.MethodGet, tablePath(args[0])+"/items/"+url.PathEscape(args[1]))
+	case "get":
+		if err := need(2, 2); err != nil {
+			return err
+		}
+		item, err := c.client(args[0]).ValueContext(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		_, err = c.stdout.Write(item.Value().([]byte))
+		return err
+	case "set":
+		if err := need(3, 3); err != nil {
+			return err
+		}
+		value := []byte(args[2])
+		if args[2] == "-" {
+			var err error
+			if value, err = io.ReadAll(c.stdin); err != nil {
+				return err
+			}
+		}
+		_, err := c.client(args[0]).AddContext(ctx, args[1], c.ttl, value)
+		return err
+	case "del":
+		if err := need(2, 2); err != nil {
+			return err
+		}
+		return c.client(args[0]).DeleteContext(ctx, args[1])
+	case "flush":
+		if err := need(1, 1); err != nil {
+			return err
+		}
+		return c.adminJSON(ctx, http.MethodPost, tablePath(args[0])+"/flush")
+	case "snapshot":
+		if err := need(1, 2); err != nil {
+			return err
+		}
+		if c.serverSide {
+			return c.adminJSON(ctx, http.MethodPost, tablePath(args[0])+"/snapshot")
+		}
+		file := args[0] + ".snapshot"
+		if len(args) == 2 {
+			file = args[1]
+		}
+		return c.download(ctx, tablePath(args[0])+"/snapshot", file)
+	}
+	return fmt.Errorf("unknown command %q", cmd)
+}
+
+func (c *ctl) client(table string) *cacheserver.Client {
+	client := cacheserver.NewClient(c.server, table)
+	client.Token = c.token
+	return client
+}
+
+func tablePath(table string) string {
+	return "/tables/" + url.PathEscape(table)
+}
+
+// adminRequest 调用管理接口, 返回状态码不是2xx时把响应中的error作为错误
+func (c *ctl) adminRequest(ctx context.Context, method, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.admin+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&body)
+		if body.Error != "" {
+			return nil, fmt.Errorf("%s: %s", resp.Status, body.Error)
+		}
+		return nil, errors.New(resp.Status)
+	}
+	return resp, nil
+}
+
+// adminJSON 调用管理接口并把JSON响应缩进后输出, 没有响应体时不输出
+func (c *ctl) adminJSON(ctx context.Context, method, path string) error {
+	resp, err := c.adminRequest(ctx, method, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || len(body) == 0 {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		_, err = c.stdout.Write(body)
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(c.stdout)
+	return err
+}
+
+// download 把管理接口的响应写入file, file为"-"时写到标准输出
+func (c *ctl) download(ctx context.Context, path, file string) error {
+	resp, err := c.adminRequest(ctx, http.MethodGet, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if file == "-" {
+		_, err = io.Copy(c.stdout, resp.Body)
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cache"
+	"cache/cacheserver"
+)
+
+func TestRun(t *testing.T) {
+	defer cache.Remove("cachectl")
+	table := cache.Cache("cachectl")
+	admin := httptest.NewServer(cache.AdminHandler())
+	defer admin.Close()
+	server := httptest.NewServer((&cacheserver.Service{}).Handler())
+	defer server.Close()
+
+	ctl := func(stdin string, args ...string) (string, int) {
+		var stdout, stderr bytes.Buffer
+		args = append([]string{"-admin", admin.URL, "-server", server.URL}, args...)
+		code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+		return stdout.String() + stderr.String(), code
+	}
+
+	if out, code := ctl("", "-ttl", "1m", "set", "cachectl", "a", "1"); code != 0 {
+		t.Fatal("Error setting value", out)
+	}
+	if _, code := ctl("piped", "set", "cachectl", "b", "-"); code != 0 {
+		t.Error("Error setting value from stdin")
+	}
+	if out, code := ctl("", "get", "cachectl", "b"); code != 0 || out != "piped" {
+		t.Error("Error getting value", out)
+	}
+	if item, err := table.Value("a"); err != nil || item.LifeSpan() != time.Minute {
+		t.Error("Error applying ttl", err)
+	}
+	if out, _ := ctl("", "tables"); !strings.Contains(out, `"name": "cachectl"`) {
+		t.Error("Error listing tables", out)
+	}
+	if out, _ := ctl("", "keys", "cachectl", "a"); !strings.Contains(out, `"key": "a"`) || strings.Contains(out, `"key": "b"`) {
+		t.Error("Error searching keys", out)
+	}
+	if out, _ := ctl("", "stats", "cachectl"); !strings.Contains(out, `"Items": 2`) {
+		t.Error("Error fetching stats", out)
+	}
+
+	file := filepath.Join(t.TempDir(), "cachectl.snapshot")
+	if out, code := ctl("", "snapshot", "cachectl", file); code != 0 {
+		t.Error("Error downloading snapshot", out)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if n, err := cache.NewTable("restored").LoadSnapshot(f); err != nil || n != 2 {
+		t.Error("Error loading downloaded snapshot", n, err)
+	}
+
+	if _, code := ctl("", "del", "cachectl", "a"); code != 0 || table.Exists("a") {
+		t.Error("Error deleting key")
+	}
+	if out, code := ctl("", "del", "cachectl", "a"); code != 1 || !strings.Contains(out, cache.ErrKeyNotFound.Error()) {
+		t.Error("Error reporting missing key", out)
+	}
+	if _, code := ctl("", "flush", "cachectl"); code != 0 || table.Count() != 0 {
+		t.Error("Error flushing table")
+	}
+	if out, code := ctl("", "stats", "missing"); code != 1 || !strings.Contains(out, cache.ErrTableNotFound.Error()) {
+		t.Error("Error reporting missing table", out)
+	}
+	if _, code := ctl("", "get", "cachectl"); code != 2 {
+		t.Error("Error rejecting wrong arguments")
+	}
+}
@@ -0,0 +1,274 @@
+// cacheload 对本地表或远程服务(cacheserver.Service.Handler)施加负载, 报告吞吐量、命中率和各操作的耗时分位数,
+// 用于上线前验证容量:
+//
+//	cacheload -server http://cache:8080 -table users -duration 30s -concurrency 32 \
+//	    -keys 1000000 -dist zipf -value-size 256 -writes 0.1 -deletes 0.01
+//
+// 不指定-server时在进程内创建一个表(容量由-max-items限制), 用于比较配置本身的开销
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cache"
+	"cache/cacheserver"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// config 负载参数
+type config struct {
+	server, table string
+	duration      time.Duration
+	requests      int
+	concurrency   int
+	keys          int
+	dist          string
+	zipfS         float64
+	valueSize     int
+	valueSizeMax  int
+	writes        float64
+	deletes       float64
+	ttl           time.Duration
+	fill          bool
+	maxItems      int
+	seed          int64
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("cacheload", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var c config
+	fs.StringVar(&c.server, "server", "", "远程服务地址, 为空时使用进程内的表")
+	fs.StringVar(&c.table, "table", "cacheload", "表名")
+	fs.DurationVar(&c.duration, "duration", 10*time.Second, "运行时长, -requests大于0时忽略")
+	fs.IntVar(&c.requests, "requests", 0, "总操作数, 大于0时达到后停止")
+	fs.IntVar(&c.concurrency, "concurrency", 8, "并发数")
+	fs.IntVar(&c.keys, "keys", 100000, "键空间大小")
+	fs.StringVar(&c.dist, "dist", "zipf", "键分布: zipf或uniform")
+	fs.Float64Var(&c.zipfS, "zipf-s", 1.1, "zipf分布的参数s, 须大于1, 越大越集中")
+	fs.IntVar(&c.valueSize, "value-size", 128, "值的字节数")
+	fs.IntVar(&c.valueSizeMax, "value-size-max", 0, "大于value-size时值的字节数在两者之间均匀分布")
+	fs.Float64Var(&c.writes, "writes", 0.1, "写入占操作的比例")
+	fs.Float64Var(&c.deletes, "deletes", 0, "删除占操作的比例")
+	fs.DurationVar(&c.ttl, "ttl", 0, "写入的有效期, 0表示不过期")
+	fs.BoolVar(&c.fill, "fill", true, "读取未命中时写入该键, 模拟使用加载函数的读路径")
+	fs.IntVar(&c.maxItems, "max-items", 0, "进程内的表的容量, 0表示不限制")
+	fs.Int64Var(&c.seed, "seed", time.Now().UnixNano(), "随机数种子")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := c.validate(); err != nil {
+		fmt.Fprintln(stderr, "cacheload:", err)
+		return 2
+	}
+
+	var (
+		target cache.Table
+		errs   uint64
+	)
+	if c.server != "" {
+		client := cacheserver.NewClient(c.server, c.table)
+		client.OnError = func(error) { atomic.AddUint64(&errs, 1) }
+		target = client
+	} else {
+		target = cache.NewTable(c.table, cache.WithMaxItems(c.maxItems))
+	}
+
+	if c.requests <= 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.duration)
+		defer cancel()
+	}
+	r := c.drive(ctx, target)
+	r.errors += atomic.LoadUint64(&errs)
+	r.print(stdout)
+	return 0
+}
+
+func (c *config) validate() error {
+	switch {
+	case c.concurrency < 1:
+		return errors.New("concurrency must be at least 1")
+	case c.keys < 1:
+		return errors.New("keys must be at least 1")
+	case c.dist != "zipf" && c.dist != "uniform":
+		return fmt.Errorf("unknown distribution %q", c.dist)
+	case c.dist == "zipf" && c.zipfS <= 1:
+		return errors.New("zipf-s must be greater than 1")
+	case c.writes < 0 || c.deletes < 0 || c.writes+c.deletes > 1:
+		return errors.New("writes and deletes must be fractions summing to at most 1")
+	}
+	return nil
+}
+
+// 操作类型
+const (
+	opGet = iota
+	opSet
+	opDelete
+	numOps
+)
+
+var opNames = [numOps]string{"get", "set", "delete"}
+
+// worker 单个并发的统计, 结束后合并
+type worker struct {
+	latencies    [numOps][]time.Duration
+	hits, misses uint64
+	errors       uint64
+}
+
+// drive 运行负载直到ctx结束或完成c.requests个操作
+func (c *config) drive(ctx context.Context, target cache.Table) *report {
+	var (
+		wg        sync.WaitGroup
+		remaining = int64(c.requests)
+		workers   = make([]*worker, c.concurrency)
+	)
+	start := time.Now()
+	for i := range workers {
+		w := &worker{}
+		workers[i] = w
+		rng := rand.New(rand.NewSource(c.seed + int64(i)))
+		next := c.keyGen(rng)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if c.requests > 0 && atomic.AddInt64(&remaining, -1) < 0 {
+					return
+				}
+				c.step(target, w, rng, next())
+			}
+		}()
+	}
+	wg.Wait()
+
+	r := &report{elapsed: time.Since(start)}
+	for _, w := range workers {
+		for op := range w.latencies {
+			r.latencies[op] = append(r.latencies[op], w.latencies[op]...)
+		}
+		r.hits += w.hits
+		r.misses += w.misses
+		r.errors += w.errors
+	}
+	return r
+}
+
+// keyGen 返回按c.dist生成键的函数
+func (c *config) keyGen(rng *rand.Rand) func() string {
+	if c.dist == "uniform" {
+		return func() string { return "key-" + strconv.Itoa(rng.Intn(c.keys)) }
+	}
+	z := rand.NewZipf(rng, c.zipfS, 1, uint64(c.keys-1))
+	return func() string { return "key-" + strconv.FormatUint(z.Uint64(), 10) }
+}
+
+// value 按值大小参数生成值
+func (c *config) value(rng *rand.Rand) []byte {
+	n := c.valueSize
+	if c.valueSizeMax > n {
+		n += rng.Intn(c.valueSizeMax - n + 1)
+	}
+	return make([]byte, n)
+}
+
+// step 执行一个随机选择的操作
+func (c *config) step(target cache.Table, w *worker, rng *rand.Rand, key string) {
+	p := rng.Float64()
+	op := opGet
+	switch {
+	case p < c.deletes:
+		op = opDelete
+	case p < c.deletes+c.writes:
+		op = opSet
+	}
+
+	begin := time.Now()
+	switch op {
+	case opGet:
+		_, err := target.Value(key)
+		switch {
+		case err == nil:
+			w.hits++
+		case errors.Is(err, cache.ErrKeyNotFound) || errors.Is(err, cache.ErrKeyNotFoundOrLoadable):
+			w.misses++
+			if c.fill {
+				target.Add(key, c.ttl, c.value(rng))
+			}
+		default:
+			w.errors++
+		}
+	case opSet:
+		target.Add(key, c.ttl, c.value(rng))
+	case opDelete:
+		if _, err := target.Delete(key); err != nil && !errors.Is(err, cache.ErrKeyNotFound) {
+			w.errors++
+		}
+	}
+	w.latencies[op] = append(w.latencies[op], time.Since(begin))
+}
+
+// report 合并后的结果
+type report struct {
+	elapsed      time.Duration
+	latencies    [numOps][]time.Duration
+	hits, misses uint64
+	errors       uint64
+}
+
+func (r *report) total() int {
+	n := 0
+	for _, l := range r.latencies {
+		n += len(l)
+	}
+	return n
+}
+
+func (r *report) print(w io.Writer) {
+	total := r.total()
+	fmt.Fprintf(w, "operations: %d in %v (%.0f ops/s)\n", total, r.elapsed.Round(time.Millisecond), float64(total)/r.elapsed.Seconds())
+	if gets := r.hits + r.misses; gets > 0 {
+		fmt.Fprintf(w, "hit rate:   %.2f%% (%d hits, %d misses)\n", 100*float64(r.hits)/float64(gets), r.hits, r.misses)
+	}
+	fmt.Fprintf(w, "errors:     %d\n", r.errors)
+	fmt.Fprintf(w, "%-8s %10s %12s %12s %12s %12s\n", "op", "count", "p50", "p95", "p99", "max")
+	for op, l := range r.latencies {
+		if len(l) == 0 {
+			continue
+		}
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		fmt.Fprintf(w, "%-8s %10d %12v %12v %12v %12v\n", opNames[op], len(l),
+			percentile(l, 0.50), percentile(l, 0.95), percentile(l, 0.99), l[len(l)-1])
+	}
+}
+
+// percentile 已排序的l的q分位数
+func percentile(l []time.Duration, q float64) time.Duration {
+	i := int(q*float64(len(l))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(l) {
+		i = len(l) - 1
+	}
+	return l[i]
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cache"
+	"cache/cacheserver"
+)
+
+func TestRunLocal(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	args := []string{"-requests", "2000", "-concurrency", "4", "-keys", "100", "-writes", "0.2", "-deletes", "0.05", "-max-items", "50", "-seed", "1"}
+	if code := run(context.Background(), args, &stdout, &stderr); code != 0 {
+		t.Fatal("Error running load", stderr.String())
+	}
+	out := stdout.String()
+	for _, want := range []string{"operations: 2000 ", "hit rate:", "errors:     0", "get ", "set ", "delete "} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Error reporting %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRunRemote(t *testing.T) {
+	defer cache.Remove("cacheload")
+	cache.Cache("cacheload")
+	srv := httptest.NewServer((&cacheserver.Service{}).Handler())
+	defer srv.Close()
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"-server", srv.URL, "-requests", "200", "-dist", "uniform", "-keys", "10", "-value-size", "8", "-value-size-max", "64"}
+	if code := run(context.Background(), args, &stdout, &stderr); code != 0 {
+		t.Fatal("Error running load", stderr.String())
+	}
+	if out := stdout.String(); !strings.Contains(out, "operations: 200 ") || !strings.Contains(out, "errors:     0") {
+		t.Error("Error driving remote server", out)
+	}
+	if cache.Cache("cacheload").Count() == 0 {
+		t.Error("Error writing to remote table")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	for _, args := range [][]string{
+		{"-dist", "normal"},
+		{"-zipf-s", "1"},
+		{"-writes", "0.8", "-deletes", "0.3"},
+		{"-concurrency", "0"},
+	} {
+		if code := run(context.Background(), args, &stdout, &stderr); code != 2 {
+			t.Error("Error rejecting invalid flags", args)
+		}
+	}
+}
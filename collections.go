@@ -0,0 +1,358 @@
+package cache
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// List ListPush等方法使用的列表值
+type List []interface{}
+
+// Set SetAdd等方法使用的集合值, 成员对应的值恒为true
+type Set map[interface{}]bool
+
+// Hash HashSet等方法使用的字段表值
+type Hash map[string]interface{}
+
+func init() {
+	// 快照、复制和跨节点读取使用gob编码
+	gob.Register(List{})
+	gob.Register(Set{})
+	gob.Register(Hash{})
+}
+
+// 集合值的修改都在一次加锁内完成, 写入的是修改后的新副本, 之前通过Value读到的值不会被修改,
+// 因此每次修改的开销与集合大小成正比. 集合被清空时删除键. 与Redis相同, 修改不会续期, 已有的键保留原来的过期时间; 新键使用默认有效期(WithDefaultTTL).
+// 键的值不是对应的类型时返回包装了ErrTypeMismatch的KeyError
+
+// ListPush 把values依次追加到key的列表末尾, 返回追加后的长度. 每次调用复制整个列表, 开销为O(n), 不适合用作很长的队列
+func (table *CacheTable) ListPush(key interface{}, values ...interface{}) (int, error) {
+	var n int
+	err := table.updateCollection("list_push", key, func(old interface{}) (interface{}, error) {
+		list, err := asList(old)
+		if err != nil {
+			return nil, err
+		}
+		next := make(List, len(list), len(list)+len(values))
+		copy(next, list)
+		next = append(next, values...)
+		n = len(next)
+		return next, nil
+	})
+	return n, err
+}
+
+// ListPop 移除并返回key的列表的第一个元素, 键不存在时ok为false. 同ListPush, 每次调用复制剩余的列表, 开销为O(n)
+func (table *CacheTable) ListPop(key interface{}) (value interface{}, ok bool, err error) {
+	err = table.updateCollection("list_pop", key, func(old interface{}) (interface{}, error) {
+		list, err := asList(old)
+		if err != nil {
+			return nil, err
+		}
+		if len(list) == 0 {
+			return nil, nil
+		}
+		value, ok = list[0], true
+		if len(list) == 1 {
+			return nil, nil
+		}
+		return append(List(nil), list[1:]...), nil
+	})
+	return value, ok, err
+}
+
+// ListRange 返回key的列表中下标在[start, stop]之间的元素, 负数下标从末尾算起(-1为最后一个); 键不存在时返回空
+func (table *CacheTable) ListRange(key interface{}, start, stop int) ([]interface{}, error) {
+	old, err := table.readCollection(key)
+	if err != nil {
+		return nil, err
+	}
+	list, err := asList(old)
+	if err != nil {
+		return nil, table.keyError("list_range", key, err)
+	}
+	n := len(list)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return nil, nil
+	}
+	return append([]interface{}(nil), list[start:stop+1]...), nil
+}
+
+// SetAdd 把members加入key的集合, 返回新加入的成员数; 成员不可比较(如切片)时返回包装了ErrUnhashableMember的KeyError
+func (table *CacheTable) SetAdd(key interface{}, members ...interface{}) (int, error) {
+	if err := checkMembers(members); err != nil {
+		return 0, table.keyError("set_add", key, err)
+	}
+	var added int
+	err := table.updateCollection("set_add", key, func(old interface{}) (interface{}, error) {
+		set, err := asSet(old)
+		if err != nil {
+			return nil, err
+		}
+		next := make(Set, len(set)+len(members))
+		for m := range set {
+			next[m] = true
+		}
+		for _, m := range members {
+			if !next[m] {
+				next[m] = true
+				added++
+			}
+		}
+		return next, nil
+	})
+	return added, err
+}
+
+// SetRemove 从key的集合中移除members, 返回实际移除的成员数; 成员不可比较时同SetAdd
+func (table *CacheTable) SetRemove(key interface{}, members ...interface{}) (int, error) {
+	if err := checkMembers(members); err != nil {
+		return 0, table.keyError("set_remove", key, err)
+	}
+	var removed int
+	err := table.updateCollection("set_remove", key, func(old interface{}) (interface{}, error) {
+		set, err := asSet(old)
+		if err != nil {
+			return nil, err
+		}
+		next := make(Set, len(set))
+		for m := range set {
+			next[m] = true
+		}
+		for _, m := range members {
+			if next[m] {
+				delete(next, m)
+				removed++
+			}
+		}
+		if len(next) == 0 {
+			return nil, nil
+		}
+		return next, nil
+	})
+	return removed, err
+}
+
+// SetIsMember member是否在key的集合中; 成员不可比较时同SetAdd
+func (table *CacheTable) SetIsMember(key interface{}, member interface{}) (bool, error) {
+	if err := checkMembers([]interface{}{member}); err != nil {
+		return false, table.keyError("set_is_member", key, err)
+	}
+	old, err := table.readCollection(key)
+	if err != nil {
+		return false, err
+	}
+	set, err := asSet(old)
+	if err != nil {
+		return false, table.keyError("set_is_member", key, err)
+	}
+	return set[member], nil
+}
+
+// SetMembers 返回key的集合的所有成员, 顺序不确定
+func (table *CacheTable) SetMembers(key interface{}) ([]interface{}, error) {
+	old, err := table.readCollection(key)
+	if err != nil {
+		return nil, err
+	}
+	set, err := asSet(old)
+	if err != nil {
+		return nil, table.keyError("set_members", key, err)
+	}
+	members := make([]interface{}, 0, len(set))
+	for m := range set {
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// HashSet 设置key的字段表中field的值, 返回field是否是新加入的
+func (table *CacheTable) HashSet(key interface{}, field string, value interface{}) (bool, error) {
+	var created bool
+	err := table.updateCollection("hash_set", key, func(old interface{}) (interface{}, error) {
+		hash, err := asHash(old)
+		if err != nil {
+			return nil, err
+		}
+		next := make(Hash, len(hash)+1)
+		for f, v := range hash {
+			next[f] = v
+		}
+		_, exists := next[field]
+		created = !exists
+		next[field] = value
+		return next, nil
+	})
+	return created, err
+}
+
+// HashGet 读取key的字段表中field的值
+func (table *CacheTable) HashGet(key interface{}, field string) (interface{}, bool, error) {
+	old, err := table.readCollection(key)
+	if err != nil {
+		return nil, false, err
+	}
+	hash, err := asHash(old)
+	if err != nil {
+		return nil, false, table.keyError("hash_get", key, err)
+	}
+	value, ok := hash[field]
+	return value, ok, nil
+}
+
+// HashDelete 从key的字段表中删除fields, 返回实际删除的字段数
+func (table *CacheTable) HashDelete(key interface{}, fields ...string) (int, error) {
+	var deleted int
+	err := table.updateCollection("hash_delete", key, func(old interface{}) (interface{}, error) {
+		hash, err := asHash(old)
+		if err != nil {
+			return nil, err
+		}
+		next := make(Hash, len(hash))
+		for f, v := range hash {
+			next[f] = v
+		}
+		for _, f := range fields {
+			if _, ok := next[f]; ok {
+				delete(next, f)
+				deleted++
+			}
+		}
+		if len(next) == 0 {
+			return nil, nil
+		}
+		return next, nil
+	})
+	return deleted, err
+}
+
+// HashGetAll 返回key的字段表的副本, 键不存在时返回空表
+func (table *CacheTable) HashGetAll(key interface{}) (Hash, error) {
+	old, err := table.readCollection(key)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := asHash(old)
+	if err != nil {
+		return nil, table.keyError("hash_get_all", key, err)
+	}
+	all := make(Hash, len(hash))
+	for f, v := range hash {
+		all[f] = v
+	}
+	return all, nil
+}
+
+// checkMembers 检查成员能否作为Set的键, 不可比较的值在写入map时会panic, 必须在加锁前拒绝
+func checkMembers(members []interface{}) error {
+	for _, m := range members {
+		if m != nil && !reflect.ValueOf(m).Comparable() {
+			return fmt.Errorf("%w: %T", ErrUnhashableMember, m)
+		}
+	}
+	return nil
+}
+
+func asList(v interface{}) (List, error) {
+	list, ok := v.(List)
+	if v != nil && !ok {
+		return nil, fmt.Errorf("%w: holds %T, want List", ErrTypeMismatch, v)
+	}
+	return list, nil
+}
+
+func asSet(v interface{}) (Set, error) {
+	set, ok := v.(Set)
+	if v != nil && !ok {
+		return nil, fmt.Errorf("%w: holds %T, want Set", ErrTypeMismatch, v)
+	}
+	return set, nil
+}
+
+func asHash(v interface{}) (Hash, error) {
+	hash, ok := v.(Hash)
+	if v != nil && !ok {
+		return nil, fmt.Errorf("%w: holds %T, want Hash", ErrTypeMismatch, v)
+	}
+	return hash, nil
+}
+
+// readCollection 返回key当前的值, 键不存在或已过期时返回nil; 不调用加载函数, 也不计入命中统计
+func (table *CacheTable) readCollection(key interface{}) (interface{}, error) {
+	key = canonicalKey(key)
+	table.RLock()
+	if table.closed {
+		table.RUnlock()
+		return nil, ErrTableClosed
+	}
+	item, ok := table.items.get(key)
+	now := table.clock.Now()
+	table.RUnlock()
+	if !ok || item.expired(now) {
+		return nil, nil
+	}
+	return item.Value(), nil
+}
+
+// updateCollection 同updateCollectionTTL, 新键使用默认有效期
+func (table *CacheTable) updateCollection(op string, key interface{}, fn func(old interface{}) (interface{}, error)) error {
+	return table.updateCollectionTTL(op, key, table.Settings().DefaultTTL, fn)
+}
+
+// updateCollectionTTL 在一次加锁内用fn计算key的新值并写入, fn收到当前值(不存在或已过期时为nil), 返回nil时删除键.
+// 新键的有效期为lifeSpan, 已有的键保留创建时间和访问时间, 因此过期时间不变. fn在持有表锁时调用
+func (table *CacheTable) updateCollectionTTL(op string, key interface{}, lifeSpan time.Duration, fn func(old interface{}) (interface{}, error)) error {
+	ctx := context.Background()
+	key = canonicalKey(key)
+	table.Lock()
+	if table.rejectsWrites() {
+		table.Unlock()
+		return ErrTableClosed
+	}
+	now := table.clock.Now()
+	createdOn, accessedOn := now, now
+	var old interface{}
+	cur, ok := table.items.get(key)
+	if ok && !cur.expired(now) {
+		cur.RLock()
+		old = cur.value
+		lifeSpan = cur.lifeSpan
+		createdOn, accessedOn = cur.createdOn, cur.accessedOn
+		cur.RUnlock()
+	}
+	value, err := fn(old)
+	if err != nil {
+		table.Unlock()
+		return table.keyError(op, key, err)
+	}
+	if value == nil {
+		if ok {
+			table.deleteInternal(ctx, key, OpDelete)
+		}
+		table.Unlock()
+		return nil
+	}
+	if err := table.admit(key, value, lifeSpan); err != nil {
+		table.Unlock()
+		return err
+	}
+	item := newCacheItem(key, lifeSpan, value, accessedOn)
+	item.createdOn = createdOn
+	table.addInternal(ctx, item)
+	return nil
+}
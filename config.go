@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config 声明式的注册表配置, 可从YAML或JSON文件读取
+type Config struct {
+	Tables []TableConfig `json:"tables" yaml:"tables"`
+}
+
+// TableConfig 单个表的配置
+type TableConfig struct {
+	Name           string         `json:"name" yaml:"name"`
+	DefaultTTL     Duration       `json:"default_ttl" yaml:"default_ttl"`
+	MaxItems       int            `json:"max_items" yaml:"max_items"`
+	EvictionPolicy EvictionPolicy `json:"eviction_policy" yaml:"eviction_policy"`
+	// ProtectedRatio EvictSLRU受保护段的比例, 见WithSegmentedLRU
+	ProtectedRatio float64 `json:"protected_ratio" yaml:"protected_ratio"`
+	// EvictionSamples EvictRandom每次抽样的元素数, 见WithRandomEviction
+	EvictionSamples int `json:"eviction_samples" yaml:"eviction_samples"`
+
+	// SnapshotFile 快照文件, 见SetSnapshotFile; RestoreSnapshot为true且文件存在时在配置时按WithWarmStart的方式加载
+	SnapshotFile    string `json:"snapshot_file" yaml:"snapshot_file"`
+	RestoreSnapshot bool   `json:"restore_snapshot" yaml:"restore_snapshot"`
+}
+
+// Duration 可以用"5m"这样的字符串配置的时长
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (p *EvictionPolicy) UnmarshalText(text []byte) error {
+	for _, candidate := range []EvictionPolicy{EvictLRU, EvictLFU, EvictFIFO, Evict2Q, EvictSLRU, EvictClock, EvictRandom} {
+		if strings.EqualFold(string(text), candidate.String()) {
+			*p = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("cache: unknown eviction policy %q", text)
+}
+
+func (p EvictionPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// Options 把配置转换为创建表用的Option
+func (c TableConfig) Options() []Option {
+	opts := []Option{
+		WithDefaultTTL(time.Duration(c.DefaultTTL)),
+		WithMaxItems(c.MaxItems),
+		WithEvictionPolicy(c.EvictionPolicy),
+	}
+	if c.EvictionPolicy == EvictSLRU {
+		opts = append(opts, WithSegmentedLRU(c.ProtectedRatio))
+	}
+	if c.EvictionPolicy == EvictRandom {
+		opts = append(opts, WithRandomEviction(c.EvictionSamples))
+	}
+	return opts
+}
+
+// ConfigureFromFile 读取YAML(.yaml/.yml)或JSON(.json)配置文件并调用Configure
+func ConfigureFromFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfg Config
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &cfg)
+	case ".json":
+		err = json.Unmarshal(data, &cfg)
+	default:
+		return fmt.Errorf("cache: unsupported config file type %q", path)
+	}
+	if err != nil {
+		return fmt.Errorf("cache: parsing %s: %w", path, err)
+	}
+	return Configure(cfg)
+}
+
+// Configure 按配置创建并注册表; 已注册的表会被更新为配置中的设置
+func Configure(cfg Config) error {
+	for _, tc := range cfg.Tables {
+		if tc.Name == "" {
+			return errors.New("cache: table config without name")
+		}
+		opts := tc.Options()
+		t, created := lookupOrCreate(tc.Name, opts...)
+		if !created {
+			t.apply(opts...)
+		}
+
+		if tc.SnapshotFile == "" {
+			continue
+		}
+		t.SetSnapshotFile(tc.SnapshotFile)
+		if !tc.RestoreSnapshot {
+			continue
+		}
+		if err := restoreSnapshotFile(t, tc.SnapshotFile); err != nil {
+			return fmt.Errorf("cache: restoring table %s: %w", tc.Name, err)
+		}
+	}
+	return nil
+}
+
+// restoreSnapshotFile 同WithWarmStart, 从最新的有效快照恢复表, 文件不存在时忽略
+func restoreSnapshotFile(t *CacheTable, path string) error {
+	_, _, err := t.restoreLatestSnapshot(path)
+	return err
+}
+
+// apply 在已创建的表上应用配置项. 配置项换了Evictor时同SetEvictor登记已有的键, 之后按新的容量淘汰
+func (table *CacheTable) apply(opts ...Option) {
+	table.Lock()
+	defer table.Unlock()
+	evictor := table.evictor
+	for _, opt := range opts {
+		opt(table)
+	}
+	if table.evictor != nil && table.evictor != evictor {
+		table.items.each(func(key interface{}, _ *CacheItem) bool {
+			table.evictor.OnAdd(key)
+			return true
+		})
+	}
+	table.enforceCapacity(context.Background(), nil)
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+)
+
+// Cloner 值实现该接口时, 深拷贝使用Clone而不是反射
+type Cloner interface {
+	Clone() interface{}
+}
+
+// DeepCopy 返回v的深拷贝. 实现了Cloner的值调用Clone, 其余通过反射递归复制指针、切片、map、数组和结构体的导出字段;
+// 未导出字段、chan和func按值复制. 不支持环状引用
+func DeepCopy(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	if c, ok := v.(Cloner); ok {
+		return c.Clone()
+	}
+	return copyValue(reflect.ValueOf(v)).Interface()
+}
+
+func copyValue(v reflect.Value) reflect.Value {
+	if v.CanInterface() {
+		if c, ok := v.Interface().(Cloner); ok && (v.Kind() != reflect.Ptr || !v.IsNil()) {
+			if cv := reflect.ValueOf(c.Clone()); cv.IsValid() && cv.Type().AssignableTo(v.Type()) {
+				return cv
+			}
+		}
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		n := reflect.New(v.Type().Elem())
+		n.Elem().Set(copyValue(v.Elem()))
+		return n
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		n := reflect.New(v.Type()).Elem()
+		n.Set(copyValue(v.Elem()))
+		return n
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		n := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			n.Index(i).Set(copyValue(v.Index(i)))
+		}
+		return n
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		n := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			n.SetMapIndex(copyValue(iter.Key()), copyValue(iter.Value()))
+		}
+		return n
+	case reflect.Array:
+		n := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			n.Index(i).Set(copyValue(v.Index(i)))
+		}
+		return n
+	case reflect.Struct:
+		n := reflect.New(v.Type()).Elem()
+		n.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if f := n.Field(i); f.CanSet() {
+				f.Set(copyValue(v.Field(i)))
+			}
+		}
+		return n
+	}
+	return v
+}
+
+// Copier 复制值的函数, 用于WithCopyOnRead
+type Copier func(v interface{}) (interface{}, error)
+
+// ReflectCopier 使用DeepCopy(Cloner或反射)复制值
+func ReflectCopier(v interface{}) (interface{}, error) {
+	return DeepCopy(v), nil
+}
+
+// GobCopier 用gob编码再解码复制值, 与快照使用相同的编码; 接口中的自定义类型需要先gob.Register
+func GobCopier(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+		return nil, err
+	}
+	var out interface{}
+	if err := gob.NewDecoder(&buf).Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SetCopyOnRead 设置后Value和Peek返回元素的副本, 其值由copier复制, 调用方修改返回的值不会影响表中的元素.
+// copier为nil时使用ReflectCopier; 复制出错时Value和Peek返回该错误
+func (table *CacheTable) SetCopyOnRead(copier Copier) {
+	table.Lock()
+	defer table.Unlock()
+	table.setCopyOnRead(copier)
+}
+
+// WithCopyOnRead 同SetCopyOnRead
+func WithCopyOnRead(copier Copier) Option {
+	return func(t *CacheTable) { t.setCopyOnRead(copier) }
+}
+
+func (table *CacheTable) setCopyOnRead(copier Copier) {
+	if copier == nil {
+		copier = ReflectCopier
+	}
+	table.copyOnRead = copier
+}
+
+// readCopy 开启了读时复制时返回item的副本
+func (table *CacheTable) readCopy(item *CacheItem) (*CacheItem, error) {
+	table.RLock()
+	copier := table.copyOnRead
+	table.RUnlock()
+	if copier == nil {
+		return item, nil
+	}
+	c := item.copyMeta()
+	v, err := copier(c.value)
+	if err != nil {
+		return nil, err
+	}
+	c.value = v
+	return c, nil
+}
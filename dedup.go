@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DedupStats Dedup的计数
+type DedupStats struct {
+	// First 窗口内第一次出现的ID数
+	First uint64
+	// Duplicates 窗口内重复出现的次数
+	Duplicates uint64
+}
+
+// Dedup 记录窗口期内见过的ID, 用于请求幂等和消息去重. 判断与记录在同一次加锁中完成,
+// 并发的相同ID只有一个调用得到true
+type Dedup struct {
+	table  *CacheTable
+	window time.Duration
+
+	first      uint64
+	duplicates uint64
+}
+
+// NewDedup 创建在table中记录ID的去重器, 每个ID在第一次出现后的window内视为重复
+func NewDedup(table *CacheTable, window time.Duration) *Dedup {
+	return &Dedup{table: table, window: window}
+}
+
+// FirstSeen 返回id在窗口内是否第一次出现; 重复出现不会延长窗口
+func (d *Dedup) FirstSeen(id interface{}) bool {
+	if d.table.NotFoundAdd(id, d.window, struct{}{}) {
+		atomic.AddUint64(&d.first, 1)
+		return true
+	}
+	atomic.AddUint64(&d.duplicates, 1)
+	return false
+}
+
+// Forget 移除id, 之后再出现时视为第一次, 如处理失败需要允许重试时
+func (d *Dedup) Forget(id interface{}) {
+	d.table.Delete(id)
+}
+
+// Stats 返回计数快照
+func (d *Dedup) Stats() DedupStats {
+	return DedupStats{
+		First:      atomic.LoadUint64(&d.first),
+		Duplicates: atomic.LoadUint64(&d.duplicates),
+	}
+}
@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// DumpSort Dump的排序方式
+type DumpSort int
+
+const (
+	// DumpByKey 按键的字符串形式排序
+	DumpByKey DumpSort = iota
+	// DumpBySize 从大到小
+	DumpBySize
+	// DumpByAge 从旧到新
+	DumpByAge
+	// DumpByTTL 剩余有效期从短到长, 不过期的元素排在最后
+	DumpByTTL
+	// DumpByAccess 访问次数从多到少
+	DumpByAccess
+)
+
+// DumpOptions Dump的选项
+type DumpOptions struct {
+	Sort DumpSort
+	// Limit 大于0时最多输出Limit个元素
+	Limit int
+}
+
+// Dump 以对齐的文本表格输出元素列表(键、值类型、估算大小、存在时长、剩余有效期、访问次数), 用于排查问题.
+// 最后一行为元素总数和输出数
+func (table *CacheTable) Dump(w io.Writer, opts DumpOptions) error {
+	now := table.now()
+	var infos []ItemInfo
+	table.Foreach(func(key interface{}, item *CacheItem) {
+		infos = append(infos, item.info(now))
+	})
+	total := len(infos)
+
+	var less func(a, b *ItemInfo) bool
+	switch opts.Sort {
+	case DumpBySize:
+		less = func(a, b *ItemInfo) bool { return a.Size > b.Size }
+	case DumpByAge:
+		less = func(a, b *ItemInfo) bool { return a.CreatedOn.Before(b.CreatedOn) }
+	case DumpByTTL:
+		less = func(a, b *ItemInfo) bool {
+			if (a.LifeSpan > 0) != (b.LifeSpan > 0) {
+				return a.LifeSpan > 0
+			}
+			return a.TTLRemaining < b.TTLRemaining
+		}
+	case DumpByAccess:
+		less = func(a, b *ItemInfo) bool { return a.AccessCount > b.AccessCount }
+	default:
+		less = func(a, b *ItemInfo) bool { return a.Key < b.Key }
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		if less(&infos[i], &infos[j]) != less(&infos[j], &infos[i]) {
+			return less(&infos[i], &infos[j])
+		}
+		return infos[i].Key < infos[j].Key
+	})
+	if opts.Limit > 0 && len(infos) > opts.Limit {
+		infos = infos[:opts.Limit]
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tTYPE\tSIZE\tAGE\tTTL\tACCESSES")
+	for _, info := range infos {
+		ttl := "-"
+		if info.LifeSpan > 0 {
+			ttl = info.TTLRemaining.Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%v\t%s\t%d\n", info.Key, info.Type, info.Size,
+			now.Sub(info.CreatedOn).Round(time.Millisecond), ttl, info.AccessCount)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d of %d items\n", len(infos), total)
+	return err
+}
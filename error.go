@@ -1,9 +1,57 @@
 package cache
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrKeyNotFound = errors.New("Key not found in cache.")
 
 	ErrKeyNotFoundOrLoadable = errors.New("Key not found and could not be loaded into cache")
+
+	ErrTableClosed = errors.New("Cache table is closed")
+
+	ErrTableNotFound = errors.New("Cache table not found")
+
+	ErrTableExists = errors.New("Cache table already exists")
+
+	ErrVersionMismatch = errors.New("Item version does not match")
+
+	ErrRebalanceInProgress = errors.New("Rebalance already in progress")
+
+	ErrTypeMismatch = errors.New("Item value has unexpected type")
+
+	ErrValueTooLarge = errors.New("Item value exceeds maximum size")
+
+	ErrPluginExists = errors.New("Plugin already registered")
+
+	ErrStaleVersion = errors.New("Item version is not newer than cached version")
+
+	ErrNotAdmitted = errors.New("Item rejected by admission policy")
+
+	ErrUnhashableMember = errors.New("Set member is not hashable")
 )
+
+// KeyError 带表名、键和操作的键错误, 如"cache: get users/42: Key not found in cache.";
+// Err为ErrKeyNotFound等原始错误, 可用errors.Is匹配, 用errors.As取出KeyError
+type KeyError struct {
+	Table string
+	Key   interface{}
+	// Op 出错的操作, 如"get", "peek", "delete", "set_if_version"
+	Op  string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("cache: %s %s/%v: %v", e.Op, e.Table, e.Key, e.Err)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// keyError 把err包装为KeyError, 调用方不能持有表锁
+func (table *CacheTable) keyError(op string, key interface{}, err error) error {
+	return &KeyError{Table: table.Name(), Key: key, Op: op, Err: err}
+}
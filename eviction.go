@@ -0,0 +1,364 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// EvictionPolicy 表超出容量时选择淘汰元素的策略
+type EvictionPolicy int
+
+const (
+	// EvictLRU 淘汰最久未被访问的元素
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU 淘汰访问次数最少的元素
+	EvictLFU
+	// EvictFIFO 淘汰最早加入的元素
+	EvictFIFO
+	// Evict2Q 2Q算法: 新元素先进入试用FIFO, 被淘汰后短期内再次加入的元素进入受保护的LRU,
+	// 一次性扫描的元素不会挤掉常用元素
+	Evict2Q
+	// EvictSLRU 分段LRU: 新元素进入试用段, 之后第一次被访问(命中或覆盖写入)时晋升到受保护段, 比例见WithSegmentedLRU
+	EvictSLRU
+	// EvictClock CLOCK(second-chance)算法: 近似LRU, 命中时只设置引用位, 不移动元素
+	EvictClock
+	// EvictRandom 随机抽样若干元素淘汰其中最久未访问的一个, 命中时没有额外开销, 见WithRandomEviction
+	EvictRandom
+)
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case EvictLRU:
+		return "lru"
+	case EvictLFU:
+		return "lfu"
+	case EvictFIFO:
+		return "fifo"
+	case Evict2Q:
+		return "2q"
+	case EvictSLRU:
+		return "slru"
+	case EvictClock:
+		return "clock"
+	case EvictRandom:
+		return "random"
+	}
+	return "unknown"
+}
+
+// Evictor 可插拔的淘汰策略实现, 由表在元素增删和命中时驱动, 以键标识元素.
+// OnAccess在表锁外调用, 其余方法在表锁内调用; 实现需自行加锁, 且不能回调表的方法.
+// 内置实现见EvictionPolicy, 用WithEvictor或SetEvictor设置自定义实现
+type Evictor interface {
+	// OnAdd 新键加入
+	OnAdd(key interface{})
+	// OnAccess 键被读取或覆盖写入
+	OnAccess(key interface{})
+	// OnRemove 键被移除, op为移除原因; 清空表时对每个键以OpFlush调用
+	OnRemove(key interface{}, op MutationOp)
+	// Victim 选出待淘汰的键, 不能选中keep(刚加入的键); 没有可淘汰的键时返回false
+	Victim(keep interface{}) (interface{}, bool)
+}
+
+// NewLRUEvictor 返回淘汰最久未被访问的键的Evictor, 命中时O(1)维护访问顺序
+func NewLRUEvictor() Evictor {
+	return &listEvictor{order: list.New(), entries: make(map[interface{}]*list.Element), moveOnAccess: true}
+}
+
+// NewFIFOEvictor 返回淘汰最早加入的键的Evictor, 命中和覆盖写入不改变顺序
+func NewFIFOEvictor() Evictor {
+	return &listEvictor{order: list.New(), entries: make(map[interface{}]*list.Element)}
+}
+
+// listEvictor LRU和FIFO的实现, 链表头部为最新的键
+type listEvictor struct {
+	mu           sync.Mutex
+	order        *list.List
+	entries      map[interface{}]*list.Element
+	moveOnAccess bool
+}
+
+func (l *listEvictor) OnAdd(key interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[key] = l.order.PushFront(key)
+}
+
+func (l *listEvictor) OnAccess(key interface{}) {
+	if !l.moveOnAccess {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.entries[key]; ok {
+		l.order.MoveToFront(e)
+	}
+}
+
+func (l *listEvictor) OnRemove(key interface{}, op MutationOp) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.entries[key]; ok {
+		l.order.Remove(e)
+		delete(l.entries, key)
+	}
+}
+
+func (l *listEvictor) Victim(keep interface{}) (interface{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for e := l.order.Back(); e != nil; e = e.Prev() {
+		if e.Value != keep {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+// NewLFUEvictor 返回淘汰访问次数最少的键的Evictor, 次数相同时淘汰最久未被访问的; 命中时O(log n)
+func NewLFUEvictor() Evictor {
+	return &lfuEvictor{entries: make(map[interface{}]*lfuEntry)}
+}
+
+// lfuEvictor 按(访问次数, 最近访问序号)排序的最小堆
+type lfuEvictor struct {
+	mu      sync.Mutex
+	heap    lfuHeap
+	entries map[interface{}]*lfuEntry
+	seq     uint64
+}
+
+type lfuEntry struct {
+	key   interface{}
+	count int64
+	seq   uint64
+	index int
+}
+
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].seq < h[j].seq
+}
+func (h lfuHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i]; h[i].index = i; h[j].index = j }
+func (h *lfuHeap) Push(x interface{}) { e := x.(*lfuEntry); e.index = len(*h); *h = append(*h, e) }
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+func (l *lfuEvictor) OnAdd(key interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	e := &lfuEntry{key: key, seq: l.seq}
+	l.entries[key] = e
+	heap.Push(&l.heap, e)
+}
+
+func (l *lfuEvictor) OnAccess(key interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.entries[key]; ok {
+		l.seq++
+		e.count++
+		e.seq = l.seq
+		heap.Fix(&l.heap, e.index)
+	}
+}
+
+func (l *lfuEvictor) OnRemove(key interface{}, op MutationOp) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.entries[key]; ok {
+		heap.Remove(&l.heap, e.index)
+		delete(l.entries, key)
+	}
+}
+
+func (l *lfuEvictor) Victim(keep interface{}) (interface{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.heap) == 0 {
+		return nil, false
+	}
+	if l.heap[0].key != keep {
+		return l.heap[0].key, true
+	}
+	// 堆顶是keep时次小的元素在它的子节点中
+	var next *lfuEntry
+	for i := 1; i <= 2 && i < len(l.heap); i++ {
+		if next == nil || l.heap.Less(i, next.index) {
+			next = l.heap[i]
+		}
+	}
+	if next == nil {
+		return nil, false
+	}
+	return next.key, true
+}
+
+// SetEvictor 用自定义的Evictor替代EvictionPolicy, 表中已有的键依次交给e; e为nil时恢复EvictionPolicy的内置实现
+func (table *CacheTable) SetEvictor(e Evictor) {
+	table.Lock()
+	defer table.Unlock()
+	if e == nil {
+		e = newEvictionState(table, table.evictionPolicy)
+	}
+	if e != nil {
+		table.items.each(func(key interface{}, _ *CacheItem) bool {
+			e.OnAdd(key)
+			return true
+		})
+	}
+	table.evictor = e
+}
+
+// WithEvictor 同SetEvictor
+func WithEvictor(e Evictor) Option {
+	return func(t *CacheTable) { t.evictor = e }
+}
+
+// removeAllFromEvictor 清空表前把所有键从Evictor中移除; 调用方需持有表锁
+func (table *CacheTable) removeAllFromEvictor() {
+	if table.evictor == nil {
+		return
+	}
+	table.items.each(func(key interface{}, _ *CacheItem) bool {
+		table.evictor.OnRemove(key, OpFlush)
+		return true
+	})
+}
+
+// newEvictionState 返回内置策略的Evictor, 淘汰时的复杂度均为O(1)或O(log n)
+func newEvictionState(table *CacheTable, p EvictionPolicy) Evictor {
+	switch p {
+	case EvictLFU:
+		return NewLFUEvictor()
+	case EvictFIFO:
+		return NewFIFOEvictor()
+	case Evict2Q:
+		return newTwoQueue()
+	case EvictSLRU:
+		return newSegmentedLRU(DefaultProtectedRatio)
+	case EvictClock:
+		return newClockPolicy()
+	case EvictRandom:
+		return newRandomPolicy(table, DefaultEvictionSamples)
+	}
+	return NewLRUEvictor()
+}
+
+// victim 按淘汰策略选出一个待淘汰的元素, 不会选中keep; 调用方需持有表锁
+func (table *CacheTable) victim(keep interface{}) (interface{}, bool) {
+	if table.evictor == nil {
+		table.evictor = table.seedEvictor()
+	}
+	return table.evictor.Victim(keep)
+}
+
+// seedEvictor 没有设置淘汰策略的表在第一次淘汰时才创建默认的Evictor, 不需要淘汰的表在读写时不维护访问顺序.
+// 已有的键按evictsBefore的顺序加入, 最先应被淘汰的先加入; 调用方需持有表锁
+func (table *CacheTable) seedEvictor() Evictor {
+	stats := make([]itemStat, 0, table.items.len())
+	table.items.each(func(_ interface{}, item *CacheItem) bool {
+		item.RLock()
+		stats = append(stats, itemStat{item, item.accessCount, item.accessedOn})
+		item.RUnlock()
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool { return table.evictsBefore(&stats[i], &stats[j]) })
+	e := newEvictionState(table, table.evictionPolicy)
+	for _, st := range stats {
+		e.OnAdd(st.item.key)
+	}
+	return e
+}
+
+// evictsBefore a是否应比b先被淘汰
+func (table *CacheTable) evictsBefore(a, b *itemStat) bool {
+	switch table.evictionPolicy {
+	case EvictLFU:
+		if a.accessCount != b.accessCount {
+			return a.accessCount < b.accessCount
+		}
+		return a.accessedOn.Before(b.accessedOn)
+	case EvictFIFO:
+		return a.item.createdOn.Before(b.item.createdOn)
+	default:
+		return a.accessedOn.Before(b.accessedOn)
+	}
+}
+
+// enforceCapacity 同步淘汰超出容量的元素, 开启后台淘汰时交给后台; 调用方需持有表锁
+func (table *CacheTable) enforceCapacity(ctx context.Context, added interface{}) {
+	if table.bgEvict == nil {
+		table.evict(ctx, added, -1)
+	}
+}
+
+// evictLocked 同enforceCapacity, 但淘汰的元素在持有表锁时直接移除, 期间不释放锁, 供需要整体生效的提交(如Tx)使用.
+// 返回被淘汰的元素, 调用方需在释放锁后执行它们的删除回调; 调用方需持有表锁
+func (table *CacheTable) evictLocked(ctx context.Context, added interface{}) []*CacheItem {
+	s := table.Settings()
+	if table.bgEvict != nil || s.MaxItems <= 0 || table.items.len() <= s.MaxItems {
+		return nil
+	}
+	target := s.MaxItems
+	if s.LowWatermark > 0 && s.LowWatermark < target {
+		target = s.LowWatermark
+	}
+	var evicted []*CacheItem
+	for table.items.len() > target {
+		key, ok := table.victim(added)
+		if !ok {
+			break
+		}
+		item, ok := table.items.get(key)
+		// 正在被其他调用删除的元素由删除方移除, 同evict
+		if !ok || !atomic.CompareAndSwapInt32(&item.deleting, 0, 1) {
+			break
+		}
+		table.logEvent(LevelDebug, EventEvict, key, "Evicting item", "policy", table.evictionPolicy)
+		table.removeItem(ctx, key, OpEvict)
+		atomic.AddUint64(&table.evictions, 1)
+		evicted = append(evicted, item)
+	}
+	return evicted
+}
+
+// evict 超出MaxItems时淘汰元素直到不超过MaxItems(设置了LowWatermark时为LowWatermark),
+// 最多淘汰budget个(<0表示不限制), 刚加入的added不会被淘汰; 调用方需持有表锁
+func (table *CacheTable) evict(ctx context.Context, added interface{}, budget int) {
+	s := table.Settings()
+	if s.MaxItems <= 0 || table.items.len() <= s.MaxItems {
+		return
+	}
+	target := s.MaxItems
+	if s.LowWatermark > 0 && s.LowWatermark < target {
+		target = s.LowWatermark
+	}
+	for ; table.items.len() > target && budget != 0; budget-- {
+		key, ok := table.victim(added)
+		if !ok {
+			return
+		}
+		table.logEvent(LevelDebug, EventEvict, key, "Evicting item", "policy", table.evictionPolicy)
+		// 候选元素正在被其他调用删除时停止, 否则会反复选中同一个元素; 超出的部分由之后的写入继续淘汰
+		if _, err := table.deleteInternal(ctx, key, OpEvict); err != nil {
+			return
+		}
+		atomic.AddUint64(&table.evictions, 1)
+	}
+}
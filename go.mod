@@ -1,3 +1,8 @@
 module cache
 
-go 1.14
+go 1.22
+
+require (
+	google.golang.org/protobuf v1.36.5
+	gopkg.in/yaml.v3 v3.0.1
+)
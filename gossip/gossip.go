@@ -0,0 +1,302 @@
+// Package gossip 在没有Redis/NATS的环境中用UDP gossip实现cache.BusTransport.
+//
+// 每个节点定期把自己知道的成员列表发给随机的几个成员, 消息按相同的方式逐跳转发, 最终到达所有节点(最终一致).
+// 成员超过DeadAfter没有消息时被移除, 种子节点永不移除. 配合cache.WithReplication和cache.WithHotEntries
+// 可以在节点间传播新值和热点数据, 冲突按变更时间解决:
+//
+//	node, err := gossip.Listen("10.0.0.1:7946", gossip.Config{Seeds: []string{"10.0.0.2:7946"}})
+//	err = cache.Cache("users").AttachBus(ctx, node, cache.WithHotEntries(100, time.Minute))
+package gossip
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MaxMessageSize 单个UDP包能携带的最大消息长度(含封装)
+const MaxMessageSize = 60 * 1024
+
+// ErrMessageTooLarge 消息超过MaxMessageSize, 无法通过gossip发送
+var ErrMessageTooLarge = errors.New("gossip: message too large")
+
+// 默认参数
+const (
+	DefaultFanout         = 3
+	DefaultMaxHops        = 4
+	DefaultGossipInterval = time.Second
+	DefaultDeadAfter      = 10 * time.Second
+)
+
+// envelope 节点间传输的UDP包
+type envelope struct {
+	ID   uint64
+	From string
+	Hops int
+	// Members 发送方已知的成员及最后一次直接收到其消息的时间(UnixNano)
+	Members map[string]int64
+	Payload []byte
+}
+
+// Config 节点参数, 零值字段使用默认值
+type Config struct {
+	// Seeds 初始联系的节点
+	Seeds []string
+	// Fanout 每次发送或转发选择的成员数
+	Fanout int
+	// MaxHops 消息最多被转发的次数
+	MaxHops int
+	// GossipInterval 同步成员列表的间隔
+	GossipInterval time.Duration
+	// DeadAfter 成员超过这么久没有消息即被移除
+	DeadAfter time.Duration
+}
+
+// Node 一个gossip节点, 实现cache.BusTransport
+type Node struct {
+	cfg  Config
+	conn *net.UDPConn
+	addr string
+
+	mu       sync.Mutex
+	seeds    map[string]bool
+	members  map[string]int64
+	seen     map[uint64]time.Time
+	handlers map[int]func([]byte)
+	nextSub  int
+	rnd      *rand.Rand
+
+	done chan struct{}
+}
+
+// Listen 在addr上监听并以addr作为本节点对外的地址, addr应是其他节点可以访问的IP和端口
+func Listen(addr string, cfg Config) (*Node, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Fanout <= 0 {
+		cfg.Fanout = DefaultFanout
+	}
+	if cfg.MaxHops <= 0 {
+		cfg.MaxHops = DefaultMaxHops
+	}
+	if cfg.GossipInterval <= 0 {
+		cfg.GossipInterval = DefaultGossipInterval
+	}
+	if cfg.DeadAfter <= 0 {
+		cfg.DeadAfter = DefaultDeadAfter
+	}
+	n := &Node{
+		cfg:      cfg,
+		conn:     conn,
+		addr:     conn.LocalAddr().String(),
+		seeds:    make(map[string]bool),
+		members:  make(map[string]int64),
+		seen:     make(map[uint64]time.Time),
+		handlers: make(map[int]func([]byte)),
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		done:     make(chan struct{}),
+	}
+	now := time.Now().UnixNano()
+	for _, seed := range cfg.Seeds {
+		if seed != n.addr {
+			n.seeds[seed] = true
+			n.members[seed] = now
+		}
+	}
+	go n.readLoop()
+	go n.gossipLoop()
+	return n, nil
+}
+
+// Addr 返回本节点的地址
+func (n *Node) Addr() string {
+	return n.addr
+}
+
+// Members 返回当前已知的其他成员, 按地址排序
+func (n *Node) Members() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	members := make([]string, 0, len(n.members))
+	for m := range n.members {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// Publish 把msg发给随机的Fanout个成员, 由它们继续转发
+func (n *Node) Publish(ctx context.Context, msg []byte) error {
+	n.mu.Lock()
+	env := &envelope{ID: n.rnd.Uint64(), From: n.addr, Payload: msg}
+	n.seen[env.ID] = time.Now()
+	targets := n.pick("")
+	n.mu.Unlock()
+	return n.send(env, targets)
+}
+
+// Subscribe 在ctx结束前把收到的消息交给handle. 本节点发布的消息不会交给自己
+func (n *Node) Subscribe(ctx context.Context, handle func(msg []byte)) error {
+	n.mu.Lock()
+	id := n.nextSub
+	n.nextSub++
+	n.handlers[id] = handle
+	n.mu.Unlock()
+	context.AfterFunc(ctx, func() {
+		n.mu.Lock()
+		delete(n.handlers, id)
+		n.mu.Unlock()
+	})
+	return nil
+}
+
+// Close 停止节点
+func (n *Node) Close() error {
+	select {
+	case <-n.done:
+		return nil
+	default:
+	}
+	close(n.done)
+	return n.conn.Close()
+}
+
+// pick 随机选出最多Fanout个成员, 不含except; 调用方需持有n.mu
+func (n *Node) pick(except string) []string {
+	candidates := make([]string, 0, len(n.members))
+	for m := range n.members {
+		if m != except {
+			candidates = append(candidates, m)
+		}
+	}
+	n.rnd.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if len(candidates) > n.cfg.Fanout {
+		candidates = candidates[:n.cfg.Fanout]
+	}
+	return candidates
+}
+
+func (n *Node) send(env *envelope, targets []string) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(env); err != nil {
+		return err
+	}
+	if buf.Len() > MaxMessageSize {
+		return ErrMessageTooLarge
+	}
+	var firstErr error
+	for _, target := range targets {
+		addr, err := net.ResolveUDPAddr("udp", target)
+		if err == nil {
+			_, err = n.conn.WriteToUDP(buf.Bytes(), addr)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (n *Node) readLoop() {
+	buf := make([]byte, 64*1024)
+	for {
+		size, _, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-n.done:
+				return
+			default:
+				continue
+			}
+		}
+		var env envelope
+		if err := gob.NewDecoder(bytes.NewReader(buf[:size])).Decode(&env); err != nil {
+			continue
+		}
+		n.receive(&env)
+	}
+}
+
+// receive 更新成员列表, 新消息交给订阅者并继续转发
+func (n *Node) receive(env *envelope) {
+	now := time.Now()
+	n.mu.Lock()
+	if env.From != "" && env.From != n.addr {
+		n.members[env.From] = now.UnixNano()
+	}
+	deadline := now.Add(-n.cfg.DeadAfter).UnixNano()
+	for m, heard := range env.Members {
+		if m != n.addr && heard > n.members[m] && heard > deadline {
+			n.members[m] = heard
+		}
+	}
+	if env.Payload == nil {
+		n.mu.Unlock()
+		return
+	}
+	if _, ok := n.seen[env.ID]; ok {
+		n.mu.Unlock()
+		return
+	}
+	n.seen[env.ID] = now
+	handlers := make([]func([]byte), 0, len(n.handlers))
+	for _, h := range n.handlers {
+		handlers = append(handlers, h)
+	}
+	var targets []string
+	if env.Hops < n.cfg.MaxHops {
+		targets = n.pick(env.From)
+	}
+	n.mu.Unlock()
+
+	for _, h := range handlers {
+		h(env.Payload)
+	}
+	if len(targets) > 0 {
+		n.send(&envelope{ID: env.ID, From: n.addr, Hops: env.Hops + 1, Payload: env.Payload}, targets)
+	}
+}
+
+// gossipLoop 定期同步成员列表, 并清理失联的成员和过期的消息ID
+func (n *Node) gossipLoop() {
+	ticker := time.NewTicker(n.cfg.GossipInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		n.mu.Lock()
+		deadline := now.Add(-n.cfg.DeadAfter).UnixNano()
+		members := map[string]int64{n.addr: now.UnixNano()}
+		for m, heard := range n.members {
+			if heard < deadline && !n.seeds[m] {
+				delete(n.members, m)
+				continue
+			}
+			members[m] = heard
+		}
+		for id, t := range n.seen {
+			if now.Sub(t) > time.Minute {
+				delete(n.seen, id)
+			}
+		}
+		targets := n.pick("")
+		n.mu.Unlock()
+		n.send(&envelope{From: n.addr, Members: members}, targets)
+	}
+}
@@ -0,0 +1,53 @@
+package gossip_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cache"
+	"cache/gossip"
+)
+
+func TestGossip(t *testing.T) {
+	cfg := gossip.Config{GossipInterval: 10 * time.Millisecond, Fanout: 1}
+	a, err := gossip.Listen("127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	cfg.Seeds = []string{a.Addr()}
+	b, _ := gossip.Listen("127.0.0.1:0", cfg)
+	defer b.Close()
+	cfg.Seeds = []string{b.Addr()}
+	c, _ := gossip.Listen("127.0.0.1:0", cfg)
+	defer c.Close()
+
+	// a只通过gossip得知c
+	waitFor(t, func() bool { return len(a.Members()) == 2 && len(c.Members()) == 2 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ta, tc := cache.NewTable("gossip"), cache.NewTable("gossip")
+	ta.AttachBus(ctx, a, cache.WithReplication())
+	tc.AttachBus(ctx, c, cache.WithReplication())
+
+	ta.Add("k", 0, "v1")
+	waitFor(t, func() bool { return tc.Exists("k") })
+	tc.Add("k", 0, "v2")
+	waitFor(t, func() bool {
+		item, err := ta.Peek("k")
+		return err == nil && item.Value() == "v2"
+	})
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 400; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Error condition not reached")
+}
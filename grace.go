@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"container/heap"
+	"time"
+)
+
+// graceEntry 宽限期内保留的过期元素
+type graceEntry struct {
+	item  *CacheItem
+	until time.Time
+	index int
+}
+
+// graceHeap 按宽限期结束时间排序的最小堆
+type graceHeap []*graceEntry
+
+func (h graceHeap) Len() int            { return len(h) }
+func (h graceHeap) Less(i, j int) bool  { return h[i].until.Before(h[j].until) }
+func (h graceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i]; h[i].index = i; h[j].index = j }
+func (h *graceHeap) Push(x interface{}) { e := x.(*graceEntry); e.index = len(*h); *h = append(*h, e) }
+func (h *graceHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+// graveyard 宽限期内保留的过期元素. 过期检查只弹出堆顶已到期的元素, 不扫描全部保留的元素
+type graveyard struct {
+	entries map[interface{}]*graceEntry
+	queue   graceHeap
+}
+
+// WithExpiredGrace 过期元素在移除后保留d时长, 见Settings.ExpiredGrace
+func WithExpiredGrace(d time.Duration) Option {
+	return func(t *CacheTable) { t.updateSettings(func(s *Settings) { s.ExpiredGrace = d }) }
+}
+
+// keepExpired 把过期移除的元素保留到宽限期结束, 调用方需持有表锁
+func (table *CacheTable) keepExpired(item *CacheItem, now time.Time) {
+	grace := table.Settings().ExpiredGrace
+	if grace <= 0 {
+		return
+	}
+	if table.graveyard == nil {
+		table.graveyard = &graveyard{entries: make(map[interface{}]*graceEntry)}
+	}
+	g := table.graveyard
+	if e, ok := g.entries[item.key]; ok {
+		e.item, e.until = item, now.Add(grace)
+		heap.Fix(&g.queue, e.index)
+		return
+	}
+	e := &graceEntry{item: item, until: now.Add(grace)}
+	g.entries[item.key] = e
+	heap.Push(&g.queue, e)
+}
+
+// dropExpired 写入新值后丢弃保留的过期元素, 调用方需持有表锁
+func (table *CacheTable) dropExpired(key interface{}) {
+	g := table.graveyard
+	if g == nil {
+		return
+	}
+	if e, ok := g.entries[key]; ok {
+		delete(g.entries, key)
+		heap.Remove(&g.queue, e.index)
+	}
+}
+
+// pruneGraveyard 清除宽限期已结束的元素, 返回距下一个元素宽限期结束的时长, 没有时返回0; 调用方需持有表锁
+func (table *CacheTable) pruneGraveyard(now time.Time) time.Duration {
+	g := table.graveyard
+	if g == nil {
+		return 0
+	}
+	for len(g.queue) > 0 {
+		e := g.queue[0]
+		if left := e.until.Sub(now); left > 0 {
+			return left
+		}
+		heap.Pop(&g.queue)
+		delete(g.entries, e.item.key)
+	}
+	return 0
+}
+
+// staleCopy 返回标记为过期的副本, 调用方需持有表锁
+func (table *CacheTable) staleCopy(key interface{}, now time.Time) (*CacheItem, bool) {
+	if table.graveyard == nil {
+		return nil, false
+	}
+	e, ok := table.graveyard.entries[key]
+	if !ok || !now.Before(e.until) {
+		return nil, false
+	}
+	item := e.item.copyMeta()
+	item.stale = true
+	return item, true
+}
+
+// Stale 返回宽限期内已过期的元素, 其Stale()为true, 可用于查看元素过期前的值和访问记录;
+// 未设置ExpiredGrace、元素已被重新写入或宽限期已结束时返回false
+func (table *CacheTable) Stale(key interface{}) (*CacheItem, bool) {
+	table.RLock()
+	defer table.RUnlock()
+	return table.staleCopy(canonicalKey(key), table.clock.Now())
+}
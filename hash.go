@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"fmt"
+	"math"
+)
+
+// Hasher 把键映射为64位哈希, 用于键锁分段(WithHasher)和节点选择(HashRing.SetHasher).
+// 用于节点选择时必须在所有进程中得到相同的结果, 因此不能使用随机种子的maphash
+type Hasher interface {
+	Hash(key interface{}) uint64
+}
+
+// HasherFunc 把函数适配为Hasher
+type HasherFunc func(key interface{}) uint64
+
+// Hash 实现Hasher
+func (f HasherFunc) Hash(key interface{}) uint64 {
+	return f(key)
+}
+
+// DefaultHasher 默认的Hasher: 字符串和[]byte使用FNV-1a, 整数、浮点数和布尔值直接打散, 其余类型按%#v格式化后计算.
+// 结果跨进程稳定; 自定义键类型格式化开销大或%#v包含指针地址时, 应提供自己的Hasher
+var DefaultHasher Hasher = HasherFunc(hashKey)
+
+// hashKey 计算键的哈希值, 常见的基本类型直接计算, 其余类型按%#v格式化后计算
+func hashKey(key interface{}) uint64 {
+	switch k := key.(type) {
+	case string:
+		return hashString(k)
+	case []byte:
+		return hashString(string(k))
+	case int:
+		return mix64(uint64(k))
+	case int64:
+		return mix64(uint64(k))
+	case int32:
+		return mix64(uint64(k))
+	case uint:
+		return mix64(uint64(k))
+	case uint64:
+		return mix64(k)
+	case uint32:
+		return mix64(uint64(k))
+	case float64:
+		return mix64(math.Float64bits(k))
+	case bool:
+		if k {
+			return mix64(1)
+		}
+		return mix64(0)
+	}
+	return hashString(fmt.Sprintf("%#v", key))
+}
+
+// hashString 计算FNV-1a, 与hash/fnv的结果相同但不分配内存
+func hashString(s string) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= prime64
+	}
+	return h
+}
+
+// mix64 打散整数键, 避免连续的键落在相邻的分片
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// HealthThresholds Health判断表是否正常的阈值, 为0的项不检查
+type HealthThresholds struct {
+	// MaxSweepLag 最早过期但还未被移除的元素已过期的时长上限, 超出说明过期检查没有运行
+	MaxSweepLag time.Duration
+	// MaxLoaderFailureRate 加载函数未返回数据的调用比例上限
+	MaxLoaderFailureRate float64
+	// MaxPersistLag 设置了快照文件时, 距上次成功Persist的时长上限
+	MaxPersistLag time.Duration
+}
+
+// DefaultHealthThresholds 新建表的默认阈值, 只检查过期检查是否停滞
+var DefaultHealthThresholds = HealthThresholds{MaxSweepLag: time.Minute}
+
+// TableHealth 表的健康状态
+type TableHealth struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	// Problems 超出阈值的项, Healthy为true时为空
+	Problems []string `json:"problems,omitempty"`
+
+	// LastSweep 最近一次过期检查的时间
+	LastSweep time.Time `json:"last_sweep"`
+	// SweepBacklog 已过期但还未被移除的元素数
+	SweepBacklog int `json:"sweep_backlog"`
+	// SweepLag 其中最早过期的元素已过期的时长
+	SweepLag time.Duration `json:"sweep_lag"`
+
+	// LoaderCalls, LoaderFailureRate 表创建以来加载函数的调用次数和未返回数据的比例
+	LoaderCalls       uint64  `json:"loader_calls"`
+	LoaderFailureRate float64 `json:"loader_failure_rate"`
+
+	// LastPersist 最近一次成功Persist的时间, PersistLag为距今的时长; 未设置快照文件时均为零值
+	LastPersist  time.Time     `json:"last_persist"`
+	PersistLag   time.Duration `json:"persist_lag"`
+	PersistError string        `json:"persist_error,omitempty"`
+}
+
+// RegistryHealth 所有已注册表的健康状态, 任一表不正常时Healthy为false
+type RegistryHealth struct {
+	Healthy bool          `json:"healthy"`
+	Tables  []TableHealth `json:"tables"`
+}
+
+// SetHealthThresholds 设置Health使用的阈值
+func (table *CacheTable) SetHealthThresholds(th HealthThresholds) {
+	table.Lock()
+	defer table.Unlock()
+	table.healthThresholds = th
+}
+
+// WithHealthThresholds 同SetHealthThresholds
+func WithHealthThresholds(th HealthThresholds) Option {
+	return func(t *CacheTable) { t.healthThresholds = th }
+}
+
+// Health 返回表的健康状态, 需要遍历整表统计过期积压
+func (table *CacheTable) Health() TableHealth {
+	table.RLock()
+	now := table.clock.Now()
+	h := TableHealth{
+		Name:        table.name,
+		LastSweep:   table.lastSweep,
+		LastPersist: table.lastPersist,
+	}
+	table.items.each(func(_ interface{}, item *CacheItem) bool {
+		item.RLock()
+		lifeSpan, accessedOn := item.lifeSpan, item.accessedOn
+		item.RUnlock()
+		if lifeSpan == 0 {
+			return true
+		}
+		if lag := now.Sub(accessedOn) - lifeSpan; lag >= 0 {
+			h.SweepBacklog++
+			if lag > h.SweepLag {
+				h.SweepLag = lag
+			}
+		}
+		return true
+	})
+	snapshotFile := table.snapshotFile
+	persistErr := table.persistErr
+	th := table.healthThresholds
+	closed := table.closed
+	table.RUnlock()
+
+	h.LoaderCalls = table.loaderLatency.summary().Count
+	if h.LoaderCalls > 0 {
+		h.LoaderFailureRate = float64(atomic.LoadUint64(&table.loaderFailures)) / float64(h.LoaderCalls)
+	}
+	if snapshotFile != "" && !h.LastPersist.IsZero() {
+		h.PersistLag = now.Sub(h.LastPersist)
+	}
+	if persistErr != nil {
+		h.PersistError = persistErr.Error()
+	}
+
+	if closed {
+		h.Problems = append(h.Problems, "table closed")
+	}
+	if th.MaxSweepLag > 0 && h.SweepLag > th.MaxSweepLag {
+		h.Problems = append(h.Problems, fmt.Sprintf("expiration sweep lagging by %v with %d expired items", h.SweepLag, h.SweepBacklog))
+	}
+	if th.MaxLoaderFailureRate > 0 && h.LoaderFailureRate > th.MaxLoaderFailureRate {
+		h.Problems = append(h.Problems, fmt.Sprintf("loader failure rate %.2f", h.LoaderFailureRate))
+	}
+	if th.MaxPersistLag > 0 && snapshotFile != "" {
+		switch {
+		case h.LastPersist.IsZero():
+			h.Problems = append(h.Problems, "never persisted")
+		case h.PersistLag > th.MaxPersistLag:
+			h.Problems = append(h.Problems, fmt.Sprintf("last persisted %v ago", h.PersistLag))
+		}
+	}
+	h.Healthy = len(h.Problems) == 0
+	return h
+}
+
+// Health 返回所有已注册表的健康状态, 按表名排序
+func Health() RegistryHealth {
+	r := RegistryHealth{Healthy: true, Tables: []TableHealth{}}
+	for _, t := range registered() {
+		h := t.Health()
+		r.Healthy = r.Healthy && h.Healthy
+		r.Tables = append(r.Tables, h)
+	}
+	sort.Slice(r.Tables, func(i, j int) bool { return r.Tables[i].Name < r.Tables[j].Name })
+	return r
+}
+
+// HealthHandler 返回Health结果的http.Handler, 可挂载为/healthz; 全部正常时返回200, 否则返回503
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := Health()
+		status := http.StatusOK
+		if !h.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, h)
+	})
+}
@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+// ImmutableConfig 不可变检查的配置. 开启后写入时记录值编码后的校验和, 在元素移除时和定期检查时重新计算,
+// 不一致说明取出的值被原地修改了
+type ImmutableConfig struct {
+	// Encode 计算校验和前编码值, 默认为json.Marshal(map按键排序, 结果稳定, 但不含未导出字段);
+	// 编码出错的值不做检查
+	Encode func(v interface{}) ([]byte, error)
+	// Interval 大于0时每隔Interval检查全部元素
+	Interval time.Duration
+	// Panic 为true时发现修改后panic, 否则以EventMutated记录错误日志. 移除时的检查持有表锁,
+	// 因此只应在测试和调试环境中使用Panic
+	Panic bool
+}
+
+// immutableState 不可变检查的状态
+type immutableState struct {
+	config ImmutableConfig
+	timer  Timer
+}
+
+// SetImmutable 开启不可变检查, 只对之后写入的元素生效; cfg为nil时关闭
+func (table *CacheTable) SetImmutable(cfg *ImmutableConfig) {
+	table.Lock()
+	defer table.Unlock()
+	table.setImmutable(cfg)
+}
+
+// WithImmutable 同SetImmutable
+func WithImmutable(cfg ImmutableConfig) Option {
+	return func(t *CacheTable) { t.setImmutable(&cfg) }
+}
+
+func (table *CacheTable) setImmutable(cfg *ImmutableConfig) {
+	if table.immutable != nil && table.immutable.timer != nil {
+		table.immutable.timer.Stop()
+	}
+	if cfg == nil {
+		table.immutable = nil
+		return
+	}
+	state := &immutableState{config: *cfg}
+	if state.config.Encode == nil {
+		state.config.Encode = json.Marshal
+	}
+	table.immutable = state
+	if state.config.Interval > 0 {
+		state.timer = table.clock.AfterFunc(state.config.Interval, func() {
+			table.labeled(context.Background(), opLabelVerify, func(context.Context) { table.immutableTick(state) })
+		})
+	}
+}
+
+// immutableTick 定期检查, state已被替换或表已关闭时停止
+func (table *CacheTable) immutableTick(state *immutableState) {
+	table.VerifyImmutable()
+	table.Lock()
+	defer table.Unlock()
+	if table.immutable == state && !table.closed {
+		state.timer = table.clock.AfterFunc(state.config.Interval, func() {
+			table.labeled(context.Background(), opLabelVerify, func(context.Context) { table.immutableTick(state) })
+		})
+	}
+}
+
+// checksum 计算值的校验和, 编码出错时ok为false
+func (s *immutableState) checksum(v interface{}) (sum uint64, ok bool) {
+	b, err := s.config.Encode(v)
+	if err != nil {
+		return 0, false
+	}
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64(), true
+}
+
+// stampChecksum 记录新写入元素的校验和, 调用方需持有表锁
+func (table *CacheTable) stampChecksum(item *CacheItem) {
+	if table.immutable == nil {
+		return
+	}
+	item.checksum, item.checksummed = table.immutable.checksum(item.value)
+}
+
+// mutated 返回元素的值在写入后是否被修改
+func (s *immutableState) mutated(item *CacheItem) bool {
+	if !item.checksummed {
+		return false
+	}
+	sum, ok := s.checksum(item.value)
+	return ok && sum != item.checksum
+}
+
+// checkRemoved 移除元素时检查, 调用方需持有表锁
+func (table *CacheTable) checkRemoved(item *CacheItem) {
+	if table.immutable != nil && table.immutable.mutated(item) {
+		table.reportMutated(table.immutable, item.key)
+	}
+}
+
+// reportMutated 记录或panic, 调用方需持有表锁(RLock即可)
+func (table *CacheTable) reportMutated(s *immutableState, key interface{}) {
+	table.logEvent(LevelError, EventMutated, key, "Cached value was mutated in place")
+	if s.config.Panic {
+		panic(fmt.Sprintf("cache: value of key %v in table %s was mutated in place", key, table.name))
+	}
+}
+
+// VerifyImmutable 立即检查全部元素, 返回值被修改过的键; 未开启SetImmutable时返回nil.
+// 校验和在表锁外计算, 检查期间被替换的元素仍按旧值检查
+func (table *CacheTable) VerifyImmutable() []interface{} {
+	table.RLock()
+	s := table.immutable
+	if s == nil {
+		table.RUnlock()
+		return nil
+	}
+	var items []*CacheItem
+	table.items.each(func(_ interface{}, item *CacheItem) bool {
+		if item.checksummed {
+			items = append(items, item)
+		}
+		return true
+	})
+	table.RUnlock()
+
+	var keys []interface{}
+	for _, item := range items {
+		if s.mutated(item) {
+			keys = append(keys, item.key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	table.RLock()
+	defer table.RUnlock()
+	for _, key := range keys {
+		table.reportMutated(s, key)
+	}
+	return keys
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// CallKind 经过中间件的操作类型
+type CallKind int
+
+const (
+	// CallGet Value
+	CallGet CallKind = iota
+	// CallAdd Add, AddContext, TryAdd和TryAddContext
+	CallAdd
+	// CallDelete Delete和DeleteContext
+	CallDelete
+)
+
+func (k CallKind) String() string {
+	switch k {
+	case CallGet:
+		return "get"
+	case CallAdd:
+		return "add"
+	case CallDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+func (k CallKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *CallKind) UnmarshalText(text []byte) error {
+	for _, candidate := range []CallKind{CallGet, CallAdd, CallDelete} {
+		if string(text) == candidate.String() {
+			*k = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("cache: unknown call kind %q", text)
+}
+
+// Call 一次经过中间件的调用, 中间件可以修改其中的字段(如改写Key)后交给下一层
+type Call struct {
+	Kind  CallKind
+	Table string
+	Key   interface{}
+	// Value, LifeSpan 只用于CallAdd
+	Value    interface{}
+	LifeSpan time.Duration
+	// Args 只用于CallGet, 传给加载函数的参数
+	Args []interface{}
+}
+
+// Handler 处理一次调用, 返回读到、写入或删除的元素
+type Handler func(ctx context.Context, call *Call) (*CacheItem, error)
+
+// Interceptor 包装Handler的中间件, 用于在不修改调用方的情况下加入指标、追踪、鉴权、键改写等逻辑
+type Interceptor func(next Handler) Handler
+
+// interceptorChain Use注册的中间件和组合后的Handler
+type interceptorChain struct {
+	mws     []Interceptor
+	handler Handler
+}
+
+// Use 追加中间件, 先注册的在外层. 中间件包装Value, Add和Delete系列方法;
+// 加载函数、总线和事务等内部写入不经过中间件
+func (table *CacheTable) Use(mws ...Interceptor) {
+	table.Lock()
+	defer table.Unlock()
+	var all []Interceptor
+	if cur := table.interceptors.Load(); cur != nil {
+		all = append(all, cur.mws...)
+	}
+	all = append(all, mws...)
+	h := Handler(table.dispatch)
+	for i := len(all) - 1; i >= 0; i-- {
+		h = all[i](h)
+	}
+	table.interceptors.Store(&interceptorChain{mws: all, handler: h})
+}
+
+// WithInterceptors 同Use
+func WithInterceptors(mws ...Interceptor) Option {
+	return func(t *CacheTable) { t.Use(mws...) }
+}
+
+// intercept 返回注册了中间件时的Handler, 否则返回nil
+func (table *CacheTable) intercept() Handler {
+	if chain := table.interceptors.Load(); chain != nil {
+		return chain.handler
+	}
+	return nil
+}
+
+// dispatch 中间件链最内层, 执行实际的操作
+func (table *CacheTable) dispatch(ctx context.Context, call *Call) (*CacheItem, error) {
+	switch call.Kind {
+	case CallGet:
+		return table.getValue(ctx, call.Key, call.Args)
+	case CallAdd:
+		return table.tryAdd(ctx, call.Key, call.LifeSpan, call.Value)
+	case CallDelete:
+		return table.delete(ctx, call.Key)
+	}
+	return nil, ErrKeyNotFound
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// typeError reports that the item's value cannot be converted to want.
+func (item *CacheItem) typeError(want string) error {
+	return fmt.Errorf("%w: key %v holds %T, want %s", ErrTypeMismatch, item.key, item.value, want)
+}
+
+// String returns the value as a string. Strings, byte slices, fmt.Stringers,
+// booleans and numbers are converted; other types return ErrTypeMismatch.
+func (item *CacheItem) String() (string, error) {
+	switch v := item.value.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case fmt.Stringer:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case int, int8, int16, int32, int64, uint8, uint16, uint32:
+		return fmt.Sprint(v), nil
+	}
+	return "", item.typeError("string")
+}
+
+// Int64 returns the value as an int64. Integer types are converted when they
+// fit, floats when they hold a whole number, and strings are parsed.
+func (item *CacheItem) Int64() (int64, error) {
+	switch v := item.value.(type) {
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint:
+		if uint64(v) <= math.MaxInt64 {
+			return int64(v), nil
+		}
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		if v <= math.MaxInt64 {
+			return int64(v), nil
+		}
+	case float32:
+		if f := float64(v); f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			return int64(f), nil
+		}
+	case float64:
+		if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+			return int64(v), nil
+		}
+	case string:
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n, nil
+		}
+	case []byte:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return n, nil
+		}
+	}
+	return 0, item.typeError("int64")
+}
+
+// Bytes returns the value as a byte slice. Only byte slices and strings are
+// accepted; the returned slice must not be modified.
+func (item *CacheItem) Bytes() ([]byte, error) {
+	switch v := item.value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, item.typeError("[]byte")
+}
+
+// Bool returns the value as a bool. Strings are parsed with strconv.ParseBool.
+func (item *CacheItem) Bool() (bool, error) {
+	switch v := item.value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b, nil
+		}
+	}
+	return false, item.typeError("bool")
+}
@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeySeparator Key拼接各部分使用的分隔符
+const KeySeparator = ":"
+
+// MaxKeySegment 单个部分超过这么多字节时以哈希代替
+const MaxKeySegment = 64
+
+// Keyer 自定义键的规范形式. 实现Keyer的键(如切片、较大的结构体)在表中以CacheKey()返回的字符串保存和查找,
+// 因此不可比较的类型也能作为键; CacheKey()相同的键视为同一个键, 也与同值的字符串键相同.
+// 元素的Key()和删除回调看到的是该字符串, 加载函数收到的是原始键
+type Keyer interface {
+	CacheKey() string
+}
+
+// canonicalKey 返回键在表中使用的形式
+func canonicalKey(key interface{}) interface{} {
+	if k, ok := key.(Keyer); ok {
+		return k.CacheKey()
+	}
+	return key
+}
+
+// keyEscaper 转义字符串部分中的分隔符和类型标记, 使"a:b"与("a", "b")不会得到相同的键
+var keyEscaper = strings.NewReplacer("%", "%25", ":", "%3A", "#", "%23")
+
+// Key 把各部分拼接成稳定的字符串键, 如 Key("user", 42, "profile") == "user:#42:profile".
+// 字符串原样保留(转义分隔符), 其他类型带#前缀, 因此Key("a", 1)与Key("a", "1")不同;
+// 超过MaxKeySegment字节的部分替换为#h加SHA-256前16字节的十六进制
+func Key(parts ...interface{}) string {
+	var b strings.Builder
+	for i, part := range parts {
+		if i > 0 {
+			b.WriteString(KeySeparator)
+		}
+		b.WriteString(keySegment(part))
+	}
+	return b.String()
+}
+
+// KeyPrefix 由Prefix创建的键前缀, 用于为一类键统一加前缀
+//
+//	users := cache.Prefix("svc", "user")
+//	key := users.Key(id, "profile") // "svc:user:#42:profile"
+type KeyPrefix string
+
+// Prefix 返回由parts组成的键前缀, 编码规则同Key
+func Prefix(parts ...interface{}) KeyPrefix {
+	return KeyPrefix(Key(parts...))
+}
+
+// Key 返回以p为前缀的键
+func (p KeyPrefix) Key(parts ...interface{}) string {
+	if len(parts) == 0 {
+		return string(p)
+	}
+	if p == "" {
+		return Key(parts...)
+	}
+	return string(p) + KeySeparator + Key(parts...)
+}
+
+// Prefix 返回在p之后追加parts的前缀
+func (p KeyPrefix) Prefix(parts ...interface{}) KeyPrefix {
+	return KeyPrefix(p.Key(parts...))
+}
+
+func keySegment(part interface{}) string {
+	var s string
+	switch v := part.(type) {
+	case nil:
+		return "#nil"
+	case KeyPrefix:
+		return string(v)
+	case Keyer:
+		s = "#k" + keyEscaper.Replace(v.CacheKey())
+	case string:
+		s = keyEscaper.Replace(v)
+	case []byte:
+		s = "#x" + hex.EncodeToString(v)
+	case int:
+		s = "#" + strconv.FormatInt(int64(v), 10)
+	case int8:
+		s = "#" + strconv.FormatInt(int64(v), 10)
+	case int16:
+		s = "#" + strconv.FormatInt(int64(v), 10)
+	case int32:
+		s = "#" + strconv.FormatInt(int64(v), 10)
+	case int64:
+		s = "#" + strconv.FormatInt(v, 10)
+	case uint:
+		s = "#" + strconv.FormatUint(uint64(v), 10)
+	case uint8:
+		s = "#" + strconv.FormatUint(uint64(v), 10)
+	case uint16:
+		s = "#" + strconv.FormatUint(uint64(v), 10)
+	case uint32:
+		s = "#" + strconv.FormatUint(uint64(v), 10)
+	case uint64:
+		s = "#" + strconv.FormatUint(v, 10)
+	case float32:
+		s = "#f" + strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		s = "#f" + strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		s = "#" + strconv.FormatBool(v)
+	case time.Time:
+		s = "#t" + v.UTC().Format(time.RFC3339Nano)
+	case time.Duration:
+		s = "#d" + strconv.FormatInt(int64(v), 10)
+	case fmt.Stringer:
+		s = "#s" + keyEscaper.Replace(v.String())
+	default:
+		s = "#v" + keyEscaper.Replace(fmt.Sprintf("%#v", v))
+	}
+	if len(s) > MaxKeySegment {
+		sum := sha256.Sum256([]byte(s))
+		s = "#h" + hex.EncodeToString(sum[:16])
+	}
+	return s
+}
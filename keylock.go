@@ -0,0 +1,80 @@
+package cache
+
+import "sync"
+
+// DefaultKeyMutexStripes KeyMutex零值和表的键锁使用的分段数量
+const DefaultKeyMutexStripes = 256
+
+// KeyMutex 按键哈希分段的互斥锁, 提供"每个动态键一把锁"的语义而不需要为每个键分配锁.
+// 不同的键可能落在同一段而互相等待. 零值可用, 使用DefaultKeyMutexStripes个分段
+type KeyMutex struct {
+	once    sync.Once
+	stripes []sync.Mutex
+	hasher  Hasher
+}
+
+// NewKeyMutex 创建有stripes个分段的KeyMutex, stripes<=0时使用DefaultKeyMutexStripes
+func NewKeyMutex(stripes int) *KeyMutex {
+	m := &KeyMutex{}
+	m.init(stripes)
+	return m
+}
+
+func (m *KeyMutex) init(stripes int) {
+	m.once.Do(func() {
+		if stripes <= 0 {
+			stripes = DefaultKeyMutexStripes
+		}
+		m.stripes = make([]sync.Mutex, stripes)
+	})
+}
+
+// SetHasher 设置键的哈希函数, 默认为DefaultHasher; 必须在第一次加锁前调用
+func (m *KeyMutex) SetHasher(h Hasher) {
+	m.hasher = h
+}
+
+func (m *KeyMutex) stripe(key interface{}) *sync.Mutex {
+	m.init(DefaultKeyMutexStripes)
+	key = canonicalKey(key)
+	var h uint64
+	if m.hasher != nil {
+		h = m.hasher.Hash(key)
+	} else {
+		h = hashKey(key)
+	}
+	return &m.stripes[h%uint64(len(m.stripes))]
+}
+
+// Lock 获取key对应的锁并返回解锁函数; 同一协程重复加锁会死锁
+func (m *KeyMutex) Lock(key interface{}) (unlock func()) {
+	s := m.stripe(key)
+	s.Lock()
+	return s.Unlock
+}
+
+// TryLock 尝试获取key对应的锁, 成功时返回解锁函数和true, 不会阻塞
+func (m *KeyMutex) TryLock(key interface{}) (unlock func(), ok bool) {
+	s := m.stripe(key)
+	if !s.TryLock() {
+		return nil, false
+	}
+	return s.Unlock, true
+}
+
+// Stripes 返回分段数量
+func (m *KeyMutex) Stripes() int {
+	m.init(DefaultKeyMutexStripes)
+	return len(m.stripes)
+}
+
+// LockKey 获取key对应的锁并返回解锁函数, 与键是否存在无关, 也不影响表的其他操作.
+// 用于把针对同一个键的多步操作串行化, 见KeyMutex
+func (table *CacheTable) LockKey(key interface{}) (unlock func()) {
+	return table.keyLocks.Lock(key)
+}
+
+// TryLockKey 尝试获取key对应的锁, 成功时返回解锁函数和true, 不会阻塞
+func (table *CacheTable) TryLockKey(key interface{}) (unlock func(), ok bool) {
+	return table.keyLocks.TryLock(key)
+}
@@ -0,0 +1,267 @@
+package cache
+
+import (
+	"context"
+	"encoding/gob"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LeaseManager 发放加载键的租约: 多个进程同时未命中同一个键时, 只有持有租约的进程调用加载函数.
+// LocalLeases在单进程内发放; HTTPPool把请求交给键的拥有者, 在集群内发放
+type LeaseManager interface {
+	// Acquire 尝试获得table中key的租约, ttl后自动失效. 获得时返回非零token, 已被其他调用方持有时返回0
+	Acquire(ctx context.Context, table string, key interface{}, ttl time.Duration) (token uint64, err error)
+	// Release 提前释放租约, token不匹配时忽略
+	Release(ctx context.Context, table string, key interface{}, token uint64) error
+}
+
+// 租约的默认参数
+const (
+	DefaultLeaseTTL  = 5 * time.Second
+	DefaultLeaseWait = time.Second
+)
+
+// LeaseConfig 表的租约配置, 零值字段使用默认值
+type LeaseConfig struct {
+	// TTL 租约的有效期, 应长于一次加载的耗时
+	TTL time.Duration
+	// Wait 未获得租约时最多等待这么久, 期间值写入表中(如通过WithReplication的总线)即返回; 超时后自行加载
+	Wait time.Duration
+	// StaleFor 元素过期后旧值保留的时长, 未获得租约时直接返回旧值而不等待; 0表示不保留
+	StaleFor time.Duration
+}
+
+// LocalLeases 进程内的LeaseManager, 零值可用
+type LocalLeases struct {
+	mu     sync.Mutex
+	leases map[leaseKey]localLease
+}
+
+type leaseKey struct {
+	table string
+	key   interface{}
+}
+
+type localLease struct {
+	token   uint64
+	expires time.Time
+}
+
+// NewLocalLeases 创建进程内的LeaseManager
+func NewLocalLeases() *LocalLeases {
+	return &LocalLeases{}
+}
+
+// Acquire 实现LeaseManager
+func (l *LocalLeases) Acquire(ctx context.Context, table string, key interface{}, ttl time.Duration) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.leases == nil {
+		l.leases = make(map[leaseKey]localLease)
+	}
+	lk := leaseKey{table, key}
+	if cur, ok := l.leases[lk]; ok && now.Before(cur.expires) {
+		return 0, nil
+	}
+	token := rand.Uint64() | 1
+	l.leases[lk] = localLease{token: token, expires: now.Add(ttl)}
+	// 顺带清理过期的租约
+	if len(l.leases) > 1024 {
+		for k, lease := range l.leases {
+			if !now.Before(lease.expires) {
+				delete(l.leases, k)
+			}
+		}
+	}
+	return token, nil
+}
+
+// Release 实现LeaseManager
+func (l *LocalLeases) Release(ctx context.Context, table string, key interface{}, token uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lk := leaseKey{table, key}
+	if cur, ok := l.leases[lk]; ok && cur.token == token {
+		delete(l.leases, lk)
+	}
+	return nil
+}
+
+// leaseState 表的租约设置和过期后保留的旧值
+type leaseState struct {
+	manager LeaseManager
+	config  LeaseConfig
+	// stale, nextPrune 受表锁保护
+	stale     map[interface{}]staleEntry
+	nextPrune int
+}
+
+type staleEntry struct {
+	value    interface{}
+	lifeSpan time.Duration
+	until    time.Time
+}
+
+// SetLeases 设置租约, m为nil时关闭
+func (table *CacheTable) SetLeases(m LeaseManager, cfg LeaseConfig) {
+	table.Lock()
+	defer table.Unlock()
+	table.setLeases(m, cfg)
+}
+
+// WithLeases 同SetLeases
+func WithLeases(m LeaseManager, cfg LeaseConfig) Option {
+	return func(t *CacheTable) { t.setLeases(m, cfg) }
+}
+
+func (table *CacheTable) setLeases(m LeaseManager, cfg LeaseConfig) {
+	if m == nil {
+		table.leases = nil
+		return
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultLeaseTTL
+	}
+	if cfg.Wait <= 0 {
+		cfg.Wait = DefaultLeaseWait
+	}
+	table.leases = &leaseState{manager: m, config: cfg}
+}
+
+// keepStale 保留过期元素的旧值, 调用方需持有表锁
+func (table *CacheTable) keepStale(item *CacheItem, now time.Time) {
+	ls := table.leases
+	if ls == nil || ls.config.StaleFor <= 0 {
+		return
+	}
+	if ls.stale == nil {
+		ls.stale = make(map[interface{}]staleEntry)
+	}
+	if len(ls.stale) >= ls.nextPrune {
+		for k, e := range ls.stale {
+			if !now.Before(e.until) {
+				delete(ls.stale, k)
+			}
+		}
+		ls.nextPrune = 2*len(ls.stale) + 64
+	}
+	item.RLock()
+	ls.stale[item.key] = staleEntry{value: item.value, lifeSpan: item.lifeSpan, until: now.Add(ls.config.StaleFor)}
+	item.RUnlock()
+}
+
+// dropStale 写入新值后丢弃旧值, 调用方需持有表锁
+func (table *CacheTable) dropStale(key interface{}) {
+	if table.leases != nil && table.leases.stale != nil {
+		delete(table.leases.stale, key)
+	}
+}
+
+// loadWithLease 持有租约时调用加载函数; 其他进程持有租约时返回保留的旧值,
+// 或等待值出现在表中, 等待超时或租约服务出错时自行加载
+func (table *CacheTable) loadWithLease(ctx context.Context, key interface{}, loadData loaderFunc, args []interface{}) (*CacheItem, error) {
+	table.RLock()
+	ls := table.leases
+	name := table.name
+	table.RUnlock()
+	if ls == nil {
+		return table.load(ctx, key, loadData, args)
+	}
+
+	ck := canonicalKey(key)
+	deadline := time.Now().Add(ls.config.Wait)
+	delay := time.Millisecond
+	for {
+		// 上一个租约持有者可能已经写入了值
+		table.RLock()
+		item, ok := table.items.get(ck)
+		stale, hasStale := ls.stale[ck]
+		now := table.clock.Now()
+		table.RUnlock()
+		if ok {
+			return item, nil
+		}
+
+		token, err := ls.manager.Acquire(ctx, name, ck, ls.config.TTL)
+		if err != nil {
+			table.RLock()
+			table.logEvent(LevelWarn, EventLease, key, "Acquiring lease failed, loading without lease", "error", err)
+			table.RUnlock()
+			return table.load(ctx, key, loadData, args)
+		}
+		if token != 0 {
+			defer ls.manager.Release(context.WithoutCancel(ctx), name, ck, token)
+			return table.load(ctx, key, loadData, args)
+		}
+		if hasStale && now.Before(stale.until) {
+			return NewCacheItem(key, stale.lifeSpan, stale.value), nil
+		}
+		if !time.Now().Before(deadline) {
+			table.RLock()
+			table.logEvent(LevelDebug, EventLease, key, "Waiting for lease holder timed out, loading", "wait", ls.config.Wait)
+			table.RUnlock()
+			return table.load(ctx, key, loadData, args)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay < 50*time.Millisecond {
+			delay *= 2
+		}
+	}
+}
+
+// Acquire 实现LeaseManager, 租约由键的拥有者发放
+func (p *HTTPPool) Acquire(ctx context.Context, table string, key interface{}, ttl time.Duration) (uint64, error) {
+	peer, ok := p.PickPeer(key)
+	if !ok {
+		return p.leases.Acquire(ctx, table, key, ttl)
+	}
+	return peer.(*httpPeer).lease(ctx, table, peerRequest{Key: key, Lease: leaseAcquire, LeaseTTL: ttl})
+}
+
+// Release 实现LeaseManager
+func (p *HTTPPool) Release(ctx context.Context, table string, key interface{}, token uint64) error {
+	peer, ok := p.PickPeer(key)
+	if !ok {
+		return p.leases.Release(ctx, table, key, token)
+	}
+	_, err := peer.(*httpPeer).lease(ctx, table, peerRequest{Key: key, Lease: leaseRelease, LeaseToken: token})
+	return err
+}
+
+// peerRequest.Lease的取值
+const (
+	leaseAcquire = iota + 1
+	leaseRelease
+)
+
+// serveLease 处理其他节点的租约请求, 与其他节点间请求一样经过HTTPPool.Security鉴权
+func (p *HTTPPool) serveLease(ctx context.Context, table string, req *peerRequest) (uint64, error) {
+	if req.Lease == leaseAcquire {
+		return p.leases.Acquire(ctx, table, req.Key, req.LeaseTTL)
+	}
+	return 0, p.leases.Release(ctx, table, req.Key, req.LeaseToken)
+}
+
+func (h *httpPeer) lease(ctx context.Context, table string, req peerRequest) (uint64, error) {
+	resp, err := h.post(ctx, table, req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, h.statusError(resp)
+	}
+	var res peerResponse
+	if err := gob.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return 0, err
+	}
+	return res.LeaseToken, nil
+}
@@ -0,0 +1,295 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel 日志级别
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// 日志事件类别, 用于SetLogRateLimit/SetLogSampling
+const (
+	EventAdd             = "add"
+	EventDelete          = "delete"
+	EventExpirationCheck = "expiration_check"
+	EventFlush           = "flush"
+	EventLoadFailed      = "load_failed"
+	EventClose           = "close"
+	EventEvict           = "evict"
+	EventSettings        = "settings"
+	EventReplaceAll      = "replace_all"
+	EventTx              = "tx"
+	EventCDCOverflow     = "cdc_overflow"
+	EventPeerFetchFailed = "peer_fetch_failed"
+	EventBus             = "bus"
+	EventRebalance       = "rebalance"
+	EventLease           = "lease"
+	EventRejected        = "rejected"
+	EventMutated         = "mutated"
+	EventRestore         = "restore"
+)
+
+// Logger 分级日志接口. *zap.SugaredLogger 已实现该接口, 可直接传入
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// fieldLogger 支持结构化字段的Logger额外实现该接口, kv为交替的字段名和值
+type fieldLogger interface {
+	logFields(level LogLevel, msg string, kv ...interface{})
+}
+
+type stdLogger struct {
+	l *log.Logger
+}
+
+// StdLogger 把*log.Logger适配为Logger, 级别作为前缀输出
+func StdLogger(l *log.Logger) Logger {
+	return stdLogger{l}
+}
+
+func (s stdLogger) Debugf(format string, args ...interface{}) { s.l.Printf("DEBUG "+format, args...) }
+func (s stdLogger) Infof(format string, args ...interface{})  { s.l.Printf("INFO "+format, args...) }
+func (s stdLogger) Warnf(format string, args ...interface{})  { s.l.Printf("WARN "+format, args...) }
+func (s stdLogger) Errorf(format string, args ...interface{}) { s.l.Printf("ERROR "+format, args...) }
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// SlogLogger 把*slog.Logger适配为Logger, 表事件以结构化字段输出
+func SlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+func (s slogLogger) Debugf(format string, args ...interface{}) {
+	s.l.Debug(fmt.Sprintf(format, args...))
+}
+func (s slogLogger) Infof(format string, args ...interface{}) {
+	s.l.Info(fmt.Sprintf(format, args...))
+}
+func (s slogLogger) Warnf(format string, args ...interface{}) {
+	s.l.Warn(fmt.Sprintf(format, args...))
+}
+func (s slogLogger) Errorf(format string, args ...interface{}) {
+	s.l.Error(fmt.Sprintf(format, args...))
+}
+
+func (s slogLogger) logFields(level LogLevel, msg string, kv ...interface{}) {
+	var l slog.Level
+	switch level {
+	case LevelDebug:
+		l = slog.LevelDebug
+	case LevelInfo:
+		l = slog.LevelInfo
+	case LevelWarn:
+		l = slog.LevelWarn
+	default:
+		l = slog.LevelError
+	}
+	s.l.Log(context.Background(), l, msg, kv...)
+}
+
+// SugaredLogger *zap.SugaredLogger中ZapLogger用到的方法, 本包不依赖zap
+type SugaredLogger interface {
+	Logger
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+type zapLogger struct {
+	SugaredLogger
+}
+
+// ZapLogger 把*zap.SugaredLogger(或其他实现SugaredLogger的类型)适配为Logger, 表事件以结构化字段输出.
+// *zap.SugaredLogger也可直接传给SetLeveledLogger, 但只输出格式化的文本
+func ZapLogger(l SugaredLogger) Logger {
+	return zapLogger{l}
+}
+
+func (z zapLogger) logFields(level LogLevel, msg string, kv ...interface{}) {
+	switch level {
+	case LevelDebug:
+		z.Debugw(msg, kv...)
+	case LevelInfo:
+		z.Infow(msg, kv...)
+	case LevelWarn:
+		z.Warnw(msg, kv...)
+	default:
+		z.Errorw(msg, kv...)
+	}
+}
+
+// 设置日志对象
+func (table *CacheTable) SetLogger(logger *log.Logger) {
+	if logger == nil {
+		table.SetLeveledLogger(nil)
+		return
+	}
+	table.SetLeveledLogger(StdLogger(logger))
+}
+
+// SetSlogLogger 设置结构化日志, 每条日志带table/event/key字段
+func (table *CacheTable) SetSlogLogger(logger *slog.Logger) {
+	if logger == nil {
+		table.SetLeveledLogger(nil)
+		return
+	}
+	table.SetLeveledLogger(SlogLogger(logger))
+}
+
+// SetLeveledLogger 设置分级日志, 传nil关闭日志
+func (table *CacheTable) SetLeveledLogger(logger Logger) {
+	table.Lock()
+	defer table.Unlock()
+	table.logger = logger
+}
+
+// eventLimit 单个事件类别的限流和采样状态
+type eventLimit struct {
+	perSecond   int
+	sampleEvery uint64
+
+	windowStart time.Time
+	inWindow    int
+	seen        uint64
+	dropped     uint64
+}
+
+// logLimiter 按事件类别限制日志输出, 有独立的锁以便在表读锁下使用
+type logLimiter struct {
+	mu     sync.Mutex
+	events map[string]*eventLimit
+}
+
+func (l *logLimiter) limit(event string) *eventLimit {
+	if l.events == nil {
+		l.events = make(map[string]*eventLimit)
+	}
+	e, ok := l.events[event]
+	if !ok {
+		e = &eventLimit{}
+		l.events[event] = e
+	}
+	return e
+}
+
+// allow 判断本条日志是否输出, 输出时返回此前被丢弃的条数
+func (l *logLimiter) allow(event string, now time.Time) (ok bool, dropped uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, found := l.events[event]
+	if !found {
+		return true, 0
+	}
+	e.seen++
+	if e.sampleEvery > 1 && (e.seen-1)%e.sampleEvery != 0 {
+		e.dropped++
+		return false, 0
+	}
+	if e.perSecond > 0 {
+		if now.Sub(e.windowStart) >= time.Second {
+			e.windowStart = now
+			e.inWindow = 0
+		}
+		if e.inWindow >= e.perSecond {
+			e.dropped++
+			return false, 0
+		}
+		e.inWindow++
+	}
+	dropped, e.dropped = e.dropped, 0
+	return true, dropped
+}
+
+// SetLogRateLimit 限制event类别的日志每秒最多输出perSecond条, perSecond<=0取消限制. Error级别的日志不受限制
+func (table *CacheTable) SetLogRateLimit(event string, perSecond int) {
+	table.logLimiter.mu.Lock()
+	defer table.logLimiter.mu.Unlock()
+	table.logLimiter.limit(event).perSecond = perSecond
+}
+
+// SetLogSampling event类别的日志每every条只输出一条, every<=1取消采样. Error级别的日志不受限制
+func (table *CacheTable) SetLogSampling(event string, every int) {
+	if every < 1 {
+		every = 1
+	}
+	table.logLimiter.mu.Lock()
+	defer table.logLimiter.mu.Unlock()
+	table.logLimiter.limit(event).sampleEvery = uint64(every)
+}
+
+// logEvent 记录一条表事件, kv为交替的字段名和值; 调用方需持有表锁
+func (table *CacheTable) logEvent(level LogLevel, event string, key interface{}, msg string, kv ...interface{}) {
+	if table.logger == nil {
+		return
+	}
+	if level < LevelError {
+		ok, dropped := table.logLimiter.allow(event, table.clock.Now())
+		if !ok {
+			return
+		}
+		if dropped > 0 {
+			kv = append(kv, "dropped", dropped)
+		}
+	}
+	if fl, ok := table.logger.(fieldLogger); ok {
+		fields := append([]interface{}{"table", table.name, "event", event}, kv...)
+		if key != nil {
+			fields = append(fields, "key", key)
+		}
+		fl.logFields(level, msg, fields...)
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s table=%s event=%s", msg, table.name, event)
+	if key != nil {
+		fmt.Fprintf(&b, " key=%v", key)
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", kv[i], kv[i+1])
+	}
+	switch level {
+	case LevelDebug:
+		table.logger.Debugf("%s", b.String())
+	case LevelInfo:
+		table.logger.Infof("%s", b.String())
+	case LevelWarn:
+		table.logger.Warnf("%s", b.String())
+	default:
+		table.logger.Errorf("%s", b.String())
+	}
+}
@@ -0,0 +1,70 @@
+package cache
+
+import "time"
+
+// MemoizeOption Memoize的配置项
+type MemoizeOption func(*memoizeConfig)
+
+type memoizeConfig struct {
+	errTTL time.Duration
+}
+
+// CacheErrors 把fn返回的错误也缓存ttl时长, 期间相同参数的调用直接返回该错误; 默认不缓存错误
+func CacheErrors(ttl time.Duration) MemoizeOption {
+	return func(c *memoizeConfig) { c.errTTL = ttl }
+}
+
+// memoizedError 缓存的错误结果
+type memoizedError struct {
+	err error
+}
+
+// Memoize 返回缓存fn结果的函数, 结果以keyFn(arg)为键在table中保存ttl时长.
+// 同一进程内相同键的并发调用只执行一次fn; 表中已有其他类型的值时视为未命中. table不应设置加载函数
+//
+//	getUser := cache.Memoize(table, func(id int) interface{} { return id }, time.Minute, loadUser)
+func Memoize[A, V any](table *CacheTable, keyFn func(A) interface{}, ttl time.Duration, fn func(A) (V, error), opts ...MemoizeOption) func(A) (V, error) {
+	var cfg memoizeConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	result := func(item *CacheItem) (V, bool, error) {
+		var zero V
+		// V可能是接口类型, 先判断错误结果
+		if e, ok := item.Value().(memoizedError); ok {
+			return zero, true, e.err
+		}
+		if v, ok := item.Value().(V); ok {
+			return v, true, nil
+		}
+		return zero, false, nil
+	}
+
+	return func(arg A) (V, error) {
+		key := keyFn(arg)
+		if item, err := table.Value(key); err == nil {
+			if v, ok, err := result(item); ok {
+				return v, err
+			}
+		}
+		item, err := table.flights.do(key, func() (*CacheItem, error) {
+			v, err := fn(arg)
+			if err != nil {
+				if cfg.errTTL > 0 {
+					table.Add(key, cfg.errTTL, memoizedError{err})
+				}
+				return NewCacheItem(key, 0, memoizedError{err}), nil
+			}
+			if item := table.Add(key, ttl, v); item != nil {
+				return item, nil
+			}
+			return NewCacheItem(key, ttl, v), nil
+		})
+		if err != nil {
+			var zero V
+			return zero, err
+		}
+		v, _, err := result(item)
+		return v, err
+	}
+}
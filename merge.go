@@ -0,0 +1,47 @@
+package cache
+
+import "context"
+
+// ConflictPolicy MergeFrom遇到两表都存在的键时的处理方式
+type ConflictPolicy int
+
+const (
+	// KeepExisting 保留当前表中的元素
+	KeepExisting ConflictPolicy = iota
+	// Overwrite 总是使用来源表的元素
+	Overwrite
+	// KeepNewest 保留创建时间较晚的元素, 相同时保留当前表的
+	KeepNewest
+)
+
+// MergeFrom 把other中的元素(含剩余有效期和访问统计)合并到当前表, 返回写入的元素数量.
+// 每个键的判断和写入在同一次加锁内完成, 并触发添加回调
+func (table *CacheTable) MergeFrom(other *CacheTable, conflict ConflictPolicy) int {
+	if other == table {
+		return 0
+	}
+	other.RLock()
+	items := other.items.values()
+	other.RUnlock()
+
+	merged := 0
+	for _, item := range items {
+		c := item.copyMeta()
+		// 合并得到的是独立的元素, 与来源表的元素分别离开各自的表
+		c.life = &itemLife{}
+		table.Lock()
+		if table.rejectsWrites() {
+			table.Unlock()
+			break
+		}
+		if existing, ok := table.items.get(c.key); ok {
+			if conflict == KeepExisting || (conflict == KeepNewest && !c.createdOn.After(existing.createdOn)) {
+				table.Unlock()
+				continue
+			}
+		}
+		table.addInternal(context.Background(), c)
+		merged++
+	}
+	return merged
+}
@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MiddlewareOption NewMiddleware的配置项
+type MiddlewareOption func(*Middleware)
+
+// WithVaryHeaders 把这些请求头的取值加入缓存键, 如Accept-Encoding, Accept-Language
+func WithVaryHeaders(names ...string) MiddlewareOption {
+	return func(m *Middleware) {
+		for _, name := range names {
+			m.vary = append(m.vary, http.CanonicalHeaderKey(name))
+		}
+	}
+}
+
+// Middleware 把处理函数的GET响应缓存在表中, 键为方法+路径(含查询参数)+WithVaryHeaders中的请求头.
+// 只缓存200响应, 带Set-Cookie或Cache-Control为no-store/private的响应不缓存
+type Middleware struct {
+	table *CacheTable
+	vary  []string
+}
+
+// NewMiddleware 创建把响应缓存在table中的中间件
+func NewMiddleware(table *CacheTable, opts ...MiddlewareOption) *Middleware {
+	m := &Middleware{table: table}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Handler 缓存next的响应ttl时长, 不同路由可以使用不同的ttl:
+//
+//	mux.Handle("/users/", m.Handler(time.Minute, usersHandler))
+func (m *Middleware) Handler(ttl time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := m.key(r)
+		if item, err := m.table.ValueContext(r.Context(), key); err == nil {
+			if c, ok := item.Value().(*CachedResponse); ok {
+				header := w.Header()
+				for name, values := range c.Header {
+					header[name] = values
+				}
+				header.Set(XFromCache, "1")
+				w.WriteHeader(c.StatusCode)
+				w.Write(c.Body)
+				return
+			}
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.cacheable() {
+			m.table.Add(key, ttl, &CachedResponse{StatusCode: rec.status, Header: rec.header, Body: rec.body.Bytes()})
+		}
+	})
+}
+
+// key 请求的缓存键; 路径在前, 便于按路径失效
+func (m *Middleware) key(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.URL.RequestURI())
+	b.WriteString(" ")
+	b.WriteString(r.Method)
+	for _, name := range m.vary {
+		b.WriteString("\n")
+		b.WriteString(name)
+		b.WriteString(": ")
+		b.WriteString(r.Header.Get(name))
+	}
+	return b.String()
+}
+
+// Invalidate 移除path(不含查询参数)的所有缓存响应
+func (m *Middleware) Invalidate(path string) int {
+	return m.invalidate(func(uri string) bool {
+		p, _, _ := strings.Cut(uri, "?")
+		return p == path
+	})
+}
+
+// InvalidatePrefix 移除路径以prefix开头的所有缓存响应
+func (m *Middleware) InvalidatePrefix(prefix string) int {
+	return m.invalidate(func(uri string) bool { return strings.HasPrefix(uri, prefix) })
+}
+
+// Purge 移除所有缓存响应
+func (m *Middleware) Purge() {
+	m.table.Flush()
+}
+
+func (m *Middleware) invalidate(match func(uri string) bool) int {
+	var keys []string
+	m.table.Foreach(func(key interface{}, item *CacheItem) {
+		if s, ok := key.(string); ok {
+			if uri, _, ok := strings.Cut(s, " "); ok && match(uri) {
+				keys = append(keys, s)
+			}
+		}
+	})
+	n := 0
+	for _, key := range keys {
+		if _, err := m.table.Delete(key); err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// responseRecorder 把响应直接写给客户端, 可缓存时同时保存一份
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+	// skip 响应不可缓存, 不再保存
+	skip bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status != 0 {
+		return
+	}
+	r.status = status
+	header := r.ResponseWriter.Header()
+	cc := parseCacheControl(header)
+	_, noStore := cc["no-store"]
+	_, private := cc["private"]
+	r.skip = status != http.StatusOK || noStore || private || header.Get("Set-Cookie") != ""
+	if !r.skip {
+		r.header = header.Clone()
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	n, err := r.ResponseWriter.Write(p)
+	if err != nil {
+		r.skip = true
+	}
+	if !r.skip {
+		r.body.Write(p[:n])
+	}
+	return n, err
+}
+
+// Flush 实现http.Flusher, 使流式响应在缓存时仍能及时发给客户端
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap 供http.ResponseController使用
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *responseRecorder) cacheable() bool {
+	if r.status == 0 {
+		// 处理函数没有写任何内容
+		r.WriteHeader(http.StatusOK)
+	}
+	return !r.skip
+}
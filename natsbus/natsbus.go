@@ -0,0 +1,238 @@
+// Package natsbus 用NATS实现cache.BusTransport, 可配合cache.WithReplication在进程间复制新值:
+//
+//	t := cache.Cache("users")
+//	err := t.AttachBus(ctx, natsbus.New("nats:4222", "cache.users"), cache.WithReplication())
+package natsbus
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transport NATS传输层. 发布使用一个共享连接, 每次Subscribe使用独立的连接, 断开后自动重连
+type Transport struct {
+	// Addr NATS地址, 如 localhost:4222
+	Addr string
+	// Subject 发布和订阅的主题
+	Subject string
+	// Token, User, Password 非空时在CONNECT中发送
+	Token    string
+	User     string
+	Password string
+	// DialTimeout 建立连接的超时, 默认5s
+	DialTimeout time.Duration
+	// ReconnectDelay 订阅连接断开后重连前的等待时间, 默认1s
+	ReconnectDelay time.Duration
+
+	mu   sync.Mutex
+	conn *conn
+}
+
+// New 返回使用addr上subject主题的传输层
+func New(addr, subject string) *Transport {
+	return &Transport{Addr: addr, Subject: subject}
+}
+
+type conn struct {
+	net.Conn
+	r  *bufio.Reader
+	mu sync.Mutex // 保护写入, 接收协程会回复PING
+}
+
+func (c *conn) write(s string, payload ...[]byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := io.WriteString(c, s); err != nil {
+		return err
+	}
+	for _, p := range payload {
+		if _, err := c.Write(p); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(c, "\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *conn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// dial 建立连接并完成握手: 读取INFO, 发送CONNECT, 以PING/PONG确认
+func (t *Transport) dial(ctx context.Context) (*conn, error) {
+	timeout := t.DialTimeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	d := net.Dialer{Timeout: timeout}
+	nc, err := d.DialContext(ctx, "tcp", t.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	nc.SetDeadline(time.Now().Add(timeout))
+	if err := t.handshake(c); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	nc.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (t *Transport) handshake(c *conn) error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("natsbus: unexpected greeting %q", line)
+	}
+	opts, _ := json.Marshal(map[string]interface{}{
+		"verbose":    false,
+		"pedantic":   false,
+		"name":       "cache",
+		"lang":       "go",
+		"auth_token": t.Token,
+		"user":       t.User,
+		"pass":       t.Password,
+	})
+	if err := c.write("CONNECT " + string(opts) + "\r\nPING\r\n"); err != nil {
+		return err
+	}
+	return expectPong(c)
+}
+
+// expectPong 等待PONG, 期间出现-ERR时返回错误
+func expectPong(c *conn) error {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("natsbus: " + strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// Publish 把msg发布到主题
+func (t *Transport) Publish(ctx context.Context, msg []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == nil {
+		c, err := t.dial(ctx)
+		if err != nil {
+			return err
+		}
+		t.conn = c
+		go keepalive(c)
+	}
+	if err := t.conn.write("PUB "+t.Subject+" "+strconv.Itoa(len(msg))+"\r\n", msg); err != nil {
+		t.conn.Close()
+		t.conn = nil
+		return err
+	}
+	return nil
+}
+
+// keepalive 发布连接不接收消息, 只需回复服务端的PING, 否则会被断开
+func keepalive(c *conn) {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return
+		}
+		if line == "PING" && c.write("PONG\r\n") != nil {
+			return
+		}
+	}
+}
+
+// Subscribe 订阅主题, 服务端确认后返回. ctx结束时关闭连接
+func (t *Transport) Subscribe(ctx context.Context, handle func(msg []byte)) error {
+	c, err := t.subscribe(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			t.receive(ctx, c, handle)
+			c = nil
+			for c == nil {
+				delay := t.ReconnectDelay
+				if delay == 0 {
+					delay = time.Second
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+				c, _ = t.subscribe(ctx)
+			}
+		}
+	}()
+	return nil
+}
+
+func (t *Transport) subscribe(ctx context.Context) (*conn, error) {
+	c, err := t.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.write("SUB " + t.Subject + " 1\r\nPING\r\n"); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if err := expectPong(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// receive 读取消息直到连接出错或ctx结束
+func (t *Transport) receive(ctx context.Context, c *conn, handle func(msg []byte)) {
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	defer stop()
+	defer c.Close()
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return
+		}
+		switch {
+		case line == "PING":
+			if c.write("PONG\r\n") != nil {
+				return
+			}
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			fields := strings.Fields(line)
+			n, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || n < 0 {
+				return
+			}
+			buf := make([]byte, n+2)
+			if _, err := io.ReadFull(c.r, buf); err != nil {
+				return
+			}
+			handle(buf[:n])
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package natsbus_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cache"
+	"cache/natsbus"
+)
+
+// fakeNATS 只支持CONNECT/PING/PUB/SUB的NATS服务端
+type fakeNATS struct {
+	mu   sync.Mutex
+	subs map[string][]net.Conn
+}
+
+func startFakeNATS(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &fakeNATS{subs: make(map[string][]net.Conn)}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func (s *fakeNATS) serve(c net.Conn) {
+	defer c.Close()
+	fmt.Fprint(c, "INFO {\"server_id\":\"fake\"}\r\n")
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		s.mu.Lock()
+		switch fields[0] {
+		case "PING":
+			fmt.Fprint(c, "PONG\r\n")
+		case "SUB":
+			s.subs[fields[1]] = append(s.subs[fields[1]], c)
+		case "PUB":
+			n, _ := strconv.Atoi(fields[len(fields)-1])
+			buf := make([]byte, n+2)
+			io.ReadFull(r, buf)
+			for _, sub := range s.subs[fields[1]] {
+				fmt.Fprintf(sub, "MSG %s 1 %d\r\n%s\r\n", fields[1], n, buf[:n])
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func TestReplication(t *testing.T) {
+	addr := startFakeNATS(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, b := cache.NewTable("users"), cache.NewTable("users")
+	if err := a.AttachBus(ctx, natsbus.New(addr, "cache.users"), cache.WithReplication()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AttachBus(ctx, natsbus.New(addr, "cache.users")); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Add("k", time.Minute, "v1")
+	waitFor(t, func() bool { return b.Exists("k") })
+	item, err := b.Value("k")
+	if err != nil || item.Value() != "v1" || item.LifeSpan() != time.Minute {
+		t.Error("Error value was not replicated", err)
+	}
+
+	// b没有开启复制, 写入只使a中的副本失效
+	b.Add("k", 0, "v2")
+	waitFor(t, func() bool { return !a.Exists("k") })
+
+	a.Add("gone", 0, "x")
+	waitFor(t, func() bool { return b.Exists("gone") })
+	a.Delete("gone")
+	waitFor(t, func() bool { return !b.Exists("gone") })
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Error condition not reached")
+}
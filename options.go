@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Option 创建表时的配置项
+type Option func(*CacheTable)
+
+// WithMaxItems 限制表中元素数量, 超出时按淘汰策略移除元素; n<=0表示不限制
+func WithMaxItems(n int) Option {
+	return func(t *CacheTable) { t.updateSettings(func(s *Settings) { s.MaxItems = n }) }
+}
+
+// WithDefaultTTL 设置Set等不带有效期参数的方法使用的有效期
+func WithDefaultTTL(d time.Duration) Option {
+	return func(t *CacheTable) { t.updateSettings(func(s *Settings) { s.DefaultTTL = d }) }
+}
+
+// WithLogger 设置分级日志
+func WithLogger(l Logger) Option {
+	return func(t *CacheTable) { t.logger = l }
+}
+
+// WithClock 设置时间源
+func WithClock(c Clock) Option {
+	return func(t *CacheTable) {
+		if c != nil {
+			t.clock = c
+		}
+	}
+}
+
+// WithWatermarks 元素数超过high后一次淘汰到low, 见Settings.LowWatermark
+func WithWatermarks(high, low int) Option {
+	return func(t *CacheTable) {
+		t.updateSettings(func(s *Settings) { s.MaxItems, s.LowWatermark = high, low })
+	}
+}
+
+// WithEvictionPolicy 设置超出WithMaxItems容量时的淘汰策略, 默认为EvictLRU
+func WithEvictionPolicy(p EvictionPolicy) Option {
+	return func(t *CacheTable) {
+		t.evictionPolicy = p
+		t.evictor = newEvictionState(t, p)
+	}
+}
+
+// WithMaxExpirePerSweep 限制每次过期检查最多移除的元素数, 见Settings.MaxExpirePerSweep
+func WithMaxExpirePerSweep(n int) Option {
+	return func(t *CacheTable) { t.updateSettings(func(s *Settings) { s.MaxExpirePerSweep = n }) }
+}
+
+// WithMinCleanupInterval 设置过期检查定时器的最小间隔, 见Settings.MinCleanupInterval
+func WithMinCleanupInterval(d time.Duration) Option {
+	return func(t *CacheTable) { t.updateSettings(func(s *Settings) { s.MinCleanupInterval = d }) }
+}
+
+// WithCleanupSchedule 设置过期检查定时器的随机增加比例和最大间隔, 见Settings.CleanupJitter和MaxCleanupInterval
+func WithCleanupSchedule(jitter float64, maxInterval time.Duration) Option {
+	return func(t *CacheTable) {
+		t.updateSettings(func(s *Settings) {
+			s.CleanupJitter = jitter
+			s.MaxCleanupInterval = maxInterval
+		})
+	}
+}
+
+// WithHasher 设置LockKey/TryLockKey分段使用的键哈希函数, 默认为DefaultHasher
+func WithHasher(h Hasher) Option {
+	return func(t *CacheTable) { t.keyLocks.SetHasher(h) }
+}
+
+// WithShards 设置表的键锁(LockKey/TryLockKey)的分段数, n<=0时使用DefaultKeyMutexStripes; 只在创建表时生效.
+// 元素仍保存在一把表锁保护的map中, 需要按键分片存储时使用BytesTable
+func WithShards(n int) Option {
+	return func(t *CacheTable) { t.keyLocks.init(n) }
+}
+
+// NewTable 创建一个独立的表, 不加入全局注册表, 适合依赖注入和并行测试
+func NewTable(name string, opts ...Option) *CacheTable {
+	t := &CacheTable{
+		name:             name,
+		items:            newItemMap(false, 0),
+		clock:            realClock{},
+		healthThresholds: DefaultHealthThresholds,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.initPlugins()
+	t.runWarmStart()
+	return t
+}
+
+// Set 使用默认有效期(WithDefaultTTL)添加键值对
+func (table *CacheTable) Set(key interface{}, data interface{}) *CacheItem {
+	return table.SetContext(context.Background(), key, data)
+}
+
+// SetContext 同Set, ctx传给回调、插件并随变更记录下来
+func (table *CacheTable) SetContext(ctx context.Context, key interface{}, data interface{}) *CacheItem {
+	return table.AddContext(ctx, key, table.Settings().DefaultTTL, data)
+}
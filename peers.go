@@ -0,0 +1,424 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Peer 另一个缓存进程. Fetch在对方的同名表中读取key(对方未命中时由对方调用加载函数), 不存在时返回ErrKeyNotFound;
+// lifeSpan为对方元素的剩余有效期, 请求方按它缓存
+type Peer interface {
+	Fetch(ctx context.Context, table string, key interface{}) (value interface{}, lifeSpan time.Duration, err error)
+}
+
+// PeerPicker 选出拥有key的进程; key归本进程所有时返回false
+type PeerPicker interface {
+	PickPeer(key interface{}) (Peer, bool)
+}
+
+// DefaultHashRingReplicas HashRing中每个节点的默认虚拟节点数
+const DefaultHashRingReplicas = 50
+
+// HashRing 一致性哈希环, 节点增减时只有少部分键改变归属. 键默认按fmt.Sprint的结果计算哈希, 可用SetHasher替换
+type HashRing struct {
+	replicas int
+	hashes   []uint64
+	owners   map[uint64]string
+	hasher   Hasher
+}
+
+// NewHashRing 创建哈希环, replicas为每个节点的虚拟节点数, <=0时使用DefaultHashRingReplicas
+func NewHashRing(replicas int) *HashRing {
+	if replicas <= 0 {
+		replicas = DefaultHashRingReplicas
+	}
+	return &HashRing{replicas: replicas, owners: make(map[uint64]string)}
+}
+
+// Add 加入节点
+func (r *HashRing) Add(nodes ...string) {
+	for _, node := range nodes {
+		for i := 0; i < r.replicas; i++ {
+			h := hashString(strconv.Itoa(i) + node)
+			if _, ok := r.owners[h]; !ok {
+				r.hashes = append(r.hashes, h)
+			}
+			r.owners[h] = node
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+// SetHasher 设置键的哈希函数, 所有进程必须使用相同的Hasher
+func (r *HashRing) SetHasher(h Hasher) {
+	r.hasher = h
+}
+
+// Remove 移除节点
+func (r *HashRing) Remove(nodes ...string) {
+	remove := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		remove[node] = true
+	}
+	hashes := r.hashes[:0]
+	for _, h := range r.hashes {
+		if remove[r.owners[h]] {
+			delete(r.owners, h)
+			continue
+		}
+		hashes = append(hashes, h)
+	}
+	r.hashes = hashes
+}
+
+// Get 返回拥有key的节点, 环为空时返回空字符串
+func (r *HashRing) Get(key interface{}) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	key = canonicalKey(key)
+	var h uint64
+	if r.hasher != nil {
+		h = r.hasher.Hash(key)
+	} else {
+		h = hashString(fmt.Sprint(key))
+	}
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// Nodes 返回环上的节点, 按名称排序
+func (r *HashRing) Nodes() []string {
+	seen := make(map[string]bool)
+	var nodes []string
+	for _, node := range r.owners {
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// DefaultPeerBasePath HTTPPool处理节点间请求的默认路径前缀
+const DefaultPeerBasePath = "/_cache/peers/"
+
+// HTTPPool 基于HTTP的PeerPicker, 同时作为处理其他节点请求的http.Handler(挂载在BasePath下).
+// 键和值以gob编码传输, 自定义类型需要事先gob.Register, 与快照的要求相同
+type HTTPPool struct {
+	// Client 请求其他节点使用的客户端, 为nil时使用http.DefaultClient
+	Client *http.Client
+	// Security 非nil时ServeHTTP先鉴权, 未通过返回401. 节点之间通常共用一个BearerTokens中的token
+	Security *Security
+	// Token 非空时请求其他节点会带上"Authorization: Bearer <Token>"
+	Token string
+
+	self     string
+	basePath string
+
+	mu     sync.RWMutex
+	ring   *HashRing
+	peers  map[string]*httpPeer
+	hasher Hasher
+
+	// leases 本节点拥有的键的租约
+	leases LocalLeases
+}
+
+// NewHTTPPool 创建节点池, self为本节点的地址(如 http://10.0.0.1:8080), 需要与其他节点Set时使用的地址一致
+func NewHTTPPool(self string) *HTTPPool {
+	return &HTTPPool{self: self, basePath: DefaultPeerBasePath, ring: NewHashRing(0)}
+}
+
+// BasePath 返回节点间请求的路径前缀
+func (p *HTTPPool) BasePath() string {
+	return p.basePath
+}
+
+// Set 替换全部节点, 应包含本节点
+func (p *HTTPPool) Set(peers ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ring = NewHashRing(0)
+	p.ring.SetHasher(p.hasher)
+	p.ring.Add(peers...)
+	p.peers = make(map[string]*httpPeer, len(peers))
+	for _, peer := range peers {
+		p.peers[peer] = &httpPeer{pool: p, baseURL: strings.TrimRight(peer, "/") + p.basePath}
+	}
+}
+
+// SetHasher 设置选择节点时键的哈希函数, 所有节点必须使用相同的Hasher
+func (p *HTTPPool) SetHasher(h Hasher) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hasher = h
+	p.ring.SetHasher(h)
+}
+
+// PickPeer 实现PeerPicker
+func (p *HTTPPool) PickPeer(key interface{}) (Peer, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	owner := p.ring.Get(key)
+	if owner == "" || owner == p.self {
+		return nil, false
+	}
+	return p.peers[owner], true
+}
+
+// peerRequest, peerResponse 节点间请求和响应的gob编码格式
+type peerRequest struct {
+	Key interface{}
+	// Entries 非空时为Rebalance转移的元素, 忽略Key
+	Entries []TransferEntry
+	// Lease 非0时为对Key的租约请求
+	Lease      int
+	LeaseTTL   time.Duration
+	LeaseToken uint64
+}
+
+type peerResponse struct {
+	Value interface{}
+	// LifeSpan 拥有者的元素的剩余有效期
+	LifeSpan   time.Duration
+	LeaseToken uint64
+}
+
+// ServeHTTP 处理其他节点的Fetch: POST BasePath+表名, 在已注册的同名表中读取, 未命中时只调用本地加载函数
+func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, p.basePath) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if err := p.Security.authorize(r); err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="cache"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	name, err := url.PathUnescape(strings.TrimPrefix(r.URL.Path, p.basePath))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req peerRequest
+	if err := gob.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var res peerResponse
+	if req.Lease != 0 {
+		// 租约不依赖本节点是否有同名表
+		if res.LeaseToken, err = p.serveLease(r.Context(), name, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeGob(w, res)
+		return
+	}
+	if !Exists(name) {
+		// 不能用404, 否则请求方会认为键不存在而不再尝试本地加载
+		http.Error(w, ErrTableNotFound.Error(), http.StatusMisdirectedRequest)
+		return
+	}
+	if req.Entries != nil {
+		Cache(name).receiveTransfer(req.Entries)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	res.Value, res.LifeSpan, err = Cache(name).peerValue(r.Context(), req.Key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeGob(w, res)
+}
+
+// peerValue 处理其他节点的读取, 返回值和剩余有效期. 命中时计入命中统计但不顺延本地元素,
+// 请求方按剩余有效期缓存, 副本与本地元素同时过期; 未命中时只使用本地加载函数
+func (table *CacheTable) peerValue(ctx context.Context, key interface{}) (interface{}, time.Duration, error) {
+	table.RLock()
+	item, ok := table.items.get(canonicalKey(key))
+	closed := table.closed
+	now := table.clock.Now()
+	table.RUnlock()
+	if ok && !closed {
+		item.RLock()
+		value, lifeSpan, accessedOn := item.value, item.lifeSpan, item.accessedOn
+		item.RUnlock()
+		if remaining := lifeSpan - now.Sub(accessedOn); lifeSpan == 0 || remaining > 0 {
+			table.recordAccess(now, true)
+			if lifeSpan > 0 {
+				lifeSpan = remaining
+			}
+			return value, lifeSpan, nil
+		}
+	}
+	item, err := table.value(ctx, key, nil, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	return item.Value(), item.LifeSpan(), nil
+}
+
+func writeGob(w http.ResponseWriter, res peerResponse) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(res); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-gob")
+	w.Write(buf.Bytes())
+}
+
+type httpPeer struct {
+	pool    *HTTPPool
+	baseURL string
+}
+
+// post 把req发给节点, 调用方负责关闭响应
+func (h *httpPeer) post(ctx context.Context, table string, preq peerRequest) (*http.Response, error) {
+	var body bytes.Buffer
+	if err := gob.NewEncoder(&body).Encode(preq); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.baseURL+url.PathEscape(table), &body)
+	if err != nil {
+		return nil, err
+	}
+	if h.pool.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.pool.Token)
+	}
+	client := h.pool.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+func (h *httpPeer) Fetch(ctx context.Context, table string, key interface{}) (interface{}, time.Duration, error) {
+	resp, err := h.post(ctx, table, peerRequest{Key: key})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, 0, ErrKeyNotFound
+	default:
+		return nil, 0, h.statusError(resp)
+	}
+	var res peerResponse
+	if err := gob.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, 0, err
+	}
+	return res.Value, res.LifeSpan, nil
+}
+
+// Receive 把Rebalance转移的元素发给节点
+func (h *httpPeer) Receive(ctx context.Context, table string, entries []TransferEntry) error {
+	resp, err := h.post(ctx, table, peerRequest{Entries: entries})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return h.statusError(resp)
+	}
+	return nil
+}
+
+// statusError 节点返回非预期状态时的错误, 鉴权失败时包装ErrUnauthorized
+func (h *httpPeer) statusError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("peer %s: %w", h.baseURL, ErrUnauthorized)
+	}
+	return fmt.Errorf("peer %s: %s", h.baseURL, resp.Status)
+}
+
+// flightGroup 合并对同一个键的并发调用
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[interface{}]*flightCall
+}
+
+type flightCall struct {
+	wg   sync.WaitGroup
+	item *CacheItem
+	err  error
+}
+
+func (g *flightGroup) do(key interface{}, fn func() (*CacheItem, error)) (*CacheItem, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[interface{}]*flightCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.item, c.err
+	}
+	c := &flightCall{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.item, c.err = fn()
+	c.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	return c.item, c.err
+}
+
+// SetPeers 设置节点选择器. 设置后Value未命中时, 不归本进程所有的键从拥有者读取并缓存在本地,
+// 拥有者出错时退回本地加载函数; 同一进程内对同一个键的并发未命中只发出一次请求或加载
+func (table *CacheTable) SetPeers(peers PeerPicker) {
+	table.Lock()
+	defer table.Unlock()
+	table.peers = peers
+}
+
+// WithPeers 同SetPeers
+func WithPeers(peers PeerPicker) Option {
+	return func(t *CacheTable) { t.peers = peers }
+}
+
+// loadFromPeers Value未命中且设置了节点时的读取路径, usePeers为false时只使用本地加载函数
+func (table *CacheTable) loadFromPeers(ctx context.Context, key interface{}, peers PeerPicker, usePeers bool, loadData loaderFunc, args []interface{}) (*CacheItem, error) {
+	return table.flights.do(canonicalKey(key), func() (*CacheItem, error) {
+		if peer, ok := peers.PickPeer(key); ok && usePeers {
+			value, lifeSpan, err := peer.Fetch(ctx, table.Name(), key)
+			if err == nil {
+				return table.addLoaded(ctx, key, lifeSpan, value)
+			}
+			if errors.Is(err, ErrKeyNotFound) {
+				// 拥有者已经尝试过加载
+				return nil, ErrKeyNotFound
+			}
+			table.RLock()
+			table.logEvent(LevelWarn, EventPeerFetchFailed, key, "Fetching from peer failed, loading locally", "error", err)
+			table.RUnlock()
+		}
+		if loadData != nil {
+			return table.loadWithLease(ctx, key, loadData, args)
+		}
+		return nil, ErrKeyNotFound
+	})
+}
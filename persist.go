@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PrevSnapshotSuffix Persist保留上一个快照时在文件名后加的后缀
+const PrevSnapshotSuffix = ".prev"
+
+// snapshotEntry 快照中的一个元素. 自定义类型的键和值需要先gob.Register
+type snapshotEntry struct {
+	Key         interface{}
+	Value       interface{}
+	LifeSpan    time.Duration
+	CreatedOn   time.Time
+	AccessedOn  time.Time
+	AccessCount int64
+	// ExpiresAt 写快照时元素的过期时间, 不过期的元素为零值
+	ExpiresAt time.Time
+}
+
+// SaveSnapshot 把表中所有元素以gob格式写入w
+func (table *CacheTable) SaveSnapshot(w io.Writer) error {
+	table.RLock()
+	entries := make([]snapshotEntry, 0, table.items.len())
+	table.items.each(func(_ interface{}, item *CacheItem) bool {
+		item.RLock()
+		e := snapshotEntry{
+			Key:         item.key,
+			Value:       item.value,
+			LifeSpan:    item.lifeSpan,
+			CreatedOn:   item.createdOn,
+			AccessedOn:  item.accessedOn,
+			AccessCount: item.accessCount,
+		}
+		if item.lifeSpan > 0 {
+			e.ExpiresAt = item.accessedOn.Add(item.lifeSpan)
+		}
+		item.RUnlock()
+		entries = append(entries, e)
+		return true
+	})
+	table.RUnlock()
+
+	return gob.NewEncoder(w).Encode(entries)
+}
+
+// LoadSnapshot 从r读取SaveSnapshot写入的快照并加入表中, 返回加载的元素数量.
+// 元素在快照中记录的过期时间失效, 已过期的元素跳过
+func (table *CacheTable) LoadSnapshot(r io.Reader) (int, error) {
+	var entries []snapshotEntry
+	if err := gob.NewDecoder(r).Decode(&entries); err != nil {
+		return 0, err
+	}
+	return table.restoreEntries(entries)
+}
+
+// SetSnapshotFile 设置表的快照文件, Persist和CloseAll会把表写入该文件; 传空字符串取消
+func (table *CacheTable) SetSnapshotFile(path string) {
+	table.Lock()
+	defer table.Unlock()
+	table.snapshotFile = path
+}
+
+// Persist 把表写入SetSnapshotFile设置的文件, 未设置时什么也不做.
+// 先写临时文件再重命名, 不会留下写了一半的快照; 原有的快照保留为文件名加PrevSnapshotSuffix
+func (table *CacheTable) Persist() error {
+	table.RLock()
+	path := table.snapshotFile
+	table.RUnlock()
+	if path == "" {
+		return nil
+	}
+
+	err := writeSnapshotFile(table, path)
+	table.Lock()
+	if err == nil {
+		table.lastPersist = table.clock.Now()
+	}
+	table.persistErr = err
+	table.Unlock()
+	return err
+}
+
+// writeSnapshotFile 把表写入临时文件后重命名为path
+func writeSnapshotFile(table *CacheTable, path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := table.SaveSnapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path, path+PrevSnapshotSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
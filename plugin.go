@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Plugin 表生命周期的钩子, 用于把持久化、指标、复制等功能做成独立的插件.
+// 除OnTableCreate外的钩子在持有表锁时调用, 不能调用表的方法, 耗时的工作应交给其他协程
+type Plugin interface {
+	// Name 插件名, 在注册表中唯一
+	Name() string
+	// OnTableCreate 表创建后、注册到Cache之前调用, 此时可以调用表的方法(如设置回调或订阅变更)和Cache等注册表函数
+	OnTableCreate(table *CacheTable)
+	// OnAdd 元素被写入或替换后调用
+	OnAdd(ctx context.Context, table *CacheTable, item *CacheItem)
+	// OnDelete 元素被删除或因容量被淘汰后调用
+	OnDelete(ctx context.Context, table *CacheTable, item *CacheItem)
+	// OnExpire 元素过期被移除后调用
+	OnExpire(ctx context.Context, table *CacheTable, item *CacheItem)
+	// OnFlush 整表被清空后调用
+	OnFlush(ctx context.Context, table *CacheTable)
+}
+
+// BasePlugin 所有钩子的空实现, 嵌入后只需实现Name和关心的钩子
+type BasePlugin struct{}
+
+func (BasePlugin) OnTableCreate(*CacheTable)                         {}
+func (BasePlugin) OnAdd(context.Context, *CacheTable, *CacheItem)    {}
+func (BasePlugin) OnDelete(context.Context, *CacheTable, *CacheItem) {}
+func (BasePlugin) OnExpire(context.Context, *CacheTable, *CacheItem) {}
+func (BasePlugin) OnFlush(context.Context, *CacheTable)              {}
+
+var (
+	plugins     = make(map[string]Plugin)
+	pluginMutex sync.RWMutex
+)
+
+// RegisterPlugin 注册全局插件, 之后创建的所有表都启用该插件; 同名插件已注册时返回ErrPluginExists
+func RegisterPlugin(p Plugin) error {
+	pluginMutex.Lock()
+	defer pluginMutex.Unlock()
+	if _, ok := plugins[p.Name()]; ok {
+		return ErrPluginExists
+	}
+	plugins[p.Name()] = p
+	return nil
+}
+
+// UnregisterPlugin 移除全局插件, 已创建的表不受影响; 插件不存在时返回false
+func UnregisterPlugin(name string) bool {
+	pluginMutex.Lock()
+	defer pluginMutex.Unlock()
+	_, ok := plugins[name]
+	delete(plugins, name)
+	return ok
+}
+
+// Plugins 返回已注册的全局插件名, 按名称排序
+func Plugins() []string {
+	pluginMutex.RLock()
+	defer pluginMutex.RUnlock()
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithPlugins 只为该表启用插件, 与全局插件同名时使用该插件
+func WithPlugins(ps ...Plugin) Option {
+	return func(t *CacheTable) { t.plugins = append(t.plugins, ps...) }
+}
+
+// initPlugins 合并全局插件并调用OnTableCreate, 在NewTable应用完选项后调用
+func (table *CacheTable) initPlugins() {
+	pluginMutex.RLock()
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	own := make(map[string]bool, len(table.plugins))
+	for _, p := range table.plugins {
+		own[p.Name()] = true
+	}
+	var global []Plugin
+	for _, name := range names {
+		if !own[name] {
+			global = append(global, plugins[name])
+		}
+	}
+	pluginMutex.RUnlock()
+
+	table.plugins = append(global, table.plugins...)
+	for _, p := range table.plugins {
+		p.OnTableCreate(table)
+	}
+}
+
+// notifyPlugins 按变更类型调用插件钩子, 调用方需持有表锁
+func (table *CacheTable) notifyPlugins(ctx context.Context, op MutationOp, item *CacheItem) {
+	for _, p := range table.plugins {
+		switch op {
+		case OpAdd, OpUpdate:
+			p.OnAdd(ctx, table, item)
+		case OpDelete, OpEvict:
+			p.OnDelete(ctx, table, item)
+		case OpExpire:
+			p.OnExpire(ctx, table, item)
+		case OpFlush:
+			p.OnFlush(ctx, table)
+		}
+	}
+}
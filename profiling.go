@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// pprof标签名, 值分别为表名和操作
+const (
+	LabelTable = "cache_table"
+	LabelOp    = "cache_op"
+)
+
+// 打在pprof标签中的操作
+const (
+	opLabelLoad     = "load"
+	opLabelCallback = "callback"
+	opLabelExpire   = "expire"
+	opLabelEvict    = "evict"
+	opLabelVerify   = "verify_immutable"
+)
+
+// SetProfilerLabels 开启后加载函数、添加和删除回调以及过期检查等定时任务运行时带上pprof标签
+// cache_table(表名)和cache_op(load, callback, expire, evict, verify_immutable), CPU profile可以按表归因.
+// 其中启动的协程会继承这些标签
+func (table *CacheTable) SetProfilerLabels(on bool) {
+	table.profilerLabels.Store(on)
+}
+
+// WithProfilerLabels 同SetProfilerLabels(true)
+func WithProfilerLabels() Option {
+	return func(t *CacheTable) { t.profilerLabels.Store(true) }
+}
+
+// labeled 开启了pprof标签时在带标签的ctx中调用f, 否则直接调用; 不能在持有表锁时调用
+func (table *CacheTable) labeled(ctx context.Context, op string, f func(ctx context.Context)) {
+	if !table.profilerLabels.Load() {
+		f(ctx)
+		return
+	}
+	pprof.Do(ctx, pprof.Labels(LabelTable, table.Name(), LabelOp, op), f)
+}
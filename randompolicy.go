@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// DefaultEvictionSamples EvictRandom每次淘汰默认抽样的元素数
+const DefaultEvictionSamples = 5
+
+// WithRandomEviction 使用EvictRandom, 每次淘汰随机抽样samples个元素, 淘汰其中最久未访问的一个;
+// samples<=0时使用DefaultEvictionSamples, samples为1时完全随机
+func WithRandomEviction(samples int) Option {
+	return func(t *CacheTable) {
+		t.evictionPolicy = EvictRandom
+		t.evictor = newRandomPolicy(t, samples)
+	}
+}
+
+// randomPolicy EvictRandom的状态, 只维护用于抽样的键数组; 命中时不做任何记录
+type randomPolicy struct {
+	mu      sync.Mutex
+	table   *CacheTable
+	samples int
+	keys    []interface{}
+	index   map[interface{}]int
+}
+
+func newRandomPolicy(table *CacheTable, samples int) *randomPolicy {
+	if samples <= 0 {
+		samples = DefaultEvictionSamples
+	}
+	return &randomPolicy{table: table, samples: samples, index: make(map[interface{}]int)}
+}
+
+func (r *randomPolicy) OnAdd(key interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.index[key] = len(r.keys)
+	r.keys = append(r.keys, key)
+}
+
+func (r *randomPolicy) OnAccess(key interface{}) {}
+
+func (r *randomPolicy) OnRemove(key interface{}, op MutationOp) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i, ok := r.index[key]
+	if !ok {
+		return
+	}
+	// 用最后一个键填补空位
+	last := len(r.keys) - 1
+	r.keys[i] = r.keys[last]
+	r.index[r.keys[i]] = i
+	r.keys[last] = nil
+	r.keys = r.keys[:last]
+	delete(r.index, key)
+}
+
+// Victim 调用方持有表锁, 可以直接读取table.items
+func (r *randomPolicy) Victim(keep interface{}) (interface{}, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(r.keys)
+	if n == 0 {
+		return nil, false
+	}
+	var (
+		found  interface{}
+		oldest *itemStat
+	)
+	for s := 0; s < r.samples; s++ {
+		i := rand.Intn(n)
+		if r.keys[i] == keep {
+			i = (i + 1) % n
+		}
+		key := r.keys[i]
+		if key == keep {
+			continue
+		}
+		item, ok := r.table.items.get(key)
+		if !ok {
+			continue
+		}
+		item.RLock()
+		st := itemStat{item, item.accessCount, item.accessedOn}
+		item.RUnlock()
+		if oldest == nil || st.accessedOn.Before(oldest.accessedOn) {
+			found, oldest = key, &st
+		}
+	}
+	return found, oldest != nil
+}
@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// itemStat 排序用的元素状态快照
+type itemStat struct {
+	item        *CacheItem
+	accessCount int64
+	accessedOn  time.Time
+}
+
+// statHeap 堆顶为当前保留元素中排名最靠后的一个
+type statHeap struct {
+	s    []itemStat
+	less func(a, b *itemStat) bool
+}
+
+func (h *statHeap) Len() int                { return len(h.s) }
+func (h *statHeap) Less(i, j int) bool      { return h.less(&h.s[j], &h.s[i]) }
+func (h *statHeap) Swap(i, j int)           { h.s[i], h.s[j] = h.s[j], h.s[i] }
+func (h *statHeap) Push(x interface{})      { h.s = append(h.s, x.(itemStat)) }
+func (h *statHeap) Pop() interface{}        { x := h.s[len(h.s)-1]; h.s = h.s[:len(h.s)-1]; return x }
+func (h *statHeap) worst() *itemStat        { return &h.s[0] }
+func (h *statHeap) replaceWorst(s itemStat) { h.s[0] = s; heap.Fix(h, 0) }
+
+// rankedStats 筛选出keep为真的元素(keep为nil时不筛选), 返回按less排序的前limit个, limit<=0时返回全部.
+// limit较小时只维护一个大小为limit的堆, 不对整表排序
+func (table *CacheTable) rankedStats(limit int, keep func(s *itemStat) bool, less func(a, b *itemStat) bool) []itemStat {
+	h := &statHeap{less: less}
+
+	table.RLock()
+	table.items.each(func(_ interface{}, item *CacheItem) bool {
+		item.RLock()
+		st := itemStat{item, item.accessCount, item.accessedOn}
+		item.RUnlock()
+		if keep != nil && !keep(&st) {
+			return true
+		}
+		switch {
+		case limit <= 0 || h.Len() < limit:
+			heap.Push(h, st)
+		case less(&st, h.worst()):
+			h.replaceWorst(st)
+		}
+		return true
+	})
+	table.RUnlock()
+
+	sort.Slice(h.s, func(i, j int) bool { return less(&h.s[i], &h.s[j]) })
+	return h.s
+}
+
+// sortedItems 同rankedStats, 返回元素本身
+func (table *CacheTable) sortedItems(count int64, keep func(s *itemStat) bool, less func(a, b *itemStat) bool) []*CacheItem {
+	p := table.rankedStats(int(count), keep, less)
+	items := make([]*CacheItem, len(p))
+	for i := range p {
+		items[i] = p[i].item
+	}
+	return items
+}
+
+func moreAccessed(a, b *itemStat) bool { return a.accessCount > b.accessCount }
+
+// MostAccessed 返回访问次数最多的count个元素, count<=0时返回nil
+func (table *CacheTable) MostAccessed(count int64) []*CacheItem {
+	if count <= 0 {
+		return nil
+	}
+	return table.sortedItems(count, nil, moreAccessed)
+}
+
+// MostAccessedOptions MostAccessedPairs的查询条件
+type MostAccessedOptions struct {
+	// LastAccessedSince 非零时只返回最后一次访问不早于此时间的元素. 只按最后访问时间筛选,
+	// 排序和返回的访问次数仍是元素的累计访问次数, 不是此时间之后的访问次数
+	LastAccessedSince time.Time
+	// Offset, Limit 分页参数, Limit<=0表示不限制
+	Offset int
+	Limit  int
+}
+
+// MostAccessedPairs 按访问次数从多到少返回键与访问次数, 支持按最后访问时间筛选和分页
+func (table *CacheTable) MostAccessedPairs(opts MostAccessedOptions) CacheItemPairList {
+	var keep func(s *itemStat) bool
+	if !opts.LastAccessedSince.IsZero() {
+		keep = func(s *itemStat) bool { return !s.accessedOn.Before(opts.LastAccessedSince) }
+	}
+	if opts.Offset < 0 {
+		opts.Offset = 0
+	}
+	limit := 0
+	if opts.Limit > 0 {
+		limit = opts.Offset + opts.Limit
+	}
+
+	p := table.rankedStats(limit, keep, moreAccessed)
+	if opts.Offset >= len(p) {
+		return CacheItemPairList{}
+	}
+	p = p[opts.Offset:]
+	pairs := make(CacheItemPairList, len(p))
+	for i := range p {
+		pairs[i] = CacheItemPair{p[i].item.key, p[i].accessCount}
+	}
+	return pairs
+}
+
+// LeastAccessed 返回访问次数最少的count个元素, count<=0时返回nil
+func (table *CacheTable) LeastAccessed(count int64) []*CacheItem {
+	if count <= 0 {
+		return nil
+	}
+	return table.sortedItems(count, nil, func(a, b *itemStat) bool {
+		return a.accessCount < b.accessCount
+	})
+}
+
+// OldestItems 返回最早加入表的count个元素, count<=0时返回nil
+func (table *CacheTable) OldestItems(count int64) []*CacheItem {
+	if count <= 0 {
+		return nil
+	}
+	return table.sortedItems(count, nil, func(a, b *itemStat) bool {
+		return a.item.createdOn.Before(b.item.createdOn)
+	})
+}
+
+// IdleLongerThan 返回超过d未被访问的元素, 按空闲时长从长到短排列
+func (table *CacheTable) IdleLongerThan(d time.Duration) []*CacheItem {
+	table.RLock()
+	deadline := table.clock.Now().Add(-d)
+	table.RUnlock()
+	return table.sortedItems(0, func(s *itemStat) bool {
+		return !s.accessedOn.After(deadline)
+	}, func(a, b *itemStat) bool {
+		return a.accessedOn.Before(b.accessedOn)
+	})
+}
+
+// expiresAt 元素按访问时间计算的过期时间, 只对lifeSpan>0的元素有意义
+func (s *itemStat) expiresAt() time.Time {
+	return s.accessedOn.Add(s.item.lifeSpan)
+}
+
+func expiring(s *itemStat) bool { return s.item.lifeSpan > 0 }
+
+func expiresSooner(a, b *itemStat) bool { return a.expiresAt().Before(b.expiresAt()) }
+
+// NextExpiration 返回最先过期的元素的键和过期时间, 没有会过期的元素时ok为false.
+// 已过期但还未被过期检查移除的元素也会返回, 其过期时间早于当前时间
+func (table *CacheTable) NextExpiration() (key interface{}, at time.Time, ok bool) {
+	p := table.rankedStats(1, expiring, expiresSooner)
+	if len(p) == 0 {
+		return nil, time.Time{}, false
+	}
+	return p[0].item.key, p[0].expiresAt(), true
+}
+
+// ExpiringWithin 返回将在d之内过期的元素, 按过期时间从早到晚排列, 可用于提前刷新
+func (table *CacheTable) ExpiringWithin(d time.Duration) []*CacheItem {
+	table.RLock()
+	deadline := table.clock.Now().Add(d)
+	table.RUnlock()
+	return table.sortedItems(0, func(s *itemStat) bool {
+		return expiring(s) && !s.expiresAt().After(deadline)
+	}, expiresSooner)
+}
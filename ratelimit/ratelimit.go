@@ -0,0 +1,160 @@
+// Package ratelimit 用CacheTable保存每个键(如客户端IP, 用户ID)的令牌桶. 桶在填满所需的时间内没有访问即从表中移除,
+// 之后再访问时重新创建的满桶与之等价, 因此表的大小只取决于活跃的键:
+//
+//	limiter := ratelimit.New(cache.Cache("ratelimit"), 10, 20) // 每秒10个, 最多突发20个
+//	if !limiter.Allow(clientIP) {
+//		http.Error(w, "too many requests", http.StatusTooManyRequests)
+//	}
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"cache"
+)
+
+// ErrNotBucket 表中键的值不是令牌桶, 通常是表与其他用途共用
+var ErrNotBucket = errors.New("ratelimit: value is not a token bucket")
+
+// bucketAttempts 读取或创建令牌桶的最多尝试次数, 创建后立即被删除时才需要重试
+const bucketAttempts = 3
+
+// Every 把每个令牌的间隔换算为每秒令牌数
+func Every(interval time.Duration) float64 {
+	if interval <= 0 {
+		return math.Inf(1)
+	}
+	return float64(time.Second) / float64(interval)
+}
+
+// Option New的配置项
+type Option func(*Limiter)
+
+// WithClock 设置时间源, 应与表的时钟一致, 默认为真实时间
+func WithClock(c cache.Clock) Option {
+	return func(l *Limiter) { l.clock = c }
+}
+
+// Limiter 按键限流的令牌桶
+type Limiter struct {
+	table *cache.CacheTable
+	rate  float64
+	burst int
+	clock cache.Clock
+}
+
+// bucket 一个键的令牌桶, 作为元素的值保存在表中
+type bucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// New 创建限流器, 每个键每秒补充rate个令牌, 最多积累burst个
+func New(table *cache.CacheTable, rate float64, burst int, opts ...Option) *Limiter {
+	l := &Limiter{table: table, rate: rate, burst: burst}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+func (l *Limiter) now() time.Time {
+	if l.clock != nil {
+		return l.clock.Now()
+	}
+	return time.Now()
+}
+
+// idleTTL 桶从空到满所需的时间, 闲置这么久的桶与新桶等价
+func (l *Limiter) idleTTL() time.Duration {
+	if l.rate <= 0 || math.IsInf(l.rate, 1) {
+		return time.Minute
+	}
+	ttl := time.Duration(float64(l.burst) / l.rate * float64(time.Second))
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return ttl
+}
+
+// bucket 返回key的令牌桶, 不存在时创建满桶. 表已关闭或拒绝写入时返回写入的错误, 值不是令牌桶时返回ErrNotBucket
+func (l *Limiter) bucket(key interface{}) (*bucket, error) {
+	for i := 0; i < bucketAttempts; i++ {
+		if item, err := l.table.Value(key); err == nil {
+			b, ok := item.Value().(*bucket)
+			if !ok {
+				return nil, fmt.Errorf("%w: %v holds %T", ErrNotBucket, key, item.Value())
+			}
+			return b, nil
+		}
+		// 并发创建时只有一个成功, 其余的在下一轮读到它
+		if _, err := l.table.TryNotFoundAdd(key, l.idleTTL(), &bucket{tokens: float64(l.burst), last: l.now()}); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("ratelimit: bucket for %v removed while it was created", key)
+}
+
+// refill 按经过的时间补充令牌, 调用方需持有b.mu
+func (l *Limiter) refill(b *bucket, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(l.burst), b.tokens+elapsed.Seconds()*l.rate)
+		b.last = now
+	}
+}
+
+// Allow 同AllowN(key, 1)
+func (l *Limiter) Allow(key interface{}) bool {
+	return l.AllowN(key, 1)
+}
+
+// AllowN 桶中至少有n个令牌时取走并返回true, 否则不取走令牌并返回false; 无法取得令牌桶时拒绝, 见TryAllowN
+func (l *Limiter) AllowN(key interface{}, n int) bool {
+	allowed, _ := l.TryAllowN(key, n)
+	return allowed
+}
+
+// TryAllowN 同AllowN, 并返回无法取得令牌桶的原因: 表已关闭(cache.ErrTableClosed)、写入被拒绝或ErrNotBucket
+func (l *Limiter) TryAllowN(key interface{}, n int) (bool, error) {
+	if math.IsInf(l.rate, 1) {
+		return true, nil
+	}
+	b, err := l.bucket(key)
+	if err != nil {
+		return false, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l.refill(b, l.now())
+	if b.tokens < float64(n) {
+		return false, nil
+	}
+	b.tokens -= float64(n)
+	return true, nil
+}
+
+// Tokens 返回key当前可用的令牌数
+func (l *Limiter) Tokens(key interface{}) float64 {
+	item, err := l.table.Peek(key)
+	if err != nil {
+		return float64(l.burst)
+	}
+	b, ok := item.Value().(*bucket)
+	if !ok {
+		return float64(l.burst)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l.refill(b, l.now())
+	return b.tokens
+}
+
+// Reset 移除key的令牌桶, 之后的请求从满桶开始
+func (l *Limiter) Reset(key interface{}) {
+	l.table.Delete(key)
+}
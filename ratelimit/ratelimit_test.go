@@ -0,0 +1,86 @@
+package ratelimit_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cache"
+	"cache/ratelimit"
+	"cache/testutil"
+)
+
+func TestAllow(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Unix(0, 0))
+	table := cache.NewTable("ratelimit", cache.WithClock(clock))
+	limiter := ratelimit.New(table, 2, 4, ratelimit.WithClock(clock))
+
+	var allowed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if limiter.Allow("client") {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 4 {
+		t.Error("Error expected burst of 4, got", allowed)
+	}
+	if !limiter.Allow("other") {
+		t.Error("Error keys should have separate buckets")
+	}
+
+	clock.Advance(time.Second)
+	if !limiter.AllowN("client", 2) || limiter.Allow("client") {
+		t.Error("Error expected two refilled tokens")
+	}
+	if n := limiter.Tokens("client"); n != 0 {
+		t.Error("Error expected empty bucket, got", n)
+	}
+
+	// 闲置到桶填满后从表中移除
+	clock.Advance(3 * time.Second)
+	if table.Exists("client") {
+		t.Error("Error idle bucket was not removed")
+	}
+	if n := limiter.Tokens("client"); n != 4 {
+		t.Error("Error removed bucket should count as full, got", n)
+	}
+}
+
+func TestAllowErrors(t *testing.T) {
+	table := cache.NewTable("ratelimitErrors")
+	limiter := ratelimit.New(table, 1, 1)
+
+	table.Add("foreign", 0, "not a bucket")
+	if allowed, err := limiter.TryAllowN("foreign", 1); allowed || !errors.Is(err, ratelimit.ErrNotBucket) {
+		t.Error("Error expected ErrNotBucket for a foreign value", allowed, err)
+	}
+
+	table.Close(false)
+	done := make(chan bool)
+	go func() { done <- limiter.Allow("client") }()
+	select {
+	case allowed := <-done:
+		if allowed {
+			t.Error("Error closed table should deny")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Error Allow did not return on a closed table")
+	}
+	if _, err := limiter.TryAllowN("client", 1); !errors.Is(err, cache.ErrTableClosed) {
+		t.Error("Error expected ErrTableClosed", err)
+	}
+}
+
+func TestEvery(t *testing.T) {
+	if r := ratelimit.Every(100 * time.Millisecond); r != 10 {
+		t.Error("Error converting interval to rate", r)
+	}
+}
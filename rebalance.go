@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RebalanceBatchSize Rebalance每次请求转移的最大元素数
+const RebalanceBatchSize = 256
+
+// TransferEntry Rebalance转移的一个元素, LifeSpan为剩余有效期(0表示不过期)
+type TransferEntry struct {
+	Key       interface{}
+	Value     interface{}
+	LifeSpan  time.Duration
+	CreatedOn time.Time
+}
+
+// PeerReceiver 能接收转移元素的Peer. Peer的实现应可比较(通常为指针), Rebalance按Peer分组发送
+type PeerReceiver interface {
+	Peer
+	Receive(ctx context.Context, table string, entries []TransferEntry) error
+}
+
+// RebalanceStats 最近一次Rebalance的进度
+type RebalanceStats struct {
+	Running bool
+	// Pending 本次需要转移的元素数
+	Pending     int
+	Transferred int
+	Failed      int
+	StartedAt   time.Time
+	FinishedAt  time.Time
+}
+
+type rebalanceState struct {
+	sync.Mutex
+	stats RebalanceStats
+}
+
+func (s *rebalanceState) snapshot() RebalanceStats {
+	s.Lock()
+	defer s.Unlock()
+	return s.stats
+}
+
+func (s *rebalanceState) add(transferred, failed int) {
+	s.Lock()
+	defer s.Unlock()
+	s.stats.Transferred += transferred
+	s.stats.Failed += failed
+}
+
+// Rebalance 节点变化后调用: 把不再归本进程所有的元素连同剩余有效期转移给新的拥有者, 成功后从本地移除
+// (记为OpEvict, 不会通过总线发布). 拥有者未实现PeerReceiver或转移失败的元素保留在本地.
+// 进度可通过Stats().Rebalance查看; 返回转移成功的元素数, 已有Rebalance在进行时返回ErrRebalanceInProgress
+func (table *CacheTable) Rebalance(ctx context.Context) (int, error) {
+	table.RLock()
+	peers := table.peers
+	name := table.name
+	now := table.clock.Now()
+	table.RUnlock()
+	if peers == nil {
+		return 0, nil
+	}
+
+	table.rebalance.Lock()
+	if table.rebalance.stats.Running {
+		table.rebalance.Unlock()
+		return 0, ErrRebalanceInProgress
+	}
+	table.rebalance.stats = RebalanceStats{Running: true, StartedAt: time.Now()}
+	table.rebalance.Unlock()
+
+	batches := make(map[PeerReceiver][]TransferEntry)
+	items := make(map[interface{}]*CacheItem)
+	pending := 0
+	table.Foreach(func(key interface{}, item *CacheItem) {
+		peer, ok := peers.PickPeer(key)
+		if !ok {
+			return
+		}
+		receiver, ok := peer.(PeerReceiver)
+		if !ok {
+			return
+		}
+		item.RLock()
+		entry := TransferEntry{Key: key, Value: item.value, CreatedOn: item.createdOn}
+		expires := item.lifeSpan > 0
+		if expires {
+			entry.LifeSpan = item.lifeSpan - now.Sub(item.accessedOn)
+		}
+		item.RUnlock()
+		if expires && entry.LifeSpan <= 0 {
+			return
+		}
+		batches[receiver] = append(batches[receiver], entry)
+		items[key] = item
+		pending++
+	})
+	table.rebalance.Lock()
+	table.rebalance.stats.Pending = pending
+	table.rebalance.Unlock()
+
+	transferred := 0
+	var err error
+	for receiver, entries := range batches {
+		for len(entries) > 0 && err == nil {
+			n := len(entries)
+			if n > RebalanceBatchSize {
+				n = RebalanceBatchSize
+			}
+			batch := entries[:n]
+			entries = entries[n:]
+			if err = ctx.Err(); err != nil {
+				break
+			}
+			if e := receiver.Receive(ctx, name, batch); e != nil {
+				table.rebalance.add(0, len(batch))
+				table.RLock()
+				table.logEvent(LevelWarn, EventRebalance, nil, "Transferring entries failed", "count", len(batch), "error", e)
+				table.RUnlock()
+				continue
+			}
+			for _, entry := range batch {
+				table.Lock()
+				// 转移期间被替换的元素保留在本地
+				if cur, ok := table.items.get(entry.Key); ok && cur == items[entry.Key] {
+					table.deleteInternal(ctx, entry.Key, OpEvict)
+				}
+				table.Unlock()
+			}
+			transferred += len(batch)
+			table.rebalance.add(len(batch), 0)
+		}
+	}
+
+	table.rebalance.Lock()
+	table.rebalance.stats.Running = false
+	table.rebalance.stats.FinishedAt = time.Now()
+	table.rebalance.Unlock()
+	table.RLock()
+	table.logEvent(LevelInfo, EventRebalance, nil, "Rebalance finished", "transferred", transferred, "pending", pending)
+	table.RUnlock()
+	return transferred, err
+}
+
+// receiveTransfer 接收其他节点转移来的元素, 本地已有不比它旧的元素时保留本地元素
+func (table *CacheTable) receiveTransfer(entries []TransferEntry) {
+	for _, entry := range entries {
+		table.applyBusMessage(&BusMessage{
+			Op:         OpUpdate,
+			Key:        entry.Key,
+			Time:       entry.CreatedOn,
+			Replicated: true,
+			Value:      entry.Value,
+			LifeSpan:   entry.LifeSpan,
+		})
+	}
+}
@@ -0,0 +1,230 @@
+// Package redisbus 用Redis pub/sub实现cache.BusTransport, 使多个进程的同名表互相失效:
+//
+//	t := cache.Cache("users")
+//	err := t.AttachBus(ctx, redisbus.New("redis:6379", "cache-invalidations"))
+package redisbus
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Transport Redis pub/sub传输层. 发布使用一个共享连接, 每次Subscribe使用独立的连接, 断开后自动重连
+type Transport struct {
+	// Addr Redis地址, 如 localhost:6379
+	Addr string
+	// Channel 发布和订阅的频道
+	Channel string
+	// Password 非空时连接后先AUTH
+	Password string
+	// DialTimeout 建立连接的超时, 默认5s
+	DialTimeout time.Duration
+	// ReconnectDelay 订阅连接断开后重连前的等待时间, 默认1s
+	ReconnectDelay time.Duration
+
+	mu   sync.Mutex
+	conn *conn
+}
+
+// New 返回使用addr上channel频道的传输层
+func New(addr, channel string) *Transport {
+	return &Transport{Addr: addr, Channel: channel}
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (t *Transport) dial(ctx context.Context) (*conn, error) {
+	timeout := t.DialTimeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	d := net.Dialer{Timeout: timeout}
+	nc, err := d.DialContext(ctx, "tcp", t.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	if t.Password != "" {
+		if _, err := c.do("AUTH", t.Password); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Publish 把msg发布到频道
+func (t *Transport) Publish(ctx context.Context, msg []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == nil {
+		c, err := t.dial(ctx)
+		if err != nil {
+			return err
+		}
+		t.conn = c
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		t.conn.SetDeadline(deadline)
+	} else {
+		t.conn.SetDeadline(time.Time{})
+	}
+	if _, err := t.conn.do("PUBLISH", t.Channel, string(msg)); err != nil {
+		// 出错后连接状态未知, 下次重新建立
+		t.conn.Close()
+		t.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Subscribe 订阅频道, 订阅确认后返回. ctx结束时关闭连接
+func (t *Transport) Subscribe(ctx context.Context, handle func(msg []byte)) error {
+	return t.SubscribeResubscribe(ctx, handle, nil)
+}
+
+// SubscribeResubscribe 同Subscribe, 连接断开后重新订阅成功时调用onResubscribe(可为nil).
+// Redis pub/sub不保存消息, 断开期间发布的消息都已丢失; 实现cache.BusResubscriber, AttachBus会因此清空表
+func (t *Transport) SubscribeResubscribe(ctx context.Context, handle func(msg []byte), onResubscribe func()) error {
+	c, err := t.subscribe(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			t.receive(ctx, c, handle)
+			c = nil
+			for c == nil {
+				delay := t.ReconnectDelay
+				if delay == 0 {
+					delay = time.Second
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+				c, _ = t.subscribe(ctx)
+			}
+			if onResubscribe != nil {
+				onResubscribe()
+			}
+		}
+	}()
+	return nil
+}
+
+func (t *Transport) subscribe(ctx context.Context) (*conn, error) {
+	c, err := t.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.send("SUBSCRIBE", t.Channel); err != nil {
+		c.Close()
+		return nil, err
+	}
+	reply, err := c.read()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if fields, ok := reply.([]interface{}); !ok || len(fields) < 1 || fields[0] != "subscribe" {
+		c.Close()
+		return nil, fmt.Errorf("redisbus: unexpected SUBSCRIBE reply %v", reply)
+	}
+	return c, nil
+}
+
+// receive 读取消息直到连接出错或ctx结束
+func (t *Transport) receive(ctx context.Context, c *conn, handle func(msg []byte)) {
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	defer stop()
+	defer c.Close()
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return
+		}
+		fields, ok := reply.([]interface{})
+		if !ok || len(fields) != 3 || fields[0] != "message" {
+			continue
+		}
+		if payload, ok := fields[2].(string); ok {
+			handle([]byte(payload))
+		}
+	}
+}
+
+// do 发送命令并读取一个回复
+func (c *conn) do(args ...string) (interface{}, error) {
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+func (c *conn) send(args ...string) error {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	_, err := c.Write(buf)
+	return err
+}
+
+// read 读取一个回复: 简单字符串和批量字符串为string, 整数为int64, 数组为[]interface{}, 空值为nil
+func (c *conn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, errors.New("redisbus: short reply")
+	}
+	body := line[1 : len(line)-2]
+	switch line[0] {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, errors.New("redisbus: " + body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redisbus: unexpected reply %q", line)
+}
@@ -0,0 +1,142 @@
+package redisbus_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cache"
+	"cache/redisbus"
+)
+
+// fakeRedis 只支持PUBLISH/SUBSCRIBE的Redis服务端
+type fakeRedis struct {
+	mu   sync.Mutex
+	subs map[string][]net.Conn
+}
+
+func startFakeRedis(t *testing.T) (string, *fakeRedis) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &fakeRedis{subs: make(map[string][]net.Conn)}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return l.Addr().String(), s
+}
+
+// dropSubscribers 断开所有订阅连接
+func (s *fakeRedis) dropSubscribers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch, conns := range s.subs {
+		for _, c := range conns {
+			c.Close()
+		}
+		delete(s.subs, ch)
+	}
+}
+
+func (s *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			line, _ = r.ReadString('\n')
+			size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			buf := make([]byte, size+2)
+			io.ReadFull(r, buf)
+			args[i] = string(buf[:size])
+		}
+		s.mu.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "SUBSCRIBE":
+			s.subs[args[1]] = append(s.subs[args[1]], c)
+			fmt.Fprintf(c, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(args[1]), args[1])
+		case "PUBLISH":
+			for _, sub := range s.subs[args[1]] {
+				fmt.Fprintf(sub, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(args[1]), args[1], len(args[2]), args[2])
+			}
+			fmt.Fprintf(c, ":%d\r\n", len(s.subs[args[1]]))
+		default:
+			fmt.Fprint(c, "-ERR unknown command\r\n")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func TestInvalidation(t *testing.T) {
+	addr, _ := startFakeRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, b := cache.NewTable("users"), cache.NewTable("users")
+	b.Add("k", 0, "stale")
+	if err := a.AttachBus(ctx, redisbus.New(addr, "inv")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AttachBus(ctx, redisbus.New(addr, "inv")); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Add("k", 0, "fresh")
+	waitFor(t, func() bool { return !b.Exists("k") })
+	time.Sleep(20 * time.Millisecond)
+	if item, err := a.Value("k"); err != nil || item.Value() != "fresh" {
+		t.Error("Error writer lost its own value", err)
+	}
+
+	b.Add("k", 0, "from b")
+	waitFor(t, func() bool { return !a.Exists("k") })
+	a.Flush()
+	waitFor(t, func() bool { return b.Count() == 0 })
+}
+
+func TestResubscribeFlushes(t *testing.T) {
+	addr, server := startFakeRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	table := cache.NewTable("resubscribe")
+	tr := redisbus.New(addr, "inv")
+	tr.ReconnectDelay = time.Millisecond
+	if err := table.AttachBus(ctx, tr); err != nil {
+		t.Fatal(err)
+	}
+	table.Add("k", 0, "possibly stale")
+	server.dropSubscribers()
+	waitFor(t, func() bool { return !table.Exists("k") })
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Error condition not reached")
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// ReplaceAll 在一次加锁内用values替换表中的全部元素, 有效期均为lifeSpan, 读者不会看到空表.
+// 替换后对不再存在的旧元素触发删除回调, 对所有新元素触发添加回调
+func (table *CacheTable) ReplaceAll(values map[interface{}]interface{}, lifeSpan time.Duration) error {
+	table.Lock()
+	if table.rejectsWrites() {
+		table.Unlock()
+		return ErrTableClosed
+	}
+	now := table.clock.Now()
+	items := make(map[interface{}]*CacheItem, len(values))
+	var size int64
+	for key, value := range values {
+		item := newCacheItem(key, lifeSpan, value, now)
+		item.size = table.sizeOf(item)
+		table.stampChecksum(item)
+		item.version = 1
+		if prev, ok := table.items.get(key); ok {
+			item.version = prev.version + 1
+		}
+		size += item.size
+		items[key] = item
+	}
+	old := table.items
+	table.markAllRemoved()
+	table.removeAllFromEvictor()
+	table.items = table.items.empty(len(items))
+	for key, item := range items {
+		table.items.set(key, item)
+	}
+	if table.buckets != nil {
+		table.buckets.reset()
+		for _, item := range items {
+			table.buckets.add(item)
+		}
+	}
+	if table.evictor != nil {
+		for key := range items {
+			table.evictor.OnAdd(key)
+		}
+	}
+	table.viewShared = false
+	table.totalSize = size
+	old.each(func(key interface{}, r *CacheItem) bool {
+		if _, ok := items[key]; !ok {
+			table.emit(context.Background(), OpDelete, r)
+		}
+		return true
+	})
+	for _, item := range items {
+		if item.version == 1 {
+			table.emit(context.Background(), OpAdd, item)
+		} else {
+			table.emit(context.Background(), OpUpdate, item)
+		}
+	}
+	table.logEvent(LevelInfo, EventReplaceAll, nil, "Replacing all items", "old", old.len(), "new", len(items))
+	table.enforceCapacity(context.Background(), nil)
+	// 只对仍在表中的元素执行添加回调, 淘汰掉的和期间被其他写入替换的跳过
+	var added []*CacheItem
+	for key, item := range items {
+		if cur, _ := table.items.get(key); cur == item {
+			added = append(added, item)
+		}
+	}
+
+	addedItem := table.addItem
+	aboutToDeleteItem := table.aboutToDeleteItem
+	table.Unlock()
+
+	old.each(func(key interface{}, r *CacheItem) bool {
+		if _, ok := values[key]; ok {
+			return true
+		}
+		for _, callback := range aboutToDeleteItem {
+			callback(context.Background(), r)
+		}
+		r.RLock()
+		aboutToExpire := r.aboutToExpire
+		r.RUnlock()
+		for _, callback := range aboutToExpire {
+			callback(key)
+		}
+		return true
+	})
+	for _, item := range added {
+		for _, callback := range addedItem {
+			callback(context.Background(), item)
+		}
+	}
+
+	// 重新计算下一次过期检查的时间
+	table.expirationCheck()
+	return nil
+}
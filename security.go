@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ErrUnauthorized 请求未通过鉴权
+var ErrUnauthorized = errors.New("cache: unauthorized")
+
+// Security 管理接口和远程服务的鉴权与TLS配置. 为nil时不做任何检查
+type Security struct {
+	// BearerTokens 允许的静态token, 请求需携带"Authorization: Bearer <token>"
+	BearerTokens []string
+	// Authenticate 自定义鉴权, 返回错误时拒绝请求; 与BearerTokens同时设置时两者都需通过.
+	// 使用mTLS时可从r.TLS.PeerCertificates取得客户端证书
+	Authenticate func(r *http.Request) error
+	// TLSConfig 非nil时Listen返回TLS监听; 设置ClientAuth和ClientCAs即可要求客户端证书(mTLS)
+	TLSConfig *tls.Config
+}
+
+// CheckToken 检查token是否在BearerTokens中, 未配置BearerTokens时总是通过. 供非HTTP协议的服务使用
+func (s *Security) CheckToken(token string) bool {
+	if s == nil || len(s.BearerTokens) == 0 {
+		return true
+	}
+	ok := false
+	for _, t := range s.BearerTokens {
+		// 逐个比较所有token, 耗时与匹配位置无关
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// authorize 检查HTTP请求的token和自定义鉴权
+func (s *Security) authorize(r *http.Request) error {
+	if s == nil {
+		return nil
+	}
+	if len(s.BearerTokens) > 0 {
+		auth := r.Header.Get("Authorization")
+		token, found := strings.CutPrefix(auth, "Bearer ")
+		if !found || !s.CheckToken(token) {
+			return ErrUnauthorized
+		}
+	}
+	if s.Authenticate != nil {
+		return s.Authenticate(r)
+	}
+	return nil
+}
+
+// Authorize 按BearerTokens和Authenticate检查请求, 供需要自行返回鉴权错误的协议(如gRPC)使用; 未通过时返回错误
+func (s *Security) Authorize(r *http.Request) error {
+	return s.authorize(r)
+}
+
+// Wrap 返回先鉴权再调用h的Handler, 鉴权失败时返回401
+func (s *Security) Wrap(h http.Handler) http.Handler {
+	if s == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.authorize(r); err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cache"`)
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Listen 在addr上监听TCP, 配置了TLSConfig时返回TLS监听
+func (s *Security) Listen(addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if s != nil && s.TLSConfig != nil {
+		return tls.NewListener(l, s.TLSConfig), nil
+	}
+	return l, nil
+}
+
+// ListenAndServeAdmin 在addr上提供AdminHandler, 使用sec做鉴权和TLS; sec为nil时不做保护
+func ListenAndServeAdmin(addr string, sec *Security) error {
+	l, err := sec.Listen(addr)
+	if err != nil {
+		return err
+	}
+	return http.Serve(l, sec.Wrap(AdminHandler()))
+}
@@ -0,0 +1,71 @@
+//go:build gorilla
+
+package sessionstore
+
+import (
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// GorillaStore 把Store适配为gorilla/sessions.Store, 会话使用gorilla的*sessions.Session类型.
+// 需要用-tags gorilla构建, 并在go.mod中引入github.com/gorilla/sessions
+type GorillaStore struct {
+	*Store
+}
+
+var _ sessions.Store = (*GorillaStore)(nil)
+
+// Gorilla 返回st的gorilla/sessions.Store适配器, 与st共享会话和过期回调
+func (st *Store) Gorilla() *GorillaStore {
+	return &GorillaStore{st}
+}
+
+// Get 同gorilla/sessions: 通过请求的Registry缓存, 同一请求中多次调用得到同一个会话
+func (g *GorillaStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(g, name)
+}
+
+// New 同Store.New, 返回gorilla的会话类型
+func (g *GorillaStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	s, err := g.Store.New(r, name)
+	gs := sessions.NewSession(g, name)
+	gs.ID, gs.Values, gs.IsNew = s.ID, s.Values, s.IsNew
+	o := s.Options
+	gs.Options = &sessions.Options{
+		Path:     o.Path,
+		Domain:   o.Domain,
+		MaxAge:   o.MaxAge,
+		Secure:   o.Secure,
+		HttpOnly: o.HttpOnly,
+		SameSite: o.SameSite,
+	}
+	return gs, err
+}
+
+// Save 同Store.Save, 新会话保存后gs.ID为生成的会话ID
+func (g *GorillaStore) Save(r *http.Request, w http.ResponseWriter, gs *sessions.Session) error {
+	s := &Session{
+		ID:      gs.ID,
+		Values:  gs.Values,
+		Options: &Options{},
+		IsNew:   gs.IsNew,
+		store:   g.Store,
+		name:    gs.Name(),
+	}
+	if o := gs.Options; o != nil {
+		*s.Options = Options{
+			Path:     o.Path,
+			Domain:   o.Domain,
+			MaxAge:   o.MaxAge,
+			Secure:   o.Secure,
+			HttpOnly: o.HttpOnly,
+			SameSite: o.SameSite,
+		}
+	} else {
+		*s.Options = *g.Store.Options
+	}
+	err := g.Store.Save(r, w, s)
+	gs.ID = s.ID
+	return err
+}
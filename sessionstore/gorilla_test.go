@@ -0,0 +1,36 @@
+//go:build gorilla
+
+package sessionstore_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cache"
+	"cache/sessionstore"
+)
+
+func TestGorillaStore(t *testing.T) {
+	store := sessionstore.New(cache.NewTable("gorillaSessions"), 60).Gorilla()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	s, err := store.Get(req, "sid")
+	if err != nil || !s.IsNew {
+		t.Fatal("Error expected a new session", err)
+	}
+	if again, _ := store.Get(req, "sid"); again != s {
+		t.Error("Error caching session in request registry")
+	}
+	s.Values["user"] = 42
+	rec := httptest.NewRecorder()
+	if err := s.Save(req, rec); err != nil || s.ID == "" {
+		t.Fatal("Error saving session", err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(rec.Result().Cookies()[0])
+	if s, err := store.Get(req, "sid"); err != nil || s.IsNew || s.Values["user"] != 42 {
+		t.Error("Error loading saved session", err)
+	}
+}
@@ -0,0 +1,198 @@
+// Package sessionstore 把Web会话保存在CacheTable中. Store实现的是本包的SessionStore接口, 方法(Get, New, Save)
+// 与gorilla/sessions.Store一一对应, Session和Options的字段也与其相同. 需要sessions.Store时用-tags gorilla构建,
+// 并通过Store.Gorilla取得适配器; 默认构建不依赖gorilla/sessions.
+//
+// Cookie中只保存随机生成的会话ID, 会话数据留在进程内. 会话的有效期为MaxAge, 每次读取都会顺延(滑动过期),
+// 过期时调用OnExpire设置的回调:
+//
+//	store := sessionstore.New(cache.Cache("sessions"), 30*60)
+//	store.OnExpire(func(id string, values map[interface{}]interface{}) { log.Println("session expired", id) })
+//	s, _ := store.Get(r, "sid")
+//	s.Values["user"] = 42
+//	err := s.Save(r, w)
+package sessionstore
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cache"
+)
+
+// Options 会话Cookie的属性, 与gorilla/sessions.Options相同. MaxAge<0表示删除会话, 0表示浏览器会话Cookie(服务端不过期)
+type Options struct {
+	Path     string
+	Domain   string
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+}
+
+// Session 一个会话, 与gorilla/sessions.Session相同
+type Session struct {
+	// ID 会话ID, 新会话在第一次Save时生成
+	ID      string
+	Values  map[interface{}]interface{}
+	Options *Options
+	IsNew   bool
+	store   *Store
+	name    string
+}
+
+// Name 返回会话(Cookie)名
+func (s *Session) Name() string {
+	return s.name
+}
+
+// Store 返回会话所属的Store
+func (s *Session) Store() *Store {
+	return s.store
+}
+
+// Save 同Store.Save
+func (s *Session) Save(r *http.Request, w http.ResponseWriter) error {
+	return s.store.Save(r, w, s)
+}
+
+// SessionStore 与gorilla/sessions.Store相同的方法集, Session为本包的类型
+type SessionStore interface {
+	Get(r *http.Request, name string) (*Session, error)
+	New(r *http.Request, name string) (*Session, error)
+	Save(r *http.Request, w http.ResponseWriter, s *Session) error
+}
+
+var _ SessionStore = (*Store)(nil)
+
+// Store 基于CacheTable的会话存储
+type Store struct {
+	// Options 新会话使用的Cookie属性
+	Options *Options
+
+	table *cache.CacheTable
+
+	mu       sync.RWMutex
+	onExpire func(id string, values map[interface{}]interface{})
+}
+
+// New 创建把会话保存在table中的Store, maxAge为会话有效期(秒)
+func New(table *cache.CacheTable, maxAge int) *Store {
+	return &Store{
+		table:   table,
+		Options: &Options{Path: "/", MaxAge: maxAge, HttpOnly: true},
+	}
+}
+
+// OnExpire 设置会话因过期或被淘汰而移除时的回调; 通过MaxAge<0删除的会话不会调用
+func (st *Store) OnExpire(f func(id string, values map[interface{}]interface{})) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.onExpire = f
+}
+
+// Get 返回请求中name对应的会话, Cookie不存在或会话已过期时返回新会话.
+// 与gorilla/sessions不同, 同一请求中多次调用会得到不同的Session, 调用方应在请求内复用
+func (st *Store) Get(r *http.Request, name string) (*Session, error) {
+	return st.New(r, name)
+}
+
+// New 同Get, 对应gorilla/sessions.Store.New
+func (st *Store) New(r *http.Request, name string) (*Session, error) {
+	opts := *st.Options
+	s := &Session{
+		Values:  make(map[interface{}]interface{}),
+		Options: &opts,
+		IsNew:   true,
+		store:   st,
+		name:    name,
+	}
+	c, err := r.Cookie(name)
+	if err != nil {
+		return s, nil
+	}
+	// Value会顺延有效期
+	item, err := st.table.Value(c.Value)
+	if err != nil {
+		return s, nil
+	}
+	if values, ok := item.Value().(map[interface{}]interface{}); ok {
+		for k, v := range values {
+			s.Values[k] = v
+		}
+		s.ID = c.Value
+		s.IsNew = false
+	}
+	return s, nil
+}
+
+// Save 保存会话并写入Cookie. Options.MaxAge<0时删除会话和Cookie
+func (st *Store) Save(r *http.Request, w http.ResponseWriter, s *Session) error {
+	if s.Options.MaxAge < 0 {
+		if s.ID != "" {
+			if item, err := st.table.Peek(s.ID); err == nil {
+				item.RemoveAboutToExpireCallback()
+			}
+			st.table.Delete(s.ID)
+		}
+		http.SetCookie(w, st.cookie(s, ""))
+		return nil
+	}
+	if s.ID == "" {
+		id, err := newSessionID()
+		if err != nil {
+			return err
+		}
+		s.ID = id
+	}
+	values := make(map[interface{}]interface{}, len(s.Values))
+	for k, v := range s.Values {
+		values[k] = v
+	}
+	item := st.table.Add(s.ID, time.Duration(s.Options.MaxAge)*time.Second, values)
+	if item == nil {
+		return cache.ErrTableClosed
+	}
+	id := s.ID
+	item.SetAboutToExpireCallback(func(interface{}) {
+		st.mu.RLock()
+		f := st.onExpire
+		st.mu.RUnlock()
+		if f != nil {
+			f(id, values)
+		}
+	})
+	http.SetCookie(w, st.cookie(s, s.ID))
+	return nil
+}
+
+func (st *Store) cookie(s *Session, value string) *http.Cookie {
+	c := &http.Cookie{
+		Name:     s.name,
+		Value:    value,
+		Path:     s.Options.Path,
+		Domain:   s.Options.Domain,
+		MaxAge:   s.Options.MaxAge,
+		Secure:   s.Options.Secure,
+		HttpOnly: s.Options.HttpOnly,
+		SameSite: s.Options.SameSite,
+	}
+	if s.Options.MaxAge > 0 {
+		c.Expires = time.Now().Add(time.Duration(s.Options.MaxAge) * time.Second)
+	} else if s.Options.MaxAge < 0 {
+		c.Expires = time.Unix(1, 0)
+	}
+	return c
+}
+
+// newSessionID 返回256位随机数的base32编码
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(b), "="), nil
+}
@@ -0,0 +1,75 @@
+package sessionstore_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cache"
+	"cache/sessionstore"
+	"cache/testutil"
+)
+
+func TestStore(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Unix(0, 0))
+	store := sessionstore.New(cache.NewTable("sessions", cache.WithClock(clock)), 60)
+	var expired string
+	store.OnExpire(func(id string, values map[interface{}]interface{}) {
+		if values["user"] == 42 {
+			expired = id
+		}
+	})
+
+	s, err := store.Get(httptest.NewRequest(http.MethodGet, "/", nil), "sid")
+	if err != nil || !s.IsNew {
+		t.Fatal("Error expected a new session", err)
+	}
+	s.Values["user"] = 42
+	rec := httptest.NewRecorder()
+	if err := s.Save(nil, rec); err != nil {
+		t.Fatal(err)
+	}
+	cookie := rec.Result().Cookies()[0]
+	if cookie.Value != s.ID || cookie.MaxAge != 60 || !cookie.HttpOnly {
+		t.Error("Error unexpected session cookie", cookie)
+	}
+
+	get := func() *sessionstore.Session {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(cookie)
+		s, err := store.Get(req, "sid")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	// 读取会顺延有效期
+	clock.Advance(40 * time.Second)
+	if s := get(); s.IsNew || s.Values["user"] != 42 {
+		t.Error("Error session was not loaded")
+	}
+	clock.Advance(40 * time.Second)
+	if s := get(); s.IsNew {
+		t.Error("Error sliding expiration was not applied")
+	}
+	clock.Advance(61 * time.Second)
+	if s := get(); !s.IsNew || expired != cookie.Value {
+		t.Error("Error session did not expire with callback")
+	}
+
+	// MaxAge<0删除会话, 不调用过期回调
+	expired = ""
+	s = get()
+	s.Values["user"] = 42
+	s.Save(nil, httptest.NewRecorder())
+	s.Options.MaxAge = -1
+	rec = httptest.NewRecorder()
+	s.Save(nil, rec)
+	if c := rec.Result().Cookies()[0]; c.MaxAge >= 0 || c.Value != "" {
+		t.Error("Error deleting session cookie", c)
+	}
+	if expired != "" {
+		t.Error("Error expiry callback called for deleted session")
+	}
+}
@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Settings 可在运行时原子更新的表设置
+type Settings struct {
+	// DefaultTTL Set等不带有效期参数的方法使用的有效期
+	DefaultTTL time.Duration
+	// MaxItems 元素数量上限, <=0表示不限制
+	MaxItems int
+	// LowWatermark 大于0且小于MaxItems时, 元素数超过MaxItems(高水位)后一次淘汰到LowWatermark,
+	// 而不是每次写入淘汰一个, 分摊淘汰开销
+	LowWatermark int
+	// RefreshAheadFraction 在(0,1)之间时, 命中的元素存在时间超过有效期的该比例后异步调用加载函数刷新
+	RefreshAheadFraction float64
+	// MaxValueSize 单个元素大小(按Sizer估算, 含键)的上限, 超出的写入返回ErrValueTooLarge; <=0表示不限制
+	MaxValueSize int64
+	// OversizePassThrough 为true时加载得到的过大的值仍返回给Value的调用方, 只是不缓存
+	OversizePassThrough bool
+	// MaxExpirePerSweep 每次过期检查最多移除的元素数, 剩余的过期元素在ExpireSweepDelay后继续移除,
+	// 以限制过期检查持有写锁的时间; <=0表示不限制
+	MaxExpirePerSweep int
+	// MinCleanupInterval 过期检查定时器的最小间隔, 避免大量即将过期的元素导致定时器频繁触发;
+	// 元素不会提前移除, 间隔内陆续到期的元素在下一次检查中一并移除. 0表示不限制
+	MinCleanupInterval time.Duration
+	// MaxCleanupInterval 大于0时过期检查定时器的最大间隔, 即使最早的元素还很久才过期
+	MaxCleanupInterval time.Duration
+	// CleanupJitter 在(0,1]之间时, 过期检查定时器的间隔随机增加最多该比例, 避免多个表或进程同时检查;
+	// 增加后仍不超过MaxCleanupInterval
+	CleanupJitter float64
+	// ExpiredGrace 大于0时过期元素移除后保留该时长, 期间可用Stale查看, 加载函数失败时Value返回该元素(stale-if-error)
+	ExpiredGrace time.Duration
+}
+
+// Settings 返回当前设置
+func (table *CacheTable) Settings() Settings {
+	if s := table.settings.Load(); s != nil {
+		return *s
+	}
+	return Settings{}
+}
+
+// SetSettings 原子替换表设置; MaxItems变小时立即淘汰多出的元素
+func (table *CacheTable) SetSettings(s Settings) {
+	table.UpdateSettings(func(cur *Settings) { *cur = s })
+}
+
+// UpdateSettings 在当前设置的副本上调用fn并原子替换, 返回更新后的设置
+func (table *CacheTable) UpdateSettings(fn func(s *Settings)) Settings {
+	for {
+		old := table.settings.Load()
+		next := Settings{}
+		if old != nil {
+			next = *old
+		}
+		fn(&next)
+		if table.settings.CompareAndSwap(old, &next) {
+			table.Lock()
+			table.enforceCapacity(context.Background(), nil)
+			table.Unlock()
+			return next
+		}
+	}
+}
+
+// updateSettings 创建表时使用, 不触发淘汰
+func (table *CacheTable) updateSettings(fn func(s *Settings)) {
+	next := table.Settings()
+	fn(&next)
+	table.settings.Store(&next)
+}
+
+// WatchSettings 每隔interval调用load获取设置并应用, 直到ctx结束. load返回错误时保留当前设置并调用onError(可为nil)
+func (table *CacheTable) WatchSettings(ctx context.Context, interval time.Duration, load func() (Settings, error), onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s, err := load()
+		if err != nil {
+			if onError != nil {
+				onError(err)
+			}
+			continue
+		}
+		if s != table.Settings() {
+			table.SetSettings(s)
+			table.RLock()
+			table.logEvent(LevelInfo, EventSettings, nil, "Settings reloaded",
+				"default_ttl", s.DefaultTTL, "max_items", s.MaxItems, "refresh_ahead", s.RefreshAheadFraction)
+			table.RUnlock()
+		}
+	}
+}
+
+// settingsFile 设置文件的格式
+type settingsFile struct {
+	DefaultTTL           Duration `json:"default_ttl" yaml:"default_ttl"`
+	MaxItems             int      `json:"max_items" yaml:"max_items"`
+	LowWatermark         int      `json:"low_watermark" yaml:"low_watermark"`
+	RefreshAheadFraction float64  `json:"refresh_ahead_fraction" yaml:"refresh_ahead_fraction"`
+	MaxValueSize         int64    `json:"max_value_size" yaml:"max_value_size"`
+	OversizePassThrough  bool     `json:"oversize_pass_through" yaml:"oversize_pass_through"`
+	MaxExpirePerSweep    int      `json:"max_expire_per_sweep" yaml:"max_expire_per_sweep"`
+	MinCleanupInterval   Duration `json:"min_cleanup_interval" yaml:"min_cleanup_interval"`
+	MaxCleanupInterval   Duration `json:"max_cleanup_interval" yaml:"max_cleanup_interval"`
+	CleanupJitter        float64  `json:"cleanup_jitter" yaml:"cleanup_jitter"`
+	ExpiredGrace         Duration `json:"expired_grace" yaml:"expired_grace"`
+}
+
+// SettingsFromFile 返回从YAML或JSON文件读取设置的load函数, 用于WatchSettings
+func SettingsFromFile(path string) func() (Settings, error) {
+	return func() (Settings, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Settings{}, err
+		}
+		var f settingsFile
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			err = yaml.Unmarshal(data, &f)
+		case ".json":
+			err = json.Unmarshal(data, &f)
+		default:
+			return Settings{}, fmt.Errorf("cache: unsupported settings file type %q", path)
+		}
+		if err != nil {
+			return Settings{}, fmt.Errorf("cache: parsing %s: %w", path, err)
+		}
+		return Settings{
+			DefaultTTL:           time.Duration(f.DefaultTTL),
+			MaxItems:             f.MaxItems,
+			LowWatermark:         f.LowWatermark,
+			RefreshAheadFraction: f.RefreshAheadFraction,
+			MaxValueSize:         f.MaxValueSize,
+			OversizePassThrough:  f.OversizePassThrough,
+			MaxExpirePerSweep:    f.MaxExpirePerSweep,
+			MinCleanupInterval:   time.Duration(f.MinCleanupInterval),
+			MaxCleanupInterval:   time.Duration(f.MaxCleanupInterval),
+			CleanupJitter:        f.CleanupJitter,
+			ExpiredGrace:         time.Duration(f.ExpiredGrace),
+		}, nil
+	}
+}
+
+// cleanupDelay 按CleanupJitter和MaxCleanupInterval调整过期检查定时器的间隔d
+func (s *Settings) cleanupDelay(d time.Duration) time.Duration {
+	if s.CleanupJitter > 0 && s.CleanupJitter <= 1 {
+		d += time.Duration(rand.Float64() * s.CleanupJitter * float64(d))
+	}
+	if s.MaxCleanupInterval > 0 && d > s.MaxCleanupInterval {
+		d = s.MaxCleanupInterval
+	}
+	return d
+}
+
+// refreshAhead 命中的元素存在时间超过有效期的RefreshAheadFraction后, 异步重新加载; 每个元素只触发一次
+func (table *CacheTable) refreshAhead(item *CacheItem, now time.Time, loadData loaderFunc, args []interface{}) {
+	fraction := table.Settings().RefreshAheadFraction
+	if loadData == nil || fraction <= 0 || fraction >= 1 || item.lifeSpan <= 0 {
+		return
+	}
+	if now.Sub(item.createdOn) < time.Duration(float64(item.lifeSpan)*fraction) {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&item.refreshing, 0, 1) {
+		return
+	}
+	go table.load(context.Background(), item.key, loadData, args)
+}
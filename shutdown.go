@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DrainTimeout Shutdown把剩余元素写入drainStore的超时时间. 写入不使用Shutdown的ctx,
+// 等待加载超时后ctx已结束, 仍要把元素写出去
+const DrainTimeout = 30 * time.Second
+
+// SetDrainStore 设置Shutdown时写入剩余元素的Store, 通常是TieredCache的L2; s为nil时取消
+func (table *CacheTable) SetDrainStore(s Store) {
+	table.Lock()
+	defer table.Unlock()
+	table.drainStore = s
+}
+
+// WithDrainStore 同SetDrainStore
+func WithDrainStore(s Store) Option {
+	return func(t *CacheTable) { t.drainStore = s }
+}
+
+// Shutdown 有序关闭表: 先拒绝新的写入和加载(返回ErrTableClosed, 读取已缓存的元素不受影响),
+// 等待正在执行的加载函数完成并缓存结果, 然后把未过期的元素按剩余有效期写入SetDrainStore设置的Store、
+// 写入SetSnapshotFile设置的快照文件, 最后停止定时器并关闭表(不清空元素).
+// ctx结束时不再等待加载, 仍会写入Store(最多DrainTimeout)和快照并关闭, 返回的错误包含ctx.Err(). 表已关闭时返回ErrTableClosed
+func (table *CacheTable) Shutdown(ctx context.Context) error {
+	table.Lock()
+	if table.closed {
+		table.Unlock()
+		return ErrTableClosed
+	}
+	table.draining = true
+	table.logEvent(LevelInfo, EventClose, nil, "Shutting down table")
+	table.Unlock()
+
+	var errs []error
+	done := make(chan struct{})
+	go func() {
+		table.loads.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, ctx.Err())
+	}
+
+	if err := table.drain(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := table.Persist(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := table.Close(false); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// rejectsWrites 表已关闭或正在Shutdown, 不接受本地写入; 调用方需持有表锁
+func (table *CacheTable) rejectsWrites() bool {
+	return table.closed || table.draining
+}
+
+// beginLoad 登记一次加载, Shutdown会等待其完成; 表不接受写入时返回false
+func (table *CacheTable) beginLoad() bool {
+	table.RLock()
+	defer table.RUnlock()
+	if table.rejectsWrites() {
+		return false
+	}
+	table.loads.Add(1)
+	return true
+}
+
+// drain 把未过期的元素写入drainStore, 返回第一个写入错误和失败的个数. 写入使用的ctx保留ctx中的值,
+// 但不随ctx取消, 超时为DrainTimeout
+func (table *CacheTable) drain(ctx context.Context) error {
+	table.RLock()
+	store := table.drainStore
+	if store == nil {
+		table.RUnlock()
+		return nil
+	}
+	now := table.clock.Now()
+	items := table.items.values()
+	table.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), DrainTimeout)
+	defer cancel()
+	var (
+		first  error
+		failed int
+	)
+	for _, item := range items {
+		item.RLock()
+		key, value, lifeSpan, accessedOn := item.key, item.value, item.lifeSpan, item.accessedOn
+		item.RUnlock()
+		if lifeSpan > 0 {
+			if lifeSpan -= now.Sub(accessedOn); lifeSpan <= 0 {
+				continue
+			}
+		}
+		if err := store.Set(ctx, key, value, lifeSpan); err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	if first != nil {
+		return fmt.Errorf("draining %d of %d items to store: %w", failed, len(items), first)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package cache
+
+import "reflect"
+
+// Sizer 估算一个元素占用的内存(或自定义的成本), 单位由调用方决定, 通常为字节
+type Sizer func(key, value interface{}) int64
+
+// SetSizer 设置元素大小的估算函数, 只对之后加入的元素生效; 传nil恢复为EstimateSize
+func (table *CacheTable) SetSizer(f Sizer) {
+	table.Lock()
+	defer table.Unlock()
+	table.sizer = f
+}
+
+// sizeOf 计算元素大小, 调用方需持有表锁
+func (table *CacheTable) sizeOf(item *CacheItem) int64 {
+	if table.sizer != nil {
+		return table.sizer(item.key, item.value)
+	}
+	return EstimateSize(item.key) + EstimateSize(item.value)
+}
+
+// Heaviest 返回占用最大的count个元素
+func (table *CacheTable) Heaviest(count int64) []*CacheItem {
+	if count <= 0 {
+		return nil
+	}
+	return table.sortedItems(count, nil, func(a, b *itemStat) bool {
+		return a.item.size > b.item.size
+	})
+}
+
+// 估算时最多递归的层数, 避免深层或环状结构耗时过长
+const maxEstimateDepth = 8
+
+// EstimateSize 通过反射粗略估算v占用的字节数, 不处理共享引用, 结果只用于比较和排查
+func EstimateSize(v interface{}) int64 {
+	if v == nil {
+		return 0
+	}
+	switch x := v.(type) {
+	case string:
+		return int64(len(x))
+	case []byte:
+		return int64(len(x))
+	}
+	return estimateValue(reflect.ValueOf(v), 0)
+}
+
+func estimateValue(v reflect.Value, depth int) int64 {
+	size := int64(v.Type().Size())
+	if depth >= maxEstimateDepth {
+		return size
+	}
+	switch v.Kind() {
+	case reflect.String:
+		size += int64(v.Len())
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			size += estimateValue(v.Elem(), depth+1)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			size += estimateValue(v.Index(i), depth+1)
+		}
+		size += int64(v.Cap()-v.Len()) * int64(v.Type().Elem().Size())
+	case reflect.Array:
+		var elems int64
+		for i := 0; i < v.Len(); i++ {
+			elems += estimateValue(v.Index(i), depth+1)
+		}
+		if elems > size {
+			size = elems
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			size += estimateValue(iter.Key(), depth+1) + estimateValue(iter.Value(), depth+1)
+		}
+	case reflect.Struct:
+		var fields int64
+		for i := 0; i < v.NumField(); i++ {
+			fields += estimateValue(v.Field(i), depth+1)
+		}
+		// 字段之和不含对齐填充, 取两者中较大的
+		if fields > size {
+			size = fields
+		}
+	}
+	return size
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultProtectedRatio EvictSLRU受保护段默认占元素总数的比例
+const DefaultProtectedRatio = 0.8
+
+// WithSegmentedLRU 使用EvictSLRU, 受保护段最多占元素总数的protectedRatio, 其余为试用段;
+// protectedRatio不在(0,1)内时使用DefaultProtectedRatio
+func WithSegmentedLRU(protectedRatio float64) Option {
+	return func(t *CacheTable) {
+		t.evictionPolicy = EvictSLRU
+		t.evictor = newSegmentedLRU(protectedRatio)
+	}
+}
+
+// segmentedLRU EvictSLRU的状态. 新元素进入试用段, 在试用段中再被访问一次(OnAccess, 包括覆盖写入)即晋升到受保护段;
+// 受保护段超出比例时把其中最久未用的元素降回试用段. 淘汰时先从试用段选
+type segmentedLRU struct {
+	mu        sync.Mutex
+	ratio     float64
+	probation *list.List
+	protected *list.List
+	entries   map[interface{}]*list.Element
+}
+
+type slruEntry struct {
+	key       interface{}
+	protected bool
+}
+
+func newSegmentedLRU(protectedRatio float64) *segmentedLRU {
+	if protectedRatio <= 0 || protectedRatio >= 1 {
+		protectedRatio = DefaultProtectedRatio
+	}
+	s := &segmentedLRU{ratio: protectedRatio}
+	s.probation, s.protected = list.New(), list.New()
+	s.entries = make(map[interface{}]*list.Element)
+	return s
+}
+
+func (s *segmentedLRU) OnAdd(key interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = s.probation.PushFront(&slruEntry{key: key})
+}
+
+func (s *segmentedLRU) OnAccess(key interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	entry := e.Value.(*slruEntry)
+	if entry.protected {
+		s.protected.MoveToFront(e)
+		return
+	}
+	s.probation.Remove(e)
+	entry.protected = true
+	s.entries[key] = s.protected.PushFront(entry)
+
+	limit := int(s.ratio * float64(len(s.entries)))
+	if limit < 1 {
+		limit = 1
+	}
+	for s.protected.Len() > limit {
+		oldest := s.protected.Back()
+		s.protected.Remove(oldest)
+		demoted := oldest.Value.(*slruEntry)
+		demoted.protected = false
+		s.entries[demoted.key] = s.probation.PushFront(demoted)
+	}
+}
+
+func (s *segmentedLRU) OnRemove(key interface{}, op MutationOp) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	delete(s.entries, key)
+	if e.Value.(*slruEntry).protected {
+		s.protected.Remove(e)
+	} else {
+		s.probation.Remove(e)
+	}
+}
+
+func (s *segmentedLRU) Victim(keep interface{}) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range []*list.List{s.probation, s.protected} {
+		for e := l.Back(); e != nil; e = e.Prev() {
+			if key := e.Value.(*slruEntry).key; key != keep {
+				return key, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,205 @@
+package cache
+
+import (
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ScoredMember 有序集合的成员及其分数
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+// SortedSet ZAdd等方法使用的有序集合值, 按分数升序排列, 分数相同时按成员排序
+type SortedSet []ScoredMember
+
+func init() {
+	gob.Register(SortedSet{})
+}
+
+func (a ScoredMember) less(b ScoredMember) bool {
+	return a.Score < b.Score || a.Score == b.Score && a.Member < b.Member
+}
+
+// 有序集合的修改规则与List等集合值相同(见ListPush). 整个集合共用一个有效期, 在创建时由lifeSpan指定,
+// 之后的修改不会续期, 适合缓存按时间窗口划分的排行榜
+
+// ZAdd 把members加入key的有序集合, 已有的成员更新分数; 集合不存在时以lifeSpan为有效期创建. 返回新加入的成员数
+func (table *CacheTable) ZAdd(key interface{}, lifeSpan time.Duration, members ...ScoredMember) (int, error) {
+	var added int
+	err := table.updateCollectionTTL("zadd", key, lifeSpan, func(old interface{}) (interface{}, error) {
+		zset, err := asSortedSet(old)
+		if err != nil {
+			return nil, err
+		}
+		next := append(SortedSet(nil), zset...)
+		for _, m := range members {
+			var existed bool
+			next, existed = next.remove(m.Member)
+			if !existed {
+				added++
+			}
+			next = next.insert(m)
+		}
+		return next, nil
+	})
+	return added, err
+}
+
+// ZIncrBy 把key的有序集合中member的分数增加delta, 成员不存在时从0开始; 集合不存在时以lifeSpan为有效期创建. 返回新的分数
+func (table *CacheTable) ZIncrBy(key interface{}, lifeSpan time.Duration, member string, delta float64) (float64, error) {
+	var score float64
+	err := table.updateCollectionTTL("zincrby", key, lifeSpan, func(old interface{}) (interface{}, error) {
+		zset, err := asSortedSet(old)
+		if err != nil {
+			return nil, err
+		}
+		next := append(SortedSet(nil), zset...)
+		if i := next.index(member); i >= 0 {
+			score = next[i].Score
+		}
+		score += delta
+		next, _ = next.remove(member)
+		return next.insert(ScoredMember{Member: member, Score: score}), nil
+	})
+	return score, err
+}
+
+// ZRem 从key的有序集合中移除members, 返回实际移除的成员数
+func (table *CacheTable) ZRem(key interface{}, members ...string) (int, error) {
+	var removed int
+	err := table.updateCollection("zrem", key, func(old interface{}) (interface{}, error) {
+		zset, err := asSortedSet(old)
+		if err != nil {
+			return nil, err
+		}
+		next := append(SortedSet(nil), zset...)
+		for _, m := range members {
+			var existed bool
+			if next, existed = next.remove(m); existed {
+				removed++
+			}
+		}
+		if len(next) == 0 {
+			return nil, nil
+		}
+		return next, nil
+	})
+	return removed, err
+}
+
+// ZScore 返回key的有序集合中member的分数
+func (table *CacheTable) ZScore(key interface{}, member string) (float64, bool, error) {
+	zset, err := table.readSortedSet("zscore", key)
+	if err != nil {
+		return 0, false, err
+	}
+	if i := zset.index(member); i >= 0 {
+		return zset[i].Score, true, nil
+	}
+	return 0, false, nil
+}
+
+// ZCard 返回key的有序集合的成员数
+func (table *CacheTable) ZCard(key interface{}) (int, error) {
+	zset, err := table.readSortedSet("zcard", key)
+	return len(zset), err
+}
+
+// ZRangeByScore 按分数升序返回key的有序集合中分数在[min, max]之间的成员, limit>0时最多返回limit个
+func (table *CacheTable) ZRangeByScore(key interface{}, min, max float64, limit int) ([]ScoredMember, error) {
+	zset, err := table.readSortedSet("zrangebyscore", key)
+	if err != nil {
+		return nil, err
+	}
+	start := sort.Search(len(zset), func(i int) bool { return zset[i].Score >= min })
+	end := sort.Search(len(zset), func(i int) bool { return zset[i].Score > max })
+	if start >= end {
+		return nil, nil
+	}
+	if limit > 0 && end-start > limit {
+		end = start + limit
+	}
+	return append([]ScoredMember(nil), zset[start:end]...), nil
+}
+
+// ZRevRange 按分数降序返回key的有序集合中排名在[start, stop]之间的成员(0为分数最高), 负数从末尾算起; 用于取排行榜前N名
+func (table *CacheTable) ZRevRange(key interface{}, start, stop int) ([]ScoredMember, error) {
+	zset, err := table.readSortedSet("zrevrange", key)
+	if err != nil {
+		return nil, err
+	}
+	n := len(zset)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return nil, nil
+	}
+	members := make([]ScoredMember, 0, stop-start+1)
+	for i := n - 1 - start; i >= n-1-stop; i-- {
+		members = append(members, zset[i])
+	}
+	return members, nil
+}
+
+// readSortedSet 读取key的有序集合, 键不存在时返回nil
+func (table *CacheTable) readSortedSet(op string, key interface{}) (SortedSet, error) {
+	old, err := table.readCollection(key)
+	if err != nil {
+		return nil, err
+	}
+	zset, err := asSortedSet(old)
+	if err != nil {
+		return nil, table.keyError(op, key, err)
+	}
+	return zset, nil
+}
+
+func asSortedSet(v interface{}) (SortedSet, error) {
+	zset, ok := v.(SortedSet)
+	if v != nil && !ok {
+		return nil, fmt.Errorf("%w: holds %T, want SortedSet", ErrTypeMismatch, v)
+	}
+	return zset, nil
+}
+
+// index 返回member的下标, 不存在时返回-1
+func (z SortedSet) index(member string) int {
+	for i, m := range z {
+		if m.Member == member {
+			return i
+		}
+	}
+	return -1
+}
+
+// remove 原地移除member, 调用方需持有z的唯一引用
+func (z SortedSet) remove(member string) (SortedSet, bool) {
+	i := z.index(member)
+	if i < 0 {
+		return z, false
+	}
+	return append(z[:i], z[i+1:]...), true
+}
+
+// insert 原地按顺序插入m, 调用方需持有z的唯一引用
+func (z SortedSet) insert(m ScoredMember) SortedSet {
+	i := sort.Search(len(z), func(i int) bool { return m.less(z[i]) })
+	z = append(z, ScoredMember{})
+	copy(z[i+1:], z[i:])
+	z[i] = m
+	return z
+}
@@ -0,0 +1,238 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 直方图分桶数量, 第i个桶记录 (2^(i-1), 2^i] 微秒的耗时
+const latencyBuckets = 40
+
+// latencyHistogram 按2的幂分桶统计耗时, 内存占用固定
+type latencyHistogram struct {
+	mu     sync.Mutex
+	counts [latencyBuckets]uint64
+	total  uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for us := d / time.Microsecond; us > 1 && i < latencyBuckets-1; us = (us + 1) / 2 {
+		i++
+	}
+	h.mu.Lock()
+	h.counts[i]++
+	h.total++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+	h.mu.Unlock()
+}
+
+// percentile 返回第q分位所在桶的上界, 不超过观测到的最大值
+func (h *latencyHistogram) percentile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(h.total) + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			upper := time.Duration(1<<uint(i)) * time.Microsecond
+			if upper > h.max {
+				return h.max
+			}
+			return upper
+		}
+	}
+	return h.max
+}
+
+func (h *latencyHistogram) summary() LatencySummary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := LatencySummary{
+		Count: h.total,
+		P50:   h.percentile(0.50),
+		P95:   h.percentile(0.95),
+		P99:   h.percentile(0.99),
+		Max:   h.max,
+	}
+	if h.total > 0 {
+		s.Mean = h.sum / time.Duration(h.total)
+	}
+	return s
+}
+
+// 命中率滑动窗口按分钟分槽, 最多保留一小时
+const hitWindowSlots = 60
+
+type hitSlot struct {
+	minute int64
+	hits   uint64
+	misses uint64
+}
+
+// hitWindow 每分钟一个槽的环形缓冲, 记录近期的命中/未命中次数
+type hitWindow struct {
+	mu    sync.Mutex
+	slots [hitWindowSlots]hitSlot
+}
+
+func (w *hitWindow) record(now time.Time, hit bool) {
+	minute := now.Unix() / 60
+	w.mu.Lock()
+	s := &w.slots[minute%hitWindowSlots]
+	if s.minute != minute {
+		*s = hitSlot{minute: minute}
+	}
+	if hit {
+		s.hits++
+	} else {
+		s.misses++
+	}
+	w.mu.Unlock()
+}
+
+// counts 汇总最近window时长(向上取整到分钟)内的命中/未命中次数
+func (w *hitWindow) counts(now time.Time, window time.Duration) (hits, misses uint64) {
+	n := int64((window + time.Minute - 1) / time.Minute)
+	if n > hitWindowSlots {
+		n = hitWindowSlots
+	}
+	current := now.Unix() / 60
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, s := range w.slots {
+		if s.minute > current-n && s.minute <= current {
+			hits += s.hits
+			misses += s.misses
+		}
+	}
+	return hits, misses
+}
+
+// series 返回最近window时长内每分钟的命中/未命中次数, 按时间顺序, 没有访问的分钟也会返回
+func (w *hitWindow) series(now time.Time, window time.Duration) []HitRatePoint {
+	n := int64((window + time.Minute - 1) / time.Minute)
+	if n > hitWindowSlots {
+		n = hitWindowSlots
+	}
+	current := now.Unix() / 60
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	points := make([]HitRatePoint, 0, n)
+	for m := current - n + 1; m <= current; m++ {
+		p := HitRatePoint{Minute: time.Unix(m*60, 0)}
+		if s := w.slots[m%hitWindowSlots]; s.minute == m {
+			p.Hits, p.Misses = s.hits, s.misses
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+// HitRatePoint 一分钟内的命中/未命中次数
+type HitRatePoint struct {
+	Minute time.Time `json:"minute"`
+	Hits   uint64    `json:"hits"`
+	Misses uint64    `json:"misses"`
+}
+
+// LatencySummary 耗时分位数汇总, 分位数精度为2倍
+type LatencySummary struct {
+	Count uint64
+	Mean  time.Duration
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// Stats 表的运行统计
+type Stats struct {
+	Items int
+	// Size 所有元素Size之和
+	Size int64
+
+	// Hits, Misses 表创建以来Value的命中/未命中次数
+	Hits   uint64
+	Misses uint64
+
+	// Evictions 因超出容量被淘汰的元素数量
+	Evictions uint64
+
+	// LoaderLatency 数据加载函数的调用耗时
+	LoaderLatency LatencySummary
+	// LoaderFailures 加载函数未返回数据的次数
+	LoaderFailures uint64
+
+	// Oversized 因超过MaxValueSize被拒绝的写入次数
+	Oversized uint64
+	// NotAdmitted 被AdmissionPolicy拒绝的写入次数
+	NotAdmitted uint64
+	// MissingFiltered 被MissingFilter拦截而没有调用加载函数的未命中次数
+	MissingFiltered uint64
+
+	// Rebalance 最近一次Rebalance的进度
+	Rebalance RebalanceStats
+}
+
+// Stats 返回当前表的统计快照
+func (table *CacheTable) Stats() Stats {
+	table.RLock()
+	items, size := table.items.len(), table.totalSize
+	var filtered uint64
+	if table.missing != nil {
+		filtered = atomic.LoadUint64(&table.missing.filtered)
+	}
+	table.RUnlock()
+
+	return Stats{
+		Items:           items,
+		Size:            size,
+		Hits:            atomic.LoadUint64(&table.hits),
+		Misses:          atomic.LoadUint64(&table.misses),
+		Evictions:       atomic.LoadUint64(&table.evictions),
+		LoaderLatency:   table.loaderLatency.summary(),
+		LoaderFailures:  atomic.LoadUint64(&table.loaderFailures),
+		Oversized:       atomic.LoadUint64(&table.oversized),
+		NotAdmitted:     atomic.LoadUint64(&table.notAdmitted),
+		MissingFiltered: filtered,
+		Rebalance:       table.rebalance.snapshot(),
+	}
+}
+
+// HitRate 返回最近window时长内的命中率, 窗口按分钟取整且最长一小时, 无访问时返回0
+func (table *CacheTable) HitRate(window time.Duration) float64 {
+	table.RLock()
+	now := table.clock.Now()
+	table.RUnlock()
+	hits, misses := table.hitWindow.counts(now, window)
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// HitRateSeries 返回最近window时长(最长一小时)内每分钟的命中/未命中次数
+func (table *CacheTable) HitRateSeries(window time.Duration) []HitRatePoint {
+	return table.hitWindow.series(table.now(), window)
+}
+
+func (table *CacheTable) recordAccess(now time.Time, hit bool) {
+	if hit {
+		atomic.AddUint64(&table.hits, 1)
+	} else {
+		atomic.AddUint64(&table.misses, 1)
+	}
+	table.hitWindow.record(now, hit)
+}
@@ -0,0 +1,165 @@
+package cache
+
+// 键为字符串时的快速路径. 以interface{}为参数的方法需要把字符串键装箱, 每次调用分配一次;
+// 下面的方法直接用字符串查找, 命中时不分配. 未命中、表已关闭或设置了中间件(Use)时转为对应的通用方法,
+// 结果与通用方法相同. 键的哈希(键锁分段、准入统计、MissingFilter)对字符串使用专门的实现, 不需要额外设置.
+//
+// 设置WithStringKeys后, 表内的字符串键保存在以string为键的map中, 查找时按字符串哈希, 不经过interface{}的
+// 类型分派; 其他类型的键仍保存在以interface{}为键的map中, 两者可以混用. 写入仍要把字符串装箱一次,
+// 因为元素本身以interface{}保存键(CacheItem.Key); 需要写入也不分配时使用BytesTable
+
+// WithStringKeys 表内的字符串键使用以string为键的map保存, 键基本都是字符串时减少查找开销; 创建表时设置
+func WithStringKeys() Option {
+	return func(t *CacheTable) {
+		if !t.items.stringKeys {
+			t.items = t.items.withMode(true)
+		}
+	}
+}
+
+// itemMap 表内保存元素的map. stringKeys为true时字符串键保存在str中, 其他键保存在other中;
+// 否则所有键保存在other中. 调用方需持有表锁
+type itemMap struct {
+	stringKeys bool
+	str        map[string]*CacheItem
+	other      map[interface{}]*CacheItem
+}
+
+func newItemMap(stringKeys bool, size int) itemMap {
+	m := itemMap{stringKeys: stringKeys}
+	if stringKeys {
+		m.str = make(map[string]*CacheItem, size)
+		m.other = make(map[interface{}]*CacheItem)
+	} else {
+		m.other = make(map[interface{}]*CacheItem, size)
+	}
+	return m
+}
+
+// withMode 返回按stringKeys保存的副本
+func (m itemMap) withMode(stringKeys bool) itemMap {
+	next := newItemMap(stringKeys, m.len())
+	m.each(func(key interface{}, item *CacheItem) bool {
+		next.set(key, item)
+		return true
+	})
+	return next
+}
+
+// empty 返回同一保存方式的空map
+func (m itemMap) empty(size int) itemMap {
+	return newItemMap(m.stringKeys, size)
+}
+
+// clone 返回同一保存方式的浅拷贝
+func (m itemMap) clone() itemMap {
+	return m.withMode(m.stringKeys)
+}
+
+func (m itemMap) get(key interface{}) (*CacheItem, bool) {
+	if m.stringKeys {
+		if s, ok := key.(string); ok {
+			item, ok := m.str[s]
+			return item, ok
+		}
+	}
+	item, ok := m.other[key]
+	return item, ok
+}
+
+// getString 同get, 不装箱
+func (m itemMap) getString(key string) (*CacheItem, bool) {
+	if m.stringKeys {
+		item, ok := m.str[key]
+		return item, ok
+	}
+	item, ok := m.other[key]
+	return item, ok
+}
+
+func (m itemMap) set(key interface{}, item *CacheItem) {
+	if m.stringKeys {
+		if s, ok := key.(string); ok {
+			m.str[s] = item
+			return
+		}
+	}
+	m.other[key] = item
+}
+
+func (m itemMap) delete(key interface{}) {
+	if m.stringKeys {
+		if s, ok := key.(string); ok {
+			delete(m.str, s)
+			return
+		}
+	}
+	delete(m.other, key)
+}
+
+func (m itemMap) len() int {
+	return len(m.str) + len(m.other)
+}
+
+// values 返回所有元素
+func (m itemMap) values() []*CacheItem {
+	items := make([]*CacheItem, 0, m.len())
+	m.each(func(_ interface{}, item *CacheItem) bool {
+		items = append(items, item)
+		return true
+	})
+	return items
+}
+
+// each 遍历所有元素, fn返回false时停止, 顺序不确定
+func (m itemMap) each(fn func(key interface{}, item *CacheItem) bool) {
+	for k, item := range m.str {
+		if !fn(k, item) {
+			return
+		}
+	}
+	for k, item := range m.other {
+		if !fn(k, item) {
+			return
+		}
+	}
+}
+
+// ValueString 同Value, 命中时不分配
+func (table *CacheTable) ValueString(key string) (*CacheItem, error) {
+	if table.intercept() == nil {
+		table.RLock()
+		item, ok := table.items.getString(key)
+		if ok && !table.closed {
+			st := table.readState()
+			table.RUnlock()
+			if st.trace != nil {
+				st.trace.record(st.now, CallGet, item.key)
+			}
+			table.recordAccess(st.now, true)
+			table.hit(item, st, nil)
+			return table.readCopy(item)
+		}
+		table.RUnlock()
+	}
+	return table.Value(key)
+}
+
+// PeekString 同Peek, 存在时不分配
+func (table *CacheTable) PeekString(key string) (*CacheItem, error) {
+	table.RLock()
+	item, ok := table.items.getString(key)
+	table.RUnlock()
+	if !ok {
+		return table.Peek(key)
+	}
+	return table.readCopy(item)
+}
+
+// ExistsString 同Exists, 不分配
+func (table *CacheTable) ExistsString(key string) bool {
+	table.RLock()
+	defer table.RUnlock()
+	_, ok := table.items.getString(key)
+	return ok
+}
@@ -0,0 +1,16 @@
+package cache
+
+import "time"
+
+// Table CacheTable的基本读写接口, 应用代码依赖Table即可在本地表和远程客户端(cacheserver.Client)之间切换
+type Table interface {
+	// Add 写入失败时返回nil
+	Add(key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem
+	Value(key interface{}, args ...interface{}) (*CacheItem, error)
+	Delete(key interface{}) (*CacheItem, error)
+	Exists(key interface{}) bool
+	Count() int
+	Flush()
+}
+
+var _ Table = (*CacheTable)(nil)
@@ -0,0 +1,37 @@
+package testutil_test
+
+import (
+	"testing"
+	"time"
+
+	"cache"
+	"cache/testutil"
+)
+
+func TestFakeClockExpiration(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Unix(1000, 0))
+	table := cache.Cache("testFakeClockExpiration")
+	table.SetClock(clock)
+
+	expired := make(chan interface{}, 1)
+	table.SetAboutToDeleteItemCallback(func(item *cache.CacheItem) {
+		expired <- item.Key()
+	})
+	table.Add("short", time.Second, "v")
+	table.Add("long", time.Minute, "v")
+
+	clock.Advance(999 * time.Millisecond)
+	if !table.Exists("short") {
+		t.Fatal("Item expired too early")
+	}
+	clock.Advance(time.Millisecond)
+	if table.Exists("short") || !table.Exists("long") {
+		t.Fatal("Error expiring item on fake clock")
+	}
+	if key := <-expired; key != "short" {
+		t.Error("Error running delete callback", key)
+	}
+	if clock.PendingTimers() != 1 {
+		t.Error("Expected cleanup timer for remaining item, got", clock.PendingTimers())
+	}
+}
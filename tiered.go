@@ -0,0 +1,238 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Store TieredCache的二级存储. 键不存在时Get返回ErrKeyNotFound, lifeSpan为剩余有效期, 0表示不过期
+type Store interface {
+	Get(ctx context.Context, key interface{}) (value interface{}, lifeSpan time.Duration, err error)
+	Set(ctx context.Context, key interface{}, value interface{}, lifeSpan time.Duration) error
+	Delete(ctx context.Context, key interface{}) error
+}
+
+// InvalidatingStore 能通知其他进程写入的Store. Invalidations在ctx结束前输出被修改或删除的键, nil表示整个存储被清空
+type InvalidatingStore interface {
+	Store
+	Invalidations(ctx context.Context) <-chan interface{}
+}
+
+// TieredCache 两级缓存: 读取依次查L1(本地表)、L2(Store)和加载函数, 写入同时更新两级.
+// L2实现InvalidatingStore时, L2上的修改和删除会使L1中的副本失效; 自身的写入也会收到通知, 只导致下次读取回源到L2
+type TieredCache struct {
+	l1 *CacheTable
+	l2 Store
+
+	mu     sync.RWMutex
+	l1TTL  time.Duration
+	loader func(ctx context.Context, key interface{}) (interface{}, time.Duration, error)
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewTieredCache 用本地表l1和二级存储l2组成两级缓存, 不再使用时需调用Close
+func NewTieredCache(l1 *CacheTable, l2 Store) *TieredCache {
+	ctx, cancel := context.WithCancel(context.Background())
+	tc := &TieredCache{l1: l1, l2: l2, cancel: cancel, done: make(chan struct{})}
+	if s, ok := l2.(InvalidatingStore); ok {
+		go tc.watch(ctx, s.Invalidations(ctx))
+	} else {
+		close(tc.done)
+	}
+	return tc
+}
+
+func (tc *TieredCache) watch(ctx context.Context, keys <-chan interface{}) {
+	defer close(tc.done)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case key, ok := <-keys:
+			if !ok {
+				return
+			}
+			tc.Invalidate(key)
+		}
+	}
+}
+
+// L1 返回本地表
+func (tc *TieredCache) L1() *CacheTable {
+	return tc.l1
+}
+
+// SetL1TTL 设置L1中副本的最长有效期, 用于在没有失效通知时限制读到旧值的时间; 0表示沿用L2的有效期
+func (tc *TieredCache) SetL1TTL(d time.Duration) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.l1TTL = d
+}
+
+// SetLoader 设置两级都未命中时调用的加载函数, 结果写入两级
+func (tc *TieredCache) SetLoader(f func(ctx context.Context, key interface{}) (interface{}, time.Duration, error)) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.loader = f
+}
+
+// l1LifeSpan 返回写入L1时使用的有效期
+func (tc *TieredCache) l1LifeSpan(lifeSpan time.Duration) time.Duration {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	if tc.l1TTL > 0 && (lifeSpan == 0 || lifeSpan > tc.l1TTL) {
+		return tc.l1TTL
+	}
+	return lifeSpan
+}
+
+// Get 依次从L1、L2和加载函数读取; L2命中时回填L1, 加载成功时写入两级. 都没有时返回ErrKeyNotFound
+func (tc *TieredCache) Get(ctx context.Context, key interface{}) (interface{}, error) {
+	if item, err := tc.l1.ValueContext(ctx, key); err == nil {
+		return item.Value(), nil
+	}
+
+	value, lifeSpan, err := tc.l2.Get(ctx, key)
+	if err == nil {
+		tc.l1.AddContext(ctx, key, tc.l1LifeSpan(lifeSpan), value)
+		return value, nil
+	}
+	if !errors.Is(err, ErrKeyNotFound) {
+		return nil, err
+	}
+
+	tc.mu.RLock()
+	loader := tc.loader
+	tc.mu.RUnlock()
+	if loader == nil {
+		return nil, ErrKeyNotFound
+	}
+	value, lifeSpan, err = loader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := tc.Set(ctx, key, value, lifeSpan); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Set 先写L2再写L1; L2写入失败时不修改L1
+func (tc *TieredCache) Set(ctx context.Context, key interface{}, value interface{}, lifeSpan time.Duration) error {
+	if err := tc.l2.Set(ctx, key, value, lifeSpan); err != nil {
+		return err
+	}
+	tc.l1.AddContext(ctx, key, tc.l1LifeSpan(lifeSpan), value)
+	return nil
+}
+
+// Delete 从两级中删除键, L2中不存在不算错误
+func (tc *TieredCache) Delete(ctx context.Context, key interface{}) error {
+	tc.l1.DeleteContext(ctx, key)
+	if err := tc.l2.Delete(ctx, key); err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Invalidate 丢弃L1中的副本, key为nil时清空L1
+func (tc *TieredCache) Invalidate(key interface{}) {
+	if key == nil {
+		tc.l1.Flush()
+		return
+	}
+	tc.l1.Delete(key)
+}
+
+// Close 停止接收失效通知, 不关闭L1和L2
+func (tc *TieredCache) Close() {
+	tc.cancel()
+	<-tc.done
+}
+
+// TableStore 把Table(本地表或远程客户端)用作Store. t为*CacheTable时返回的Store实现InvalidatingStore,
+// 通知来自表的CDC, 订阅落后时通知nil
+func TableStore(t Table) Store {
+	if ct, ok := t.(*CacheTable); ok {
+		return cacheTableStore{tableStore{ct}, ct}
+	}
+	return tableStore{t}
+}
+
+type tableStore struct {
+	t Table
+}
+
+func (s tableStore) Get(ctx context.Context, key interface{}) (interface{}, time.Duration, error) {
+	var item *CacheItem
+	var err error
+	if ct, ok := s.t.(*CacheTable); ok {
+		item, err = ct.ValueContext(ctx, key)
+	} else {
+		item, err = s.t.Value(key)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return item.Value(), item.LifeSpan(), nil
+}
+
+func (s tableStore) Set(ctx context.Context, key interface{}, value interface{}, lifeSpan time.Duration) error {
+	if s.t.Add(key, lifeSpan, value) == nil {
+		return errTableAdd
+	}
+	return nil
+}
+
+// errTableAdd Table.Add返回nil时的错误, 具体原因已由Table自行处理(如cacheserver.Client的OnError)
+var errTableAdd = errors.New("Table rejected the write")
+
+func (s cacheTableStore) Set(ctx context.Context, key interface{}, value interface{}, lifeSpan time.Duration) error {
+	_, err := s.table.TryAddContext(ctx, key, lifeSpan, value)
+	return err
+}
+
+func (s tableStore) Delete(ctx context.Context, key interface{}) error {
+	_, err := s.t.Delete(key)
+	return err
+}
+
+type cacheTableStore struct {
+	tableStore
+	table *CacheTable
+}
+
+func (s cacheTableStore) Invalidations(ctx context.Context) <-chan interface{} {
+	keys := make(chan interface{})
+	// 在返回前订阅, 之后的变更都不会遗漏
+	mutations := s.table.CDC(ctx)
+	go func() {
+		defer close(keys)
+		for {
+			for m := range mutations {
+				if m.Op == OpAdd {
+					continue
+				}
+				select {
+				case keys <- m.Key:
+				case <-ctx.Done():
+					return
+				}
+			}
+			// 订阅因落后被关闭时无法知道遗漏了哪些键, 清空L1后重新订阅
+			select {
+			case keys <- nil:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil || s.table.Closed() {
+				return
+			}
+			mutations = s.table.CDC(ctx)
+		}
+	}()
+	return keys
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// TraceRecord 访问记录中的一条, 键按fmt.Sprint转为字符串. 以JSON Lines格式写出, 如
+// {"time":"2024-01-02T15:04:05.123Z","op":"get","key":"users/42"}
+type TraceRecord struct {
+	Time time.Time `json:"time"`
+	// Op CallGet(Value, 命中和未命中), CallAdd(写入)或CallDelete(删除, 不含过期和淘汰)
+	Op  CallKind `json:"op"`
+	Key string   `json:"key"`
+}
+
+// traceRecorder 把访问记录写入缓冲的writer
+type traceRecorder struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	enc *json.Encoder
+	err error
+}
+
+func (r *traceRecorder) record(now time.Time, op CallKind, key interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.enc.Encode(TraceRecord{Time: now, Op: op, Key: fmt.Sprint(key)})
+	}
+}
+
+// StartTrace 开始把表的访问记录写入w, 用Replay在不同的淘汰策略和容量下重放以比较命中率.
+// 已在记录时先停止之前的记录
+func (table *CacheTable) StartTrace(w io.Writer) {
+	bw := bufio.NewWriter(w)
+	r := &traceRecorder{w: bw, enc: json.NewEncoder(bw)}
+	table.Lock()
+	old := table.trace
+	table.trace = r
+	table.Unlock()
+	if old != nil {
+		old.flush()
+	}
+}
+
+// StopTrace 停止记录并写出缓冲的记录, 返回记录期间第一个写入错误
+func (table *CacheTable) StopTrace() error {
+	table.Lock()
+	r := table.trace
+	table.trace = nil
+	table.Unlock()
+	if r == nil {
+		return nil
+	}
+	return r.flush()
+}
+
+func (r *traceRecorder) flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.w.Flush(); r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// ReadTrace 读取StartTrace写出的访问记录
+func ReadTrace(rd io.Reader) ([]TraceRecord, error) {
+	var records []TraceRecord
+	dec := json.NewDecoder(rd)
+	for {
+		var rec TraceRecord
+		if err := dec.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				return records, nil
+			}
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
+
+// ReplayResult 一种配置的重放结果
+type ReplayResult struct {
+	Policy   EvictionPolicy
+	Capacity int
+	// Gets, Hits 读取次数和命中次数
+	Gets uint64
+	Hits uint64
+}
+
+// HitRate 命中率, 没有读取时为0
+func (r ReplayResult) HitRate() float64 {
+	if r.Gets == 0 {
+		return 0
+	}
+	return float64(r.Hits) / float64(r.Gets)
+}
+
+// Replay 对policies和capacities的每种组合重放访问记录, 返回各自的命中率. 读取未命中时写入该键,
+// 模拟使用加载函数的表; 时间按记录中的时间推进, 元素不会过期
+func Replay(records []TraceRecord, policies []EvictionPolicy, capacities []int) []ReplayResult {
+	results := make([]ReplayResult, 0, len(policies)*len(capacities))
+	for _, p := range policies {
+		for _, c := range capacities {
+			results = append(results, replay(records, p, c))
+		}
+	}
+	return results
+}
+
+func replay(records []TraceRecord, p EvictionPolicy, capacity int) ReplayResult {
+	clock := &replayClock{}
+	table := NewTable("replay", WithEvictionPolicy(p), WithClock(clock), WithMaxItems(capacity))
+	defer table.Close(true)
+
+	res := ReplayResult{Policy: p, Capacity: capacity}
+	for _, rec := range records {
+		if rec.Time.After(clock.now) {
+			clock.now = rec.Time
+		}
+		switch rec.Op {
+		case CallGet:
+			res.Gets++
+			if _, err := table.Value(rec.Key); err == nil {
+				res.Hits++
+			} else {
+				table.Add(rec.Key, 0, struct{}{})
+			}
+		case CallAdd:
+			table.Add(rec.Key, 0, struct{}{})
+		case CallDelete:
+			table.Delete(rec.Key)
+		}
+	}
+	return res
+}
+
+// replayClock 按访问记录推进的时钟, 不触发定时器
+type replayClock struct {
+	now time.Time
+}
+
+func (c *replayClock) Now() time.Time                  { return c.now }
+func (c *replayClock) Since(t time.Time) time.Duration { return c.now.Sub(t) }
+func (c *replayClock) AfterFunc(d time.Duration, f func()) Timer {
+	return replayTimer{}
+}
+
+type replayTimer struct{}
+
+func (replayTimer) Stop() bool { return false }
@@ -0,0 +1,327 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XFromCache 由缓存返回的响应带有此头部, 值为1
+const XFromCache = "X-From-Cache"
+
+// DefaultRevalidateWindow 带ETag或Last-Modified的响应过期后仍保留的时长, 期间用条件请求重新验证
+const DefaultRevalidateWindow = time.Hour
+
+// DefaultMaxCachedBody Transport缓存的响应体的默认大小上限, 更大的响应直接返回, 不缓存
+const DefaultMaxCachedBody = 8 << 20
+
+// CachedResponse Transport缓存的响应, 以请求方法和URL为键保存在表中
+type CachedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Expires 之前直接使用, 之后需要重新验证
+	Expires time.Time
+	// Vary 响应Vary头部列出的请求头在缓存时的取值
+	Vary map[string]string
+}
+
+// fresh 响应在now时是否可以直接使用
+func (c *CachedResponse) fresh(now time.Time) bool {
+	return now.Before(c.Expires)
+}
+
+// matches 请求的Vary头部是否与缓存时一致
+func (c *CachedResponse) matches(req *http.Request) bool {
+	for name, value := range c.Vary {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// TransportOption NewTransport的配置项
+type TransportOption func(*Transport)
+
+// WithTransportBase 设置实际发送请求的RoundTripper, 默认为http.DefaultTransport
+func WithTransportBase(rt http.RoundTripper) TransportOption {
+	return func(t *Transport) { t.base = rt }
+}
+
+// WithHeuristicTTL 响应没有max-age或Expires时的有效期, 默认为0(不缓存, 有验证器时每次重新验证)
+func WithHeuristicTTL(d time.Duration) TransportOption {
+	return func(t *Transport) { t.heuristicTTL = d }
+}
+
+// WithForcedTTL 忽略响应的Cache-Control和Expires, 所有200响应缓存d
+func WithForcedTTL(d time.Duration) TransportOption {
+	return func(t *Transport) { t.forcedTTL = d }
+}
+
+// WithRevalidateWindow 设置带验证器的响应过期后保留的时长, 默认DefaultRevalidateWindow
+func WithRevalidateWindow(d time.Duration) TransportOption {
+	return func(t *Transport) { t.revalidateWindow = d }
+}
+
+// WithMaxCachedBody 设置缓存的响应体大小上限, 默认DefaultMaxCachedBody; <=0表示不限制
+func WithMaxCachedBody(n int64) TransportOption {
+	return func(t *Transport) { t.maxBody = n }
+}
+
+// Transport 缓存GET响应的http.RoundTripper, 遵循Cache-Control(max-age, no-store, no-cache)、Expires
+// 和ETag/Last-Modified条件请求. 作为私有缓存使用, private响应也会缓存; 带Vary: *或响应体超过上限的响应不缓存
+type Transport struct {
+	table            *CacheTable
+	base             http.RoundTripper
+	heuristicTTL     time.Duration
+	forcedTTL        time.Duration
+	revalidateWindow time.Duration
+	maxBody          int64
+}
+
+// NewTransport 创建把响应缓存在table中的Transport
+func NewTransport(table *CacheTable, opts ...TransportOption) *Transport {
+	t := &Transport{table: table, revalidateWindow: DefaultRevalidateWindow, maxBody: DefaultMaxCachedBody}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Client 返回使用该Transport的http.Client
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+func (t *Transport) roundTripper() http.RoundTripper {
+	if t.base != nil {
+		return t.base
+	}
+	return http.DefaultTransport
+}
+
+// transportKey 响应在表中的键
+func transportKey(req *http.Request) string {
+	return req.Method + " " + req.URL.String()
+}
+
+// RoundTrip 实现http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return t.roundTripper().RoundTrip(req)
+	}
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		return t.roundTripper().RoundTrip(req)
+	}
+
+	key := transportKey(req)
+	var cached *CachedResponse
+	if item, err := t.table.Peek(key); err == nil {
+		if c, ok := item.Value().(*CachedResponse); ok && c.matches(req) {
+			cached = c
+		}
+	}
+	_, noCache := reqCC["no-cache"]
+	if cached != nil && !noCache && cached.fresh(t.table.now()) {
+		return cached.response(req), nil
+	}
+
+	outReq := req
+	if cached != nil {
+		// 过期或要求重新验证, 带上验证器
+		etag, lastModified := cached.Header.Get("ETag"), cached.Header.Get("Last-Modified")
+		if etag != "" || lastModified != "" {
+			outReq = req.Clone(req.Context())
+			if etag != "" && req.Header.Get("If-None-Match") == "" {
+				outReq.Header.Set("If-None-Match", etag)
+			}
+			if lastModified != "" && req.Header.Get("If-Modified-Since") == "" {
+				outReq.Header.Set("If-Modified-Since", lastModified)
+			}
+		}
+	}
+	resp, err := t.roundTripper().RoundTrip(outReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil && outReq != req {
+		resp.Body.Close()
+		updated := &CachedResponse{
+			StatusCode: cached.StatusCode,
+			Header:     cached.Header.Clone(),
+			Body:       cached.Body,
+			Vary:       cached.Vary,
+		}
+		for name, values := range resp.Header {
+			updated.Header[name] = values
+		}
+		t.store(key, updated)
+		return updated.response(req), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	if _, ok := parseCacheControl(resp.Header)["no-store"]; ok && t.forcedTTL <= 0 {
+		return resp, nil
+	}
+	vary := varyHeaders(resp.Header)
+	if vary["*"] {
+		// 响应随请求头以外的条件变化, 无法判断之后的请求能否使用
+		return resp, nil
+	}
+	if t.maxBody > 0 && resp.ContentLength > t.maxBody {
+		return resp, nil
+	}
+
+	body, tooLarge, err := t.readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	if tooLarge {
+		return resp, nil
+	}
+	c := &CachedResponse{StatusCode: resp.StatusCode, Header: resp.Header.Clone(), Body: body}
+	for name := range vary {
+		if c.Vary == nil {
+			c.Vary = make(map[string]string)
+		}
+		c.Vary[name] = req.Header.Get(name)
+	}
+	t.store(key, c)
+	return resp, nil
+}
+
+// readBody 读取响应体并用读到的内容替换resp.Body. 超过maxBody时只读取maxBody+1字节,
+// resp.Body换为已读部分与剩余部分的拼接, tooLarge为true
+func (t *Transport) readBody(resp *http.Response) (body []byte, tooLarge bool, err error) {
+	r := io.Reader(resp.Body)
+	if t.maxBody > 0 {
+		r = io.LimitReader(resp.Body, t.maxBody+1)
+	}
+	body, err = io.ReadAll(r)
+	if err != nil {
+		resp.Body.Close()
+		return nil, false, err
+	}
+	if t.maxBody > 0 && int64(len(body)) > t.maxBody {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return nil, true, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return body, false, nil
+}
+
+// varyHeaders 返回响应Vary头部列出的请求头(规范化后), "*"原样保留
+func varyHeaders(header http.Header) map[string]bool {
+	var names map[string]bool
+	for _, field := range header.Values("Vary") {
+		for _, name := range strings.Split(field, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				if names == nil {
+					names = make(map[string]bool)
+				}
+				if name != "*" {
+					name = http.CanonicalHeaderKey(name)
+				}
+				names[name] = true
+			}
+		}
+	}
+	return names
+}
+
+// store 计算c的有效期并写入表中, 既不新鲜也无法重新验证的响应不保存
+func (t *Transport) store(key string, c *CachedResponse) {
+	now := t.table.now()
+	ttl := t.freshness(c.Header, now)
+	c.Expires = now.Add(ttl)
+	lifeSpan := ttl
+	if c.Header.Get("ETag") != "" || c.Header.Get("Last-Modified") != "" {
+		lifeSpan += t.revalidateWindow
+	}
+	if lifeSpan <= 0 {
+		t.table.Delete(key)
+		return
+	}
+	t.table.Add(key, lifeSpan, c)
+}
+
+// freshness 按WithForcedTTL, Cache-Control, Expires, WithHeuristicTTL的顺序计算响应的有效期
+func (t *Transport) freshness(header http.Header, now time.Time) time.Duration {
+	if t.forcedTTL > 0 {
+		return t.forcedTTL
+	}
+	cc := parseCacheControl(header)
+	if _, ok := cc["no-cache"]; ok {
+		return 0
+	}
+	if maxAge, ok := cc["max-age"]; ok {
+		seconds, err := strconv.Atoi(maxAge)
+		if err != nil || seconds <= 0 {
+			return 0
+		}
+		age, _ := strconv.Atoi(header.Get("Age"))
+		if d := time.Duration(seconds-age) * time.Second; d > 0 {
+			return d
+		}
+		return 0
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		exp, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		if d := exp.Sub(date); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return t.heuristicTTL
+}
+
+// response 用缓存的内容构造req的响应
+func (c *CachedResponse) response(req *http.Request) *http.Response {
+	header := c.Header.Clone()
+	header.Set(XFromCache, "1")
+	return &http.Response{
+		Status:        strconv.Itoa(c.StatusCode) + " " + http.StatusText(c.StatusCode),
+		StatusCode:    c.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
+
+// parseCacheControl 解析Cache-Control头部, 指令名转为小写, 无值的指令值为空串
+func parseCacheControl(header http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, field := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(field, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(part, "=")
+			cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return cc
+}
@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// twoQueue Evict2Q的状态. in为试用FIFO, am为受保护的LRU, out记录最近从in淘汰的键(不含值);
+// 在out中的键再次加入时直接进入am
+type twoQueue struct {
+	mu      sync.Mutex
+	in      *list.List
+	am      *list.List
+	out     *list.List
+	entries map[interface{}]*list.Element
+	ghosts  map[interface{}]*list.Element
+}
+
+type twoQueueEntry struct {
+	key       interface{}
+	protected bool
+}
+
+func newTwoQueue() *twoQueue {
+	q := &twoQueue{}
+	q.in, q.am, q.out = list.New(), list.New(), list.New()
+	q.entries = make(map[interface{}]*list.Element)
+	q.ghosts = make(map[interface{}]*list.Element)
+	return q
+}
+
+func (q *twoQueue) OnAdd(key interface{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if g, ok := q.ghosts[key]; ok {
+		q.out.Remove(g)
+		delete(q.ghosts, key)
+		q.entries[key] = q.am.PushFront(&twoQueueEntry{key: key, protected: true})
+		return
+	}
+	q.entries[key] = q.in.PushFront(&twoQueueEntry{key: key})
+}
+
+func (q *twoQueue) OnAccess(key interface{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// 试用队列中的命中不改变顺序
+	if e, ok := q.entries[key]; ok && e.Value.(*twoQueueEntry).protected {
+		q.am.MoveToFront(e)
+	}
+}
+
+func (q *twoQueue) OnRemove(key interface{}, op MutationOp) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.entries[key]
+	if !ok {
+		return
+	}
+	delete(q.entries, key)
+	if e.Value.(*twoQueueEntry).protected {
+		q.am.Remove(e)
+		return
+	}
+	q.in.Remove(e)
+	if op == OpEvict {
+		q.ghosts[key] = q.out.PushFront(key)
+		// out最多记录总容量一半的键
+		for limit := (q.in.Len() + q.am.Len() + 1) / 2; q.out.Len() > limit; {
+			oldest := q.out.Back()
+			q.out.Remove(oldest)
+			delete(q.ghosts, oldest.Value)
+		}
+	}
+}
+
+func (q *twoQueue) Victim(keep interface{}) (interface{}, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// 试用队列超过总数的1/4或受保护队列为空时从试用队列淘汰
+	first, second := q.am, q.in
+	if q.in.Len() > (q.in.Len()+q.am.Len())/4 || q.am.Len() == 0 {
+		first, second = q.in, q.am
+	}
+	for _, l := range []*list.List{first, second} {
+		for e := l.Back(); e != nil; e = e.Prev() {
+			if key := e.Value.(*twoQueueEntry).key; key != keep {
+				return key, true
+			}
+		}
+	}
+	return nil, false
+}
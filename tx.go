@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Txn Tx中使用的事务句柄, 写入先暂存, fn成功返回后一次性生效. 只能在fn内使用
+type Txn struct {
+	table   *CacheTable
+	now     time.Time
+	writes  map[interface{}]*CacheItem
+	deletes map[interface{}]bool
+}
+
+// Get 读取键的值, 能看到本事务中之前的写入和删除
+func (tx *Txn) Get(key interface{}) (interface{}, bool) {
+	key = canonicalKey(key)
+	if item, ok := tx.writes[key]; ok {
+		return item.value, true
+	}
+	if tx.deletes[key] {
+		return nil, false
+	}
+	item, ok := tx.table.items.get(key)
+	if !ok {
+		return nil, false
+	}
+	return item.value, true
+}
+
+// Exists 同Get, 只返回键是否存在
+func (tx *Txn) Exists(key interface{}) bool {
+	_, ok := tx.Get(key)
+	return ok
+}
+
+// Set 暂存一次写入, 返回的元素在事务提交后才有版本号
+func (tx *Txn) Set(key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem {
+	key = canonicalKey(key)
+	delete(tx.deletes, key)
+	item := newCacheItem(key, lifeSpan, data, tx.now)
+	tx.writes[key] = item
+	return item
+}
+
+// Version 返回键在表中已提交的版本号, 不反映本事务暂存的写入和删除
+func (tx *Txn) Version(key interface{}) (uint64, bool) {
+	key = canonicalKey(key)
+	item, ok := tx.table.items.get(key)
+	if !ok {
+		return 0, false
+	}
+	return item.version, true
+}
+
+// Delete 暂存一次删除, 返回键在事务视图中是否存在
+func (tx *Txn) Delete(key interface{}) bool {
+	key = canonicalKey(key)
+	existed := tx.Exists(key)
+	delete(tx.writes, key)
+	if _, ok := tx.table.items.get(key); ok {
+		tx.deletes[key] = true
+	}
+	return existed
+}
+
+// Tx 持有表的写锁执行fn, fn返回nil时其中的写入和删除一次性生效, 返回错误时全部丢弃.
+// 其他操作不会看到事务的中间状态, 提交引起的淘汰也在同一次加锁内完成; 回调在提交后执行. fn中不能调用表的方法, 否则会死锁
+func (table *CacheTable) Tx(fn func(tx *Txn) error) error {
+	table.Lock()
+	if table.rejectsWrites() {
+		table.Unlock()
+		return ErrTableClosed
+	}
+	tx := &Txn{
+		table:   table,
+		now:     table.clock.Now(),
+		writes:  make(map[interface{}]*CacheItem),
+		deletes: make(map[interface{}]bool),
+	}
+	if err := fn(tx); err != nil {
+		table.Unlock()
+		return err
+	}
+	for key, item := range tx.writes {
+		if err := table.admit(key, item.value, item.lifeSpan); err != nil {
+			table.Unlock()
+			return err
+		}
+	}
+
+	var deleted []*CacheItem
+	for key := range tx.deletes {
+		if item, ok := table.removeItem(context.Background(), key, OpDelete); ok {
+			deleted = append(deleted, item)
+		}
+	}
+	expiring := false
+	for _, item := range tx.writes {
+		table.setItem(context.Background(), item)
+		expiring = expiring || item.lifeSpan > 0
+	}
+	table.logEvent(LevelDebug, EventTx, nil, "Committing transaction", "writes", len(tx.writes), "deletes", len(deleted))
+	// 淘汰也在锁内完成, 删除回调与事务中的删除一起在提交后执行
+	for key := range tx.writes {
+		deleted = append(deleted, table.evictLocked(context.Background(), key)...)
+	}
+	// 被同一次提交淘汰的写入不执行添加回调
+	var added []*CacheItem
+	for key, item := range tx.writes {
+		if cur, _ := table.items.get(key); cur == item {
+			added = append(added, item)
+		}
+	}
+	addedItem := table.addItem
+	aboutToDeleteItem := table.aboutToDeleteItem
+	table.Unlock()
+
+	for _, r := range deleted {
+		for _, callback := range aboutToDeleteItem {
+			callback(context.Background(), r)
+		}
+		r.RLock()
+		aboutToExpire := r.aboutToExpire
+		r.RUnlock()
+		for _, callback := range aboutToExpire {
+			callback(r.key)
+		}
+	}
+	for _, item := range added {
+		for _, callback := range addedItem {
+			callback(context.Background(), item)
+		}
+	}
+	if expiring {
+		table.expirationCheck()
+	}
+	return nil
+}
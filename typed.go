@@ -0,0 +1,31 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Get 读取key并断言为T, 类型不符时返回包装了ErrTypeMismatch的错误, 其中包含实际类型
+//
+//	user, err := cache.Get[*User](table, id)
+func Get[T any](table *CacheTable, key interface{}, args ...interface{}) (T, error) {
+	var zero T
+	item, err := table.Value(key, args...)
+	if err != nil {
+		return zero, err
+	}
+	v, ok := item.Value().(T)
+	if !ok {
+		return zero, fmt.Errorf("%w: key %v holds %T, want %v", ErrTypeMismatch, key, item.Value(), reflect.TypeOf((*T)(nil)).Elem())
+	}
+	return v, nil
+}
+
+// MustGet 同Get, 出错时panic, 适合值一定存在的场景(如启动时预热的配置)
+func MustGet[T any](table *CacheTable, key interface{}, args ...interface{}) T {
+	v, err := Get[T](table, key, args...)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
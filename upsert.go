@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Upsert 在一次加锁内根据当前元素计算新值并写入, 有效期为lifeSpan, 用于计数器、集合合并等累积值.
+// 键不存在或已过期时fn收到nil和false. fn在持有表锁时调用, 不能调用表的方法, 也不应原地修改old的值.
+// 表已关闭或写入被拒绝时返回错误
+//
+//	table.Upsert("hits", 0, func(old *cache.CacheItem, ok bool) interface{} {
+//		if !ok {
+//			return 1
+//		}
+//		return old.Value().(int) + 1
+//	})
+func (table *CacheTable) Upsert(key interface{}, lifeSpan time.Duration, fn func(old *CacheItem, exists bool) interface{}) (*CacheItem, error) {
+	return table.UpsertContext(context.Background(), key, lifeSpan, fn)
+}
+
+// UpsertContext 同Upsert, ctx传给回调、插件并随变更记录下来
+func (table *CacheTable) UpsertContext(ctx context.Context, key interface{}, lifeSpan time.Duration, fn func(old *CacheItem, exists bool) interface{}) (*CacheItem, error) {
+	key = canonicalKey(key)
+	table.Lock()
+	if table.rejectsWrites() {
+		table.Unlock()
+		return nil, ErrTableClosed
+	}
+	now := table.clock.Now()
+	old, ok := table.items.get(key)
+	if ok && old.expired(now) {
+		old, ok = nil, false
+	}
+	value := fn(old, ok)
+	if err := table.admit(key, value, lifeSpan); err != nil {
+		table.Unlock()
+		return nil, err
+	}
+	item := newCacheItem(key, lifeSpan, value, now)
+	table.addInternal(ctx, item)
+	return item, nil
+}
+
+// Swap 在一次加锁内把key的值替换为value并返回之前的值, 键不存在或已过期时existed为false.
+// 表已关闭或写入被拒绝时不写入, 返回nil和false
+func (table *CacheTable) Swap(key interface{}, lifeSpan time.Duration, value interface{}) (old interface{}, existed bool) {
+	_, err := table.Upsert(key, lifeSpan, func(item *CacheItem, ok bool) interface{} {
+		if ok {
+			old, existed = item.value, true
+		}
+		return value
+	})
+	if err != nil {
+		return nil, false
+	}
+	return old, existed
+}
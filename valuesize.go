@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// OversizeCallback 值超过Settings.MaxValueSize被拒绝时调用, size为Sizer估算的大小.
+// 调用时持有表锁, 不能调用表的方法
+type OversizeCallback func(key, value interface{}, size int64)
+
+// SetOversizeCallback 设置值过大被拒绝时的回调, 用于上报指标或告警; f为nil时取消
+func (table *CacheTable) SetOversizeCallback(f OversizeCallback) {
+	table.Lock()
+	defer table.Unlock()
+	table.oversize = f
+}
+
+// WithMaxValueSize 限制单个元素的大小(按Sizer估算, 含键), 超出的写入返回ErrValueTooLarge;
+// passThrough为true时加载函数和其他节点返回的过大的值仍返回给调用方, 只是不缓存
+func WithMaxValueSize(size int64, passThrough bool) Option {
+	return func(t *CacheTable) {
+		t.updateSettings(func(s *Settings) {
+			s.MaxValueSize = size
+			s.OversizePassThrough = passThrough
+		})
+	}
+}
+
+// WithOversizeCallback 同SetOversizeCallback
+func WithOversizeCallback(f OversizeCallback) Option {
+	return func(t *CacheTable) { t.oversize = f }
+}
+
+// checkValueSize 检查值是否超过MaxValueSize, 调用方需持有表锁
+func (table *CacheTable) checkValueSize(key, value interface{}) error {
+	max := table.Settings().MaxValueSize
+	if max <= 0 {
+		return nil
+	}
+	size := table.sizeOf(&CacheItem{key: key, value: value})
+	if size <= max {
+		return nil
+	}
+	atomic.AddUint64(&table.oversized, 1)
+	if table.oversize != nil {
+		table.oversize(key, value, size)
+	}
+	return fmt.Errorf("%w: %d > %d", ErrValueTooLarge, size, max)
+}
+
+// addLoaded 缓存加载得到的值; 被准入策略拒绝, 或值过大且开启了OversizePassThrough时返回不在表中的元素
+func (table *CacheTable) addLoaded(ctx context.Context, key interface{}, lifeSpan time.Duration, value interface{}) (*CacheItem, error) {
+	item, err := table.insert(ctx, key, lifeSpan, value, true)
+	if err != nil && (errors.Is(err, ErrNotAdmitted) || errors.Is(err, ErrValueTooLarge) && table.Settings().OversizePassThrough) {
+		return newCacheItem(key, lifeSpan, value, table.clock.Now()), nil
+	}
+	return item, err
+}
@@ -0,0 +1,59 @@
+package cache
+
+import "context"
+
+// SetIfVersion 只有当前元素的版本等于version时才把值替换为value, 有效期沿用当前元素; 返回新元素.
+// version为0表示只在键不存在时写入(使用默认有效期). 版本不符时返回ErrVersionMismatch
+func (table *CacheTable) SetIfVersion(key interface{}, version uint64, value interface{}) (*CacheItem, error) {
+	key = canonicalKey(key)
+	table.Lock()
+	if table.rejectsWrites() {
+		table.Unlock()
+		return nil, ErrTableClosed
+	}
+	lifeSpan := table.Settings().DefaultTTL
+	cur, ok := table.items.get(key)
+	switch {
+	case ok && cur.version != version:
+		table.Unlock()
+		return nil, table.keyError("set_if_version", key, ErrVersionMismatch)
+	case !ok && version != 0:
+		table.Unlock()
+		return nil, table.keyError("set_if_version", key, ErrKeyNotFound)
+	case ok:
+		lifeSpan = cur.lifeSpan
+	}
+	if err := table.admit(key, value, lifeSpan); err != nil {
+		table.Unlock()
+		return nil, err
+	}
+	item := newCacheItem(key, lifeSpan, value, table.clock.Now())
+	table.addInternal(context.Background(), item)
+	return item, nil
+}
+
+// SetIfNewer 只有version大于当前元素的来源版本(SourceVersion)时才写入value, 用于乱序到达的更新不覆盖更新的数据;
+// 时间戳可用t.UnixNano()作为版本. 键不存在或已过期时直接写入, 其他方法写入的元素来源版本为0.
+// 使用默认有效期, 版本不更新时返回包装了ErrStaleVersion的KeyError
+func (table *CacheTable) SetIfNewer(key interface{}, value interface{}, version uint64) (*CacheItem, error) {
+	key = canonicalKey(key)
+	table.Lock()
+	if table.rejectsWrites() {
+		table.Unlock()
+		return nil, ErrTableClosed
+	}
+	now := table.clock.Now()
+	if cur, ok := table.items.get(key); ok && !cur.expired(now) && cur.sourceVersion >= version {
+		table.Unlock()
+		return nil, table.keyError("set_if_newer", key, ErrStaleVersion)
+	}
+	lifeSpan := table.Settings().DefaultTTL
+	if err := table.admit(key, value, lifeSpan); err != nil {
+		table.Unlock()
+		return nil, err
+	}
+	item := newCacheItem(key, lifeSpan, value, now)
+	item.sourceVersion = version
+	table.addInternal(context.Background(), item)
+	return item, nil
+}
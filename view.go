@@ -0,0 +1,71 @@
+package cache
+
+import "time"
+
+// View 表在某一时刻的只读视图, 之后对表的写入和删除在视图中不可见.
+// 通过写时复制实现: 获取视图不复制数据, 表在下一次修改时才复制一份自己的map.
+// 元素的值不可变, 但访问时间和访问次数等元数据仍是共享的
+type View struct {
+	name    string
+	items   itemMap
+	takenAt time.Time
+}
+
+// View 返回表当前内容的只读视图
+func (table *CacheTable) View() *View {
+	table.Lock()
+	defer table.Unlock()
+	table.viewShared = true
+	return &View{
+		name:    table.name,
+		items:   table.items,
+		takenAt: table.clock.Now(),
+	}
+}
+
+// ensureOwned 当前map被视图引用时先复制一份再修改, 调用方需持有表锁
+func (table *CacheTable) ensureOwned() {
+	if !table.viewShared {
+		return
+	}
+	table.items = table.items.clone()
+	table.viewShared = false
+}
+
+// Name 返回表名
+func (v *View) Name() string {
+	return v.name
+}
+
+// TakenAt 返回获取视图的时间
+func (v *View) TakenAt() time.Time {
+	return v.takenAt
+}
+
+// Value 返回视图中键对应的元素, 不更新访问统计也不调用加载函数
+func (v *View) Value(key interface{}) (*CacheItem, error) {
+	item, ok := v.items.get(canonicalKey(key))
+	if !ok {
+		return nil, &KeyError{Table: v.name, Key: key, Op: "view", Err: ErrKeyNotFound}
+	}
+	return item, nil
+}
+
+// Exists 视图中是否存在键
+func (v *View) Exists(key interface{}) bool {
+	_, ok := v.items.get(canonicalKey(key))
+	return ok
+}
+
+// Count 视图中的元素数量
+func (v *View) Count() int {
+	return v.items.len()
+}
+
+// Foreach 遍历视图中的所有元素
+func (v *View) Foreach(trans func(key interface{}, item *CacheItem)) {
+	v.items.each(func(k interface{}, item *CacheItem) bool {
+		trans(k, item)
+		return true
+	})
+}
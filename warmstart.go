@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"context"
+	"encoding/gob"
+	"os"
+	"sort"
+)
+
+// RestoreCallback 预热结束时调用, n为恢复的元素数, path为使用的快照文件(没有可用的快照时为空),
+// err为无法读取的快照的错误(没有快照文件不算错误)
+type RestoreCallback func(n int, path string, err error)
+
+// warmStart WithWarmStart的参数
+type warmStart struct {
+	path      string
+	onRestore RestoreCallback
+}
+
+// WithWarmStart 设置快照文件(同SetSnapshotFile), 并在NewTable返回前从path和Persist保留的上一个快照中
+// 选择最新且能完整读取的一个恢复元素. 元素保留快照时的剩余有效期, 已过期的跳过. onRestore可为nil.
+// 只在创建表时生效
+func WithWarmStart(path string, onRestore RestoreCallback) Option {
+	return func(t *CacheTable) {
+		t.snapshotFile = path
+		t.warmStart = &warmStart{path: path, onRestore: onRestore}
+	}
+}
+
+// runWarmStart 执行WithWarmStart设置的预热, NewTable在应用完所有Option后调用
+func (table *CacheTable) runWarmStart() {
+	ws := table.warmStart
+	table.warmStart = nil
+	if ws == nil {
+		return
+	}
+	n, path, err := table.restoreLatestSnapshot(ws.path)
+	table.RLock()
+	if err != nil {
+		table.logEvent(LevelWarn, EventRestore, nil, "Reading snapshot failed", "path", ws.path, "error", err)
+	} else {
+		table.logEvent(LevelInfo, EventRestore, nil, "Restored snapshot", "path", path, "items", n)
+	}
+	table.RUnlock()
+	if ws.onRestore != nil {
+		ws.onRestore(n, path, err)
+	}
+}
+
+// restoreLatestSnapshot 从path和path+PrevSnapshotSuffix中按修改时间从新到旧尝试读取快照,
+// 恢复第一个能完整读取的快照, 返回恢复的元素数和所用的文件; 没有可用快照时返回最后一个读取错误
+func (table *CacheTable) restoreLatestSnapshot(path string) (int, string, error) {
+	type candidate struct {
+		path string
+		info os.FileInfo
+	}
+	var candidates []candidate
+	for _, p := range []string{path, path + PrevSnapshotSuffix} {
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, candidate{p, info})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].info.ModTime().After(candidates[j].info.ModTime())
+	})
+
+	var lastErr error
+	for _, c := range candidates {
+		entries, err := readSnapshotFile(c.path)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		n, err := table.restoreEntries(entries)
+		return n, c.path, err
+	}
+	return 0, "", lastErr
+}
+
+// readSnapshotFile 读取并解码整个快照文件
+func readSnapshotFile(path string) ([]snapshotEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []snapshotEntry
+	if err := gob.NewDecoder(f).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// restoreEntries 把快照中的元素加入表中, 保留创建时间和访问次数; 访问时间按快照中的过期时间倒推,
+// 因此元素在原来的时间点过期, 之后访问仍按原有效期续期. 已过期或被拒绝的元素跳过, 返回恢复的元素数
+func (table *CacheTable) restoreEntries(entries []snapshotEntry) (int, error) {
+	n := 0
+	for _, e := range entries {
+		key := canonicalKey(e.Key)
+		accessedOn := e.AccessedOn
+		if !e.ExpiresAt.IsZero() {
+			accessedOn = e.ExpiresAt.Add(-e.LifeSpan)
+		} else if accessedOn.IsZero() {
+			// 没有记录时间的旧快照
+			accessedOn = e.CreatedOn
+		}
+		table.Lock()
+		if table.closed {
+			table.Unlock()
+			return n, ErrTableClosed
+		}
+		if e.LifeSpan > 0 && table.clock.Now().Sub(accessedOn) >= e.LifeSpan {
+			table.Unlock()
+			continue
+		}
+		if err := table.admit(key, e.Value, e.LifeSpan); err != nil {
+			table.Unlock()
+			continue
+		}
+		item := newCacheItem(key, e.LifeSpan, e.Value, accessedOn)
+		item.createdOn = e.CreatedOn
+		item.accessCount = e.AccessCount
+		table.addInternal(context.Background(), item)
+		n++
+	}
+	return n, nil
+}